@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
@@ -13,7 +14,6 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
-	"path"
 	"runtime"
 	"sort"
 	"strconv"
@@ -32,7 +32,6 @@ import (
 	sdktypes "github.com/compliance-framework/api/sdk/types"
 	"github.com/coreos/go-systemd/v22/daemon"
 	oscalTypes_1_1_3 "github.com/defenseunicorns/go-oscal/src/types/oscal-1-1-3"
-	"github.com/fsnotify/fsnotify"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/hashicorp/go-hclog"
@@ -61,15 +60,40 @@ type agentPolicy string
 type agentPluginConfig map[string]string
 
 type agentPlugin struct {
-	ProtocolVersion int32                  `mapstructure:"protocol_version"`
-	Schedule        *string                `mapstructure:"schedule,omitempty"`
-	Source          string                 `mapstructure:"source"`
-	Policies        []agentPolicy          `mapstructure:"policies"`
-	Config          agentPluginConfig      `mapstructure:"config"`
-	Labels          map[string]string      `mapstructure:"labels"`
-	PolicyData      map[string]interface{} `mapstructure:"policy_data,omitempty"`
-	PolicyBehavior  map[string][]string    `mapstructure:"policy_behavior,omitempty"`
-	protocolSet     bool
+	ProtocolVersion  int32                  `mapstructure:"protocol_version"`
+	Schedule         *string                `mapstructure:"schedule,omitempty"`
+	Source           string                 `mapstructure:"source"`
+	Policies         []agentPolicy          `mapstructure:"policies"`
+	Config           agentPluginConfig      `mapstructure:"config"`
+	Labels           map[string]string      `mapstructure:"labels"`
+	PolicyData       map[string]interface{} `mapstructure:"policy_data,omitempty"`
+	PolicyBehavior   map[string][]string    `mapstructure:"policy_behavior,omitempty"`
+	Verify           *agentVerifyConfig     `mapstructure:"verify,omitempty"`
+	PolicyVerify     *agentVerifyConfig     `mapstructure:"policy_verify,omitempty"`
+	PullPolicy       string                 `mapstructure:"pull_policy,omitempty"`
+	PolicyPullPolicy string                 `mapstructure:"policy_pull_policy,omitempty"`
+	Timeout          string                 `mapstructure:"timeout,omitempty"`
+	Retry            *agentRetryConfig      `mapstructure:"retry,omitempty"`
+	Persistent       bool                   `mapstructure:"persistent,omitempty"`
+	protocolSet      bool
+	// timeout and retryPolicy are parsed from Timeout and Retry when the configuration is
+	// validated.
+	timeout     time.Duration
+	retryPolicy pluginRetryPolicy
+}
+
+func (p *agentPlugin) pluginDownloadOptions() internal.DownloadOptions {
+	return internal.DownloadOptions{
+		Verify:     p.Verify.options(),
+		PullPolicy: internal.PullPolicy(strings.TrimSpace(p.PullPolicy)),
+	}
+}
+
+func (p *agentPlugin) policyDownloadOptions() internal.DownloadOptions {
+	return internal.DownloadOptions{
+		Verify:     p.PolicyVerify.options(),
+		PullPolicy: internal.PullPolicy(strings.TrimSpace(p.PolicyPullPolicy)),
+	}
 }
 
 type agentEvidenceConfig struct {
@@ -78,12 +102,47 @@ type agentEvidenceConfig struct {
 	Interval            string `mapstructure:"interval,omitempty"`
 }
 
+type agentOutboxConfig struct {
+	Enabled        *bool  `mapstructure:"enabled,omitempty"`
+	Directory      string `mapstructure:"directory,omitempty"`
+	MaxBytes       int64  `mapstructure:"max_bytes,omitempty"`
+	ReplayInterval string `mapstructure:"replay_interval,omitempty"`
+}
+
+type agentInputRecordingConfig struct {
+	Enabled          *bool  `mapstructure:"enabled,omitempty"`
+	Directory        string `mapstructure:"directory,omitempty"`
+	MaxRunsPerPlugin int    `mapstructure:"max_runs_per_plugin,omitempty"`
+}
+
+type agentPolicyEvaluationConfig struct {
+	Timeout    string `mapstructure:"timeout,omitempty"`
+	Instrument bool   `mapstructure:"instrument,omitempty"`
+	Profile    bool   `mapstructure:"profile,omitempty"`
+}
+
 type agentConfig struct {
 	Daemon        bool                    `mapstructure:"daemon"`
 	Verbosity     int32                   `mapstructure:"verbosity"`
 	ApiConfig     *apiConfig              `mapstructure:"api"`
 	Plugins       map[string]*agentPlugin `mapstructure:"plugins"`
 	AgentEvidence *agentEvidenceConfig    `mapstructure:"agent_evidence"`
+	Outbox        *agentOutboxConfig      `mapstructure:"outbox"`
+	StatusAPI     *agentStatusAPIConfig   `mapstructure:"status_api"`
+	Metrics       *agentMetricsConfig     `mapstructure:"metrics"`
+	RemoteConfig  *agentRemoteConfig      `mapstructure:"remote_config"`
+	// InputRecording stores the input plugins evaluate their policies with, for replaying
+	// new policy versions against it.
+	InputRecording *agentInputRecordingConfig `mapstructure:"input_recording"`
+	// PolicyEvaluation limits and instruments the evaluation of each policy package by
+	// plugins.
+	PolicyEvaluation *agentPolicyEvaluationConfig `mapstructure:"policy_evaluation"`
+	// MaxConcurrency is how many plugins a one-shot run executes at the same time.
+	MaxConcurrency int `mapstructure:"max_concurrency"`
+
+	// dryRun is set with --dry-run, when requests to the API are captured locally instead
+	// of being sent.
+	dryRun bool
 }
 
 // logVerbosity reverses our verbosity "increase" to hclog's reversed "decrease."
@@ -95,33 +154,83 @@ func (ac *agentConfig) logVerbosity() int32 {
 }
 
 func (ac *agentConfig) validate() error {
-	if err := ac.ApiConfig.validate(); err != nil {
-		return err
+	return errors.Join(ac.validationErrors()...)
+}
+
+// validationErrors returns every problem with the configuration, with plugins checked in
+// name order.
+func (ac *agentConfig) validationErrors() []error {
+	var errs []error
+	for _, validate := range []func() error{
+		ac.validateAPI,
+		func() error {
+			_, err := ac.agentEvidenceInterval()
+			return err
+		},
+		ac.validateOutbox,
+		ac.validateStatusAPI,
+		ac.validateMetrics,
+		ac.validateMaxConcurrency,
+		ac.validateRemoteConfig,
+		ac.validateInputRecording,
+		ac.validatePolicyEvaluation,
+	} {
+		if err := validate(); err != nil {
+			errs = append(errs, err)
+		}
 	}
 
-	if _, err := ac.agentEvidenceInterval(); err != nil {
-		return err
+	names := make([]string, 0, len(ac.Plugins))
+	for name := range ac.Plugins {
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
-	for name, pluginConfig := range ac.Plugins {
+	for _, name := range names {
+		pluginConfig := ac.Plugins[name]
 		if pluginConfig == nil {
-			return fmt.Errorf("plugin %s has null configuration", name)
+			errs = append(errs, fmt.Errorf("plugin %s has null configuration", name))
+			continue
 		}
 
-		if pluginConfig.ProtocolVersion == 0 {
-			if pluginConfig.protocolSet {
-				return fmt.Errorf("plugin %s has unsupported protocol_version=%d; supported values are %d and %d", name, pluginConfig.ProtocolVersion, DefaultProtocolVersion, RunnerV2ProtocolVersion)
-			}
+		if err := pluginConfig.Verify.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("plugin %s has invalid verify configuration: %w", name, err))
+		}
+
+		if err := pluginConfig.PolicyVerify.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("plugin %s has invalid policy_verify configuration: %w", name, err))
+		}
 
+		if err := pluginConfig.pluginDownloadOptions().PullPolicy.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("plugin %s has invalid pull_policy: %w", name, err))
+		}
+
+		if err := pluginConfig.policyDownloadOptions().PullPolicy.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("plugin %s has invalid policy_pull_policy: %w", name, err))
+		}
+
+		if timeout, err := pluginConfig.runTimeout(); err != nil {
+			errs = append(errs, fmt.Errorf("plugin %s has invalid timeout: %w", name, err))
+		} else {
+			pluginConfig.timeout = timeout
+		}
+
+		if policy, err := pluginConfig.parseRetryPolicy(); err != nil {
+			errs = append(errs, fmt.Errorf("plugin %s has invalid retry configuration: %w", name, err))
+		} else {
+			pluginConfig.retryPolicy = policy
+		}
+
+		if pluginConfig.ProtocolVersion == 0 && !pluginConfig.protocolSet {
 			continue
 		}
 
 		if !isSupportedProtocolVersion(pluginConfig.ProtocolVersion) {
-			return fmt.Errorf("plugin %s has unsupported protocol_version=%d; supported values are %d and %d", name, pluginConfig.ProtocolVersion, DefaultProtocolVersion, RunnerV2ProtocolVersion)
+			errs = append(errs, fmt.Errorf("plugin %s has unsupported protocol_version=%d; supported values are %d and %d", name, pluginConfig.ProtocolVersion, DefaultProtocolVersion, RunnerV2ProtocolVersion))
 		}
 	}
 
-	return nil
+	return errs
 }
 
 func (ac *agentConfig) agentEvidenceEnabled() bool {
@@ -157,6 +266,16 @@ func (ac *agentConfig) agentEvidenceInterval() (time.Duration, error) {
 	return interval, nil
 }
 
+// validateAPI checks the api config, which a dry run only needs if it is set, since it
+// never contacts the API.
+func (ac *agentConfig) validateAPI() error {
+	if ac.dryRun && ac.ApiConfig == nil {
+		return nil
+	}
+
+	return ac.ApiConfig.validate()
+}
+
 func (ac *apiConfig) validate() error {
 	if ac == nil {
 		return fmt.Errorf("no api config specified in config")
@@ -198,6 +317,9 @@ func (ac *apiConfig) hasPartialAuth() bool {
 
 const AgentPluginDir = ".compliance-framework/plugins"
 const AgentPolicyDir = ".compliance-framework/policies"
+const AgentOutboxDir = ".compliance-framework/outbox"
+const AgentRemoteConfigCache = ".compliance-framework/remote-config.json"
+const AgentPolicyInputDir = ".compliance-framework/policy-inputs"
 const DefaultProtocolVersion int32 = 1
 const RunnerV2ProtocolVersion int32 = 2
 const AnnotationProtocolVersionKey = "org.ccf.plugin.protocol.version"
@@ -218,6 +340,16 @@ type pluginRunRecord struct {
 	Error      string
 	StartedAt  time.Time
 	FinishedAt time.Time
+	// SourceDigest and PolicyDigests are the OCI digests used by the most recent run,
+	// keyed by policy source. Local sources have no digest.
+	SourceDigest  string
+	PolicyDigests map[string]string
+	// Attempt is the attempt number of the current or most recent run, out of
+	// MaxAttempts allowed by the plugin's retry policy. NextRetryAt is set while a
+	// failed run waits to be retried.
+	Attempt     int
+	MaxAttempts int
+	NextRetryAt time.Time
 }
 
 type pluginRunSnapshot struct {
@@ -242,15 +374,23 @@ with plugins to ensure continuous compliance.`,
 	agentCmd.Flags().BoolP("daemon", "d", false, "Specify to run as a long running daemon")
 	viper.BindPFlag("daemon", agentCmd.Flags().Lookup("daemon"))
 
+	agentCmd.Flags().Int("max-concurrency", defaultMaxConcurrency, "Number of plugins to run at the same time when not running as a daemon")
+
 	agentCmd.Flags().StringP("config", "c", "", "Location of config file")
-	agentCmd.MarkFlagRequired("config")
+	agentCmd.Flags().String("config-dir", "", "Directory of config fragments (*.yaml) merged over the config file in lexical order")
+	agentCmd.MarkFlagsOneRequired("config", "config-dir")
+
+	agentCmd.Flags().Bool("dry-run", false, "Run plugins without contacting the API, capturing the evidence and templates they would send")
+	agentCmd.Flags().String("output", "", "Directory to write dry run captures to as JSON files, instead of stdout")
+
+	agentCmd.AddCommand(ValidateCmd())
+	agentCmd.AddCommand(ConfigCmd())
+	agentCmd.AddCommand(RunCmd())
 
 	return agentCmd
 }
 
 func mergeConfig(cmd *cobra.Command, fileConfig *viper.Viper) (*agentConfig, error) {
-	// For now, we are reading from a file. This will probably be updated to a remote source soon.
-
 	// Daemon has a default false value, which will override all values passed through Viper.
 	// We need to check whether it was actually passed `Changed()`, and then merge its value into our config.
 	if cmd.Flags().Changed("daemon") {
@@ -280,6 +420,19 @@ func mergeConfig(cmd *cobra.Command, fileConfig *viper.Viper) (*agentConfig, err
 		}
 	}
 
+	if cmd.Flags().Changed("max-concurrency") {
+		maxConcurrency, err := cmd.Flags().GetInt("max-concurrency")
+		if err != nil {
+			return nil, err
+		}
+		err = fileConfig.MergeConfigMap(map[string]interface{}{
+			"max_concurrency": maxConcurrency,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	config := &agentConfig{}
 	err := fileConfig.Unmarshal(config)
 
@@ -290,6 +443,13 @@ func mergeConfig(cmd *cobra.Command, fileConfig *viper.Viper) (*agentConfig, err
 	markExplicitPluginProtocols(fileConfig, config)
 	updateAllPluginProtocols(config)
 
+	if cmd.Flags().Changed("dry-run") {
+		config.dryRun, err = cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return config, nil
 }
 
@@ -378,12 +538,12 @@ func runnerDispenseName(protocolVersion int32) (string, error) {
 	}
 }
 
-func initRunner(name string, protocolVersion int32, runnerInstance runner.RunnerV2, policyPaths []string, policyBehavior map[string]*proto.StringList, resultsHelper runner.ApiHelper) error {
+func initRunner(ctx context.Context, name string, protocolVersion int32, runnerInstance runner.RunnerV2, policyPaths []string, policyBehavior map[string]*proto.StringList, resultsHelper runner.ApiHelper) error {
 	if protocolVersion <= DefaultProtocolVersion {
 		return nil
 	}
 
-	_, err := runnerInstance.Init(&proto.InitRequest{
+	_, err := runnerInit(ctx, runnerInstance, &proto.InitRequest{
 		PolicyPaths:    policyPaths,
 		PolicyBehavior: policyBehavior,
 	}, resultsHelper)
@@ -392,28 +552,34 @@ func initRunner(name string, protocolVersion int32, runnerInstance runner.Runner
 	}
 
 	if status.Code(err) == codes.Unimplemented {
-		return fmt.Errorf("plugin %s configured as protocol_version=%d but does not implement Init", name, protocolVersion)
+		return &pluginConfigError{err: fmt.Errorf("plugin %s configured as protocol_version=%d but does not implement Init", name, protocolVersion)}
 	}
 
 	return err
 }
 
-func configureRunner(name string, runnerInstance runner.RunnerV2, config agentPluginConfig, policyData map[string]interface{}, policyBehavior map[string][]string) error {
+func configureRunner(ctx context.Context, name string, runnerInstance runner.RunnerV2, config agentPluginConfig, policyData map[string]interface{}, policyBehavior map[string][]string) error {
 	policyDataStruct, err := mapToStruct(policyData)
 	if err != nil {
-		return fmt.Errorf("invalid policy_data for plugin %s: %w", name, err)
+		return &pluginConfigError{err: fmt.Errorf("invalid policy_data for plugin %s: %w", name, err)}
 	}
 
-	_, err = runnerInstance.Configure(&proto.ConfigureRequest{
+	_, err = runnerConfigure(ctx, runnerInstance, &proto.ConfigureRequest{
 		Config:         config,
 		PolicyData:     policyDataStruct,
 		PolicyBehavior: policyBehaviorToProto(policyBehavior),
 	})
+	// A plugin rejects its configuration with InvalidArgument or FailedPrecondition. Other
+	// errors, including the Unknown code of a plain error, may be transient.
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.FailedPrecondition:
+		return &pluginConfigError{err: err}
+	}
 	return err
 }
 
-func loadConfig(cmd *cobra.Command, v *viper.Viper) (*agentConfig, error) {
-	err := v.ReadInConfig()
+func loadConfig(cmd *cobra.Command, source agentConfigSource) (*agentConfig, error) {
+	v, err := source.read()
 	if err != nil {
 		return nil, err
 	}
@@ -435,53 +601,87 @@ func loadConfig(cmd *cobra.Command, v *viper.Viper) (*agentConfig, error) {
 // It will read the configuration file, and then run the agent. Various command line flags can
 // be used to override the config file.
 func agentRunner(cmd *cobra.Command, args []string) error {
-	configPath := cmd.Flag("config").Value.String()
-
-	if !path.IsAbs(configPath) {
-		workDir, err := os.Getwd()
-		if err != nil {
-			return err
-		}
-		configPath = path.Join(workDir, configPath)
+	source, err := configSourceFromFlags(cmd)
+	if err != nil {
+		return err
 	}
 
-	v := viper.New()
-	v.SetConfigFile(configPath)
-	v.SetEnvPrefix("CCF")
-	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
-	v.AutomaticEnv()
-	if err := bindAgentEnv(v); err != nil {
+	agentRun := NewAgentRunner()
+	if err := agentRun.setupDryRun(cmd); err != nil {
 		return err
 	}
 
 	logger := hclog.New(&hclog.LoggerOptions{
 		Name:   "agent",
-		Output: os.Stdout,
+		Output: agentRun.logOutput(),
 		Level:  hclog.Debug,
 	})
 
-	agentRun := NewAgentRunner()
-
 	ctx, configCancel := context.WithCancel(context.Background())
 	defer configCancel()
 
-	v.OnConfigChange(func(in fsnotify.Event) {
-		// We want to wait for any running agent processes to finish first.
-		logger.Debug("config file changed", "path", in.Name)
-		configCancel()
-	})
-	v.WatchConfig()
+	// Changes to the remote config are applied like changes to the config files. A dry run
+	// does not contact the API, so it runs with the local config only.
+	if agentRun.dryRun == nil {
+		source.remote = newRemoteConfigLoader(ctx, agentRun.httpClient, logger, agentRun.requestReload)
+	}
+
+	// Changes are validated and applied by the running daemon, which only restarts when
+	// a change cannot be applied in place.
+	agentRun.loadConfigFunc = func() (*agentConfig, error) {
+		return loadConfig(cmd, source)
+	}
+	stopWatching, err := source.watch(logger, agentRun.requestReload)
+	if err != nil {
+		return err
+	}
+	defer stopWatching()
+	agentRun.setReloadFunc(agentRun.requestReload)
+
+	var statusAPI *statusServer
+	var metricsServer *metricsServer
+	defer func() {
+		if statusAPI != nil {
+			statusAPI.Close()
+		}
+		if metricsServer != nil {
+			metricsServer.Close()
+		}
+	}()
 
 	// For the daemon, we run the agent continuously.
-	// It will exit as soon as the config changes, and then start again with new configs set.
+	// It returns when a config change needs a restart, and then starts again with the new config.
+	var config *agentConfig
 	for {
-		ctx, configCancel = context.WithCancel(context.Background())
-		config, err := loadConfig(cmd, v)
+		newConfig, err := loadConfig(cmd, source)
 		if err != nil {
-			logger.Error("Error loading new config", "error", err)
-			panic(err)
+			if config == nil {
+				logger.Error("Error loading config", "error", err)
+				return err
+			}
+			logger.Error("Error loading new config, keeping the current configuration", "error", err)
+			newConfig = config
 		}
+		config = newConfig
 		agentRun.UpdateConfig(config)
+
+		// The status API outlives config reloads, so it is started once with the first
+		// config that enables it.
+		if statusAPI == nil && config.Daemon && config.statusAPIListen() != "" {
+			statusAPI, err = agentRun.startStatusServer(config.statusAPIListen())
+			if err != nil {
+				logger.Error("Error starting status API", "error", err)
+				os.Exit(1)
+			}
+		}
+		if metricsServer == nil && config.metricsListen() != "" {
+			metricsServer, err = startMetricsServer(agentRun.getLogger(), config.metricsListen(), config.metricsPath())
+			if err != nil {
+				logger.Error("Error starting metrics endpoint", "error", err)
+				os.Exit(1)
+			}
+		}
+
 		err = agentRun.Run(ctx)
 
 		if err != nil {
@@ -505,9 +705,21 @@ type AgentRunner struct {
 	config     *agentConfig
 	apiClient  *sdk.Client
 	httpClient *http.Client
-
-	pluginLocations      map[string]string
-	policyLocations      map[string]string
+	outbox     *runner.EvidenceOutbox
+	// dryRun, when set, captures the requests plugins and the agent would make to the API
+	// instead of sending them, and logWriter is where logs are written.
+	dryRun    *runner.DryRunOutput
+	logWriter io.Writer
+	// evidenceCounts, when set, counts the evidence each plugin creates.
+	evidenceCounts *evidenceCounter
+
+	locationMu      sync.RWMutex
+	pluginLocations map[string]string
+	policyLocations map[string]string
+	// pluginDigests and policyDigests are the OCI digests of the downloaded sources, for
+	// one-shot runs, which use the sources downloaded up front.
+	pluginDigests        map[string]string
+	policyDigests        map[string]string
 	activePluginClients  map[*plugin.Client]struct{}
 	activePluginClientMu sync.Mutex
 	pluginClientsClosing bool
@@ -515,10 +727,29 @@ type AgentRunner struct {
 	fetchAnnotations     func(ctx context.Context, source string, option ...remote.Option) (map[string]string, error)
 	runPluginFunc        func(ctx context.Context, name string, pluginConfig *agentPlugin) error
 
+	persistentPluginMu     sync.Mutex
+	persistentPlugins      map[string]*persistentPlugin
+	startPluginProcessFunc func(logger hclog.Logger, path string, protocolVersion int32) (*pluginProcess, error)
+
 	pluginRunMu                   sync.RWMutex
 	pluginRuns                    map[string]pluginRunRecord
 	firstAgentEvidenceSendStarted bool
 
+	cronMu            sync.RWMutex
+	pluginCron        *cron.Cron
+	pluginCronEntries map[string]cron.EntryID
+	pluginJobs        map[string]cron.Job
+	reloadFunc        func()
+	pluginRunLocks    map[string]*sync.Mutex
+
+	// reloadRequests receives a value when the configuration should be reloaded, which
+	// loadConfigFunc does.
+	reloadRequests chan struct{}
+	loadConfigFunc func() (*agentConfig, error)
+
+	// secrets holds the secrets resolved from plugin configs, to be masked in logs.
+	secrets *secretRedactor
+
 	queryBundles []*rego.Rego
 }
 
@@ -526,17 +757,30 @@ func NewAgentRunner() *AgentRunner {
 	return &AgentRunner{
 		pluginLocations:     map[string]string{},
 		policyLocations:     map[string]string{},
+		pluginDigests:       map[string]string{},
+		policyDigests:       map[string]string{},
 		activePluginClients: map[*plugin.Client]struct{}{},
+		persistentPlugins:   map[string]*persistentPlugin{},
+		pluginRunLocks:      map[string]*sync.Mutex{},
+		reloadRequests:      make(chan struct{}, 1),
 		pluginRuns:          map[string]pluginRunRecord{},
+		secrets:             newSecretRedactor(),
 		fetchAnnotations:    internal.GetAnnotations,
 		httpClient:          http.DefaultClient,
 	}
 }
 
 func (ar *AgentRunner) UpdateConfig(config *agentConfig) {
+	ar.setConfig(config)
+	ar.resetPluginRunState(config)
+}
+
+// setConfig makes config the agent's current configuration, without touching the state
+// of plugin runs.
+func (ar *AgentRunner) setConfig(config *agentConfig) {
 	logger := hclog.New(&hclog.LoggerOptions{
 		Name:   "agent-runner",
-		Output: os.Stdout,
+		Output: ar.logOutput(),
 		Level:  hclog.Level(config.logVerbosity()),
 	})
 	client := ar.buildAPIClient(config, logger)
@@ -546,13 +790,12 @@ func (ar *AgentRunner) UpdateConfig(config *agentConfig) {
 	ar.logger = logger
 	ar.apiClient = client
 	ar.stateMu.Unlock()
-	ar.resetPluginRunState(config)
 
 	ar.logAPIClientConfig("config updated")
 }
 
 func (ar *AgentRunner) buildAPIClient(config *agentConfig, logger hclog.Logger) *sdk.Client {
-	if config == nil || config.ApiConfig == nil {
+	if config == nil || config.ApiConfig == nil || ar.dryRun != nil {
 		return nil
 	}
 
@@ -640,6 +883,41 @@ func (ar *AgentRunner) markPluginRunStarted(name string) {
 	record.Status = pluginRunStatusRunning
 	record.StartedAt = now
 	record.FinishedAt = time.Time{}
+	record.NextRetryAt = time.Time{}
+	ar.pluginRuns[name] = record
+	internal.PluginRunning.WithLabelValues(name).Set(1)
+}
+
+func (ar *AgentRunner) markPluginRunAttempt(name string, attempt int, maxAttempts int) {
+	ar.pluginRunMu.Lock()
+	defer ar.pluginRunMu.Unlock()
+
+	record := ar.pluginRuns[name]
+	record.Attempt = attempt
+	record.MaxAttempts = maxAttempts
+	record.NextRetryAt = time.Time{}
+	ar.pluginRuns[name] = record
+}
+
+// markPluginRunRetrying records a failed attempt that will be retried at nextRetryAt.
+// The run is still in progress, so it is not counted in the run metrics.
+func (ar *AgentRunner) markPluginRunRetrying(name string, nextRetryAt time.Time, err error) {
+	ar.pluginRunMu.Lock()
+	defer ar.pluginRunMu.Unlock()
+
+	record := ar.pluginRuns[name]
+	record.NextRetryAt = nextRetryAt
+	record.Error = pluginRunErrorMessage(err)
+	ar.pluginRuns[name] = record
+}
+
+func (ar *AgentRunner) recordPluginRunArtifacts(name string, sourceDigest string, policyDigests map[string]string) {
+	ar.pluginRunMu.Lock()
+	defer ar.pluginRunMu.Unlock()
+
+	record := ar.pluginRuns[name]
+	record.SourceDigest = sourceDigest
+	record.PolicyDigests = policyDigests
 	ar.pluginRuns[name] = record
 }
 
@@ -650,6 +928,12 @@ func (ar *AgentRunner) markPluginRunFinished(name string, err error) {
 	defer ar.pluginRunMu.Unlock()
 
 	record := ar.pluginRuns[name]
+	outcome := internal.MetricOutcome(err)
+	internal.PluginRunsTotal.WithLabelValues(name, outcome).Inc()
+	if record.Status == pluginRunStatusRunning {
+		internal.PluginRunDuration.WithLabelValues(name, outcome).Observe(now.Sub(record.StartedAt).Seconds())
+		internal.PluginRunning.WithLabelValues(name).Set(0)
+	}
 	if record.StartedAt.IsZero() {
 		record.StartedAt = now
 	}
@@ -1033,6 +1317,11 @@ func (ar *AgentRunner) Run(ctx context.Context) error {
 	logger.Info("Starting agent", "daemon", config.Daemon)
 	ar.allowPluginClientTracking()
 
+	if err := ar.openOutbox(); err != nil {
+		logger.Error("Error opening evidence outbox", "error", err)
+		return err
+	}
+
 	logger.Debug("Pessimistically downloading plugins and policies to fail early in case daemon runs later.")
 	err := ar.DownloadPlugins(ctx)
 	if err != nil {
@@ -1068,9 +1357,14 @@ func (ar *AgentRunner) resolvePluginProtocols(ctx context.Context) {
 		ctx = context.Background()
 	}
 
-	config := ar.getConfig()
+	ar.resolveProtocolsOf(ctx, ar.getConfig().Plugins)
+}
+
+// resolveProtocolsOf sets the protocol version of each of plugins that does not configure
+// one from its OCI annotations.
+func (ar *AgentRunner) resolveProtocolsOf(ctx context.Context, plugins map[string]*agentPlugin) {
 	logger := ar.getLogger()
-	for pluginName, pluginConfig := range config.Plugins {
+	for pluginName, pluginConfig := range plugins {
 		if pluginConfig == nil || pluginConfig.protocolSet || !internal.IsOCI(pluginConfig.Source) {
 			continue
 		}
@@ -1101,7 +1395,8 @@ func (ar *AgentRunner) resolvePluginProtocols(ctx context.Context) {
 	}
 }
 
-// Should never return, either handles any error or panics.
+// runDaemon returns when ctx is cancelled, or when a reloaded configuration can only be
+// applied by restarting the agent. It exits the process on SIGINT and SIGTERM.
 func (ar *AgentRunner) runDaemon(ctx context.Context) {
 	logger := ar.getLogger()
 	sigs := make(chan os.Signal, 1)
@@ -1124,11 +1419,17 @@ func (ar *AgentRunner) runDaemon(ctx context.Context) {
 		logger.Error("Error setting up agent evidence", "error", err)
 		os.Exit(1)
 	}
+	outboxCron, err := ar.setupOutboxCron(ctx)
+	if err != nil {
+		logger.Error("Error setting up evidence outbox replay", "error", err)
+		os.Exit(1)
+	}
 
 	// Start the cron and notify readiness
 	agentCron.Start()
 	heartbeatCron.Start()
 	agentEvidenceCron.Start()
+	outboxCron.Start()
 	if ar.reserveFirstAgentEvidenceSend() {
 		if err := ar.SendAgentRunEvidence(ctx); err != nil {
 			ar.releaseFirstAgentEvidenceSend()
@@ -1137,32 +1438,40 @@ func (ar *AgentRunner) runDaemon(ctx context.Context) {
 	}
 	go daemon.SdNotify(false, "READY=1")
 
-	select {
-	case sig := <-sigs:
-		logger.Info("received signal to terminate plugins and exit", "signal", sig)
+	stopCrons := func() {
 		logger.Debug("Stopping crons")
 		agentCronStopCtx := agentCron.Stop()
 		heartbeatCronStopCtx := heartbeatCron.Stop()
 		agentEvidenceCronStopCtx := agentEvidenceCron.Stop()
-		if !waitForCronStop(daemonCronStopTimeout, agentCronStopCtx, heartbeatCronStopCtx, agentEvidenceCronStopCtx) {
+		outboxCronStopCtx := outboxCron.Stop()
+		if !waitForCronStop(daemonCronStopTimeout, agentCronStopCtx, heartbeatCronStopCtx, agentEvidenceCronStopCtx, outboxCronStopCtx) {
 			logger.Warn("Timed out waiting for cron jobs to stop before plugin cleanup", "timeout", daemonCronStopTimeout)
 		}
 		logger.Debug("Shutting down plugins")
 		ar.closePluginClients()
-		logger.Debug("Exiting")
-		os.Exit(0)
-	case <-ctx.Done():
-		logger.Debug("received cancel signal to return from daemon")
-		logger.Debug("Stopping crons")
-		agentCronStopCtx := agentCron.Stop()
-		heartbeatCronStopCtx := heartbeatCron.Stop()
-		agentEvidenceCronStopCtx := agentEvidenceCron.Stop()
-		if !waitForCronStop(daemonCronStopTimeout, agentCronStopCtx, heartbeatCronStopCtx, agentEvidenceCronStopCtx) {
-			logger.Warn("Timed out waiting for cron jobs to stop before plugin cleanup", "timeout", daemonCronStopTimeout)
+	}
+
+	for {
+		select {
+		case sig := <-sigs:
+			logger.Info("received signal to terminate plugins and exit", "signal", sig)
+			stopCrons()
+			logger.Debug("Exiting")
+			os.Exit(0)
+		case <-ctx.Done():
+			logger.Debug("received cancel signal to return from daemon")
+			stopCrons()
+			ar.clearPluginCron()
+			return
+		case <-ar.reloadRequests:
+			if !ar.reloadConfig(ctx) {
+				continue
+			}
+			logger.Info("Restarting the agent to apply the new configuration")
+			stopCrons()
+			ar.clearPluginCron()
+			return
 		}
-		logger.Debug("Shutting down plugins")
-		ar.closePluginClients()
-		return
 	}
 }
 
@@ -1217,20 +1526,38 @@ func (l cronLogger) Error(err error, msg string, keysAndValues ...interface{}) {
 	l.logger.Error(msg, append([]interface{}{"error", err}, keysAndValues...)...)
 }
 
-func (ar *AgentRunner) download(ctx context.Context, source string, outputDir string, binaryPath string, optionKey string, logger hclog.Logger, option ...remote.Option) (string, error) {
-	lockKey := strings.Join([]string{outputDir, binaryPath, source, optionKey}, "\x00")
+func (ar *AgentRunner) download(ctx context.Context, source string, outputDir string, binaryPath string, optionKey string, opts internal.DownloadOptions, logger hclog.Logger, option ...remote.Option) (internal.Artifact, error) {
+	lockKey := strings.Join([]string{outputDir, binaryPath, source, optionKey, downloadOptionsKey(opts)}, "\x00")
 	result, err, _ := ar.downloadGroup.Do(lockKey, func() (interface{}, error) {
-		return internal.Download(ctx, source, outputDir, binaryPath, logger, option...)
+		return internal.DownloadArtifact(ctx, source, outputDir, binaryPath, opts, logger, option...)
 	})
 	if err != nil {
-		return "", err
+		return internal.Artifact{}, err
 	}
 
-	return result.(string), nil
+	return result.(internal.Artifact), nil
+}
+
+func downloadOptionsKey(opts internal.DownloadOptions) string {
+	return strings.Join([]string{opts.Verify.String(), string(opts.PullPolicy)}, "\x00")
+}
+
+// addSourceDownload records that source must be downloaded with opts. A source shared by
+// several plugins is downloaded once per distinct set of options, so that it satisfies
+// every plugin that uses it.
+func addSourceDownload(sources map[string]map[string]internal.DownloadOptions, source string, opts internal.DownloadOptions) {
+	if sources[source] == nil {
+		sources[source] = map[string]internal.DownloadOptions{}
+	}
+	sources[source][downloadOptionsKey(opts)] = opts
 }
 
 func (ar *AgentRunner) setupHeartbeatCron(ctx context.Context) (*cron.Cron, error) {
 	logger := ar.getLogger()
+	if ar.dryRun != nil {
+		logger.Debug("Not sending heartbeats during a dry run")
+		return cron.New(), nil
+	}
 
 	// staggeredSeconds is used to offset the heartbeat by x seconds to prevent a massive influx of heartbeats on
 	// the beginning of each minute to the API.
@@ -1286,53 +1613,72 @@ func (ar *AgentRunner) setupAgentEvidenceCron(ctx context.Context) (*cron.Cron,
 
 func (ar *AgentRunner) setupCron(ctx context.Context) (*cron.Cron, error) {
 	logger := ar.getLogger()
-	parserOptions := cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor
-	c := cron.New(cron.WithParser(cron.NewParser(
-		parserOptions,
-	)))
+	c := cron.New(cron.WithParser(pluginScheduleParser))
 	config := ar.getConfig()
-	runPlugin := ar.runPlugin
-	if ar.runPluginFunc != nil {
-		runPlugin = ar.runPluginFunc
-	}
+	runPlugin := ar.pluginRunner()
+	entries := map[string]cron.EntryID{}
+	jobs := map[string]cron.Job{}
 
 	for pluginName, pluginConfig := range config.Plugins {
-		currentPluginName := pluginName
-		currentPluginConfig := pluginConfig
-		var schedule string
-		if currentPluginConfig.Schedule == nil {
-			schedule = "* * * * *"
-		} else {
-			schedule = *currentPluginConfig.Schedule
-		}
-
-		jobLogger := logger.With("plugin", currentPluginName, "schedule", schedule)
-		job := cron.NewChain(cron.SkipIfStillRunning(cronLogger{logger: jobLogger})).Then(cron.FuncJob(func() {
-			ar.markPluginRunStarted(currentPluginName)
-			err := runPlugin(ctx, currentPluginName, currentPluginConfig)
-			ar.markPluginRunFinished(currentPluginName, err)
-			if err != nil {
-				// TODO how will we handle these errors ?
-				jobLogger.Error("Error running plugin", "error", err, "protocol_version", currentPluginConfig.ProtocolVersion)
-			}
-			if ar.reserveFirstAgentEvidenceSend() {
-				if evidenceErr := ar.SendAgentRunEvidence(ctx); evidenceErr != nil {
-					ar.releaseFirstAgentEvidenceSend()
-					jobLogger.Error("Failed to send agent run evidence", "error", evidenceErr)
-				}
-			}
-		}))
-		_, err := c.AddJob(schedule, job)
+		schedule, job := ar.newPluginJob(ctx, pluginName, pluginConfig, runPlugin, logger)
+		entryID, err := c.AddJob(schedule, job)
 
 		if err != nil {
 			logger.Error("Error adding plugin schedule", "schedule", schedule, "error", err)
 			// TODO We should figure out how to handle this, especially in the context of automatically configured
 			// agents. We should probably send a health status to the API with errors.
+			continue
 		}
+		entries[pluginName] = entryID
+		jobs[pluginName] = job
 	}
+
+	ar.cronMu.Lock()
+	ar.pluginCron = c
+	ar.pluginCronEntries = entries
+	ar.pluginJobs = jobs
+	ar.cronMu.Unlock()
 	return c, nil
 }
 
+func (ar *AgentRunner) pluginRunner() func(ctx context.Context, name string, pluginConfig *agentPlugin) error {
+	if ar.runPluginFunc != nil {
+		return ar.runPluginFunc
+	}
+	return ar.runPlugin
+}
+
+// newPluginJob returns the cron job running a plugin, and the schedule it runs on.
+func (ar *AgentRunner) newPluginJob(ctx context.Context, name string, pluginConfig *agentPlugin, runPlugin func(ctx context.Context, name string, pluginConfig *agentPlugin) error, logger hclog.Logger) (string, cron.Job) {
+	schedule := "* * * * *"
+	if pluginConfig.Schedule != nil {
+		schedule = *pluginConfig.Schedule
+	}
+
+	jobLogger := logger.With("plugin", name, "schedule", schedule)
+	job := cron.NewChain(cron.SkipIfStillRunning(cronLogger{logger: jobLogger})).Then(cron.FuncJob(func() {
+		// A job replaced by a config reload may still be running, so runs of the same
+		// plugin are also serialised across jobs.
+		runLock := ar.pluginRunLock(name)
+		runLock.Lock()
+		defer runLock.Unlock()
+
+		err := ar.runPluginWithRetry(ctx, name, pluginConfig, runPlugin, jobLogger)
+		if err != nil {
+			// TODO how will we handle these errors ?
+			jobLogger.Error("Error running plugin", "error", err, "protocol_version", pluginConfig.ProtocolVersion)
+		}
+		if ar.reserveFirstAgentEvidenceSend() {
+			if evidenceErr := ar.SendAgentRunEvidence(ctx); evidenceErr != nil {
+				ar.releaseFirstAgentEvidenceSend()
+				jobLogger.Error("Failed to send agent run evidence", "error", evidenceErr)
+			}
+		}
+	}))
+
+	return schedule, job
+}
+
 // Run the agent as an instance, this is a single run of the agent that will check the
 // policies against the plugins.
 //
@@ -1349,6 +1695,10 @@ func (ar *AgentRunner) runAllPlugins(ctx context.Context) error {
 
 	defer ar.closePluginClients()
 
+	if err := ar.ReplayOutbox(ctx); err != nil {
+		logger.Warn("Failed to replay queued evidence before running plugins", "error", err)
+	}
+
 	pluginNames := make([]string, 0, len(config.Plugins))
 	for pluginName := range config.Plugins {
 		pluginNames = append(pluginNames, pluginName)
@@ -1356,110 +1706,114 @@ func (ar *AgentRunner) runAllPlugins(ctx context.Context) error {
 	sort.Strings(pluginNames)
 	configHash := agentConfigurationHash(config)
 
-	for _, pluginName := range pluginNames {
-		pluginConfig := config.Plugins[pluginName]
-		ar.markPluginRunStarted(pluginName)
-		logger := hclog.New(&hclog.LoggerOptions{
-			Name:   fmt.Sprintf("runner.%s", pluginName),
-			Output: os.Stdout,
-			Level:  hclog.Level(config.logVerbosity()),
-		})
+	runPlugin := func(ctx context.Context, pluginName string, _ *agentPlugin) error {
+		return ar.runLocalPlugin(ctx, config, client, pluginName, configHash)
+	}
+	err := runPluginsConcurrently(ctx, pluginNames, config.maxConcurrency(), func(ctx context.Context, pluginName string) error {
+		return ar.runPluginWithRetry(ctx, pluginName, config.Plugins[pluginName], runPlugin, logger.With("plugin", pluginName))
+	})
 
-		labels := pluginEvidenceLabelsWithHash(config, pluginName, pluginConfig, configHash)
+	if evidenceErr := ar.sendAgentRunEvidenceAfterCompleteRun(ctx); evidenceErr != nil {
+		logger.Error("Error sending agent run evidence", "error", evidenceErr)
+	}
+	return err
+}
 
-		source := ar.pluginLocations[pluginConfig.Source]
+// runLocalPlugin runs a plugin from its already downloaded source as part of a one-shot
+// run of the agent.
+func (ar *AgentRunner) runLocalPlugin(ctx context.Context, config *agentConfig, client *sdk.Client, pluginName string, configHash string) error {
+	pluginConfig := config.Plugins[pluginName]
+	logger := hclog.New(&hclog.LoggerOptions{
+		Name:   fmt.Sprintf("runner.%s", pluginName),
+		Output: ar.logOutput(),
+		Level:  hclog.Level(config.logVerbosity()),
+	})
 
-		logger.Debug("Running plugin", "source", source, "protocol_version", pluginConfig.ProtocolVersion)
+	labels := pluginEvidenceLabelsWithHash(config, pluginName, pluginConfig, configHash)
 
-		if _, err := os.ReadFile(source); err != nil {
-			ar.markPluginRunFinished(pluginName, err)
-			if evidenceErr := ar.sendAgentRunEvidenceAfterCompleteRun(ctx); evidenceErr != nil {
-				logger.Error("Error sending agent run evidence", "error", evidenceErr)
-			}
-			return err
-		}
+	source := ar.pluginLocation(pluginConfig.Source)
+	digest, policyDigests := ar.downloadedDigests(pluginConfig)
+	ar.recordPluginRunArtifacts(pluginName, digest, policyDigests)
 
-		runnerInstance, cleanupRunner, err := ar.getRunnerInstance(logger, source, pluginConfig.ProtocolVersion)
+	logger.Info("Running plugin", "source", source, "digest", digest, "policy_digests", policyDigests, "protocol_version", pluginConfig.ProtocolVersion)
 
-		if err != nil {
-			ar.markPluginRunFinished(pluginName, err)
-			if evidenceErr := ar.sendAgentRunEvidenceAfterCompleteRun(ctx); evidenceErr != nil {
-				logger.Error("Error sending agent run evidence", "error", evidenceErr)
-			}
-			return err
-		}
-		if err := func() error {
-			defer cleanupRunner()
-
-			if err := configureRunner(pluginName, runnerInstance, pluginConfig.Config, pluginConfig.PolicyData, pluginConfig.PolicyBehavior); err != nil {
-				// What do we do here ?
-				//endTimer := time.Now()
-				//_, err = client.Results.Create(&sdk.Result{
-				//	StreamID:    streamId,
-				//	Labels:      resultLabels,
-				//	Title:       "Agent has failed to configure plugin.",
-				//	Remarks:     "Agent has failed to configure plugin. Fix agent to continue receiving results",
-				//	Description: fmt.Errorf("agent execution failed with error. %v", err).Error(),
-				//	Start:       startTimer,
-				//	End:         &endTimer,
-				//})
-				return err
-			}
+	if _, err := os.ReadFile(source); err != nil {
+		return err
+	}
 
-			policyPaths := make([]string, 0, len(pluginConfig.Policies))
+	pluginConfig, err := ar.withResolvedConfig(ctx, pluginConfig)
+	if err != nil {
+		return err
+	}
 
-			for _, inputBundle := range pluginConfig.Policies {
-				policyPaths = append(policyPaths, ar.policyLocations[string(inputBundle)])
-			}
+	runnerInstance, cleanupRunner, err := ar.getRunnerInstance(logger, source, pluginConfig.ProtocolVersion)
 
-			// Create a new results helper for the plugin to send results back to
-			logger.Debug("Creating plugin API helper",
-				"plugin", pluginName,
-				"auth_enabled", hasAPIAuth(config),
-				"client_id", apiClientID(config),
-			)
-			resultsHelper := runner.NewApiHelper(logger, client, labels, pluginName)
+	if err != nil {
+		return err
+	}
+	defer cleanupRunner()
 
-			policyBehaviorProto := policyBehaviorToProto(pluginConfig.PolicyBehavior)
-			if err := initRunner(pluginName, pluginConfig.ProtocolVersion, runnerInstance, policyPaths, policyBehaviorProto, resultsHelper); err != nil {
-				return err
-			}
+	timeout := pluginConfig.timeout
+	runCtx, cancelRun := pluginRunContext(ctx, timeout, cleanupRunner, logger)
+	defer cancelRun()
+
+	if err := configureRunner(runCtx, pluginName, runnerInstance, pluginConfig.Config, pluginConfig.PolicyData, pluginConfig.PolicyBehavior); err != nil {
+		err = pluginTimeoutError(runCtx, pluginName, timeout, err)
+		// What do we do here ?
+		//endTimer := time.Now()
+		//_, err = client.Results.Create(&sdk.Result{
+		//	StreamID:    streamId,
+		//	Labels:      resultLabels,
+		//	Title:       "Agent has failed to configure plugin.",
+		//	Remarks:     "Agent has failed to configure plugin. Fix agent to continue receiving results",
+		//	Description: fmt.Errorf("agent execution failed with error. %v", err).Error(),
+		//	Start:       startTimer,
+		//	End:         &endTimer,
+		//})
+		return err
+	}
 
-			// TODO: Send failed results to the database?
-			_, err = runnerInstance.Eval(&proto.EvalRequest{
-				PolicyPaths:    policyPaths,
-				PolicyBehavior: policyBehaviorProto,
-			}, resultsHelper)
+	policyPaths := make([]string, 0, len(pluginConfig.Policies))
 
-			if err != nil {
-				// What do we do here ?
-				//endTimer := time.Now()
-				//_, err = client.Results.Create(&sdk.Result{
-				//	StreamID:    streamId,
-				//	Labels:      resultLabels,
-				//	Title:       "Agent has failed to execute policies.",
-				//	Remarks:     "Agent has failed to execute policies. Fix agent to continue receiving results",
-				//	Description: fmt.Errorf("agent execution failed with error. %v", err).Error(),
-				//	Start:       startTimer,
-				//	End:         &endTimer,
-				//})
-				return err
-			}
+	for _, inputBundle := range pluginConfig.Policies {
+		policyPaths = append(policyPaths, ar.policyLocation(string(inputBundle)))
+	}
 
-			return nil
-		}(); err != nil {
-			ar.markPluginRunFinished(pluginName, err)
-			if evidenceErr := ar.sendAgentRunEvidenceAfterCompleteRun(ctx); evidenceErr != nil {
-				logger.Error("Error sending agent run evidence", "error", evidenceErr)
-			}
-			return err
-		}
-		ar.markPluginRunFinished(pluginName, nil)
+	// Create a new results helper for the plugin to send results back to
+	logger.Debug("Creating plugin API helper",
+		"plugin", pluginName,
+		"auth_enabled", hasAPIAuth(config),
+		"client_id", apiClientID(config),
+	)
+	resultsHelper := ar.newPluginApiHelper(logger, client, labels, pluginName)
+
+	policyBehaviorProto := policyBehaviorToProto(pluginConfig.PolicyBehavior)
+	if err := initRunner(runCtx, pluginName, pluginConfig.ProtocolVersion, runnerInstance, policyPaths, policyBehaviorProto, resultsHelper); err != nil {
+		return pluginTimeoutError(runCtx, pluginName, timeout, err)
 	}
 
-	if evidenceErr := ar.sendAgentRunEvidenceAfterCompleteRun(ctx); evidenceErr != nil {
-		logger.Error("Error sending agent run evidence", "error", evidenceErr)
+	// TODO: Send failed results to the database?
+	_, err = runnerEval(runCtx, runnerInstance, &proto.EvalRequest{
+		PolicyPaths:    policyPaths,
+		PolicyBehavior: policyBehaviorProto,
+	}, resultsHelper)
+
+	if err != nil {
+		err = pluginTimeoutError(runCtx, pluginName, timeout, err)
+		// What do we do here ?
+		//endTimer := time.Now()
+		//_, err = client.Results.Create(&sdk.Result{
+		//	StreamID:    streamId,
+		//	Labels:      resultLabels,
+		//	Title:       "Agent has failed to execute policies.",
+		//	Remarks:     "Agent has failed to execute policies. Fix agent to continue receiving results",
+		//	Description: fmt.Errorf("agent execution failed with error. %v", err).Error(),
+		//	Start:       startTimer,
+		//	End:         &endTimer,
+		//})
+		return err
 	}
+
 	return nil
 }
 
@@ -1497,34 +1851,40 @@ func (ar *AgentRunner) runPlugin(ctx context.Context, name string, plugin *agent
 	)
 
 	policyPaths := make([]string, 0)
+	policyDigests := map[string]string{}
 	for _, inputBundle := range plugin.Policies {
-		policyLocation, err := ar.download(ctx, string(inputBundle), AgentPolicyDir, "policies", "", logger)
+		policyArtifact, err := ar.download(ctx, string(inputBundle), AgentPolicyDir, "policies", "", plugin.policyDownloadOptions(), logger)
 		if err != nil {
 			return err
 		}
-		policyPaths = append(policyPaths, policyLocation)
+		policyPaths = append(policyPaths, policyArtifact.Path)
+		if policyArtifact.Digest != "" {
+			policyDigests[string(inputBundle)] = policyArtifact.Digest
+		}
 	}
 
 	platform := v1.Platform{
 		Architecture: runtime.GOARCH,
 		OS:           runtime.GOOS,
 	}
-	pluginExecutable, err := ar.download(ctx, plugin.Source, AgentPluginDir, "plugin", platformDownloadKey(platform), logger, remote.WithPlatform(platform))
+	pluginArtifact, err := ar.download(ctx, plugin.Source, AgentPluginDir, "plugin", platformDownloadKey(platform), plugin.pluginDownloadOptions(), logger, remote.WithPlatform(platform))
 
 	if err != nil {
 		return err
 	}
+	pluginExecutable := pluginArtifact.Path
+	ar.recordPluginRunArtifacts(name, pluginArtifact.Digest, policyDigests)
 
-	logger.Info("Running plugin", "source", plugin.Source, "protocol_version", plugin.ProtocolVersion)
-	logger.Info("Running plugin", "source", pluginExecutable, "protocol_version", plugin.ProtocolVersion)
+	logger.Info("Running plugin", "source", pluginExecutable, "digest", pluginArtifact.Digest, "policy_digests", policyDigests, "protocol_version", plugin.ProtocolVersion)
 
 	pluginLogger := hclog.New(&hclog.LoggerOptions{
 		Name:   fmt.Sprintf("runner.%s", name),
-		Output: os.Stdout,
+		Output: ar.logOutput(),
 		Level:  hclog.Level(config.logVerbosity()),
 	})
 
-	labels := pluginEvidenceLabelsWithHash(config, name, plugin, agentConfigurationHash(config))
+	configHash := agentConfigurationHash(config)
+	labels := pluginEvidenceLabelsWithHash(config, name, plugin, configHash)
 
 	pluginLogger.Debug("Running plugin", "source", pluginExecutable, "protocol_version", plugin.ProtocolVersion)
 
@@ -1532,16 +1892,12 @@ func (ar *AgentRunner) runPlugin(ctx context.Context, name string, plugin *agent
 		return err
 	}
 
-	runnerInstance, cleanupRunner, err := ar.getRunnerInstance(pluginLogger, pluginExecutable, plugin.ProtocolVersion)
-
+	// Secrets are resolved on every run, so that rotated secrets are picked up. A
+	// persistent plugin is configured again when they change.
+	plugin, err = ar.withResolvedConfig(ctx, plugin)
 	if err != nil {
 		return err
 	}
-	defer cleanupRunner()
-
-	if err := configureRunner(name, runnerInstance, plugin.Config, plugin.PolicyData, plugin.PolicyBehavior); err != nil {
-		return err
-	}
 
 	// Create a new results helper for the plugin to send results back to
 	pluginLogger.Debug("Creating plugin API helper",
@@ -1549,21 +1905,41 @@ func (ar *AgentRunner) runPlugin(ctx context.Context, name string, plugin *agent
 		"auth_enabled", hasAPIAuth(config),
 		"client_id", apiClientID(config),
 	)
-	resultsHelper := runner.NewApiHelper(pluginLogger, client, labels, name)
+	resultsHelper := ar.newPluginApiHelper(pluginLogger, client, labels, name)
 
-	policyBehaviorProto := policyBehaviorToProto(plugin.PolicyBehavior)
-	if err := initRunner(name, plugin.ProtocolVersion, runnerInstance, policyPaths, policyBehaviorProto, resultsHelper); err != nil {
+	if plugin.Persistent {
+		generation := persistentPluginGeneration(pluginExecutable, pluginArtifact.Digest, policyDigests, pluginConfigurationHash(plugin))
+		return ar.runPersistentPlugin(ctx, name, plugin, generation, pluginExecutable, policyPaths, resultsHelper, pluginLogger)
+	}
+
+	runnerInstance, cleanupRunner, err := ar.getRunnerInstance(pluginLogger, pluginExecutable, plugin.ProtocolVersion)
+
+	if err != nil {
 		return err
 	}
+	defer cleanupRunner()
+
+	timeout := plugin.timeout
+	runCtx, cancelRun := pluginRunContext(ctx, timeout, cleanupRunner, pluginLogger)
+	defer cancelRun()
+
+	if err := configureRunner(runCtx, name, runnerInstance, plugin.Config, plugin.PolicyData, plugin.PolicyBehavior); err != nil {
+		return pluginTimeoutError(runCtx, name, timeout, err)
+	}
+
+	policyBehaviorProto := policyBehaviorToProto(plugin.PolicyBehavior)
+	if err := initRunner(runCtx, name, plugin.ProtocolVersion, runnerInstance, policyPaths, policyBehaviorProto, resultsHelper); err != nil {
+		return pluginTimeoutError(runCtx, name, timeout, err)
+	}
 
 	// TODO: Send failed results to the database?
-	_, err = runnerInstance.Eval(&proto.EvalRequest{
+	_, err = runnerEval(runCtx, runnerInstance, &proto.EvalRequest{
 		PolicyPaths:    policyPaths,
 		PolicyBehavior: policyBehaviorProto,
 	}, resultsHelper)
 
 	if err != nil {
-		return err
+		return pluginTimeoutError(runCtx, name, timeout, err)
 	}
 
 	return nil
@@ -1585,6 +1961,7 @@ func (ar *AgentRunner) SendHeartbeat(ctx context.Context, staticAgentUUID uuid.U
 		UUID:      staticAgentUUID,
 		CreatedAt: time.Now().UTC(),
 	})
+	internal.HeartbeatsTotal.WithLabelValues(internal.MetricOutcome(err)).Inc()
 	if err != nil {
 		logger.Error("Error sending heartbeat via SDK", "error", err, "uuid", staticAgentUUID.String())
 		return err
@@ -1610,6 +1987,10 @@ func (ar *AgentRunner) SendAgentRunEvidence(ctx context.Context) error {
 		return err
 	}
 
+	if ar.dryRun != nil {
+		return ar.captureDryRun(runner.DryRunRecord{Kind: runner.DryRunKindAgentEvidence, Payload: evidence})
+	}
+
 	payload, err := json.Marshal(evidence)
 	if err != nil {
 		return err
@@ -1690,6 +2071,10 @@ func (ar *AgentRunner) buildAgentRunEvidence(now time.Time) (*agentEvidenceCreat
 	}
 
 	links, backMatter := agentEvidenceErrorArtifacts(snapshot.Errors)
+	props, outboxRemarks := ar.outboxEvidenceProps()
+	if outboxRemarks != "" {
+		remarks = remarks + "\n" + outboxRemarks
+	}
 	evidence := &agentEvidenceCreateRequest{
 		Evidence: sdktypes.Evidence{
 			UUID:        evidenceUUID,
@@ -1701,6 +2086,7 @@ func (ar *AgentRunner) buildAgentRunEvidence(now time.Time) (*agentEvidenceCreat
 			End:         now,
 			Expires:     expires,
 			Links:       links,
+			Props:       props,
 			Status: sdktypes.ObjectiveStatus{
 				Reason:  reason,
 				Remarks: remarks,
@@ -1829,13 +2215,26 @@ func safePluginErrorFilename(pluginName string) string {
 }
 
 func (ar *AgentRunner) getRunnerInstance(logger hclog.Logger, path string, protocolVersion int32) (runner.RunnerV2, func(), error) {
+	process, err := ar.startPluginProcess(logger, path, protocolVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+	return process.runner, process.cleanup, nil
+}
+
+func (ar *AgentRunner) startPluginProcess(logger hclog.Logger, path string, protocolVersion int32) (*pluginProcess, error) {
 	// We're a host! Start by launching the plugin process.
+	cmd := exec.Command(path)
+	// go-plugin appends the host environment after cmd.Env, where it would take
+	// precedence, so the configured environment includes it instead.
+	cmd.Env = ar.getConfig().pluginProcessEnv()
 	client := plugin.NewClient(&plugin.ClientConfig{
 		HandshakeConfig:  runner.HandshakeConfig,
 		Plugins:          runner.PluginMap,
-		Cmd:              exec.Command(path),
+		Cmd:              cmd,
 		Logger:           logger,
 		AllowedProtocols: []plugin.Protocol{plugin.ProtocolGRPC},
+		SkipHostEnv:      cmd.Env != nil,
 	})
 	cleanup := ar.trackPluginClient(client)
 
@@ -1843,13 +2242,13 @@ func (ar *AgentRunner) getRunnerInstance(logger hclog.Logger, path string, proto
 	rpcClient, err := client.Client()
 	if err != nil {
 		cleanup()
-		return nil, nil, err
+		return nil, err
 	}
 
 	dispenseName, err := runnerDispenseName(protocolVersion)
 	if err != nil {
 		cleanup()
-		return nil, nil, err
+		return nil, err
 	}
 
 	// Request the plugin
@@ -1857,7 +2256,7 @@ func (ar *AgentRunner) getRunnerInstance(logger hclog.Logger, path string, proto
 	raw, err := rpcClient.Dispense(dispenseName)
 	if err != nil {
 		cleanup()
-		return nil, nil, err
+		return nil, err
 	}
 
 	// We should have a Greeter now! This feels like a normal interface
@@ -1865,9 +2264,18 @@ func (ar *AgentRunner) getRunnerInstance(logger hclog.Logger, path string, proto
 	runnerInstance, ok := raw.(runner.RunnerV2)
 	if !ok {
 		cleanup()
-		return nil, nil, fmt.Errorf("dispensed plugin %q does not implement runner.RunnerV2", dispenseName)
-	}
-	return runnerInstance, cleanup, nil
+		return nil, fmt.Errorf("dispensed plugin %q does not implement runner.RunnerV2", dispenseName)
+	}
+	return &pluginProcess{
+		runner:  runnerInstance,
+		cleanup: cleanup,
+		ping: func() error {
+			if client.Exited() {
+				return errPluginProcessExited
+			}
+			return rpcClient.Ping()
+		},
+	}, nil
 }
 
 // DownloadPlugins checks each item in the config and retrieves the source of the plugin
@@ -1880,59 +2288,104 @@ func (ar *AgentRunner) getRunnerInstance(logger hclog.Logger, path string, proto
 // We return any errors that occurred during the download process. TODO: What is the right
 // error handling here?
 func (ar *AgentRunner) DownloadPlugins(ctx context.Context) error {
+	return ar.downloadPluginsOf(ctx, ar.getConfig().Plugins)
+}
+
+func (ar *AgentRunner) downloadPluginsOf(ctx context.Context, plugins map[string]*agentPlugin) error {
 	logger := ar.getLogger()
-	config := ar.getConfig()
-	// Build a set of unique plugin sources
-	pluginSources := map[string]struct{}{}
+	// Build a set of unique plugin sources, with every set of download options used for each
+	pluginSources := map[string]map[string]internal.DownloadOptions{}
 
-	for _, pluginConfig := range config.Plugins {
-		pluginSources[pluginConfig.Source] = struct{}{}
+	for _, pluginConfig := range plugins {
+		addSourceDownload(pluginSources, pluginConfig.Source, pluginConfig.pluginDownloadOptions())
 	}
 
-	for source := range pluginSources {
+	for source, downloads := range pluginSources {
 		platform := v1.Platform{
 			Architecture: runtime.GOARCH,
 			OS:           runtime.GOOS,
 		}
-		out, err := ar.download(ctx, source, AgentPluginDir, "plugin", platformDownloadKey(platform), logger, remote.WithPlatform(platform))
+		for _, opts := range downloads {
+			out, err := ar.download(ctx, source, AgentPluginDir, "plugin", platformDownloadKey(platform), opts, logger, remote.WithPlatform(platform))
 
-		if err != nil {
-			ar.markPluginsWithSourceFailed(source, err)
-			return err
-		}
+			if err != nil {
+				ar.markPluginsWithSourceFailed(source, err)
+				return err
+			}
 
-		ar.pluginLocations[source] = out
+			ar.locationMu.Lock()
+			ar.pluginLocations[source] = out.Path
+			ar.pluginDigests[source] = out.Digest
+			ar.locationMu.Unlock()
+		}
 	}
 
 	return nil
 }
 
 func (ar *AgentRunner) DownloadPolicies(ctx context.Context) error {
+	return ar.downloadPoliciesOf(ctx, ar.getConfig().Plugins)
+}
+
+func (ar *AgentRunner) downloadPoliciesOf(ctx context.Context, plugins map[string]*agentPlugin) error {
 	logger := ar.getLogger()
-	config := ar.getConfig()
-	// Build a set of unique policy sources
-	policySources := map[string]struct{}{}
+	// Build a set of unique policy sources, with every set of download options used for each
+	policySources := map[string]map[string]internal.DownloadOptions{}
 
-	for _, pluginConfig := range config.Plugins {
+	for _, pluginConfig := range plugins {
 		for _, policy := range pluginConfig.Policies {
-			policySources[string(policy)] = struct{}{}
+			addSourceDownload(policySources, string(policy), pluginConfig.policyDownloadOptions())
 		}
 	}
 
-	for source := range policySources {
-		out, err := ar.download(ctx, source, AgentPolicyDir, "policies", "", logger)
+	for source, downloads := range policySources {
+		for _, opts := range downloads {
+			out, err := ar.download(ctx, source, AgentPolicyDir, "policies", "", opts, logger)
 
-		if err != nil {
-			ar.markPluginsWithPolicyFailed(agentPolicy(source), err)
-			return err
-		}
+			if err != nil {
+				ar.markPluginsWithPolicyFailed(agentPolicy(source), err)
+				return err
+			}
 
-		ar.policyLocations[source] = out
+			ar.locationMu.Lock()
+			ar.policyLocations[source] = out.Path
+			ar.policyDigests[source] = out.Digest
+			ar.locationMu.Unlock()
+		}
 	}
 
 	return nil
 }
 
+func (ar *AgentRunner) pluginLocation(source string) string {
+	ar.locationMu.RLock()
+	defer ar.locationMu.RUnlock()
+
+	return ar.pluginLocations[source]
+}
+
+func (ar *AgentRunner) policyLocation(source string) string {
+	ar.locationMu.RLock()
+	defer ar.locationMu.RUnlock()
+
+	return ar.policyLocations[source]
+}
+
+// downloadedDigests returns the digests of the downloaded source and policies of a
+// plugin. Local sources and policies have no digest.
+func (ar *AgentRunner) downloadedDigests(pluginConfig *agentPlugin) (string, map[string]string) {
+	ar.locationMu.RLock()
+	defer ar.locationMu.RUnlock()
+
+	policyDigests := map[string]string{}
+	for _, policy := range pluginConfig.Policies {
+		if digest := ar.policyDigests[string(policy)]; digest != "" {
+			policyDigests[string(policy)] = digest
+		}
+	}
+	return ar.pluginDigests[pluginConfig.Source], policyDigests
+}
+
 func platformDownloadKey(platform v1.Platform) string {
 	return strings.Join([]string{platform.OS, platform.Architecture, platform.Variant}, "/")
 }
@@ -1963,6 +2416,7 @@ func (ar *AgentRunner) closePluginClients() {
 		}
 	}
 
+	ar.clearPersistentPlugins()
 	logger.Debug("Completed plugin cleanup")
 }
 
@@ -15,6 +15,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/compliance-framework/agent/internal"
 	"github.com/compliance-framework/agent/runner"
 	"github.com/compliance-framework/agent/runner/proto"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
@@ -492,6 +493,59 @@ func TestMergeConfig_RejectsExplicitZeroProtocolVersion(t *testing.T) {
 	}
 }
 
+func TestMergeConfig_ValidatesPullPolicies(t *testing.T) {
+	tests := []struct {
+		name     string
+		plugin   string
+		expected string
+	}{
+		{name: "valid", plugin: "    pull_policy: on-digest-change\n    policy_pull_policy: always\n"},
+		{name: "invalid plugin pull policy", plugin: "    pull_policy: sometimes\n", expected: "plugin mutable has invalid pull_policy"},
+		{name: "invalid policy pull policy", plugin: "    policy_pull_policy: never\n", expected: "plugin mutable has invalid policy_pull_policy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := viper.New()
+			v.SetConfigType("yaml")
+			err := v.ReadConfig(bytes.NewBufferString("api:\n  url: http://localhost:8080\n\nplugins:\n  mutable:\n    source: ghcr.io/some-plugin:latest\n" + tt.plugin))
+			if err != nil {
+				t.Fatalf("Error reading config: %v", err)
+			}
+
+			config, err := mergeConfig(AgentCmd(), v)
+			if err != nil {
+				t.Fatalf("Error merging config: %v", err)
+			}
+
+			err = config.validate()
+			if tt.expected == "" {
+				if err != nil {
+					t.Fatalf("Expected config to be valid, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), tt.expected) {
+				t.Fatalf("Expected error starting with %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestAddSourceDownload_KeepsEachDistinctDownload(t *testing.T) {
+	sources := map[string]map[string]internal.DownloadOptions{}
+	keyed := &agentVerifyConfig{Key: "/etc/ccf/cosign.pub"}
+
+	addSourceDownload(sources, "ghcr.io/shared:v1", internal.DownloadOptions{})
+	addSourceDownload(sources, "ghcr.io/shared:v1", internal.DownloadOptions{Verify: keyed.options()})
+	addSourceDownload(sources, "ghcr.io/shared:v1", internal.DownloadOptions{Verify: keyed.options()})
+	addSourceDownload(sources, "ghcr.io/shared:v1", internal.DownloadOptions{PullPolicy: internal.PullAlways})
+
+	if got := len(sources["ghcr.io/shared:v1"]); got != 3 {
+		t.Fatalf("Expected three distinct downloads of the shared source, got %d", got)
+	}
+}
+
 func TestMergeConfig_RejectsNullPluginConfiguration(t *testing.T) {
 	v := viper.New()
 	v.SetConfigType("yaml")
@@ -589,6 +643,40 @@ func TestResolvePluginProtocols_UsesAnnotationsOnlyForImplicitOCIPlugins(t *test
 	}
 }
 
+func TestResolvePluginProtocols_UsesAnnotationsForDigestOCIPlugins(t *testing.T) {
+	source := "ghcr.io/pinned@sha256:88252198a40099248f5cc3272bc879fade8b7001a2bcb36d7b43aa8f54328714"
+	var lookedUp []string
+	fetchAnnotations := func(fetchCtx context.Context, lookupSource string, option ...remote.Option) (map[string]string, error) {
+		lookedUp = append(lookedUp, lookupSource)
+		return map[string]string{
+			AnnotationProtocolVersionKey: "2",
+		}, nil
+	}
+
+	config := &agentConfig{
+		Plugins: map[string]*agentPlugin{
+			"pinned-oci": {
+				Source:          source,
+				ProtocolVersion: DefaultProtocolVersion,
+				protocolSet:     false,
+			},
+		},
+	}
+
+	runner := NewAgentRunner()
+	runner.fetchAnnotations = fetchAnnotations
+	runner.UpdateConfig(config)
+	runner.resolvePluginProtocols(context.Background())
+
+	if len(lookedUp) != 1 || lookedUp[0] != source {
+		t.Fatalf("Expected one annotation lookup for %q, got %v", source, lookedUp)
+	}
+
+	if got := config.Plugins["pinned-oci"].ProtocolVersion; got != RunnerV2ProtocolVersion {
+		t.Fatalf("Expected pinned-oci protocol version to be %d, got %d", RunnerV2ProtocolVersion, got)
+	}
+}
+
 func TestResolvePluginProtocols_KeepsDefaultWhenLookupFails(t *testing.T) {
 	fetchAnnotations := func(fetchCtx context.Context, source string, option ...remote.Option) (map[string]string, error) {
 		if fetchCtx == nil {
@@ -953,7 +1041,7 @@ func activePluginClientCount(agentRunner *AgentRunner) int {
 
 func TestInitRunner(t *testing.T) {
 	t.Run("skips init for v1", func(t *testing.T) {
-		err := initRunner("test-plugin", DefaultProtocolVersion, &initTestRunner{}, nil, nil, nil)
+		err := initRunner(context.Background(), "test-plugin", DefaultProtocolVersion, &initTestRunner{}, nil, nil, nil)
 		if err != nil {
 			t.Fatalf("initRunner() error = %v, expected nil", err)
 		}
@@ -966,6 +1054,7 @@ func TestInitRunner(t *testing.T) {
 		}
 
 		err := initRunner(
+			context.Background(),
 			"test-plugin",
 			RunnerV2ProtocolVersion,
 			testRunner,
@@ -986,6 +1075,7 @@ func TestInitRunner(t *testing.T) {
 
 	t.Run("wraps unimplemented init for configured v2 plugin", func(t *testing.T) {
 		err := initRunner(
+			context.Background(),
 			"test-plugin",
 			RunnerV2ProtocolVersion,
 			&initTestRunner{initErr: status.Error(codes.Unimplemented, "not implemented")},
@@ -1006,6 +1096,7 @@ func TestInitRunner(t *testing.T) {
 	t.Run("passes through non-unimplemented init errors", func(t *testing.T) {
 		expectedErr := errors.New("boom")
 		err := initRunner(
+			context.Background(),
 			"test-plugin",
 			RunnerV2ProtocolVersion,
 			&initTestRunner{initErr: expectedErr},
@@ -1024,6 +1115,7 @@ func TestConfigureRunner(t *testing.T) {
 		testRunner := &initTestRunner{}
 
 		err := configureRunner(
+			context.Background(),
 			"test-plugin",
 			testRunner,
 			agentPluginConfig{"endpoint": "localhost"},
@@ -1053,6 +1145,7 @@ func TestConfigureRunner(t *testing.T) {
 		testRunner := &initTestRunner{}
 
 		err := configureRunner(
+			context.Background(),
 			"test-plugin",
 			testRunner,
 			nil,
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const defaultMaxConcurrency = 1
+
+// maxConcurrency returns how many plugins a one-shot run executes at the same time.
+func (ac *agentConfig) maxConcurrency() int {
+	if ac == nil || ac.MaxConcurrency <= 0 {
+		return defaultMaxConcurrency
+	}
+
+	return ac.MaxConcurrency
+}
+
+func (ac *agentConfig) validateMaxConcurrency() error {
+	if ac.MaxConcurrency < 0 {
+		return fmt.Errorf("max_concurrency must not be negative")
+	}
+
+	return nil
+}
+
+// pluginRunsError is returned by a one-shot run in which some plugins failed. It lists
+// every failed plugin, so that one broken plugin does not hide the others.
+type pluginRunsError struct {
+	total  int
+	names  []string
+	errors []error
+}
+
+func (e *pluginRunsError) Error() string {
+	failures := make([]string, 0, len(e.names))
+	for i, name := range e.names {
+		failures = append(failures, fmt.Sprintf("plugin %s: %s", name, pluginRunErrorMessage(e.errors[i])))
+	}
+
+	return fmt.Sprintf("%d of %d plugins failed: %s", len(e.names), e.total, strings.Join(failures, "; "))
+}
+
+func (e *pluginRunsError) Unwrap() []error {
+	return e.errors
+}
+
+// runPluginsConcurrently runs each named plugin with at most maxConcurrency running at
+// once. Every plugin is attempted whatever the others return, and the failures are
+// returned together, in the order of names.
+func runPluginsConcurrently(ctx context.Context, names []string, maxConcurrency int, run func(ctx context.Context, name string) error) error {
+	if maxConcurrency < 1 {
+		maxConcurrency = 1
+	}
+
+	results := make([]error, len(names))
+	slots := make(chan struct{}, maxConcurrency)
+	var wg sync.WaitGroup
+	for i, name := range names {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results[i] = run(ctx, name)
+		}()
+	}
+	wg.Wait()
+
+	failed := &pluginRunsError{total: len(names)}
+	for i, err := range results {
+		if err != nil {
+			failed.names = append(failed.names, names[i])
+			failed.errors = append(failed.errors, err)
+		}
+	}
+	if len(failed.names) == 0 {
+		return nil
+	}
+
+	return failed
+}
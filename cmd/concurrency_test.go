@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestRunPluginsConcurrently_BoundsConcurrency(t *testing.T) {
+	names := []string{"a", "b", "c", "d", "e", "f"}
+	var running, peak atomic.Int32
+
+	err := runPluginsConcurrently(context.Background(), names, 2, func(ctx context.Context, name string) error {
+		current := running.Add(1)
+		for {
+			previous := peak.Load()
+			if current <= previous || peak.CompareAndSwap(previous, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		running.Add(-1)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("runPluginsConcurrently() error = %v, expected nil", err)
+	}
+	if peak.Load() != 2 {
+		t.Fatalf("expected at most and at least 2 plugins running at once, got %d", peak.Load())
+	}
+}
+
+func TestRunPluginsConcurrently_AttemptsEveryPluginAndAggregatesFailures(t *testing.T) {
+	names := []string{"a", "broken", "c", "missing"}
+	errBroken := errors.New("eval failed")
+	var mu sync.Mutex
+	attempted := map[string]bool{}
+
+	err := runPluginsConcurrently(context.Background(), names, 1, func(ctx context.Context, name string) error {
+		mu.Lock()
+		attempted[name] = true
+		mu.Unlock()
+
+		switch name {
+		case "broken":
+			return errBroken
+		case "missing":
+			return errors.New("no such file")
+		}
+		return nil
+	})
+
+	if len(attempted) != len(names) {
+		t.Fatalf("expected every plugin to be attempted, got %v", attempted)
+	}
+	expected := "2 of 4 plugins failed: plugin broken: eval failed; plugin missing: no such file"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("runPluginsConcurrently() error = %v, expected %q", err, expected)
+	}
+	if !errors.Is(err, errBroken) {
+		t.Fatal("expected the aggregated error to wrap each plugin error")
+	}
+}
+
+func TestMergeConfig_MaxConcurrency(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		args     []string
+		expected int
+		wantErr  bool
+	}{
+		{name: "default", expected: 1},
+		{name: "config", config: "max_concurrency: 4\n", expected: 4},
+		{name: "flag overrides config", config: "max_concurrency: 4\n", args: []string{"--max-concurrency", "8"}, expected: 8},
+		{name: "negative", config: "max_concurrency: -1\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := viper.New()
+			v.SetConfigType("yaml")
+			err := v.ReadConfig(bytes.NewBufferString("api:\n  url: http://localhost:8080\n" + tt.config))
+			if err != nil {
+				t.Fatalf("Error reading config: %v", err)
+			}
+
+			cmd := AgentCmd()
+			if err := cmd.ParseFlags(tt.args); err != nil {
+				t.Fatalf("ParseFlags() error = %v", err)
+			}
+			config, err := mergeConfig(cmd, v)
+			if err != nil {
+				t.Fatalf("Error merging config: %v", err)
+			}
+
+			err = config.validate()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Expected config to be invalid")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected config to be valid, got %v", err)
+			}
+			if got := config.maxConcurrency(); got != tt.expected {
+				t.Fatalf("maxConcurrency() = %d, expected %d", got, tt.expected)
+			}
+		})
+	}
+}
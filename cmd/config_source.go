@@ -0,0 +1,216 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// agentConfigSource is where the agent reads its configuration from: a config file, a
+// directory of config fragments, or both. Fragments are merged over the config file in
+// lexical order, so that each team can own the fragment configuring its plugins, and the
+// remote config is merged over them all.
+type agentConfigSource struct {
+	file string
+	dir  string
+	// remote, when set, merges the remote config the API assigns to the agent over the
+	// local config, if the local config enables it.
+	remote *remoteConfigLoader
+}
+
+// configSourceFromFlags returns the config source set with --config and --config-dir,
+// with relative paths resolved against the working directory.
+func configSourceFromFlags(cmd *cobra.Command) (agentConfigSource, error) {
+	var source agentConfigSource
+	for _, target := range []struct {
+		flag string
+		path *string
+	}{
+		{flag: "config", path: &source.file},
+		{flag: "config-dir", path: &source.dir},
+	} {
+		value, err := cmd.Flags().GetString(target.flag)
+		if err != nil {
+			return source, err
+		}
+		if value == "" {
+			continue
+		}
+		if *target.path, err = filepath.Abs(value); err != nil {
+			return source, err
+		}
+	}
+
+	if source.file == "" && source.dir == "" {
+		return source, fmt.Errorf("either --config or --config-dir must be set")
+	}
+	return source, nil
+}
+
+func (s agentConfigSource) String() string {
+	switch {
+	case s.file != "" && s.dir != "":
+		return s.file + " and " + s.dir
+	case s.dir != "":
+		return s.dir
+	default:
+		return s.file
+	}
+}
+
+// isConfigFragment reports whether a file in the config directory is merged into the
+// configuration.
+func isConfigFragment(name string) bool {
+	if strings.HasPrefix(filepath.Base(name), ".") {
+		return false
+	}
+	ext := filepath.Ext(name)
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// fragments returns the config fragments in the config directory, in lexical order.
+func (s agentConfigSource) fragments() ([]string, error) {
+	if s.dir == "" {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading config directory: %w", err)
+	}
+
+	var fragments []string
+	for _, entry := range entries {
+		if isConfigFragment(entry.Name()) && !entry.IsDir() {
+			fragments = append(fragments, filepath.Join(s.dir, entry.Name()))
+		}
+	}
+	sort.Strings(fragments)
+	return fragments, nil
+}
+
+// read reads the config file and merges each config fragment into it, with values
+// overridable by CCF_ environment variables. A plugin may only be defined once across
+// the config file and its fragments.
+func (s agentConfigSource) read() (*viper.Viper, error) {
+	v := viper.New()
+	v.SetEnvPrefix("CCF")
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+	if err := bindAgentEnv(v); err != nil {
+		return nil, err
+	}
+
+	pluginFiles := map[string]string{}
+	if s.file != "" {
+		v.SetConfigFile(s.file)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, err
+		}
+		for name := range v.GetStringMap("plugins") {
+			pluginFiles[name] = s.file
+		}
+	}
+
+	fragments, err := s.fragments()
+	if err != nil {
+		return nil, err
+	}
+	for _, fragment := range fragments {
+		fragmentConfig := viper.New()
+		fragmentConfig.SetConfigFile(fragment)
+		if err := fragmentConfig.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("reading config fragment %s: %w", fragment, err)
+		}
+
+		names := make([]string, 0)
+		for name := range fragmentConfig.GetStringMap("plugins") {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if definedIn, ok := pluginFiles[name]; ok {
+				return nil, fmt.Errorf("plugin %s is defined in both %s and %s", name, definedIn, fragment)
+			}
+			pluginFiles[name] = fragment
+		}
+
+		if err := v.MergeConfigMap(fragmentConfig.AllSettings()); err != nil {
+			return nil, fmt.Errorf("merging config fragment %s: %w", fragment, err)
+		}
+	}
+
+	if s.remote != nil {
+		if err := s.remote.mergeInto(v); err != nil {
+			return nil, err
+		}
+	}
+
+	return v, nil
+}
+
+// watch calls onChange when the config file, or any file in the config directory, is
+// written, created, removed or renamed. Editors and Kubernetes replace files rather than
+// writing them, so the directories holding them are watched rather than the files.
+func (s agentConfigSource) watch(logger hclog.Logger, onChange func()) (func() error, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	realFile := ""
+	if s.file != "" {
+		realFile, _ = filepath.EvalSymlinks(s.file)
+		if err := watcher.Add(filepath.Dir(s.file)); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+	if s.dir != "" {
+		if err := watcher.Add(s.dir); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op == fsnotify.Chmod {
+					continue
+				}
+
+				changed := s.dir != "" && filepath.Dir(event.Name) == s.dir
+				if s.file != "" && !changed {
+					// A config file mounted from a Kubernetes ConfigMap is a symlink whose
+					// target is swapped, which only shows as a change of its real path.
+					currentFile, _ := filepath.EvalSymlinks(s.file)
+					changed = filepath.Clean(event.Name) == s.file || currentFile != realFile
+					realFile = currentFile
+				}
+				if changed {
+					logger.Debug("config changed", "path", event.Name)
+					onChange()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Error("Error watching config", "error", err)
+			}
+		}
+	}()
+
+	return watcher.Close, nil
+}
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func writeConfigFile(t *testing.T, path string, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+}
+
+func TestAgentConfigSource_MergesFragmentsInLexicalOrder(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, file, `
+api:
+  url: http://localhost:8080
+max_concurrency: 1
+plugins:
+  base:
+    source: ghcr.io/base:v1
+`)
+	writeConfigFile(t, filepath.Join(dir, "20-network.yaml"), `
+max_concurrency: 4
+plugins:
+  network:
+    source: ghcr.io/network:v1
+`)
+	writeConfigFile(t, filepath.Join(dir, "10-ssh.yml"), `
+max_concurrency: 2
+plugins:
+  ssh:
+    protocol_version: 2
+    source: ghcr.io/ssh:v1
+`)
+	writeConfigFile(t, filepath.Join(dir, "README.md"), "not a fragment")
+	writeConfigFile(t, filepath.Join(dir, ".30-hidden.yaml"), "plugins: [")
+
+	config, err := loadConfig(AgentCmd(), agentConfigSource{file: file, dir: dir})
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+
+	for _, name := range []string{"base", "ssh", "network"} {
+		if config.Plugins[name] == nil {
+			t.Fatalf("expected plugin %s to be configured, got %v", name, config.Plugins)
+		}
+	}
+	if config.MaxConcurrency != 4 {
+		t.Fatalf("expected the last fragment to win, got max_concurrency=%d", config.MaxConcurrency)
+	}
+	if !config.Plugins["ssh"].protocolSet || config.Plugins["ssh"].ProtocolVersion != RunnerV2ProtocolVersion {
+		t.Fatal("expected the protocol_version set in a fragment to be kept")
+	}
+}
+
+func TestAgentConfigSource_RejectsDuplicatePlugins(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		expected string
+	}{
+		{name: "between fragments", expected: "plugin ssh is defined in both"},
+		{name: "between the config file and a fragment", file: "plugins:\n  ssh:\n    source: ghcr.io/ssh:v0\n", expected: "config.yaml and"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			source := agentConfigSource{dir: dir}
+			if tt.file != "" {
+				source.file = filepath.Join(t.TempDir(), "config.yaml")
+				writeConfigFile(t, source.file, tt.file)
+			} else {
+				writeConfigFile(t, filepath.Join(dir, "10-team-a.yaml"), "plugins:\n  ssh:\n    source: ghcr.io/ssh:v1\n")
+			}
+			writeConfigFile(t, filepath.Join(dir, "20-team-b.yaml"), "plugins:\n  ssh:\n    source: ghcr.io/ssh:v2\n")
+
+			_, err := source.read()
+			if err == nil || !strings.Contains(err.Error(), tt.expected) || !strings.Contains(err.Error(), "20-team-b.yaml") {
+				t.Fatalf("read() error = %v, expected it to name both definitions", err)
+			}
+		})
+	}
+}
+
+func TestConfigSourceFromFlags(t *testing.T) {
+	cmd := AgentCmd()
+	if err := cmd.ParseFlags([]string{"--config-dir", "conf.d"}); err != nil {
+		t.Fatalf("ParseFlags() error = %v", err)
+	}
+
+	source, err := configSourceFromFlags(cmd)
+	if err != nil {
+		t.Fatalf("configSourceFromFlags() error = %v", err)
+	}
+	if source.file != "" || !filepath.IsAbs(source.dir) || filepath.Base(source.dir) != "conf.d" {
+		t.Fatalf("unexpected config source %+v", source)
+	}
+
+	if _, err := configSourceFromFlags(AgentCmd()); err == nil {
+		t.Fatal("configSourceFromFlags() error = nil, expected a config to be required")
+	}
+}
+
+func TestAgentConfigSource_WatchesNewFragments(t *testing.T) {
+	dir := t.TempDir()
+	changes := make(chan struct{}, 10)
+	stop, err := agentConfigSource{dir: dir}.watch(hclog.NewNullLogger(), func() {
+		changes <- struct{}{}
+	})
+	if err != nil {
+		t.Fatalf("watch() error = %v", err)
+	}
+	defer stop()
+
+	writeConfigFile(t, filepath.Join(dir, "30-new.yaml"), "plugins:\n  new:\n    source: ghcr.io/new:v1\n")
+
+	select {
+	case <-changes:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for the new fragment to be noticed")
+	}
+}
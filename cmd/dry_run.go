@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/compliance-framework/agent/runner"
+	"github.com/spf13/cobra"
+)
+
+// setupDryRun prepares the agent for a dry run when --dry-run is set. Plugins run as
+// usual, but the evidence and templates they would send to the API, and the agent's own
+// evidence, are written as JSON to the --output directory, or to stdout, with logs moved
+// to stderr so that the two are not mixed.
+func (ar *AgentRunner) setupDryRun(cmd *cobra.Command) error {
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return err
+	}
+	dir, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return err
+	}
+
+	if !dryRun {
+		if dir != "" {
+			return fmt.Errorf("--output can only be used with --dry-run")
+		}
+		return nil
+	}
+
+	output, err := runner.NewDryRunOutput(dir, cmd.OutOrStdout())
+	if err != nil {
+		return err
+	}
+	ar.dryRun = output
+	if dir == "" {
+		ar.logWriter = cmd.ErrOrStderr()
+	}
+	return nil
+}
+
+func (ar *AgentRunner) captureDryRun(record runner.DryRunRecord) error {
+	path, err := ar.dryRun.Write(record)
+	if err != nil {
+		return err
+	}
+
+	ar.getLogger().Info("Captured dry run request instead of sending it", "kind", record.Kind, "path", path)
+	return nil
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/compliance-framework/agent/runner"
+	"github.com/compliance-framework/agent/runner/proto"
+	"github.com/google/uuid"
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestSetupDryRun(t *testing.T) {
+	cmd := AgentCmd()
+	if err := cmd.ParseFlags([]string{"--output", t.TempDir()}); err != nil {
+		t.Fatalf("ParseFlags() error = %v", err)
+	}
+	if err := NewAgentRunner().setupDryRun(cmd); err == nil || !strings.Contains(err.Error(), "--dry-run") {
+		t.Fatalf("setupDryRun() error = %v, expected --output to require --dry-run", err)
+	}
+
+	cmd = AgentCmd()
+	var stderr bytes.Buffer
+	cmd.SetErr(&stderr)
+	if err := cmd.ParseFlags([]string{"--dry-run"}); err != nil {
+		t.Fatalf("ParseFlags() error = %v", err)
+	}
+	agentRunner := NewAgentRunner()
+	if err := agentRunner.setupDryRun(cmd); err != nil {
+		t.Fatalf("setupDryRun() error = %v", err)
+	}
+	if agentRunner.dryRun == nil || agentRunner.logWriter != &stderr {
+		t.Fatal("expected a dry run to stdout to move logs to stderr")
+	}
+}
+
+func TestLoadConfig_DryRunDoesNotRequireAPI(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, file, "plugins:\n  local:\n    source: ghcr.io/local:v1\n")
+	source := agentConfigSource{file: file}
+
+	if _, err := loadConfig(AgentCmd(), source); err == nil {
+		t.Fatal("loadConfig() error = nil, expected the api to be required")
+	}
+
+	cmd := AgentCmd()
+	if err := cmd.ParseFlags([]string{"--dry-run"}); err != nil {
+		t.Fatalf("ParseFlags() error = %v", err)
+	}
+	config, err := loadConfig(cmd, source)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if !config.dryRun {
+		t.Fatal("expected the config to be marked as a dry run")
+	}
+}
+
+func TestDryRun_CapturesPluginAndAgentEvidence(t *testing.T) {
+	dir := t.TempDir()
+	output, err := runner.NewDryRunOutput(dir, nil)
+	if err != nil {
+		t.Fatalf("NewDryRunOutput() error = %v", err)
+	}
+
+	enabled := true
+	config := newTestAgentConfig("http://example.test", nil)
+	config.Outbox = &agentOutboxConfig{Enabled: &enabled, Directory: filepath.Join(dir, "outbox")}
+	agentRunner := NewAgentRunner()
+	agentRunner.dryRun = output
+	agentRunner.UpdateConfig(config)
+
+	if agentRunner.getAPIClient() != nil {
+		t.Fatal("expected no API client during a dry run")
+	}
+	if err := agentRunner.openOutbox(); err != nil || agentRunner.getOutbox() != nil {
+		t.Fatalf("openOutbox() = %v, expected the outbox to stay closed during a dry run", err)
+	}
+
+	helper := agentRunner.newPluginApiHelper(hclog.NewNullLogger(), nil, map[string]string{"_plugin": "test-plugin"}, "test-plugin")
+	if err := helper.CreateEvidence(context.Background(), []*proto.Evidence{{UUID: uuid.NewString(), Title: "Evidence"}}); err != nil {
+		t.Fatalf("CreateEvidence() error = %v", err)
+	}
+	if err := agentRunner.SendAgentRunEvidence(context.Background()); err != nil {
+		t.Fatalf("SendAgentRunEvidence() error = %v", err)
+	}
+
+	captures, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil || len(captures) != 2 {
+		t.Fatalf("expected two captures, got %v (error %v)", captures, err)
+	}
+	if !strings.HasSuffix(captures[0], "-test-plugin-evidence.json") || !strings.HasSuffix(captures[1], "-agent-evidence.json") {
+		t.Fatalf("unexpected captures %v", captures)
+	}
+
+	content, err := os.ReadFile(captures[1])
+	if err != nil {
+		t.Fatalf("os.ReadFile() error = %v", err)
+	}
+	var record runner.DryRunRecord
+	if err := json.Unmarshal(content, &record); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if record.Kind != runner.DryRunKindAgentEvidence || record.Payload == nil {
+		t.Fatalf("unexpected agent evidence record %+v", record)
+	}
+}
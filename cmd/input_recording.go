@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	policyManager "github.com/compliance-framework/agent/policy-manager"
+	"github.com/compliance-framework/agent/runner"
+	"github.com/hashicorp/go-hclog"
+)
+
+const defaultInputRecordingMaxRunsPerPlugin = 10
+
+func (ac *agentConfig) inputRecordingEnabled() bool {
+	if ac == nil || ac.InputRecording == nil || ac.InputRecording.Enabled == nil {
+		return false
+	}
+
+	return *ac.InputRecording.Enabled
+}
+
+func (ac *agentConfig) inputRecordingDirectory() string {
+	if ac == nil || ac.InputRecording == nil || strings.TrimSpace(ac.InputRecording.Directory) == "" {
+		return AgentPolicyInputDir
+	}
+
+	return strings.TrimSpace(ac.InputRecording.Directory)
+}
+
+func (ac *agentConfig) inputRecordingMaxRunsPerPlugin() int {
+	if ac == nil || ac.InputRecording == nil || ac.InputRecording.MaxRunsPerPlugin == 0 {
+		return defaultInputRecordingMaxRunsPerPlugin
+	}
+
+	return ac.InputRecording.MaxRunsPerPlugin
+}
+
+func (ac *agentConfig) validateInputRecording() error {
+	if ac == nil || ac.InputRecording == nil {
+		return nil
+	}
+
+	if ac.InputRecording.MaxRunsPerPlugin < 0 {
+		return fmt.Errorf("input_recording.max_runs_per_plugin must not be negative")
+	}
+	return nil
+}
+
+// recordPolicyInputs wraps a plugin's API helper so that the inputs the plugin evaluates
+// its policies with are stored, along with its policy data, when input recording is
+// enabled. Recording stays local, so it also happens during a dry run.
+func (ar *AgentRunner) recordPolicyInputs(logger hclog.Logger, helper runner.ApiHelper, pluginName string) runner.ApiHelper {
+	config := ar.getConfig()
+	if !config.inputRecordingEnabled() {
+		return helper
+	}
+
+	store, err := runner.NewPolicyInputStore(config.inputRecordingDirectory(), config.inputRecordingMaxRunsPerPlugin())
+	if err != nil {
+		logger.Warn("Policy inputs will not be recorded", "error", err)
+		return helper
+	}
+
+	var policyData map[string]interface{}
+	if pluginConfig := config.Plugins[pluginName]; pluginConfig != nil {
+		policyData = pluginConfig.PolicyData
+	}
+	return runner.NewRecordingApiHelper(logger, helper, store, pluginName, policyData, policyManager.BundleDigest)
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	policyManager "github.com/compliance-framework/agent/policy-manager"
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestInputRecordingConfig(t *testing.T) {
+	config := newTestAgentConfig("http://example.test", nil)
+	if config.inputRecordingEnabled() || config.inputRecordingDirectory() != AgentPolicyInputDir || config.inputRecordingMaxRunsPerPlugin() != defaultInputRecordingMaxRunsPerPlugin {
+		t.Fatal("expected input recording to be disabled, with the default directory and retention")
+	}
+
+	agentRunner := NewAgentRunner()
+	agentRunner.UpdateConfig(config)
+	if _, ok := agentRunner.newPluginApiHelper(hclog.NewNullLogger(), nil, nil, "ssh").(policyManager.InputRecorder); ok {
+		t.Fatal("expected policy inputs not to be recorded while input recording is disabled")
+	}
+
+	config.InputRecording = &agentInputRecordingConfig{MaxRunsPerPlugin: -1}
+	if err := config.validate(); err == nil || !strings.Contains(err.Error(), "input_recording.max_runs_per_plugin") {
+		t.Fatalf("validate() error = %v, expected a negative max_runs_per_plugin to be rejected", err)
+	}
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/compliance-framework/agent/internal"
+	"github.com/hashicorp/go-hclog"
+)
+
+const defaultMetricsPath = "/metrics"
+
+type agentMetricsConfig struct {
+	Listen string `mapstructure:"listen,omitempty"`
+	Path   string `mapstructure:"path,omitempty"`
+}
+
+func (ac *agentConfig) metricsListen() string {
+	if ac == nil || ac.Metrics == nil {
+		return ""
+	}
+
+	return strings.TrimSpace(ac.Metrics.Listen)
+}
+
+func (ac *agentConfig) metricsPath() string {
+	if ac == nil || ac.Metrics == nil || strings.TrimSpace(ac.Metrics.Path) == "" {
+		return defaultMetricsPath
+	}
+
+	return strings.TrimSpace(ac.Metrics.Path)
+}
+
+func (ac *agentConfig) validateMetrics() error {
+	listen := ac.metricsListen()
+	if listen == "" {
+		return nil
+	}
+
+	if _, _, err := net.SplitHostPort(listen); err != nil {
+		return fmt.Errorf("metrics.listen must be host:port: %w", err)
+	}
+
+	if !strings.HasPrefix(ac.metricsPath(), "/") {
+		return fmt.Errorf("metrics.path must start with /")
+	}
+
+	return nil
+}
+
+// metricsServer exposes the agent's Prometheus metrics on a dedicated listener, so that
+// it can be scraped remotely without exposing the local status API.
+type metricsServer struct {
+	server   *http.Server
+	listener net.Listener
+	logger   hclog.Logger
+}
+
+func startMetricsServer(logger hclog.Logger, listen string, path string) (*metricsServer, error) {
+	logger = logger.Named("metrics")
+
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, fmt.Errorf("start metrics endpoint on %q: %w", listen, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET "+path, internal.MetricsHandler())
+
+	s := &metricsServer{
+		server: &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+		listener: listener,
+		logger:   logger,
+	}
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Metrics endpoint stopped unexpectedly", "error", err)
+		}
+	}()
+	logger.Info("Metrics endpoint listening", "address", listener.Addr().String(), "path", path)
+	return s, nil
+}
+
+func (s *metricsServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *metricsServer) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), statusAPIShutdownTimeout)
+	defer cancel()
+
+	if err := s.server.Shutdown(ctx); err != nil {
+		s.logger.Warn("Failed to shut down metrics endpoint cleanly", "error", err)
+	}
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/compliance-framework/agent/internal"
+	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPluginRunMetrics(t *testing.T) {
+	agentRunner := NewAgentRunner()
+
+	succeeded := testutil.ToFloat64(internal.PluginRunsTotal.WithLabelValues("metrics-plugin", internal.MetricOutcomeSuccess))
+	failed := testutil.ToFloat64(internal.PluginRunsTotal.WithLabelValues("metrics-plugin", internal.MetricOutcomeFailure))
+
+	agentRunner.markPluginRunStarted("metrics-plugin")
+	if got := testutil.ToFloat64(internal.PluginRunning.WithLabelValues("metrics-plugin")); got != 1 {
+		t.Fatalf("expected plugin to be reported as running, got %v", got)
+	}
+	agentRunner.markPluginRunFinished("metrics-plugin", nil)
+	if got := testutil.ToFloat64(internal.PluginRunning.WithLabelValues("metrics-plugin")); got != 0 {
+		t.Fatalf("expected plugin to be reported as stopped, got %v", got)
+	}
+
+	agentRunner.markPluginRunStarted("metrics-plugin")
+	agentRunner.markPluginRunFinished("metrics-plugin", errors.New("boom"))
+
+	if got := testutil.ToFloat64(internal.PluginRunsTotal.WithLabelValues("metrics-plugin", internal.MetricOutcomeSuccess)) - succeeded; got != 1 {
+		t.Fatalf("expected 1 successful run, got %v", got)
+	}
+	if got := testutil.ToFloat64(internal.PluginRunsTotal.WithLabelValues("metrics-plugin", internal.MetricOutcomeFailure)) - failed; got != 1 {
+		t.Fatalf("expected 1 failed run, got %v", got)
+	}
+}
+
+func TestMetricsServerServesMetrics(t *testing.T) {
+	server, err := startMetricsServer(hclog.NewNullLogger(), "127.0.0.1:0", "/custom-metrics")
+	if err != nil {
+		t.Fatalf("startMetricsServer() error = %v", err)
+	}
+	defer server.Close()
+
+	internal.PluginRunsTotal.WithLabelValues("served-plugin", internal.MetricOutcomeSuccess).Inc()
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/custom-metrics", server.Addr()))
+	if err != nil {
+		t.Fatalf("GET metrics error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if !strings.Contains(string(body), `ccf_agent_plugin_runs_total{outcome="success",plugin="served-plugin"}`) {
+		t.Fatalf("expected plugin run counter in metrics output, got:\n%s", body)
+	}
+}
+
+func TestMetricsConfigValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		metrics *agentMetricsConfig
+		wantErr bool
+	}{
+		{name: "disabled", metrics: nil},
+		{name: "all interfaces", metrics: &agentMetricsConfig{Listen: ":9464"}},
+		{name: "custom path", metrics: &agentMetricsConfig{Listen: "0.0.0.0:9464", Path: "/prom"}},
+		{name: "missing port", metrics: &agentMetricsConfig{Listen: "0.0.0.0"}, wantErr: true},
+		{name: "relative path", metrics: &agentMetricsConfig{Listen: ":9464", Path: "prom"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &agentConfig{Metrics: tt.metrics}
+			err := config.validateMetrics()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateMetrics() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/compliance-framework/agent/internal"
+	"github.com/compliance-framework/agent/runner"
+	"github.com/compliance-framework/api/sdk"
+	sdktypes "github.com/compliance-framework/api/sdk/types"
+	"github.com/hashicorp/go-hclog"
+	"github.com/robfig/cron/v3"
+)
+
+const defaultOutboxMaxBytes int64 = 100 * 1024 * 1024
+const defaultOutboxReplayInterval = time.Minute
+
+func (ac *agentConfig) outboxEnabled() bool {
+	if ac == nil || ac.Outbox == nil || ac.Outbox.Enabled == nil {
+		return false
+	}
+
+	return *ac.Outbox.Enabled
+}
+
+func (ac *agentConfig) outboxDirectory() string {
+	if ac == nil || ac.Outbox == nil || strings.TrimSpace(ac.Outbox.Directory) == "" {
+		return AgentOutboxDir
+	}
+
+	return strings.TrimSpace(ac.Outbox.Directory)
+}
+
+func (ac *agentConfig) outboxMaxBytes() int64 {
+	if ac == nil || ac.Outbox == nil || ac.Outbox.MaxBytes == 0 {
+		return defaultOutboxMaxBytes
+	}
+
+	return ac.Outbox.MaxBytes
+}
+
+func (ac *agentConfig) outboxReplayInterval() (time.Duration, error) {
+	if ac == nil || ac.Outbox == nil || strings.TrimSpace(ac.Outbox.ReplayInterval) == "" {
+		return defaultOutboxReplayInterval, nil
+	}
+
+	interval, err := time.ParseDuration(strings.TrimSpace(ac.Outbox.ReplayInterval))
+	if err != nil {
+		return 0, fmt.Errorf("outbox.replay_interval must be a valid duration: %w", err)
+	}
+
+	if interval <= 0 {
+		return 0, fmt.Errorf("outbox.replay_interval must be positive")
+	}
+
+	return interval, nil
+}
+
+func (ac *agentConfig) validateOutbox() error {
+	if ac == nil || ac.Outbox == nil {
+		return nil
+	}
+
+	if ac.Outbox.MaxBytes < 0 {
+		return fmt.Errorf("outbox.max_bytes must not be negative")
+	}
+
+	_, err := ac.outboxReplayInterval()
+	return err
+}
+
+// openOutbox prepares the evidence outbox for the current configuration. The outbox is
+// only opened when enabled, otherwise evidence is posted straight to the API.
+func (ar *AgentRunner) openOutbox() error {
+	config := ar.getConfig()
+	logger := ar.getLogger()
+
+	var outbox *runner.EvidenceOutbox
+	if config.outboxEnabled() && ar.dryRun == nil {
+		var err error
+		outbox, err = runner.NewEvidenceOutbox(logger, config.outboxDirectory(), config.outboxMaxBytes())
+		if err != nil {
+			return err
+		}
+		logger.Debug("Evidence outbox enabled", "directory", config.outboxDirectory(), "max_bytes", config.outboxMaxBytes())
+	}
+
+	ar.stateMu.Lock()
+	ar.outbox = outbox
+	ar.stateMu.Unlock()
+	return nil
+}
+
+func (ar *AgentRunner) getOutbox() *runner.EvidenceOutbox {
+	ar.stateMu.RLock()
+	defer ar.stateMu.RUnlock()
+
+	return ar.outbox
+}
+
+// newPluginApiHelper builds the API helper a plugin uses to send results back to the
+// agent, routing evidence through the outbox when one is configured, or capturing it
+// during a dry run. Evidence is counted when the agent keeps evidence counts, and policy
+// inputs are recorded when input recording is enabled.
+func (ar *AgentRunner) newPluginApiHelper(logger hclog.Logger, client *sdk.Client, labels map[string]string, pluginName string) runner.ApiHelper {
+	var helper runner.ApiHelper
+	if ar.dryRun != nil {
+		helper = runner.NewDryRunApiHelper(logger, ar.dryRun, labels, pluginName)
+	} else {
+		apiHelper := runner.NewApiHelper(logger, client, labels, pluginName)
+		apiHelper.SetEvidenceObserver(evidenceMetricsObserver(pluginName))
+		if outbox := ar.getOutbox(); outbox != nil {
+			apiHelper.SetOutbox(outbox)
+		}
+		helper = apiHelper
+	}
+
+	if ar.evidenceCounts != nil {
+		helper = ar.evidenceCounts.wrap(pluginName, helper)
+	}
+	return ar.recordPolicyInputs(logger, helper, pluginName)
+}
+
+// evidenceMetricsObserver counts the evidence a plugin creates in the agent's metrics,
+// by outcome.
+func evidenceMetricsObserver(pluginName string) runner.EvidenceObserver {
+	return func(delivered, queued, failed int) {
+		internal.EvidenceCreatedTotal.WithLabelValues(pluginName, internal.MetricOutcomeSuccess).Add(float64(delivered))
+		internal.EvidenceCreatedTotal.WithLabelValues(pluginName, internal.MetricOutcomeQueued).Add(float64(queued))
+		internal.EvidenceCreatedTotal.WithLabelValues(pluginName, internal.MetricOutcomeFailure).Add(float64(failed))
+	}
+}
+
+// ReplayOutbox sends any queued evidence to the API. It is a no-op when the outbox is
+// disabled.
+func (ar *AgentRunner) ReplayOutbox(ctx context.Context) error {
+	outbox := ar.getOutbox()
+	if outbox == nil {
+		return nil
+	}
+
+	client := ar.getAPIClient()
+	if client == nil {
+		return fmt.Errorf("api client is not configured")
+	}
+
+	_, err := outbox.Replay(ctx, client)
+	return err
+}
+
+func (ar *AgentRunner) setupOutboxCron(ctx context.Context) (*cron.Cron, error) {
+	logger := ar.getLogger()
+	config := ar.getConfig()
+	c := cron.New(cron.WithParser(cron.NewParser(
+		cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor,
+	)))
+	if ar.getOutbox() == nil {
+		return c, nil
+	}
+	if ar.getAPIClient() == nil {
+		logger.Debug("Not replaying queued evidence without an API client")
+		return c, nil
+	}
+
+	interval, err := config.outboxReplayInterval()
+	if err != nil {
+		return nil, err
+	}
+
+	jobLogger := logger.With("job", "outbox_replay", "schedule", "@every "+interval.String())
+	job := cron.NewChain(cron.SkipIfStillRunning(cronLogger{logger: jobLogger})).Then(cron.FuncJob(func() {
+		if err := ar.ReplayOutbox(ctx); err != nil {
+			jobLogger.Warn("Failed to replay queued evidence", "error", err)
+		}
+	}))
+	if _, err := c.AddJob("@every "+interval.String(), job); err != nil {
+		return nil, fmt.Errorf("adding outbox replay schedule: %w", err)
+	}
+	return c, nil
+}
+
+// outboxEvidenceProps reports the outbox queue depth on agent run evidence, so that a
+// backlog of undelivered evidence is visible from the API.
+func (ar *AgentRunner) outboxEvidenceProps() ([]sdktypes.Property, string) {
+	outbox := ar.getOutbox()
+	if outbox == nil {
+		return nil, ""
+	}
+
+	stats, err := outbox.Stats()
+	if err != nil {
+		ar.getLogger().Warn("Failed to read evidence outbox stats", "error", err)
+	}
+
+	props := []sdktypes.Property{
+		{Name: "outbox-queued-batches", Value: strconv.Itoa(stats.Batches)},
+		{Name: "outbox-queued-evidence", Value: strconv.Itoa(stats.Evidence)},
+		{Name: "outbox-queued-bytes", Value: strconv.FormatInt(stats.Bytes, 10)},
+		{Name: "outbox-dropped-evidence", Value: strconv.Itoa(stats.Dropped)},
+	}
+	remarks := fmt.Sprintf("Evidence queued for delivery: %d in %d batches", stats.Evidence, stats.Batches)
+	return props, remarks
+}
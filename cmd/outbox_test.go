@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/compliance-framework/agent/runner"
+	"github.com/compliance-framework/agent/runner/proto"
+	sdktypes "github.com/compliance-framework/api/sdk/types"
+	"github.com/google/uuid"
+	"github.com/hashicorp/go-hclog"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestOutboxConfigDefaultsAndValidation(t *testing.T) {
+	config := &agentConfig{}
+	if config.outboxEnabled() {
+		t.Fatal("expected outbox to be disabled by default")
+	}
+	if got := config.outboxDirectory(); got != AgentOutboxDir {
+		t.Fatalf("expected default outbox directory %q, got %q", AgentOutboxDir, got)
+	}
+	if got := config.outboxMaxBytes(); got != defaultOutboxMaxBytes {
+		t.Fatalf("expected default outbox max bytes %d, got %d", defaultOutboxMaxBytes, got)
+	}
+	if got, err := config.outboxReplayInterval(); err != nil || got != time.Minute {
+		t.Fatalf("expected default replay interval of 1m, got %s (error %v)", got, err)
+	}
+
+	tests := []struct {
+		name   string
+		outbox *agentOutboxConfig
+		errMsg string
+	}{
+		{name: "negative max bytes", outbox: &agentOutboxConfig{MaxBytes: -1}, errMsg: "outbox.max_bytes must not be negative"},
+		{name: "invalid interval", outbox: &agentOutboxConfig{ReplayInterval: "soon"}, errMsg: "outbox.replay_interval must be a valid duration"},
+		{name: "zero interval", outbox: &agentOutboxConfig{ReplayInterval: "0s"}, errMsg: "outbox.replay_interval must be positive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := newTestAgentConfig("http://example.test", nil)
+			config.Outbox = tt.outbox
+			err := config.validate()
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("validate() error = %v, expected %q", err, tt.errMsg)
+			}
+		})
+	}
+}
+
+func TestAgentRunEvidenceReportsOutboxQueueDepth(t *testing.T) {
+	enabled := true
+	config := newTestAgentConfig("http://example.test", nil)
+	config.Outbox = &agentOutboxConfig{
+		Enabled:   &enabled,
+		Directory: t.TempDir(),
+	}
+
+	agentRunner := NewAgentRunner()
+	agentRunner.UpdateConfig(config)
+	if err := agentRunner.openOutbox(); err != nil {
+		t.Fatalf("openOutbox() error = %v", err)
+	}
+
+	if err := agentRunner.getOutbox().Enqueue("plugin-a", []sdktypes.Evidence{
+		{UUID: uuid.New(), Title: "queued-1"},
+		{UUID: uuid.New(), Title: "queued-2"},
+	}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	evidence, err := agentRunner.buildAgentRunEvidence(time.Now().UTC())
+	if err != nil {
+		t.Fatalf("buildAgentRunEvidence() error = %v", err)
+	}
+
+	props := map[string]string{}
+	for _, prop := range evidence.Props {
+		props[prop.Name] = prop.Value
+	}
+	if props["outbox-queued-batches"] != "1" || props["outbox-queued-evidence"] != "2" {
+		t.Fatalf("expected outbox queue depth props, got %v", props)
+	}
+	if evidence.Remarks == nil || !strings.Contains(*evidence.Remarks, "Evidence queued for delivery: 2 in 1 batches") {
+		t.Fatalf("expected remarks to include outbox queue depth, got %v", evidence.Remarks)
+	}
+}
+
+func TestPluginApiHelperQueuesEvidenceWhenAPIUnavailable(t *testing.T) {
+	var evidenceRequests int
+	client := newTestHTTPClient(func(r *http.Request) (*http.Response, error) {
+		evidenceRequests++
+		return jsonResponse(http.StatusBadGateway, ""), nil
+	})
+
+	enabled := true
+	config := newTestAgentConfig("http://example.test", nil)
+	config.Outbox = &agentOutboxConfig{
+		Enabled:   &enabled,
+		Directory: t.TempDir(),
+	}
+
+	agentRunner := NewAgentRunner()
+	agentRunner.httpClient = client
+	agentRunner.UpdateConfig(config)
+	if err := agentRunner.openOutbox(); err != nil {
+		t.Fatalf("openOutbox() error = %v", err)
+	}
+
+	helper := agentRunner.newPluginApiHelper(hclog.NewNullLogger(), agentRunner.getAPIClient(), map[string]string{"_agent": "agent-a"}, "plugin-a")
+	now := time.Now().UTC()
+	if err := helper.CreateEvidence(context.Background(), []*proto.Evidence{
+		{
+			UUID:    uuid.NewString(),
+			Title:   "Evidence",
+			Start:   timestamppb.New(now),
+			End:     timestamppb.New(now),
+			Expires: timestamppb.New(now.Add(time.Hour)),
+		},
+	}); err != nil {
+		t.Fatalf("CreateEvidence() error = %v, expected evidence to be queued", err)
+	}
+	if evidenceRequests != 1 {
+		t.Fatalf("expected one delivery attempt, got %d", evidenceRequests)
+	}
+
+	stats, err := agentRunner.getOutbox().Stats()
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	if stats.Evidence != 1 {
+		t.Fatalf("expected evidence to be queued, got %+v", stats)
+	}
+}
+
+func TestOutboxReplayIsNotScheduledWithoutAPIClient(t *testing.T) {
+	enabled := true
+	config := newTestAgentConfig("http://example.test", nil)
+	config.Outbox = &agentOutboxConfig{
+		Enabled:   &enabled,
+		Directory: t.TempDir(),
+	}
+
+	agentRunner := NewAgentRunner()
+	agentRunner.UpdateConfig(config)
+	if err := agentRunner.openOutbox(); err != nil {
+		t.Fatalf("openOutbox() error = %v", err)
+	}
+	outboxCron, err := agentRunner.setupOutboxCron(context.Background())
+	if err != nil {
+		t.Fatalf("setupOutboxCron() error = %v", err)
+	}
+	if len(outboxCron.Entries()) != 1 {
+		t.Fatalf("expected the replay to be scheduled, got %d entries", len(outboxCron.Entries()))
+	}
+
+	dryRun, err := runner.NewDryRunOutput(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("NewDryRunOutput() error = %v", err)
+	}
+	agentRunner.dryRun = dryRun
+	agentRunner.UpdateConfig(config)
+	outboxCron, err = agentRunner.setupOutboxCron(context.Background())
+	if err != nil {
+		t.Fatalf("setupOutboxCron() error = %v", err)
+	}
+	if len(outboxCron.Entries()) != 0 {
+		t.Fatalf("expected no replay to be scheduled without an API client, got %d entries", len(outboxCron.Entries()))
+	}
+}
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/compliance-framework/agent/runner"
+	"github.com/compliance-framework/agent/runner/proto"
+	"github.com/hashicorp/go-hclog"
+)
+
+var errPluginProcessExited = errors.New("plugin process has exited")
+
+// pluginProcess is a running plugin binary and the runner dispensed from it.
+type pluginProcess struct {
+	runner runner.RunnerV2
+	// cleanup kills the process. It is safe to call more than once.
+	cleanup func()
+	// ping reports whether the process is still running and answering health checks.
+	ping func() error
+}
+
+// persistentPlugin is a plugin process kept alive across daemon runs of a plugin with
+// persistent: true. It is only used by the plugin's own cron job, which never overlaps
+// itself, so its fields need no locking.
+type persistentPlugin struct {
+	generation  string
+	process     *pluginProcess
+	initialized bool
+}
+
+// persistentPluginGeneration identifies what a persistent process was configured and
+// initialised with. A process from another generation is replaced rather than reused.
+func persistentPluginGeneration(executable string, digest string, policyDigests map[string]string, configHash string) string {
+	parts := []string{executable, digest, configHash}
+	policies := make([]string, 0, len(policyDigests))
+	for policy, policyDigest := range policyDigests {
+		policies = append(policies, policy+"="+policyDigest)
+	}
+	sort.Strings(policies)
+
+	return strings.Join(append(parts, policies...), "\x00")
+}
+
+// persistentPluginProcess returns the live process of a persistent plugin, starting one
+// if there is none yet, the running one is from another generation, or it fails its
+// health check.
+func (ar *AgentRunner) persistentPluginProcess(name string, generation string, executable string, protocolVersion int32, logger hclog.Logger) (*persistentPlugin, error) {
+	ar.persistentPluginMu.Lock()
+	existing := ar.persistentPlugins[name]
+	ar.persistentPluginMu.Unlock()
+
+	if existing != nil {
+		if existing.generation != generation {
+			logger.Info("Plugin source or configuration changed, restarting persistent plugin process")
+		} else if err := existing.process.ping(); err != nil {
+			logger.Warn("Persistent plugin process failed its health check, restarting", "error", err)
+		} else {
+			return existing, nil
+		}
+		ar.discardPersistentPlugin(name, existing)
+	}
+
+	startProcess := ar.startPluginProcess
+	if ar.startPluginProcessFunc != nil {
+		startProcess = ar.startPluginProcessFunc
+	}
+	process, err := startProcess(logger, executable, protocolVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &persistentPlugin{generation: generation, process: process}
+	ar.persistentPluginMu.Lock()
+	ar.persistentPlugins[name] = entry
+	ar.persistentPluginMu.Unlock()
+	return entry, nil
+}
+
+// discardPersistentPlugin kills a persistent plugin process, so that the next run of the
+// plugin starts a new one.
+func (ar *AgentRunner) discardPersistentPlugin(name string, entry *persistentPlugin) {
+	ar.persistentPluginMu.Lock()
+	if ar.persistentPlugins[name] == entry {
+		delete(ar.persistentPlugins, name)
+	}
+	ar.persistentPluginMu.Unlock()
+
+	entry.process.cleanup()
+}
+
+func (ar *AgentRunner) clearPersistentPlugins() {
+	ar.persistentPluginMu.Lock()
+	defer ar.persistentPluginMu.Unlock()
+	ar.persistentPlugins = map[string]*persistentPlugin{}
+}
+
+// runPersistentPlugin runs a plugin in its long lived process. Configure and Init are
+// only called when the process is started for a new generation, and later runs only call
+// Eval. A process that fails, or dies during a run, is replaced on the next run.
+func (ar *AgentRunner) runPersistentPlugin(ctx context.Context, name string, plugin *agentPlugin, generation string, executable string, policyPaths []string, resultsHelper runner.ApiHelper, logger hclog.Logger) error {
+	entry, err := ar.persistentPluginProcess(name, generation, executable, plugin.ProtocolVersion, logger)
+	if err != nil {
+		return err
+	}
+	discard := func() { ar.discardPersistentPlugin(name, entry) }
+
+	timeout := plugin.timeout
+	runCtx, cancelRun := pluginRunContext(ctx, timeout, discard, logger)
+	defer cancelRun()
+
+	policyBehaviorProto := policyBehaviorToProto(plugin.PolicyBehavior)
+	if !entry.initialized {
+		if err := configureRunner(runCtx, name, entry.process.runner, plugin.Config, plugin.PolicyData, plugin.PolicyBehavior); err != nil {
+			discard()
+			return pluginTimeoutError(runCtx, name, timeout, err)
+		}
+		if err := initRunner(runCtx, name, plugin.ProtocolVersion, entry.process.runner, policyPaths, policyBehaviorProto, resultsHelper); err != nil {
+			discard()
+			return pluginTimeoutError(runCtx, name, timeout, err)
+		}
+		entry.initialized = true
+	}
+
+	_, err = runnerEval(runCtx, entry.process.runner, &proto.EvalRequest{
+		PolicyPaths:    policyPaths,
+		PolicyBehavior: policyBehaviorProto,
+	}, resultsHelper)
+	if err != nil {
+		if pingErr := entry.process.ping(); pingErr != nil {
+			logger.Warn("Persistent plugin process failed during run, it will be restarted on the next run", "error", pingErr)
+			discard()
+		}
+		return pluginTimeoutError(runCtx, name, timeout, err)
+	}
+
+	return nil
+}
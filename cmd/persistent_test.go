@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/compliance-framework/agent/runner"
+	"github.com/compliance-framework/agent/runner/proto"
+	"github.com/hashicorp/go-hclog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type persistentTestRunner struct {
+	initTestRunner
+	evalCalls int
+	evalErr   error
+	// dieOnEval makes the process exit while evaluating, as a crashing plugin would.
+	dieOnEval bool
+	dead      bool
+}
+
+func (r *persistentTestRunner) Eval(request *proto.EvalRequest, a runner.ApiHelper) (*proto.EvalResponse, error) {
+	r.evalCalls++
+	if r.dieOnEval {
+		r.dead = true
+		return nil, errors.New("transport is closing")
+	}
+	return &proto.EvalResponse{}, r.evalErr
+}
+
+// fakePluginProcesses stands in for plugin binaries, recording each process started.
+type fakePluginProcesses struct {
+	started []*persistentTestRunner
+	killed  int
+	pingErr error
+}
+
+func (f *fakePluginProcesses) start(logger hclog.Logger, path string, protocolVersion int32) (*pluginProcess, error) {
+	testRunner := &persistentTestRunner{}
+	f.started = append(f.started, testRunner)
+	killed := false
+	return &pluginProcess{
+		runner: testRunner,
+		cleanup: func() {
+			if !killed {
+				killed = true
+				f.killed++
+			}
+		},
+		ping: func() error {
+			if killed || testRunner.dead {
+				return errPluginProcessExited
+			}
+			return f.pingErr
+		},
+	}, nil
+}
+
+func newPersistentTestAgentRunner() (*AgentRunner, *fakePluginProcesses) {
+	processes := &fakePluginProcesses{}
+	agentRunner := NewAgentRunner()
+	agentRunner.startPluginProcessFunc = processes.start
+	return agentRunner, processes
+}
+
+func TestRunPersistentPlugin_InitialisesOnceAndOnlyEvaluatesLaterRuns(t *testing.T) {
+	agentRunner, processes := newPersistentTestAgentRunner()
+	plugin := &agentPlugin{ProtocolVersion: RunnerV2ProtocolVersion, Persistent: true}
+
+	for range 3 {
+		if err := agentRunner.runPersistentPlugin(context.Background(), "steady", plugin, "gen-1", "/plugins/steady", nil, nil, hclog.NewNullLogger()); err != nil {
+			t.Fatalf("runPersistentPlugin() error = %v, expected nil", err)
+		}
+	}
+
+	if len(processes.started) != 1 {
+		t.Fatalf("expected one plugin process to be started, got %d", len(processes.started))
+	}
+	process := processes.started[0]
+	if process.configureCalls != 1 || process.initCalls != 1 || process.evalCalls != 3 {
+		t.Fatalf("expected Configure and Init once and Eval 3 times, got %d, %d and %d", process.configureCalls, process.initCalls, process.evalCalls)
+	}
+	if processes.killed != 0 {
+		t.Fatalf("expected the plugin process to be kept alive, got %d kills", processes.killed)
+	}
+}
+
+func TestRunPersistentPlugin_RestartsForNewGeneration(t *testing.T) {
+	agentRunner, processes := newPersistentTestAgentRunner()
+	plugin := &agentPlugin{ProtocolVersion: RunnerV2ProtocolVersion, Persistent: true}
+
+	if err := agentRunner.runPersistentPlugin(context.Background(), "changing", plugin, "gen-1", "/plugins/changing", nil, nil, hclog.NewNullLogger()); err != nil {
+		t.Fatalf("runPersistentPlugin() error = %v", err)
+	}
+	if err := agentRunner.runPersistentPlugin(context.Background(), "changing", plugin, "gen-2", "/plugins/changing", nil, nil, hclog.NewNullLogger()); err != nil {
+		t.Fatalf("runPersistentPlugin() error = %v", err)
+	}
+
+	if len(processes.started) != 2 || processes.killed != 1 {
+		t.Fatalf("expected the old process to be replaced, got %d started and %d killed", len(processes.started), processes.killed)
+	}
+	if processes.started[1].initCalls != 1 {
+		t.Fatal("expected the new process to be initialised")
+	}
+}
+
+func TestRunPersistentPlugin_RestartsUnhealthyProcess(t *testing.T) {
+	agentRunner, processes := newPersistentTestAgentRunner()
+	plugin := &agentPlugin{ProtocolVersion: RunnerV2ProtocolVersion, Persistent: true}
+
+	if err := agentRunner.runPersistentPlugin(context.Background(), "crashy", plugin, "gen-1", "/plugins/crashy", nil, nil, hclog.NewNullLogger()); err != nil {
+		t.Fatalf("runPersistentPlugin() error = %v", err)
+	}
+
+	processes.pingErr = errors.New("health check failed")
+	if err := agentRunner.runPersistentPlugin(context.Background(), "crashy", plugin, "gen-1", "/plugins/crashy", nil, nil, hclog.NewNullLogger()); err != nil {
+		t.Fatalf("runPersistentPlugin() error = %v", err)
+	}
+
+	if len(processes.started) != 2 || processes.killed != 1 {
+		t.Fatalf("expected the unhealthy process to be replaced, got %d started and %d killed", len(processes.started), processes.killed)
+	}
+}
+
+func TestRunPersistentPlugin_DiscardsProcessThatDiesDuringRun(t *testing.T) {
+	agentRunner, processes := newPersistentTestAgentRunner()
+	plugin := &agentPlugin{ProtocolVersion: RunnerV2ProtocolVersion, Persistent: true}
+
+	if err := agentRunner.runPersistentPlugin(context.Background(), "dying", plugin, "gen-1", "/plugins/dying", nil, nil, hclog.NewNullLogger()); err != nil {
+		t.Fatalf("runPersistentPlugin() error = %v", err)
+	}
+
+	processes.started[0].dieOnEval = true
+	if err := agentRunner.runPersistentPlugin(context.Background(), "dying", plugin, "gen-1", "/plugins/dying", nil, nil, hclog.NewNullLogger()); err == nil {
+		t.Fatal("runPersistentPlugin() error = nil, expected the failed run to be reported")
+	}
+	if processes.killed != 1 {
+		t.Fatalf("expected the dead process to be discarded, got %d kills", processes.killed)
+	}
+
+	if err := agentRunner.runPersistentPlugin(context.Background(), "dying", plugin, "gen-1", "/plugins/dying", nil, nil, hclog.NewNullLogger()); err != nil {
+		t.Fatalf("runPersistentPlugin() error = %v", err)
+	}
+	if len(processes.started) != 2 || processes.started[1].initCalls != 1 {
+		t.Fatal("expected a new process to be started and initialised after the crash")
+	}
+}
+
+func TestRunPersistentPlugin_KeepsHealthyProcessAfterEvalError(t *testing.T) {
+	agentRunner, processes := newPersistentTestAgentRunner()
+	plugin := &agentPlugin{ProtocolVersion: RunnerV2ProtocolVersion, Persistent: true}
+
+	if err := agentRunner.runPersistentPlugin(context.Background(), "failing", plugin, "gen-1", "/plugins/failing", nil, nil, hclog.NewNullLogger()); err != nil {
+		t.Fatalf("runPersistentPlugin() error = %v", err)
+	}
+	processes.started[0].evalErr = errors.New("policy evaluation failed")
+	if err := agentRunner.runPersistentPlugin(context.Background(), "failing", plugin, "gen-1", "/plugins/failing", nil, nil, hclog.NewNullLogger()); err == nil {
+		t.Fatal("runPersistentPlugin() error = nil, expected eval error")
+	}
+
+	if len(processes.started) != 1 || processes.killed != 0 {
+		t.Fatalf("expected the healthy process to be kept, got %d started and %d killed", len(processes.started), processes.killed)
+	}
+}
+
+func TestRunPersistentPlugin_DiscardsProcessWhenConfigureFails(t *testing.T) {
+	agentRunner, processes := newPersistentTestAgentRunner()
+	plugin := &agentPlugin{ProtocolVersion: RunnerV2ProtocolVersion, Persistent: true}
+	agentRunner.startPluginProcessFunc = func(logger hclog.Logger, path string, protocolVersion int32) (*pluginProcess, error) {
+		process, err := processes.start(logger, path, protocolVersion)
+		processes.started[len(processes.started)-1].configureErr = status.Error(codes.InvalidArgument, "endpoint is required")
+		return process, err
+	}
+
+	err := agentRunner.runPersistentPlugin(context.Background(), "misconfigured", plugin, "gen-1", "/plugins/misconfigured", nil, nil, hclog.NewNullLogger())
+	var configErr *pluginConfigError
+	if !errors.As(err, &configErr) {
+		t.Fatalf("runPersistentPlugin() error = %v, expected configuration error", err)
+	}
+	if processes.killed != 1 {
+		t.Fatalf("expected the unconfigured process to be killed, got %d kills", processes.killed)
+	}
+}
+
+func TestPersistentPluginGeneration(t *testing.T) {
+	base := persistentPluginGeneration("/plugins/a", "sha256:1", map[string]string{"p1": "sha256:2", "p2": "sha256:3"}, "hash")
+
+	if got := persistentPluginGeneration("/plugins/a", "sha256:1", map[string]string{"p2": "sha256:3", "p1": "sha256:2"}, "hash"); got != base {
+		t.Fatal("expected the generation not to depend on policy order")
+	}
+	for _, changed := range []string{
+		persistentPluginGeneration("/plugins/a", "sha256:9", map[string]string{"p1": "sha256:2", "p2": "sha256:3"}, "hash"),
+		persistentPluginGeneration("/plugins/a", "sha256:1", map[string]string{"p1": "sha256:9", "p2": "sha256:3"}, "hash"),
+		persistentPluginGeneration("/plugins/a", "sha256:1", map[string]string{"p1": "sha256:2", "p2": "sha256:3"}, "other"),
+	} {
+		if changed == base {
+			t.Fatal("expected a changed source, policy or configuration to change the generation")
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	policyManager "github.com/compliance-framework/agent/policy-manager"
+	"github.com/compliance-framework/agent/runner"
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	policyOutputTable = "table"
+	policyOutputJSON  = "json"
+)
+
+type policyEvalOptions struct {
+	policies    []string
+	input       string
+	policyData  string
+	labels      []string
+	output      string
+	evalOptions policyManager.EvalOptions
+}
+
+// policyEvalResult is a piece of evidence generated by `policy eval`, with the
+// violations that made it fail.
+type policyEvalResult struct {
+	UUID       string                    `json:"uuid"`
+	Title      string                    `json:"title"`
+	Policy     string                    `json:"policy"`
+	Status     string                    `json:"status"`
+	Violations []policyManager.Violation `json:"violations"`
+	Labels     map[string]string         `json:"labels"`
+}
+
+func PolicyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "policy",
+		Short: "work with policy bundles without a plugin",
+	}
+	cmd.AddCommand(PolicyEvalCmd())
+	cmd.AddCommand(PolicyReplayCmd())
+
+	return cmd
+}
+
+func PolicyEvalCmd() *cobra.Command {
+	opts := &policyEvalOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "eval",
+		Short: "evaluate a policy bundle against input data and print the evidence it generates",
+		Long: `Eval runs a policy bundle against input data the same way plugins do, and prints the evidence it
+generates, with each evidence's status, violations and labels. Nothing is sent to the API, so policy
+authors can iterate on a bundle without building and running a plugin.`,
+		Example: "  ccf-agent policy eval --policy ./bundle.tar.gz --input data.json --policy-data data.yaml",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runPolicyEval(cmd, opts)
+		},
+	}
+
+	cmd.Flags().CountP("verbose", "v", "Enable verbose output")
+	cmd.Flags().StringArrayVarP(&opts.policies, "policy", "p", nil, "Policy bundle directory or archive; may be repeated")
+	cmd.MarkFlagRequired("policy")
+	cmd.Flags().StringVarP(&opts.input, "input", "i", "", "JSON or YAML file with the data a plugin would collect, or - for stdin")
+	cmd.MarkFlagRequired("input")
+	cmd.Flags().StringVar(&opts.policyData, "policy-data", "", "JSON or YAML file with the plugin's policy_data")
+	cmd.Flags().StringArrayVar(&opts.labels, "label", nil, "Label the plugin would add to evidence, in key=value form; may be repeated")
+	cmd.Flags().StringVarP(&opts.output, "output", "o", policyOutputTable, "Output format: table or json")
+	addPolicyEvaluationFlags(cmd, &opts.evalOptions)
+
+	return cmd
+}
+
+// addPolicyEvaluationFlags adds the flags that limit and instrument the evaluation of
+// each policy package. Metrics and profiles are logged.
+func addPolicyEvaluationFlags(cmd *cobra.Command, opts *policyManager.EvalOptions) {
+	cmd.Flags().DurationVar(&opts.Timeout, "timeout", 0, "How long each policy package may take to evaluate before it is reported as an error; 0 means no timeout")
+	cmd.Flags().BoolVar(&opts.Instrument, "instrument", false, "Log OPA metrics for each policy package evaluation")
+	cmd.Flags().BoolVar(&opts.Profile, "profile", false, "Log the expressions each policy package spends the most time evaluating")
+}
+
+func runPolicyEval(cmd *cobra.Command, opts *policyEvalOptions) error {
+	if opts.output != policyOutputTable && opts.output != policyOutputJSON {
+		return fmt.Errorf("unsupported output format %q; supported values are %s and %s", opts.output, policyOutputTable, policyOutputJSON)
+	}
+
+	input, err := readPolicyDataFile(cmd.InOrStdin(), opts.input)
+	if err != nil {
+		return fmt.Errorf("reading input: %w", err)
+	}
+
+	policyData, err := readPolicyDataMapping(cmd.InOrStdin(), opts.policyData)
+	if err != nil {
+		return err
+	}
+
+	labels, err := parseLabels(opts.labels)
+	if err != nil {
+		return err
+	}
+	cmd.SilenceUsage = true
+
+	logger, err := newPolicyLogger(cmd)
+	if err != nil {
+		return err
+	}
+
+	results, evalErr := evaluatePolicies(cmd.Context(), logger, opts.evalOptions, opts.policies, input, policyData, labels)
+	if err := writePolicyEvalResults(cmd.OutOrStdout(), opts.output, results); err != nil {
+		return err
+	}
+	return evalErr
+}
+
+// newPolicyLogger logs to stderr, so that the output of policy commands can be piped.
+func newPolicyLogger(cmd *cobra.Command) (hclog.Logger, error) {
+	verbosity, err := cmd.Flags().GetCount("verbose")
+	if err != nil {
+		return nil, err
+	}
+	return hclog.New(&hclog.LoggerOptions{
+		Name:   "policy",
+		Output: cmd.ErrOrStderr(),
+		Level:  hclog.Level(int32(hclog.Info) - int32(verbosity)),
+	}), nil
+}
+
+// readPolicyDataFile reads a JSON or YAML file, or stdin when path is -, into the
+// generic values policies are evaluated with.
+func readPolicyDataFile(stdin io.Reader, path string) (interface{}, error) {
+	var content []byte
+	var err error
+	if path == "-" {
+		content, err = io.ReadAll(stdin)
+	} else {
+		content, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var data interface{}
+	if err := yaml.Unmarshal(content, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// readPolicyDataMapping reads the policy data file at path, if any.
+func readPolicyDataMapping(stdin io.Reader, path string) (map[string]interface{}, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := readPolicyDataFile(stdin, path)
+	if err != nil {
+		return nil, fmt.Errorf("reading policy data: %w", err)
+	}
+	policyData, ok := data.(map[string]interface{})
+	if !ok && data != nil {
+		return nil, fmt.Errorf("policy data must be a mapping, got %T", data)
+	}
+	return policyData, nil
+}
+
+// evaluatePolicies generates evidence from each policy bundle the same way plugins do.
+// The evidence of every bundle is returned, along with the errors of those that failed.
+func evaluatePolicies(ctx context.Context, logger hclog.Logger, evalOptions policyManager.EvalOptions, policies []string, input interface{}, policyData map[string]interface{}, labels map[string]string) ([]policyEvalResult, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	results := make([]policyEvalResult, 0)
+	var errs []error
+	for _, policyPath := range policies {
+		processor := policyManager.NewPolicyProcessor(logger, labels, nil, nil, nil, nil, nil, policyData)
+		processor.SetEvalOptions(evalOptions)
+		evidence, err := processor.GenerateResults(ctx, policyPath, input)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("policy %s: %w", policyPath, err))
+		}
+
+		for _, evid := range evidence {
+			violations, err := policyManager.ViolationsFromProps(evid.GetProps())
+			if err != nil {
+				errs = append(errs, fmt.Errorf("policy %s: %w", policyPath, err))
+				violations = []policyManager.Violation{}
+			}
+			sort.SliceStable(violations, func(i, j int) bool {
+				return violationName(violations[i]) < violationName(violations[j])
+			})
+			results = append(results, policyEvalResult{
+				UUID:       evid.GetUUID(),
+				Title:      evid.GetTitle(),
+				Policy:     evid.GetLabels()["_policy"],
+				Status:     runner.EvidenceStatusStateFromEnum(evid.GetStatus().GetState()),
+				Violations: violations,
+				Labels:     evid.GetLabels(),
+			})
+		}
+	}
+
+	return results, errors.Join(errs...)
+}
+
+func writePolicyEvalResults(out io.Writer, output string, results []policyEvalResult) error {
+	if output == policyOutputJSON {
+		encoded, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, string(encoded))
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "UUID\tTITLE\tSTATUS\tVIOLATIONS\tLABELS")
+	for _, result := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", result.UUID, result.Title, result.Status, formatViolations(result.Violations), formatLabels(result.Labels))
+	}
+	return w.Flush()
+}
+
+// formatViolations lists violations by ID, or by title when they have none.
+func formatViolations(violations []policyManager.Violation) string {
+	if len(violations) == 0 {
+		return "-"
+	}
+
+	names := make([]string, 0, len(violations))
+	for _, violation := range violations {
+		names = append(names, violationName(violation))
+	}
+	return strings.Join(names, ", ")
+}
+
+func violationName(violation policyManager.Violation) string {
+	return *policyManager.FirstOf(violation.ID, violation.Title, policyManager.Pointer("(untitled)"))
+}
+
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
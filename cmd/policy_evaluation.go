@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	policyManager "github.com/compliance-framework/agent/policy-manager"
+)
+
+func (ac *agentConfig) policyEvaluationTimeout() (time.Duration, error) {
+	if ac == nil || ac.PolicyEvaluation == nil || strings.TrimSpace(ac.PolicyEvaluation.Timeout) == "" {
+		return 0, nil
+	}
+
+	timeout, err := time.ParseDuration(strings.TrimSpace(ac.PolicyEvaluation.Timeout))
+	if err != nil {
+		return 0, fmt.Errorf("policy_evaluation.timeout must be a Go duration such as 30s or 5m: %w", err)
+	}
+	if timeout < 0 {
+		return 0, fmt.Errorf("policy_evaluation.timeout must not be negative")
+	}
+	return timeout, nil
+}
+
+func (ac *agentConfig) validatePolicyEvaluation() error {
+	_, err := ac.policyEvaluationTimeout()
+	return err
+}
+
+// pluginProcessEnv is the environment of a plugin process: the agent's own environment,
+// with its policy evaluation variables replaced by the policy_evaluation configuration.
+// It is nil when policy_evaluation is not configured, so the plugin inherits the agent's
+// environment as is.
+func (ac *agentConfig) pluginProcessEnv() []string {
+	configured := ac.policyEvaluationEnv()
+	if configured == nil {
+		return nil
+	}
+
+	env := make([]string, 0, len(os.Environ())+len(configured))
+	for _, entry := range os.Environ() {
+		name, _, _ := strings.Cut(entry, "=")
+		switch name {
+		case policyManager.EnvEvalTimeout, policyManager.EnvEvalInstrument, policyManager.EnvEvalProfile:
+			continue
+		}
+		env = append(env, entry)
+	}
+	return append(env, configured...)
+}
+
+// policyEvaluationEnv passes the policy_evaluation configuration to a plugin process, for
+// the policy manager to read when the plugin evaluates its policies. Nothing is passed
+// when it is not configured, so the agent's own environment applies.
+func (ac *agentConfig) policyEvaluationEnv() []string {
+	if ac == nil || ac.PolicyEvaluation == nil {
+		return nil
+	}
+
+	// The timeout is passed as configured, for the policy manager to parse, and left out
+	// when it is not set so that packages have no timeout.
+	env := []string{
+		policyManager.EnvEvalInstrument + "=" + strconv.FormatBool(ac.PolicyEvaluation.Instrument),
+		policyManager.EnvEvalProfile + "=" + strconv.FormatBool(ac.PolicyEvaluation.Profile),
+	}
+	if timeout := strings.TrimSpace(ac.PolicyEvaluation.Timeout); timeout != "" {
+		env = append(env, policyManager.EnvEvalTimeout+"="+timeout)
+	}
+	return env
+}
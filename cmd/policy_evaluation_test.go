@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	policyManager "github.com/compliance-framework/agent/policy-manager"
+)
+
+const policyEvaluationTestRunawayPolicy = `package compliance_framework.runaway
+
+import future.keywords.in
+
+title := "Never finishes"
+
+violation[{"id": "impossible"}] if {
+	some a in numbers.range(1, 100000)
+	some b in numbers.range(1, 100000)
+	a + b == -1
+}
+`
+
+func TestPolicyEvaluationConfig(t *testing.T) {
+	config := newTestAgentConfig("http://example.test", nil)
+	if timeout, err := config.policyEvaluationTimeout(); err != nil || timeout != 0 {
+		t.Fatalf("policyEvaluationTimeout() = %s, %v, expected no timeout", timeout, err)
+	}
+	if env := config.policyEvaluationEnv(); env != nil {
+		t.Fatalf("policyEvaluationEnv() = %v, expected nothing to be passed to plugins", env)
+	}
+
+	config.PolicyEvaluation = &agentPolicyEvaluationConfig{Timeout: "5s", Profile: true}
+	env := config.policyEvaluationEnv()
+	for _, expected := range []string{
+		policyManager.EnvEvalTimeout + "=5s",
+		policyManager.EnvEvalInstrument + "=false",
+		policyManager.EnvEvalProfile + "=true",
+	} {
+		if !slices.Contains(env, expected) {
+			t.Fatalf("policyEvaluationEnv() = %v, expected %s", env, expected)
+		}
+	}
+
+	t.Setenv(policyManager.EnvEvalTimeout, "1h")
+	t.Setenv(policyManager.EnvEvalProfile, "false")
+	processEnv := config.pluginProcessEnv()
+	if slices.Contains(processEnv, policyManager.EnvEvalTimeout+"=1h") || slices.Contains(processEnv, policyManager.EnvEvalProfile+"=false") {
+		t.Fatalf("pluginProcessEnv() = %v, expected the host policy evaluation variables to be dropped", processEnv)
+	}
+	if !slices.Contains(processEnv, policyManager.EnvEvalTimeout+"=5s") || !slices.Contains(processEnv, "PATH="+os.Getenv("PATH")) {
+		t.Fatalf("pluginProcessEnv() = %v, expected the configured options and the rest of the host environment", processEnv)
+	}
+
+	config.PolicyEvaluation.Timeout = "-1s"
+	if err := config.validate(); err == nil || !strings.Contains(err.Error(), "policy_evaluation.timeout") {
+		t.Fatalf("validate() error = %v, expected a negative timeout to be rejected", err)
+	}
+}
+
+func TestPolicyEval_ReportsTimedOutPackages(t *testing.T) {
+	bundle, input, policyData := writePolicyEvalFixtures(t)
+	writeConfigFile(t, filepath.Join(bundle, "runaway.rego"), policyEvaluationTestRunawayPolicy)
+
+	out, err := executePolicyEval(t, "--policy", bundle, "--input", input, "--policy-data", policyData, "--timeout", "200ms", "-o", "json")
+	if err != nil {
+		t.Fatalf("policy eval error = %v", err)
+	}
+
+	var results []policyEvalResult
+	if err := json.Unmarshal([]byte(out), &results); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v, output %q", err, out)
+	}
+	statuses := map[string]string{}
+	for _, result := range results {
+		statuses[result.Policy] = result.Status
+	}
+	if len(statuses) != 2 || statuses["compliance_framework.runaway"] != "not-satisfied" || statuses["compliance_framework.ssh_ciphers"] != "not-satisfied" {
+		t.Fatalf("expected the runaway package to fail on its own, got %+v", results)
+	}
+}
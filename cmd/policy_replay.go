@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	policyManager "github.com/compliance-framework/agent/policy-manager"
+	"github.com/compliance-framework/agent/runner"
+	"github.com/spf13/cobra"
+)
+
+type policyReplayOptions struct {
+	snapshots   string
+	policy      string
+	baseline    string
+	policyData  string
+	plugins     []string
+	output      string
+	evalOptions policyManager.EvalOptions
+}
+
+// policyReplayResult compares the status of a policy's evidence for a recorded input,
+// before and after a policy bundle change. A status is empty when no evidence was
+// generated, such as for a policy that was added, removed or skipped.
+type policyReplayResult struct {
+	Snapshot   string            `json:"snapshot"`
+	Plugin     string            `json:"plugin"`
+	RecordedAt time.Time         `json:"recorded_at"`
+	Policy     string            `json:"policy"`
+	Title      string            `json:"title"`
+	Labels     map[string]string `json:"labels"`
+	Before     string            `json:"before,omitempty"`
+	After      string            `json:"after,omitempty"`
+	Changed    bool              `json:"changed"`
+}
+
+func PolicyReplayCmd() *cobra.Command {
+	opts := &policyReplayOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "replay",
+		Short: "evaluate a new policy bundle against recorded plugin inputs and show the evidence statuses that change",
+		Long: `Replay evaluates a policy bundle against the plugin inputs recorded by an agent with
+input_recording enabled, and compares the status of each policy's evidence with the status the
+baseline bundle gives for the same input. The baseline is the bundle each input was recorded with,
+unless --baseline is given, and must be given when that bundle has changed since. This shows which evidence a new version of a bundle would flip before
+it is rolled out.`,
+		Example: "  ccf-agent policy replay --snapshots .compliance-framework/policy-inputs --policy ./bundle-v2.tar.gz --baseline ./bundle-v1.tar.gz",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runPolicyReplay(cmd, opts)
+		},
+	}
+
+	cmd.Flags().CountP("verbose", "v", "Enable verbose output")
+	cmd.Flags().StringVarP(&opts.snapshots, "snapshots", "s", AgentPolicyInputDir, "Directory of recorded policy inputs, or a single recorded input file")
+	cmd.Flags().StringVarP(&opts.policy, "policy", "p", "", "Policy bundle directory or archive to replay the inputs against")
+	cmd.MarkFlagRequired("policy")
+	cmd.Flags().StringVar(&opts.baseline, "baseline", "", "Policy bundle to compare with, instead of the bundle each input was recorded with")
+	cmd.Flags().StringVar(&opts.policyData, "policy-data", "", "JSON or YAML file with policy_data to use instead of the recorded policy data")
+	cmd.Flags().StringArrayVar(&opts.plugins, "plugin", nil, "Only replay the inputs of this plugin; may be repeated")
+	cmd.Flags().StringVarP(&opts.output, "output", "o", policyOutputTable, "Output format: table or json")
+	addPolicyEvaluationFlags(cmd, &opts.evalOptions)
+
+	return cmd
+}
+
+func runPolicyReplay(cmd *cobra.Command, opts *policyReplayOptions) error {
+	if opts.output != policyOutputTable && opts.output != policyOutputJSON {
+		return fmt.Errorf("unsupported output format %q; supported values are %s and %s", opts.output, policyOutputTable, policyOutputJSON)
+	}
+
+	files, snapshots, err := runner.ReadPolicyInputSnapshots(opts.snapshots)
+	if err != nil {
+		return fmt.Errorf("reading recorded inputs: %w", err)
+	}
+
+	policyData, err := readPolicyDataMapping(cmd.InOrStdin(), opts.policyData)
+	if err != nil {
+		return err
+	}
+	cmd.SilenceUsage = true
+
+	logger, err := newPolicyLogger(cmd)
+	if err != nil {
+		return err
+	}
+
+	plugins := map[string]bool{}
+	for _, name := range opts.plugins {
+		plugins[name] = true
+	}
+
+	results := make([]policyReplayResult, 0)
+	var errs []error
+	replayed := 0
+	for i, snapshot := range snapshots {
+		if len(plugins) > 0 && !plugins[snapshot.Plugin] {
+			continue
+		}
+		replayed++
+
+		var input interface{}
+		if err := json.Unmarshal(snapshot.Input, &input); err != nil {
+			errs = append(errs, fmt.Errorf("recorded input %s: %w", files[i], err))
+			continue
+		}
+		data := snapshot.PolicyData
+		if opts.policyData != "" {
+			data = policyData
+		}
+		baseline := opts.baseline
+		if baseline == "" {
+			baseline = snapshot.PolicyPath
+			if err := checkRecordedBundle(snapshot); err != nil {
+				errs = append(errs, fmt.Errorf("recorded input %s: %w", files[i], err))
+				continue
+			}
+		}
+
+		before, err := evaluatePolicies(cmd.Context(), logger, opts.evalOptions, []string{baseline}, input, data, snapshot.Labels)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("recorded input %s: baseline %w", files[i], err))
+			continue
+		}
+		after, err := evaluatePolicies(cmd.Context(), logger, opts.evalOptions, []string{opts.policy}, input, data, snapshot.Labels)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("recorded input %s: %w", files[i], err))
+			continue
+		}
+		results = append(results, diffPolicyEvalResults(files[i], snapshot, before, after)...)
+	}
+	if replayed == 0 {
+		errs = append(errs, fmt.Errorf("no recorded inputs found in %s", opts.snapshots))
+	}
+
+	if err := writePolicyReplayResults(cmd.OutOrStdout(), opts.output, results); err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+// checkRecordedBundle makes sure the bundle at the path a snapshot was recorded with is
+// still the bundle it was recorded with, as pulled policies are replaced in place and
+// would otherwise be compared with themselves.
+func checkRecordedBundle(snapshot runner.PolicyInputSnapshot) error {
+	if _, err := os.Stat(snapshot.PolicyPath); err != nil {
+		return fmt.Errorf("the policy bundle it was recorded with is not available, pass --baseline: %w", err)
+	}
+	if snapshot.PolicyDigest == "" {
+		return fmt.Errorf("the digest of the policy bundle it was recorded with is unknown, pass --baseline")
+	}
+	digest, err := policyManager.BundleDigest(snapshot.PolicyPath)
+	if err != nil {
+		return fmt.Errorf("reading the policy bundle it was recorded with: %w", err)
+	}
+	if digest != snapshot.PolicyDigest {
+		return fmt.Errorf("the policy bundle at %s has changed since the input was recorded, pass --baseline", snapshot.PolicyPath)
+	}
+	return nil
+}
+
+// diffPolicyEvalResults pairs the evidence of two evaluations of a recorded input by
+// policy package and labels, as a package reports an evidence per resource when it
+// reports several. Results are in policy and label order.
+func diffPolicyEvalResults(file string, snapshot runner.PolicyInputSnapshot, before, after []policyEvalResult) []policyReplayResult {
+	byKey := map[string]*policyReplayResult{}
+	result := func(evalResult policyEvalResult) *policyReplayResult {
+		key := evalResult.Policy + "\x00" + formatLabels(evalResult.Labels)
+		if byKey[key] == nil {
+			byKey[key] = &policyReplayResult{
+				Snapshot:   file,
+				Plugin:     snapshot.Plugin,
+				RecordedAt: snapshot.RecordedAt,
+				Policy:     evalResult.Policy,
+				Labels:     evalResult.Labels,
+			}
+		}
+		// Titles are taken from the new bundle when it has the policy.
+		byKey[key].Title = evalResult.Title
+		return byKey[key]
+	}
+	for _, evalResult := range before {
+		result(evalResult).Before = evalResult.Status
+	}
+	for _, evalResult := range after {
+		result(evalResult).After = evalResult.Status
+	}
+
+	keys := make([]string, 0, len(byKey))
+	for key := range byKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	results := make([]policyReplayResult, 0, len(keys))
+	for _, key := range keys {
+		replayResult := byKey[key]
+		replayResult.Changed = replayResult.Before != replayResult.After
+		results = append(results, *replayResult)
+	}
+	return results
+}
+
+func writePolicyReplayResults(out io.Writer, output string, results []policyReplayResult) error {
+	if output == policyOutputJSON {
+		encoded, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, string(encoded))
+		return err
+	}
+
+	changed := 0
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PLUGIN\tRECORDED\tPOLICY\tBEFORE\tAFTER\tCHANGED\tLABELS")
+	for _, result := range results {
+		flag := "no"
+		if result.Changed {
+			flag = "yes"
+			changed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", result.Plugin, result.RecordedAt.Format(time.RFC3339), result.Policy,
+			replayStatus(result.Before), replayStatus(result.After), flag, formatLabels(result.Labels))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(out, "\n%d of %d evidence statuses changed\n", changed, len(results))
+	return err
+}
+
+func replayStatus(status string) string {
+	if status == "" {
+		return "-"
+	}
+	return status
+}
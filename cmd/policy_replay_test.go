@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	policyManager "github.com/compliance-framework/agent/policy-manager"
+	"github.com/hashicorp/go-hclog"
+)
+
+// policyReplayTestPolicyV2 also treats CBC ciphers as weak, and adds a policy.
+const policyReplayTestPolicyV2 = `package compliance_framework.ssh_ciphers
+
+import future.keywords.in
+
+title := "SSH only allows strong ciphers"
+
+violation[{"id": "weak-cipher", "title": "Weak cipher enabled"}] if {
+	some cipher in input.ciphers
+	endswith(cipher, "-cbc")
+}
+`
+
+const policyReplayTestPolicyRoot = `package compliance_framework.ssh_root
+
+title := "SSH does not allow root login"
+`
+
+// recordPolicyInput records an input the way a plugin does, through the API helper the
+// agent gives it.
+func recordPolicyInput(t *testing.T, dir, bundle string, input interface{}) {
+	t.Helper()
+
+	enabled := true
+	config := newTestAgentConfig("http://example.test", nil)
+	config.InputRecording = &agentInputRecordingConfig{Enabled: &enabled, Directory: dir}
+	config.Plugins = map[string]*agentPlugin{
+		"ssh": {Source: "./ssh", PolicyData: map[string]interface{}{"weak_ciphers": []interface{}{"3des-cbc"}}},
+	}
+	agentRunner := NewAgentRunner()
+	agentRunner.UpdateConfig(config)
+
+	helper := agentRunner.newPluginApiHelper(hclog.NewNullLogger(), nil, nil, "ssh")
+	recorder, ok := helper.(policyManager.InputRecorder)
+	if !ok {
+		t.Fatal("expected the plugin API helper to record policy inputs")
+	}
+	processor := policyManager.NewPolicyProcessor(hclog.NewNullLogger(), map[string]string{"host": "web-1"}, nil, nil, nil, nil, nil, config.Plugins["ssh"].PolicyData)
+	processor.SetInputRecorder(recorder)
+	if _, err := processor.GenerateResults(context.Background(), bundle, input); err != nil {
+		t.Fatalf("GenerateResults() error = %v", err)
+	}
+}
+
+func executePolicyReplay(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+
+	cmd := PolicyCmd()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs(append([]string{"replay"}, args...))
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func TestPolicyReplay_DiffsStatusesOfRecordedInputs(t *testing.T) {
+	bundle, _, _ := writePolicyEvalFixtures(t)
+	snapshots := filepath.Join(t.TempDir(), "policy-inputs")
+	recordPolicyInput(t, snapshots, bundle, map[string]interface{}{"ciphers": []string{"aes256-ctr", "aes128-cbc"}})
+
+	bundleV2 := filepath.Join(t.TempDir(), "bundle-v2")
+	if err := os.Mkdir(bundleV2, 0o755); err != nil {
+		t.Fatalf("os.Mkdir() error = %v", err)
+	}
+	writeConfigFile(t, filepath.Join(bundleV2, "ssh_ciphers.rego"), policyReplayTestPolicyV2)
+	writeConfigFile(t, filepath.Join(bundleV2, "ssh_root.rego"), policyReplayTestPolicyRoot)
+
+	out, err := executePolicyReplay(t, "--snapshots", snapshots, "--policy", bundleV2, "-o", "json")
+	if err != nil {
+		t.Fatalf("policy replay error = %v", err)
+	}
+	var results []policyReplayResult
+	if err := json.Unmarshal([]byte(out), &results); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v, output %q", err, out)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected a result per policy, got %+v", results)
+	}
+	if ciphers := results[0]; ciphers.Plugin != "ssh" || ciphers.Policy != "compliance_framework.ssh_ciphers" || ciphers.Before != "satisfied" || ciphers.After != "not-satisfied" || !ciphers.Changed {
+		t.Fatalf("expected the cipher evidence to flip, got %+v", ciphers)
+	}
+	if root := results[1]; root.Policy != "compliance_framework.ssh_root" || root.Before != "" || root.After != "satisfied" || !root.Changed {
+		t.Fatalf("expected the new policy to be reported as added, got %+v", root)
+	}
+
+	// Replaying the recorded bundle against itself changes nothing.
+	out, err = executePolicyReplay(t, "--snapshots", snapshots, "--policy", bundle, "--plugin", "ssh")
+	if err != nil {
+		t.Fatalf("policy replay error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "PLUGIN") || !strings.HasSuffix(strings.Join(strings.Fields(lines[1]), " "), "satisfied satisfied no _policy=compliance_framework.ssh_ciphers,host=web-1") || lines[3] != "0 of 1 evidence statuses changed" {
+		t.Fatalf("unexpected table:\n%s", out)
+	}
+}
+
+func TestPolicyReplay_ReportsMissingInputsAndBaselines(t *testing.T) {
+	bundle, _, _ := writePolicyEvalFixtures(t)
+	snapshots := filepath.Join(t.TempDir(), "policy-inputs")
+
+	if _, err := executePolicyReplay(t, "--snapshots", snapshots, "--policy", bundle); err == nil {
+		t.Fatal("policy replay error = nil, expected missing recorded inputs to fail")
+	}
+
+	recordPolicyInput(t, snapshots, bundle, map[string]interface{}{"ciphers": []string{}})
+	if _, err := executePolicyReplay(t, "--snapshots", snapshots, "--policy", bundle, "--plugin", "other"); err == nil || !strings.Contains(err.Error(), "no recorded inputs") {
+		t.Fatalf("policy replay error = %v, expected no inputs to match the plugin", err)
+	}
+
+	// A bundle updated in place is no longer the bundle the input was recorded with.
+	writeConfigFile(t, filepath.Join(bundle, "ssh_root.rego"), policyReplayTestPolicyRoot)
+	if _, err := executePolicyReplay(t, "--snapshots", snapshots, "--policy", bundle); err == nil || !strings.Contains(err.Error(), "has changed") {
+		t.Fatalf("policy replay error = %v, expected the changed baseline to be refused", err)
+	}
+
+	if err := os.RemoveAll(bundle); err != nil {
+		t.Fatalf("os.RemoveAll() error = %v", err)
+	}
+	if _, err := executePolicyReplay(t, "--snapshots", snapshots, "--policy", t.TempDir()); err == nil || !strings.Contains(err.Error(), "--baseline") {
+		t.Fatalf("policy replay error = %v, expected the missing baseline to be reported", err)
+	}
+}
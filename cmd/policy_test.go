@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const policyEvalTestPolicy = `package compliance_framework.ssh_ciphers
+
+import future.keywords.in
+
+title := "SSH only allows strong ciphers"
+
+violation[{"id": "weak-cipher", "title": "Weak cipher enabled", "cipher": cipher}] if {
+	some cipher in input.ciphers
+	cipher in data.weak_ciphers
+}
+`
+
+func writePolicyEvalFixtures(t *testing.T) (string, string, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	bundle := filepath.Join(dir, "bundle")
+	if err := os.Mkdir(bundle, 0o755); err != nil {
+		t.Fatalf("os.Mkdir() error = %v", err)
+	}
+	writeConfigFile(t, filepath.Join(bundle, "ssh_ciphers.rego"), policyEvalTestPolicy)
+	input := filepath.Join(dir, "input.json")
+	writeConfigFile(t, input, `{"ciphers": ["aes256-ctr", "3des-cbc"]}`)
+	policyData := filepath.Join(dir, "data.yaml")
+	writeConfigFile(t, policyData, "weak_ciphers:\n  - 3des-cbc\n")
+	return bundle, input, policyData
+}
+
+func executePolicyEval(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+
+	cmd := PolicyCmd()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs(append([]string{"eval"}, args...))
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func TestPolicyEval_PrintsEvidenceAsJSON(t *testing.T) {
+	bundle, input, policyData := writePolicyEvalFixtures(t)
+
+	out, err := executePolicyEval(t, "--policy", bundle, "--input", input, "--policy-data", policyData, "--label", "host=web-1", "-o", "json")
+	if err != nil {
+		t.Fatalf("policy eval error = %v", err)
+	}
+
+	var results []policyEvalResult
+	if err := json.Unmarshal([]byte(out), &results); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v, output %q", err, out)
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected one evidence, got %+v", results)
+	}
+	result := results[0]
+	if result.Title != "SSH only allows strong ciphers" || result.Status != "not-satisfied" || result.Policy != "compliance_framework.ssh_ciphers" || result.UUID == "" {
+		t.Fatalf("unexpected evidence %+v", result)
+	}
+	if len(result.Violations) != 1 || *result.Violations[0].ID != "weak-cipher" || result.Violations[0].Fields["cipher"] != "3des-cbc" {
+		t.Fatalf("expected the weak cipher violation with the cipher it found, got %+v", result.Violations)
+	}
+	if result.Labels["host"] != "web-1" || result.Labels["_policy"] != "compliance_framework.ssh_ciphers" {
+		t.Fatalf("expected the given labels and the policy label, got %v", result.Labels)
+	}
+}
+
+func TestPolicyEval_PrintsTable(t *testing.T) {
+	bundle, input, _ := writePolicyEvalFixtures(t)
+
+	// Without policy data no cipher is weak, so the evidence is satisfied.
+	out, err := executePolicyEval(t, "--policy", bundle, "--input", input)
+	if err != nil {
+		t.Fatalf("policy eval error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "UUID") {
+		t.Fatalf("expected a header and one row, got:\n%s", out)
+	}
+	if !strings.Contains(lines[1], "SSH only allows strong ciphers  satisfied  -") {
+		t.Fatalf("unexpected row %q", lines[1])
+	}
+}
+
+func TestPolicyEval_ReportsPolicyErrors(t *testing.T) {
+	_, input, _ := writePolicyEvalFixtures(t)
+
+	if _, err := executePolicyEval(t, "--policy", filepath.Join(t.TempDir(), "missing"), "--input", input); err == nil {
+		t.Fatal("policy eval error = nil, expected a missing bundle to fail")
+	}
+	if _, err := executePolicyEval(t, "--policy", "bundle", "--input", input, "-o", "yaml"); err == nil || !strings.Contains(err.Error(), "unsupported output format") {
+		t.Fatalf("policy eval error = %v, expected an unsupported output format", err)
+	}
+}
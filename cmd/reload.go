@@ -0,0 +1,264 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// agentConfigDiff describes how a reloaded configuration differs from the running one.
+type agentConfigDiff struct {
+	Added     []string
+	Removed   []string
+	Changed   []string
+	Unchanged []string
+	// RestartReason is set when the change cannot be applied in place, and the agent has
+	// to be restarted with the new configuration.
+	RestartReason string
+}
+
+func (d agentConfigDiff) empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 && d.RestartReason == ""
+}
+
+// diffAgentConfig compares a reloaded configuration with the running one. Plugins are
+// compared as configured, so a protocol version resolved from OCI annotations does not
+// make an otherwise unchanged plugin look changed.
+func diffAgentConfig(current *agentConfig, next *agentConfig) agentConfigDiff {
+	diff := agentConfigDiff{}
+
+	switch {
+	case current.Daemon != next.Daemon:
+		diff.RestartReason = "daemon changed"
+	case !reflect.DeepEqual(current.ApiConfig, next.ApiConfig):
+		diff.RestartReason = "api changed"
+	case !reflect.DeepEqual(current.AgentEvidence, next.AgentEvidence):
+		diff.RestartReason = "agent_evidence changed"
+	case !reflect.DeepEqual(current.Outbox, next.Outbox):
+		diff.RestartReason = "outbox changed"
+	case !reflect.DeepEqual(current.PolicyEvaluation, next.PolicyEvaluation):
+		diff.RestartReason = "policy_evaluation changed"
+	}
+
+	for name, nextPlugin := range next.Plugins {
+		currentPlugin, ok := current.Plugins[name]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, name)
+		case pluginConfigEqual(currentPlugin, nextPlugin):
+			diff.Unchanged = append(diff.Unchanged, name)
+		default:
+			diff.Changed = append(diff.Changed, name)
+		}
+	}
+	for name := range current.Plugins {
+		if _, ok := next.Plugins[name]; !ok {
+			diff.Removed = append(diff.Removed, name)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	sort.Strings(diff.Unchanged)
+	return diff
+}
+
+func pluginConfigEqual(a *agentPlugin, b *agentPlugin) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return pluginConfigurationHash(a) == pluginConfigurationHash(b)
+}
+
+// pluginConfigurationHash identifies a plugin's configuration as written, ignoring a
+// protocol version that was not configured but resolved from the plugin's annotations.
+func pluginConfigurationHash(plugin *agentPlugin) string {
+	configured := *plugin
+	if !configured.protocolSet {
+		configured.ProtocolVersion = 0
+	}
+
+	payload, err := json.Marshal(configured)
+	if err != nil {
+		payload = []byte(err.Error())
+	}
+	sum := sha256.Sum256(payload)
+	return fmt.Sprintf("%x", sum[:])
+}
+
+// requestReload asks the running daemon to reload its configuration. Requests made while
+// one is pending are merged into it.
+func (ar *AgentRunner) requestReload() {
+	select {
+	case ar.reloadRequests <- struct{}{}:
+	default:
+	}
+}
+
+// reloadConfig loads the configuration again and applies it. A configuration that fails
+// to load or validate is logged and the running configuration is kept. It returns true
+// when the new configuration can only be applied by restarting the agent.
+func (ar *AgentRunner) reloadConfig(ctx context.Context) bool {
+	logger := ar.getLogger()
+	if ar.loadConfigFunc == nil {
+		return true
+	}
+
+	config, err := ar.loadConfigFunc()
+	if err != nil {
+		logger.Error("Reloaded configuration is invalid, keeping the current configuration", "error", err)
+		return false
+	}
+
+	restart, err := ar.applyConfig(ctx, config)
+	if err != nil {
+		logger.Error("Failed to apply reloaded configuration, keeping the current configuration", "error", err)
+		return false
+	}
+	return restart
+}
+
+// applyConfig switches the running daemon to config, rescheduling only the plugins that
+// were added, removed or changed. Artifacts of new and changed plugins are downloaded
+// first, so that the running configuration is kept if they cannot be. Runs already in
+// progress are left to finish. It returns true, without applying anything, when the
+// change can only be applied by restarting the agent.
+func (ar *AgentRunner) applyConfig(ctx context.Context, config *agentConfig) (bool, error) {
+	logger := ar.getLogger()
+	current := ar.getConfig()
+	diff := diffAgentConfig(current, config)
+	if diff.RestartReason != "" {
+		logger.Info("Reloaded configuration requires restarting the agent", "reason", diff.RestartReason)
+		return true, nil
+	}
+	if diff.empty() {
+		logger.Info("Reloaded configuration has no plugin changes")
+	}
+	if current.statusAPIListen() != config.statusAPIListen() || current.metricsListen() != config.metricsListen() || current.metricsPath() != config.metricsPath() {
+		logger.Warn("Changes to status_api and metrics only take effect when the agent is restarted")
+	}
+
+	// Unchanged plugins keep their running configuration, with its resolved protocol
+	// version, so that their scheduled jobs are untouched.
+	for _, name := range diff.Unchanged {
+		config.Plugins[name] = current.Plugins[name]
+	}
+
+	updated := map[string]*agentPlugin{}
+	for _, name := range append(append([]string{}, diff.Added...), diff.Changed...) {
+		updated[name] = config.Plugins[name]
+	}
+	if len(updated) > 0 {
+		ar.resolveProtocolsOf(ctx, updated)
+		if err := ar.downloadPluginsOf(ctx, updated); err != nil {
+			return false, err
+		}
+		if err := ar.downloadPoliciesOf(ctx, updated); err != nil {
+			return false, err
+		}
+	}
+
+	ar.setConfig(config)
+	ar.reconcilePluginRunState(diff)
+	ar.reschedulePlugins(ctx, config, diff)
+
+	logger.Info("Reloaded configuration applied", "added", diff.Added, "removed", diff.Removed, "changed", diff.Changed)
+	return false, nil
+}
+
+// reconcilePluginRunState keeps the run state of unchanged plugins, and starts added and
+// changed plugins as pending.
+func (ar *AgentRunner) reconcilePluginRunState(diff agentConfigDiff) {
+	ar.pluginRunMu.Lock()
+	defer ar.pluginRunMu.Unlock()
+
+	for _, name := range append(append([]string{}, diff.Added...), diff.Changed...) {
+		if ar.pluginRuns[name].Status == pluginRunStatusRunning {
+			continue
+		}
+		ar.pluginRuns[name] = pluginRunRecord{Status: pluginRunStatusPending}
+	}
+}
+
+// reschedulePlugins replaces the cron jobs of changed plugins, adds jobs for added
+// plugins and removes the jobs of removed ones. Removed plugins are cleaned up once any
+// run in progress has finished.
+func (ar *AgentRunner) reschedulePlugins(ctx context.Context, config *agentConfig, diff agentConfigDiff) {
+	logger := ar.getLogger()
+	runPlugin := ar.pluginRunner()
+
+	ar.cronMu.Lock()
+	defer ar.cronMu.Unlock()
+	if ar.pluginCron == nil {
+		return
+	}
+
+	for _, name := range append(append([]string{}, diff.Removed...), diff.Changed...) {
+		if entryID, ok := ar.pluginCronEntries[name]; ok {
+			ar.pluginCron.Remove(entryID)
+			delete(ar.pluginCronEntries, name)
+			delete(ar.pluginJobs, name)
+		}
+	}
+
+	for _, name := range append(append([]string{}, diff.Added...), diff.Changed...) {
+		schedule, job := ar.newPluginJob(ctx, name, config.Plugins[name], runPlugin, logger)
+		entryID, err := ar.pluginCron.AddJob(schedule, job)
+		if err != nil {
+			logger.Error("Error adding plugin schedule", "plugin", name, "schedule", schedule, "error", err)
+			continue
+		}
+		ar.pluginCronEntries[name] = entryID
+		ar.pluginJobs[name] = job
+	}
+
+	for _, name := range diff.Removed {
+		go ar.forgetPlugin(name)
+	}
+}
+
+// forgetPlugin drops the run state and any persistent process of a removed plugin, after
+// waiting for a run in progress to finish.
+func (ar *AgentRunner) forgetPlugin(name string) {
+	runLock := ar.pluginRunLock(name)
+	runLock.Lock()
+	defer runLock.Unlock()
+
+	if config := ar.getConfig(); config != nil {
+		if _, ok := config.Plugins[name]; ok {
+			// Added back by a later reload.
+			return
+		}
+	}
+
+	ar.pluginRunMu.Lock()
+	delete(ar.pluginRuns, name)
+	ar.pluginRunMu.Unlock()
+
+	ar.persistentPluginMu.Lock()
+	entry := ar.persistentPlugins[name]
+	ar.persistentPluginMu.Unlock()
+	if entry != nil {
+		ar.discardPersistentPlugin(name, entry)
+	}
+}
+
+// pluginRunLock returns the lock held while a plugin runs on its schedule. It outlives
+// config reloads, so that a replaced job and its replacement never run at once.
+func (ar *AgentRunner) pluginRunLock(name string) *sync.Mutex {
+	ar.cronMu.Lock()
+	defer ar.cronMu.Unlock()
+
+	runLock, ok := ar.pluginRunLocks[name]
+	if !ok {
+		runLock = &sync.Mutex{}
+		ar.pluginRunLocks[name] = runLock
+	}
+	return runLock
+}
@@ -0,0 +1,258 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/robfig/cron/v3"
+)
+
+func writeTestPluginSource(t *testing.T) string {
+	t.Helper()
+
+	source := filepath.Join(t.TempDir(), "plugin")
+	if err := os.WriteFile(source, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+	return source
+}
+
+func reloadTestConfig(plugins map[string]*agentPlugin) *agentConfig {
+	return &agentConfig{
+		Daemon:  true,
+		Plugins: plugins,
+	}
+}
+
+// newReloadTestAgentRunner returns a daemon with its plugin schedules set up but not
+// started, running plugins with run.
+func newReloadTestAgentRunner(t *testing.T, config *agentConfig, run func(ctx context.Context, name string, pluginConfig *agentPlugin) error) *AgentRunner {
+	t.Helper()
+
+	agentRunner := NewAgentRunner()
+	agentRunner.UpdateConfig(config)
+	agentRunner.logger = hclog.NewNullLogger()
+	agentRunner.runPluginFunc = run
+	if _, err := agentRunner.setupCron(context.Background()); err != nil {
+		t.Fatalf("setupCron() error = %v", err)
+	}
+	return agentRunner
+}
+
+func cronEntries(agentRunner *AgentRunner) map[string]cron.EntryID {
+	agentRunner.cronMu.RLock()
+	defer agentRunner.cronMu.RUnlock()
+
+	entries := map[string]cron.EntryID{}
+	for name, entryID := range agentRunner.pluginCronEntries {
+		entries[name] = entryID
+	}
+	return entries
+}
+
+func noopPluginRun(ctx context.Context, name string, pluginConfig *agentPlugin) error {
+	return nil
+}
+
+func TestDiffAgentConfig(t *testing.T) {
+	hourly := "@hourly"
+	current := reloadTestConfig(map[string]*agentPlugin{
+		"keep":    {Source: "ghcr.io/keep:v1", ProtocolVersion: RunnerV2ProtocolVersion},
+		"change":  {Source: "ghcr.io/change:v1"},
+		"removed": {Source: "ghcr.io/removed:v1"},
+	})
+	next := reloadTestConfig(map[string]*agentPlugin{
+		// The running config resolved protocol_version 2 from annotations.
+		"keep":   {Source: "ghcr.io/keep:v1", ProtocolVersion: DefaultProtocolVersion},
+		"change": {Source: "ghcr.io/change:v1", Schedule: &hourly},
+		"added":  {Source: "ghcr.io/added:v1"},
+	})
+
+	diff := diffAgentConfig(current, next)
+	if !reflect.DeepEqual(diff.Added, []string{"added"}) || !reflect.DeepEqual(diff.Removed, []string{"removed"}) ||
+		!reflect.DeepEqual(diff.Changed, []string{"change"}) || !reflect.DeepEqual(diff.Unchanged, []string{"keep"}) {
+		t.Fatalf("unexpected diff %+v", diff)
+	}
+	if diff.RestartReason != "" {
+		t.Fatalf("expected plugin changes to be applied in place, got restart reason %q", diff.RestartReason)
+	}
+
+	next.ApiConfig = &apiConfig{Url: "http://other.example"}
+	if diff := diffAgentConfig(current, next); diff.RestartReason != "api changed" {
+		t.Fatalf("expected an API change to require a restart, got %q", diff.RestartReason)
+	}
+}
+
+func TestApplyConfig_ReschedulesOnlyChangedPlugins(t *testing.T) {
+	source := writeTestPluginSource(t)
+	minutely := "* * * * *"
+	hourly := "@hourly"
+	agentRunner := newReloadTestAgentRunner(t, reloadTestConfig(map[string]*agentPlugin{
+		"keep":    {Source: source, Schedule: &minutely},
+		"change":  {Source: source, Schedule: &minutely},
+		"removed": {Source: source, Schedule: &minutely},
+	}), noopPluginRun)
+	agentRunner.markPluginRunStarted("keep")
+	agentRunner.markPluginRunFinished("keep", nil)
+	before := cronEntries(agentRunner)
+
+	restart, err := agentRunner.applyConfig(context.Background(), reloadTestConfig(map[string]*agentPlugin{
+		"keep":   {Source: source, Schedule: &minutely},
+		"change": {Source: source, Schedule: &hourly},
+		"added":  {Source: source, Schedule: &minutely},
+	}))
+	if err != nil || restart {
+		t.Fatalf("applyConfig() = %t, %v, expected the change to be applied in place", restart, err)
+	}
+
+	after := cronEntries(agentRunner)
+	if after["keep"] != before["keep"] {
+		t.Fatal("expected the unchanged plugin to keep its cron entry")
+	}
+	if after["change"] == before["change"] || after["change"] == 0 {
+		t.Fatal("expected the changed plugin to be rescheduled")
+	}
+	if _, ok := after["removed"]; ok {
+		t.Fatal("expected the removed plugin to be unscheduled")
+	}
+	if _, ok := after["added"]; !ok {
+		t.Fatal("expected the added plugin to be scheduled")
+	}
+
+	if got := agentRunner.pluginRunRecord("keep").Status; got != pluginRunStatusPassing {
+		t.Fatalf("expected the unchanged plugin to keep its run state, got %q", got)
+	}
+	if got := agentRunner.pluginRunRecord("added").Status; got != pluginRunStatusPending {
+		t.Fatalf("expected the added plugin to be pending, got %q", got)
+	}
+	waitForPluginForgotten(t, agentRunner, "removed")
+}
+
+func TestApplyConfig_LetsRunningEvaluationFinish(t *testing.T) {
+	source := writeTestPluginSource(t)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	finished := make(chan error, 1)
+	agentRunner := newReloadTestAgentRunner(t, reloadTestConfig(map[string]*agentPlugin{
+		"slow": {Source: source},
+	}), func(ctx context.Context, name string, pluginConfig *agentPlugin) error {
+		close(started)
+		select {
+		case <-release:
+			finished <- nil
+		case <-ctx.Done():
+			finished <- ctx.Err()
+		}
+		return nil
+	})
+
+	if err := agentRunner.triggerPluginRun("slow"); err != nil {
+		t.Fatalf("triggerPluginRun() error = %v", err)
+	}
+	<-started
+
+	if _, err := agentRunner.applyConfig(context.Background(), reloadTestConfig(map[string]*agentPlugin{})); err != nil {
+		t.Fatalf("applyConfig() error = %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if got := agentRunner.pluginRunRecord("slow").Status; got != pluginRunStatusRunning {
+		t.Fatalf("expected the removed plugin's run to still be in progress, got %q", got)
+	}
+
+	close(release)
+	select {
+	case err := <-finished:
+		if err != nil {
+			t.Fatalf("expected the running evaluation to finish, got %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for the running evaluation to finish")
+	}
+	waitForPluginForgotten(t, agentRunner, "slow")
+}
+
+func TestApplyConfig_RestartsForAgentLevelChanges(t *testing.T) {
+	source := writeTestPluginSource(t)
+	agentRunner := newReloadTestAgentRunner(t, reloadTestConfig(map[string]*agentPlugin{"keep": {Source: source}}), noopPluginRun)
+	current := agentRunner.getConfig()
+
+	next := reloadTestConfig(map[string]*agentPlugin{"keep": {Source: source}})
+	next.Outbox = &agentOutboxConfig{Directory: t.TempDir()}
+	restart, err := agentRunner.applyConfig(context.Background(), next)
+	if err != nil || !restart {
+		t.Fatalf("applyConfig() = %t, %v, expected a restart to be required", restart, err)
+	}
+	if agentRunner.getConfig() != current {
+		t.Fatal("expected the running configuration to be left for the restart to replace")
+	}
+}
+
+func TestApplyConfig_KeepsCurrentConfigWhenDownloadsFail(t *testing.T) {
+	source := writeTestPluginSource(t)
+	agentRunner := newReloadTestAgentRunner(t, reloadTestConfig(map[string]*agentPlugin{"keep": {Source: source}}), noopPluginRun)
+	current := agentRunner.getConfig()
+	before := cronEntries(agentRunner)
+
+	_, err := agentRunner.applyConfig(context.Background(), reloadTestConfig(map[string]*agentPlugin{
+		"keep":    {Source: source},
+		"missing": {Source: filepath.Join(t.TempDir(), "does-not-exist")},
+	}))
+	if err == nil {
+		t.Fatal("applyConfig() error = nil, expected the missing plugin to fail to download")
+	}
+	if agentRunner.getConfig() != current {
+		t.Fatal("expected the running configuration to be kept")
+	}
+	if !reflect.DeepEqual(cronEntries(agentRunner), before) {
+		t.Fatal("expected the schedules to be left unchanged")
+	}
+}
+
+func TestReloadConfig_KeepsCurrentConfigWhenInvalid(t *testing.T) {
+	source := writeTestPluginSource(t)
+	agentRunner := newReloadTestAgentRunner(t, reloadTestConfig(map[string]*agentPlugin{"keep": {Source: source}}), noopPluginRun)
+	current := agentRunner.getConfig()
+	agentRunner.loadConfigFunc = func() (*agentConfig, error) {
+		return nil, errors.New("plugin keep has invalid timeout")
+	}
+
+	if agentRunner.reloadConfig(context.Background()) {
+		t.Fatal("expected an invalid configuration not to restart the agent")
+	}
+	if agentRunner.getConfig() != current {
+		t.Fatal("expected the running configuration to be kept")
+	}
+}
+
+func TestRequestReload_MergesPendingRequests(t *testing.T) {
+	agentRunner := NewAgentRunner()
+
+	agentRunner.requestReload()
+	agentRunner.requestReload()
+
+	if got := len(agentRunner.reloadRequests); got != 1 {
+		t.Fatalf("expected one pending reload request, got %d", got)
+	}
+}
+
+func waitForPluginForgotten(t *testing.T, agentRunner *AgentRunner, name string) {
+	t.Helper()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		agentRunner.pluginRunMu.RLock()
+		_, ok := agentRunner.pluginRuns[name]
+		agentRunner.pluginRunMu.RUnlock()
+		if !ok {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("expected the run state of removed plugin %s to be dropped", name)
+}
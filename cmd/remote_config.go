@@ -0,0 +1,456 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/compliance-framework/api/sdk"
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/viper"
+)
+
+const defaultRemoteConfigPath = "/api/agent/config"
+const defaultRemoteConfigPollInterval = time.Minute
+
+// remoteConfigRequestTimeout bounds a request for the remote config, on top of the time
+// a long poll is held by the API.
+const remoteConfigRequestTimeout = 30 * time.Second
+
+type agentRemoteConfig struct {
+	Enabled      *bool  `mapstructure:"enabled,omitempty"`
+	Path         string `mapstructure:"path,omitempty"`
+	PollInterval string `mapstructure:"poll_interval,omitempty"`
+	Wait         string `mapstructure:"wait,omitempty"`
+	CacheFile    string `mapstructure:"cache_file,omitempty"`
+}
+
+func (ac *agentConfig) remoteConfigEnabled() bool {
+	if ac == nil || ac.RemoteConfig == nil || ac.RemoteConfig.Enabled == nil {
+		return false
+	}
+
+	return *ac.RemoteConfig.Enabled
+}
+
+// remoteConfigSettings is how the remote config is fetched, as set by the local config.
+type remoteConfigSettings struct {
+	url          string
+	clientID     string
+	clientSecret string
+	path         string
+	pollInterval time.Duration
+	wait         time.Duration
+	cacheFile    string
+}
+
+func (ac *agentConfig) remoteConfigSettings() (remoteConfigSettings, error) {
+	settings := remoteConfigSettings{
+		path:         defaultRemoteConfigPath,
+		pollInterval: defaultRemoteConfigPollInterval,
+		cacheFile:    AgentRemoteConfigCache,
+	}
+	if !ac.remoteConfigEnabled() {
+		return settings, nil
+	}
+
+	if !ac.ApiConfig.hasAuth() {
+		return settings, fmt.Errorf("remote_config requires api.auth, which identifies the agent to the API")
+	}
+	settings.url = strings.TrimSpace(ac.ApiConfig.Url)
+	settings.clientID = strings.TrimSpace(ac.ApiConfig.Auth.ClientID)
+	settings.clientSecret = strings.TrimSpace(ac.ApiConfig.Auth.ClientSecret)
+
+	remote := ac.RemoteConfig
+	if path := strings.TrimSpace(remote.Path); path != "" {
+		settings.path = path
+	}
+	if cacheFile := strings.TrimSpace(remote.CacheFile); cacheFile != "" {
+		settings.cacheFile = cacheFile
+	}
+
+	if raw := strings.TrimSpace(remote.PollInterval); raw != "" {
+		interval, err := time.ParseDuration(raw)
+		if err != nil {
+			return settings, fmt.Errorf("remote_config.poll_interval must be a valid duration: %w", err)
+		}
+		if interval <= 0 {
+			return settings, fmt.Errorf("remote_config.poll_interval must be positive")
+		}
+		settings.pollInterval = interval
+	}
+
+	if raw := strings.TrimSpace(remote.Wait); raw != "" {
+		wait, err := time.ParseDuration(raw)
+		if err != nil {
+			return settings, fmt.Errorf("remote_config.wait must be a valid duration: %w", err)
+		}
+		if wait < 0 {
+			return settings, fmt.Errorf("remote_config.wait must not be negative")
+		}
+		settings.wait = wait
+	}
+
+	return settings, nil
+}
+
+func (ac *agentConfig) validateRemoteConfig() error {
+	_, err := ac.remoteConfigSettings()
+	return err
+}
+
+// remoteConfigDocument is the configuration the API assigns to an agent. Config has the
+// same layout as the config file, and Version changes whenever Config does.
+type remoteConfigDocument struct {
+	Version string                 `json:"version"`
+	Config  map[string]interface{} `json:"config"`
+}
+
+// remoteConfigLoader keeps the latest remote config of the agent, polling the API for
+// changes in the background and calling onChange when there is one.
+type remoteConfigLoader struct {
+	ctx        context.Context
+	httpClient *http.Client
+	logger     hclog.Logger
+	onChange   func()
+
+	mu          sync.Mutex
+	settings    remoteConfigSettings
+	client      *sdk.Client
+	document    *remoteConfigDocument
+	stopPolling context.CancelFunc
+}
+
+func newRemoteConfigLoader(ctx context.Context, httpClient *http.Client, logger hclog.Logger, onChange func()) *remoteConfigLoader {
+	return &remoteConfigLoader{
+		ctx:        ctx,
+		httpClient: httpClient,
+		logger:     logger,
+		onChange:   onChange,
+	}
+}
+
+// mergeInto merges the remote config over the local config read into v, when the local
+// config enables it. The remote config cannot change api or remote_config, which the
+// agent needs to reach the API in the first place, nor the verify and policy_verify
+// settings of plugins in the local config, which it could otherwise weaken.
+func (r *remoteConfigLoader) mergeInto(v *viper.Viper) error {
+	bootstrap := &agentConfig{}
+	if err := v.UnmarshalKey("api", &bootstrap.ApiConfig); err != nil {
+		return err
+	}
+	if err := v.UnmarshalKey("remote_config", &bootstrap.RemoteConfig); err != nil {
+		return err
+	}
+	if !bootstrap.remoteConfigEnabled() {
+		r.stop()
+		return nil
+	}
+
+	settings, err := bootstrap.remoteConfigSettings()
+	if err != nil {
+		return err
+	}
+
+	document := r.current(settings)
+	if document == nil {
+		return nil
+	}
+
+	overrides := make(map[string]interface{}, len(document.Config))
+	for key, value := range document.Config {
+		switch strings.ToLower(key) {
+		case "api", "remote_config":
+			r.logger.Warn("Ignoring remote config setting that can only be set locally", "key", key)
+		case "plugins":
+			overrides[key] = r.withoutLocalVerify(v, value)
+		default:
+			overrides[key] = value
+		}
+	}
+	return v.MergeConfigMap(overrides)
+}
+
+// withoutLocalVerify returns the remote plugins with the verify and policy_verify
+// settings of plugins that are also in the local config read into v left out, so that
+// the local ones apply.
+func (r *remoteConfigLoader) withoutLocalVerify(v *viper.Viper, value interface{}) interface{} {
+	plugins, ok := value.(map[string]interface{})
+	if !ok {
+		return value
+	}
+	local := v.GetStringMap("plugins")
+
+	result := make(map[string]interface{}, len(plugins))
+	for name, plugin := range plugins {
+		settings, ok := plugin.(map[string]interface{})
+		if _, isLocal := local[strings.ToLower(name)]; !ok || !isLocal {
+			result[name] = plugin
+			continue
+		}
+
+		kept := make(map[string]interface{}, len(settings))
+		for key, setting := range settings {
+			switch strings.ToLower(key) {
+			case "verify", "policy_verify":
+				r.logger.Warn("Ignoring remote config setting that can only be set locally", "key", "plugins."+name+"."+key)
+			default:
+				kept[key] = setting
+			}
+		}
+		result[name] = kept
+	}
+	return result
+}
+
+// current returns the latest remote config. The first time, or after the settings
+// change, it is fetched from the API, falling back to the copy cached on disk when the
+// API cannot be reached. It returns nil when neither is available, and the agent runs
+// with its local config until the remote config can be fetched.
+func (r *remoteConfigLoader) current(settings remoteConfigSettings) *remoteConfigDocument {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.client == nil || settings != r.settings {
+		r.stopLocked()
+		r.settings = settings
+		r.client = sdk.NewClient(r.httpClient, &sdk.Config{
+			BaseURL: settings.url,
+			AgentAuth: &sdk.AgentAuthConfig{
+				ClientID:     settings.clientID,
+				ClientSecret: settings.clientSecret,
+			},
+		})
+		r.document = nil
+	}
+
+	if r.document == nil {
+		document, err := fetchRemoteConfig(r.ctx, r.client, settings.path, "", 0)
+		if err == nil && document != nil {
+			r.document = document
+			r.writeCache(settings.cacheFile, document)
+		} else {
+			r.logger.Warn("Failed to fetch remote config, using the cached copy", "error", err, "cache_file", settings.cacheFile)
+			cached, cacheErr := readRemoteConfigCache(settings.cacheFile)
+			if cacheErr != nil {
+				r.logger.Warn("No cached remote config, running with the local config only", "error", cacheErr)
+			}
+			r.document = cached
+		}
+	}
+
+	if r.stopPolling == nil {
+		pollCtx, cancel := context.WithCancel(r.ctx)
+		r.stopPolling = cancel
+		version := ""
+		if r.document != nil {
+			version = r.document.Version
+		}
+		go r.poll(pollCtx, r.client, settings, version)
+	}
+
+	return r.document
+}
+
+func (r *remoteConfigLoader) stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopLocked()
+	r.client = nil
+	r.document = nil
+}
+
+func (r *remoteConfigLoader) stopLocked() {
+	if r.stopPolling != nil {
+		r.stopPolling()
+		r.stopPolling = nil
+	}
+}
+
+// poll asks the API for changes to the remote config until ctx is done. A request is
+// made at most every poll interval, and with wait set the API may hold each request
+// until the config changes.
+func (r *remoteConfigLoader) poll(ctx context.Context, client *sdk.Client, settings remoteConfigSettings, version string) {
+	for {
+		started := time.Now()
+		document, err := fetchRemoteConfig(ctx, client, settings.path, version, settings.wait)
+		if ctx.Err() != nil {
+			return
+		}
+
+		delay := settings.pollInterval - time.Since(started)
+		switch {
+		case err != nil:
+			r.logger.Warn("Failed to poll remote config", "error", err)
+			delay = settings.pollInterval
+		case document != nil && document.Version != version:
+			version = document.Version
+			if r.update(ctx, settings, document) {
+				r.logger.Info("Remote config changed", "version", version)
+				r.onChange()
+			}
+		}
+
+		if delay > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+		}
+	}
+}
+
+// update makes document the latest remote config, unless polling was stopped since it
+// was fetched.
+func (r *remoteConfigLoader) update(ctx context.Context, settings remoteConfigSettings, document *remoteConfigDocument) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if ctx.Err() != nil {
+		return false
+	}
+	r.document = document
+	r.writeCache(settings.cacheFile, document)
+	return true
+}
+
+func (r *remoteConfigLoader) writeCache(cacheFile string, document *remoteConfigDocument) {
+	if err := writeRemoteConfigCache(cacheFile, document); err != nil {
+		r.logger.Warn("Failed to cache remote config", "error", err, "cache_file", cacheFile)
+	}
+}
+
+// fetchRemoteConfig fetches the agent's remote config. When version is set, the API
+// answers 304 Not Modified, and nil is returned, if the config has not changed since, and
+// with wait set it may hold the request for up to wait until it does.
+func fetchRemoteConfig(ctx context.Context, client *sdk.Client, path string, version string, wait time.Duration) (*remoteConfigDocument, error) {
+	query := url.Values{}
+	if version != "" {
+		query.Set("version", version)
+	}
+	if wait > 0 {
+		query.Set("wait", wait.String())
+	}
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, wait+remoteConfigRequestTimeout)
+	defer cancel()
+
+	resp, err := client.NewRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Body != nil {
+		defer resp.Body.Close()
+	}
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, nil
+	case http.StatusOK:
+	default:
+		return nil, unexpectedAPIResponseError(resp)
+	}
+
+	document := &remoteConfigDocument{}
+	if err := json.NewDecoder(resp.Body).Decode(document); err != nil {
+		return nil, fmt.Errorf("decoding remote config: %w", err)
+	}
+	if document.Config == nil {
+		return nil, fmt.Errorf("remote config response has no config")
+	}
+	if err := checkRemoteConfigSecrets(document); err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+// checkRemoteConfigSecrets rejects a remote config that refers to secrets. References
+// are resolved on the agent's host, so the API could otherwise read its files and
+// environment, or run commands on it.
+func checkRemoteConfigSecrets(document *remoteConfigDocument) error {
+	if key := secretReferenceKey("", document.Config); key != "" {
+		return fmt.Errorf("remote config must not refer to secrets, found a reference in %s", key)
+	}
+	return nil
+}
+
+// secretReferenceKey returns the key of the first value under key that refers to a
+// secret, or "" when none does.
+func secretReferenceKey(key string, value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		if hasSecretReference(value) {
+			return key
+		}
+	case map[string]interface{}:
+		for name, nested := range value {
+			if key != "" {
+				name = key + "." + name
+			}
+			if found := secretReferenceKey(name, nested); found != "" {
+				return found
+			}
+		}
+	case []interface{}:
+		for i, nested := range value {
+			if found := secretReferenceKey(fmt.Sprintf("%s[%d]", key, i), nested); found != "" {
+				return found
+			}
+		}
+	}
+	return ""
+}
+
+func readRemoteConfigCache(cacheFile string) (*remoteConfigDocument, error) {
+	content, err := os.ReadFile(cacheFile)
+	if err != nil {
+		return nil, err
+	}
+
+	document := &remoteConfigDocument{}
+	if err := json.Unmarshal(content, document); err != nil {
+		return nil, fmt.Errorf("decoding cached remote config: %w", err)
+	}
+	if err := checkRemoteConfigSecrets(document); err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+// writeRemoteConfigCache replaces the cached remote config, so that a restart finds
+// either the previous or the new copy, never a partial one.
+func writeRemoteConfigCache(cacheFile string, document *remoteConfigDocument) error {
+	content, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(cacheFile), 0o700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(cacheFile), filepath.Base(cacheFile)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), cacheFile)
+}
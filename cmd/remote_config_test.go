@@ -0,0 +1,333 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// remoteConfigAPI serves a remote config that tests can change.
+type remoteConfigAPI struct {
+	mu       sync.Mutex
+	document remoteConfigDocument
+	fail     bool
+	requests []string
+}
+
+func (a *remoteConfigAPI) setDocument(document remoteConfigDocument) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.document = document
+}
+
+func (a *remoteConfigAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/api/auth/agent/token":
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "token", "token_type": "Bearer", "expires_in": 3600})
+	case defaultRemoteConfigPath:
+		a.requests = append(a.requests, r.URL.RawQuery)
+		if a.fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("version") == a.document.Version {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(a.document)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func writeRemoteConfigBootstrap(t *testing.T, apiURL string, cacheFile string) agentConfigSource {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, file, `
+api:
+  url: `+apiURL+`
+  auth:
+    client_id: 7f6f0a4e-3b0c-4e8e-9d59-5c2c8b7f7e21
+    client_secret: secret
+remote_config:
+  enabled: true
+  poll_interval: 10ms
+  cache_file: `+cacheFile+`
+plugins:
+  local:
+    source: ghcr.io/local:v1
+`)
+	return agentConfigSource{file: file}
+}
+
+func remotePluginDocument(version string, plugin string) remoteConfigDocument {
+	return remoteConfigDocument{
+		Version: version,
+		Config: map[string]interface{}{
+			"plugins": map[string]interface{}{
+				plugin: map[string]interface{}{"source": "ghcr.io/" + plugin + ":v1", "protocol_version": 2},
+			},
+			"api": map[string]interface{}{"url": "http://elsewhere.example"},
+		},
+	}
+}
+
+func TestRemoteConfig_MergedOverLocalConfigAndCached(t *testing.T) {
+	api := &remoteConfigAPI{}
+	api.setDocument(remotePluginDocument("v1", "remote"))
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cacheFile := filepath.Join(t.TempDir(), "remote-config.json")
+	source := writeRemoteConfigBootstrap(t, server.URL, cacheFile)
+	source.remote = newRemoteConfigLoader(ctx, server.Client(), hclog.NewNullLogger(), func() {})
+
+	config, err := loadConfig(AgentCmd(), source)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if config.Plugins["local"] == nil || config.Plugins["remote"] == nil {
+		t.Fatalf("expected local and remote plugins, got %v", config.Plugins)
+	}
+	if config.Plugins["remote"].ProtocolVersion != RunnerV2ProtocolVersion || !config.Plugins["remote"].protocolSet {
+		t.Fatal("expected the remote plugin's protocol_version to be kept")
+	}
+	if config.ApiConfig.Url != server.URL {
+		t.Fatalf("expected the remote config not to change the api, got %q", config.ApiConfig.Url)
+	}
+
+	cached, err := readRemoteConfigCache(cacheFile)
+	if err != nil || cached.Version != "v1" {
+		t.Fatalf("readRemoteConfigCache() = %+v, %v, expected the fetched config to be cached", cached, err)
+	}
+}
+
+func TestRemoteConfig_UsesCacheWhenAPIUnreachable(t *testing.T) {
+	api := &remoteConfigAPI{fail: true}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	cacheFile := filepath.Join(t.TempDir(), "remote-config.json")
+	document := remotePluginDocument("v1", "cached")
+	if err := writeRemoteConfigCache(cacheFile, &document); err != nil {
+		t.Fatalf("writeRemoteConfigCache() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	source := writeRemoteConfigBootstrap(t, server.URL, cacheFile)
+	source.remote = newRemoteConfigLoader(ctx, server.Client(), hclog.NewNullLogger(), func() {})
+
+	config, err := loadConfig(AgentCmd(), source)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if config.Plugins["cached"] == nil {
+		t.Fatalf("expected the cached remote plugin, got %v", config.Plugins)
+	}
+}
+
+func TestRemoteConfig_RunsWithLocalConfigWithoutAPIOrCache(t *testing.T) {
+	api := &remoteConfigAPI{fail: true}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	source := writeRemoteConfigBootstrap(t, server.URL, filepath.Join(t.TempDir(), "missing.json"))
+	source.remote = newRemoteConfigLoader(ctx, server.Client(), hclog.NewNullLogger(), func() {})
+
+	config, err := loadConfig(AgentCmd(), source)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if len(config.Plugins) != 1 || config.Plugins["local"] == nil {
+		t.Fatalf("expected only the local plugin, got %v", config.Plugins)
+	}
+}
+
+func TestRemoteConfig_PollsForChanges(t *testing.T) {
+	api := &remoteConfigAPI{}
+	api.setDocument(remotePluginDocument("v1", "first"))
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	changes := make(chan struct{}, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	source := writeRemoteConfigBootstrap(t, server.URL, filepath.Join(t.TempDir(), "remote-config.json"))
+	source.remote = newRemoteConfigLoader(ctx, server.Client(), hclog.NewNullLogger(), func() {
+		changes <- struct{}{}
+	})
+
+	if _, err := loadConfig(AgentCmd(), source); err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+
+	api.setDocument(remotePluginDocument("v2", "second"))
+	select {
+	case <-changes:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for the remote config change to be noticed")
+	}
+
+	config, err := loadConfig(AgentCmd(), source)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if config.Plugins["second"] == nil || config.Plugins["first"] != nil {
+		t.Fatalf("expected the reloaded config to have the new remote plugins, got %v", config.Plugins)
+	}
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if !strings.Contains(strings.Join(api.requests, " "), "version=v1") {
+		t.Fatalf("expected polls to send the current version, got %v", api.requests)
+	}
+}
+
+func TestAgentConfigValidate_RemoteConfigRequiresAuth(t *testing.T) {
+	enabled := true
+	config := &agentConfig{
+		ApiConfig:    &apiConfig{Url: "http://localhost:8080"},
+		RemoteConfig: &agentRemoteConfig{Enabled: &enabled},
+	}
+
+	if err := config.validate(); err == nil || !strings.Contains(err.Error(), "remote_config requires api.auth") {
+		t.Fatalf("validate() error = %v, expected remote_config to require api.auth", err)
+	}
+}
+
+func TestWriteRemoteConfigCache_ReplacesCache(t *testing.T) {
+	cacheFile := filepath.Join(t.TempDir(), "nested", "remote-config.json")
+	for _, version := range []string{"v1", "v2"} {
+		document := remotePluginDocument(version, "plugin")
+		if err := writeRemoteConfigCache(cacheFile, &document); err != nil {
+			t.Fatalf("writeRemoteConfigCache() error = %v", err)
+		}
+	}
+
+	cached, err := readRemoteConfigCache(cacheFile)
+	if err != nil || cached.Version != "v2" {
+		t.Fatalf("readRemoteConfigCache() = %+v, %v, expected the latest config", cached, err)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(cacheFile))
+	if len(entries) != 1 {
+		t.Fatalf("expected no temporary files to be left behind, got %d entries", len(entries))
+	}
+}
+
+func TestRemoteConfig_KeepsLocalVerifySettings(t *testing.T) {
+	api := &remoteConfigAPI{}
+	api.setDocument(remoteConfigDocument{
+		Version: "v1",
+		Config: map[string]interface{}{
+			"plugins": map[string]interface{}{
+				"local": map[string]interface{}{
+					"source":        "ghcr.io/elsewhere:v1",
+					"verify":        map[string]interface{}{"key": "/keys/remote.pub", "ignore_tlog": true},
+					"policy_verify": map[string]interface{}{"key": "/keys/remote.pub"},
+				},
+				"remote": map[string]interface{}{
+					"source": "ghcr.io/remote:v1",
+					"verify": map[string]interface{}{"key": "/keys/remote.pub"},
+				},
+			},
+		},
+	})
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, file, `
+api:
+  url: `+server.URL+`
+  auth:
+    client_id: 7f6f0a4e-3b0c-4e8e-9d59-5c2c8b7f7e21
+    client_secret: secret
+remote_config:
+  enabled: true
+  cache_file: `+filepath.Join(t.TempDir(), "remote-config.json")+`
+plugins:
+  local:
+    source: ghcr.io/local:v1
+    verify:
+      key: /keys/local.pub
+`)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	source := agentConfigSource{file: file}
+	source.remote = newRemoteConfigLoader(ctx, server.Client(), hclog.NewNullLogger(), func() {})
+
+	config, err := loadConfig(AgentCmd(), source)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	local := config.Plugins["local"]
+	if local.Source != "ghcr.io/elsewhere:v1" {
+		t.Fatalf("expected the remote config to change the source, got %q", local.Source)
+	}
+	if local.Verify == nil || local.Verify.Key != "/keys/local.pub" || local.Verify.IgnoreTlog {
+		t.Fatalf("expected the local verify settings to be kept, got %+v", local.Verify)
+	}
+	if local.PolicyVerify != nil {
+		t.Fatalf("expected the remote config not to set policy_verify for a local plugin, got %+v", local.PolicyVerify)
+	}
+	if remote := config.Plugins["remote"]; remote == nil || remote.Verify == nil || remote.Verify.Key != "/keys/remote.pub" {
+		t.Fatalf("expected the remote plugin to keep its verify settings, got %+v", remote)
+	}
+}
+
+func TestRemoteConfig_RejectsSecretReferences(t *testing.T) {
+	document := remotePluginDocument("v1", "remote")
+	document.Config["plugins"].(map[string]interface{})["remote"].(map[string]interface{})["config"] = map[string]interface{}{
+		"password": "${exec:cat /etc/shadow}",
+	}
+	api := &remoteConfigAPI{}
+	api.setDocument(document)
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cacheFile := filepath.Join(t.TempDir(), "remote-config.json")
+	source := writeRemoteConfigBootstrap(t, server.URL, cacheFile)
+	source.remote = newRemoteConfigLoader(ctx, server.Client(), hclog.NewNullLogger(), func() {})
+
+	config, err := loadConfig(AgentCmd(), source)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if config.Plugins["remote"] != nil {
+		t.Fatalf("expected a remote config with secret references to be rejected, got %v", config.Plugins)
+	}
+	if _, err := os.Stat(cacheFile); !os.IsNotExist(err) {
+		t.Fatalf("expected a rejected remote config not to be cached, stat error = %v", err)
+	}
+
+	if err := writeRemoteConfigCache(cacheFile, &document); err != nil {
+		t.Fatalf("writeRemoteConfigCache() error = %v", err)
+	}
+	if _, err := readRemoteConfigCache(cacheFile); err == nil || !strings.Contains(err.Error(), "plugins.remote.config.password") {
+		t.Fatalf("readRemoteConfigCache() error = %v, expected the secret reference to be rejected", err)
+	}
+}
@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultRetryInitialBackoff = 10 * time.Second
+	defaultRetryMaxBackoff     = 5 * time.Minute
+	defaultRetryJitter         = 0.2
+)
+
+// agentRetryConfig re-runs a failed plugin with exponential backoff, rather than waiting
+// for its next scheduled run.
+type agentRetryConfig struct {
+	MaxAttempts    int      `mapstructure:"max_attempts,omitempty"`
+	InitialBackoff string   `mapstructure:"initial_backoff,omitempty"`
+	MaxBackoff     string   `mapstructure:"max_backoff,omitempty"`
+	Jitter         *float64 `mapstructure:"jitter,omitempty"`
+}
+
+// pluginRetryPolicy is the parsed form of agentRetryConfig.
+type pluginRetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Jitter         float64
+}
+
+// retryPolicy returns how failed runs of the plugin are retried. Without a retry block a
+// plugin is run once per scheduled run.
+func (p *agentPlugin) parseRetryPolicy() (pluginRetryPolicy, error) {
+	policy := pluginRetryPolicy{
+		MaxAttempts:    1,
+		InitialBackoff: defaultRetryInitialBackoff,
+		MaxBackoff:     defaultRetryMaxBackoff,
+		Jitter:         defaultRetryJitter,
+	}
+	if p == nil || p.Retry == nil {
+		return policy, nil
+	}
+
+	if p.Retry.MaxAttempts < 1 {
+		return policy, fmt.Errorf("max_attempts must be at least 1")
+	}
+	policy.MaxAttempts = p.Retry.MaxAttempts
+
+	var err error
+	if policy.InitialBackoff, err = parseRetryDuration("initial_backoff", p.Retry.InitialBackoff, defaultRetryInitialBackoff); err != nil {
+		return policy, err
+	}
+	if policy.MaxBackoff, err = parseRetryDuration("max_backoff", p.Retry.MaxBackoff, defaultRetryMaxBackoff); err != nil {
+		return policy, err
+	}
+	if policy.MaxBackoff < policy.InitialBackoff {
+		return policy, fmt.Errorf("max_backoff must not be less than initial_backoff")
+	}
+
+	if p.Retry.Jitter != nil {
+		policy.Jitter = *p.Retry.Jitter
+		if policy.Jitter < 0 || policy.Jitter > 1 {
+			return policy, fmt.Errorf("jitter must be between 0 and 1")
+		}
+	}
+
+	return policy, nil
+}
+
+func parseRetryDuration(field string, raw string, fallback time.Duration) (time.Duration, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return fallback, nil
+	}
+
+	duration, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a Go duration such as 30s or 5m: %w", field, err)
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("%s must be positive", field)
+	}
+
+	return duration, nil
+}
+
+// backoff returns how long to wait before the attempt following attempt. The delay
+// doubles with each attempt up to MaxBackoff, and is then spread by up to Jitter of
+// itself in either direction so that plugins failing together do not retry together.
+func (p pluginRetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.InitialBackoff
+	for i := 1; i < attempt && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, p.MaxBackoff)
+
+	if p.Jitter > 0 {
+		spread := float64(delay) * p.Jitter
+		delay = time.Duration(float64(delay) - spread + rand.Float64()*2*spread)
+	}
+
+	return delay
+}
+
+// pluginConfigError marks a plugin run error caused by the plugin's configuration, which
+// a retry would fail on again.
+type pluginConfigError struct {
+	err error
+}
+
+func (e *pluginConfigError) Error() string {
+	return e.err.Error()
+}
+
+func (e *pluginConfigError) Unwrap() error {
+	return e.err
+}
+
+// retryablePluginError reports whether a failed run may succeed if it is run again.
+// Configuration errors, and errors the plugin reports as a problem with its request,
+// are not retried, nor are runs cancelled because the agent is stopping or reloading.
+func retryablePluginError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var configErr *pluginConfigError
+	if errors.As(err, &configErr) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.Unimplemented, codes.PermissionDenied, codes.Unauthenticated, codes.Canceled:
+		return false
+	}
+
+	return true
+}
+
+// runPluginWithRetry runs a plugin, retrying failed runs according to the plugin's retry
+// policy. The run is recorded in the plugin's run state once, with the result of its last
+// attempt, while each failed attempt shows in the status API and agent evidence until
+// it is retried.
+func (ar *AgentRunner) runPluginWithRetry(ctx context.Context, name string, plugin *agentPlugin, runPlugin func(ctx context.Context, name string, plugin *agentPlugin) error, logger hclog.Logger) error {
+	policy := plugin.retryPolicy
+
+	ar.markPluginRunStarted(name)
+	for attempt := 1; ; attempt++ {
+		ar.markPluginRunAttempt(name, attempt, policy.MaxAttempts)
+		err := runPlugin(ctx, name, plugin)
+		if err == nil || policy.MaxAttempts <= 1 {
+			ar.markPluginRunFinished(name, err)
+			return err
+		}
+
+		if attempt >= policy.MaxAttempts {
+			err = fmt.Errorf("attempt %d of %d failed: %w", attempt, policy.MaxAttempts, err)
+			ar.markPluginRunFinished(name, err)
+			return err
+		}
+		if !retryablePluginError(err) {
+			err = fmt.Errorf("attempt %d of %d failed and will not be retried: %w", attempt, policy.MaxAttempts, err)
+			ar.markPluginRunFinished(name, err)
+			return err
+		}
+
+		delay := policy.backoff(attempt)
+		logger.Warn("Plugin run failed, retrying", "error", err, "attempt", attempt, "max_attempts", policy.MaxAttempts, "backoff", delay.String())
+		ar.markPluginRunRetrying(name, time.Now().UTC().Add(delay), fmt.Errorf("attempt %d of %d failed, retrying in %s: %w", attempt, policy.MaxAttempts, delay.Round(time.Millisecond), err))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			err = fmt.Errorf("attempt %d of %d failed, retry cancelled: %w", attempt, policy.MaxAttempts, err)
+			ar.markPluginRunFinished(name, err)
+			return err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/compliance-framework/agent/internal"
+	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMergeConfig_ValidatesPluginRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		plugin   string
+		expected string
+	}{
+		{name: "valid", plugin: "    retry:\n      max_attempts: 3\n      initial_backoff: 5s\n      max_backoff: 1m\n      jitter: 0.5\n"},
+		{name: "defaults", plugin: "    retry:\n      max_attempts: 2\n"},
+		{name: "missing max attempts", plugin: "    retry:\n      initial_backoff: 5s\n", expected: "plugin flaky has invalid retry configuration: max_attempts"},
+		{name: "invalid backoff", plugin: "    retry:\n      max_attempts: 2\n      initial_backoff: soon\n", expected: "plugin flaky has invalid retry configuration: initial_backoff"},
+		{name: "max below initial", plugin: "    retry:\n      max_attempts: 2\n      initial_backoff: 1m\n      max_backoff: 10s\n", expected: "plugin flaky has invalid retry configuration: max_backoff"},
+		{name: "jitter out of range", plugin: "    retry:\n      max_attempts: 2\n      jitter: 1.5\n", expected: "plugin flaky has invalid retry configuration: jitter"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := viper.New()
+			v.SetConfigType("yaml")
+			err := v.ReadConfig(bytes.NewBufferString("api:\n  url: http://localhost:8080\n\nplugins:\n  flaky:\n    source: ghcr.io/some-plugin:latest\n" + tt.plugin))
+			if err != nil {
+				t.Fatalf("Error reading config: %v", err)
+			}
+
+			config, err := mergeConfig(AgentCmd(), v)
+			if err != nil {
+				t.Fatalf("Error merging config: %v", err)
+			}
+
+			err = config.validate()
+			if tt.expected == "" {
+				if err != nil {
+					t.Fatalf("Expected config to be valid, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), tt.expected) {
+				t.Fatalf("Expected error starting with %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestPluginRetryPolicyBackoff(t *testing.T) {
+	policy := pluginRetryPolicy{MaxAttempts: 10, InitialBackoff: time.Second, MaxBackoff: 10 * time.Second}
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, want := range expected {
+		if got := policy.backoff(i + 1); got != want {
+			t.Fatalf("backoff(%d) = %s, expected %s", i+1, got, want)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for range 100 {
+		got := policy.backoff(2)
+		if got < time.Second || got > 3*time.Second {
+			t.Fatalf("backoff(2) with jitter = %s, expected between 1s and 3s", got)
+		}
+	}
+}
+
+func TestRetryablePluginError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "transient", err: errors.New("connection reset"), want: true},
+		{name: "timeout", err: pluginTimeoutError(expiredContext(t), "slow", time.Second, errors.New("deadline")), want: true},
+		{name: "unavailable", err: status.Error(codes.Unavailable, "plugin exited"), want: true},
+		{name: "configuration", err: &pluginConfigError{err: errors.New("missing endpoint")}, want: false},
+		{name: "wrapped configuration", err: errors.Join(errors.New("run failed"), &pluginConfigError{err: errors.New("missing endpoint")}), want: false},
+		{name: "invalid argument", err: status.Error(codes.InvalidArgument, "bad config"), want: false},
+		{name: "cancelled", err: context.Canceled, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryablePluginError(tt.err); got != tt.want {
+				t.Fatalf("retryablePluginError(%v) = %t, expected %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigureRunner_MarksRejectedConfigurationAsConfigError(t *testing.T) {
+	for _, code := range []codes.Code{codes.InvalidArgument, codes.FailedPrecondition} {
+		err := configureRunner(context.Background(), "test-plugin", &initTestRunner{configureErr: status.Error(code, "endpoint is required")}, nil, nil, nil)
+
+		var configErr *pluginConfigError
+		if !errors.As(err, &configErr) {
+			t.Fatalf("configureRunner() error = %v, expected a configuration error", err)
+		}
+	}
+
+	// Other errors, such as a plugin failing to reach the system it checks, are retried.
+	for _, configureErr := range []error{errors.New("connection reset"), status.Error(codes.Unavailable, "unavailable")} {
+		err := configureRunner(context.Background(), "test-plugin", &initTestRunner{configureErr: configureErr}, nil, nil, nil)
+		if !retryablePluginError(err) {
+			t.Fatalf("configureRunner() error = %v, expected a retryable error", err)
+		}
+	}
+}
+
+// newRetryTestRunner returns an agent runner configured with a single plugin, which has
+// been validated so that its retry policy is parsed.
+func newRetryTestRunner(t *testing.T, name string, retry *agentRetryConfig) (*AgentRunner, *agentPlugin) {
+	t.Helper()
+
+	config := newTestAgentConfig("http://example.test", nil)
+	plugin := &agentPlugin{Source: "ghcr.io/some-plugin:v1", Retry: retry}
+	config.Plugins = map[string]*agentPlugin{name: plugin}
+	if err := config.validate(); err != nil {
+		t.Fatalf("validate() error = %v", err)
+	}
+
+	agentRunner := NewAgentRunner()
+	agentRunner.UpdateConfig(config)
+	return agentRunner, plugin
+}
+
+func TestRunPluginWithRetry_RetriesUntilSuccess(t *testing.T) {
+	jitter := 0.0
+	agentRunner, plugin := newRetryTestRunner(t, "flaky", &agentRetryConfig{MaxAttempts: 3, InitialBackoff: "10ms", MaxBackoff: "20ms", Jitter: &jitter})
+
+	succeeded := testutil.ToFloat64(internal.PluginRunsTotal.WithLabelValues("flaky", internal.MetricOutcomeSuccess))
+	failed := testutil.ToFloat64(internal.PluginRunsTotal.WithLabelValues("flaky", internal.MetricOutcomeFailure))
+
+	attempts := 0
+	var recordedErrors []string
+	runPlugin := func(ctx context.Context, name string, pluginConfig *agentPlugin) error {
+		attempts++
+		if attempts > 1 {
+			recordedErrors = append(recordedErrors, agentRunner.pluginRunSnapshot().Errors[name])
+			if status := agentRunner.pluginRunRecord(name).Status; status != pluginRunStatusRunning {
+				t.Errorf("expected the run to be in progress while it is retried, got %q", status)
+			}
+		}
+		if attempts < 3 {
+			return errors.New("connection reset")
+		}
+		return nil
+	}
+
+	if err := agentRunner.runPluginWithRetry(context.Background(), "flaky", plugin, runPlugin, hclog.NewNullLogger()); err != nil {
+		t.Fatalf("runPluginWithRetry() error = %v, expected nil", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts)
+	}
+	if len(recordedErrors) != 2 || !strings.HasPrefix(recordedErrors[0], "attempt 1 of 3 failed, retrying in 10ms") || !strings.HasPrefix(recordedErrors[1], "attempt 2 of 3 failed, retrying in 20ms") {
+		t.Fatalf("expected each failed attempt in the run state, got %q", recordedErrors)
+	}
+
+	record := agentRunner.pluginRuns["flaky"]
+	if record.Status != pluginRunStatusPassing || record.Attempt != 3 || record.MaxAttempts != 3 || !record.NextRetryAt.IsZero() {
+		t.Fatalf("unexpected run record after successful retry: %+v", record)
+	}
+
+	// The run is counted once, with the result of its last attempt.
+	if got := testutil.ToFloat64(internal.PluginRunsTotal.WithLabelValues("flaky", internal.MetricOutcomeSuccess)) - succeeded; got != 1 {
+		t.Fatalf("expected 1 successful run, got %v", got)
+	}
+	if got := testutil.ToFloat64(internal.PluginRunsTotal.WithLabelValues("flaky", internal.MetricOutcomeFailure)) - failed; got != 0 {
+		t.Fatalf("expected failed attempts not to be counted as runs, got %v", got)
+	}
+}
+
+func TestRunPluginWithRetry_GivesUpAfterMaxAttempts(t *testing.T) {
+	agentRunner, plugin := newRetryTestRunner(t, "down", &agentRetryConfig{MaxAttempts: 2, InitialBackoff: "1ms", MaxBackoff: "1ms"})
+
+	attempts := 0
+	err := agentRunner.runPluginWithRetry(context.Background(), "down", plugin, func(ctx context.Context, name string, pluginConfig *agentPlugin) error {
+		attempts++
+		return errors.New("connection refused")
+	}, hclog.NewNullLogger())
+	if err == nil || err.Error() != "attempt 2 of 2 failed: connection refused" {
+		t.Fatalf("runPluginWithRetry() error = %v, expected final attempt error", err)
+	}
+	if attempts != 2 {
+		t.Fatalf("expected 2 attempts, got %d", attempts)
+	}
+	if got := agentRunner.pluginRunSnapshot().Errors["down"]; got != err.Error() {
+		t.Fatalf("expected agent evidence error %q, got %q", err.Error(), got)
+	}
+}
+
+func TestRunPluginWithRetry_DoesNotRetryConfigurationErrors(t *testing.T) {
+	agentRunner, plugin := newRetryTestRunner(t, "misconfigured", &agentRetryConfig{MaxAttempts: 5, InitialBackoff: "1ms"})
+
+	attempts := 0
+	err := agentRunner.runPluginWithRetry(context.Background(), "misconfigured", plugin, func(ctx context.Context, name string, pluginConfig *agentPlugin) error {
+		attempts++
+		return &pluginConfigError{err: errors.New("endpoint is required")}
+	}, hclog.NewNullLogger())
+	if attempts != 1 {
+		t.Fatalf("expected a configuration error not to be retried, got %d attempts", attempts)
+	}
+	if err == nil || !strings.Contains(err.Error(), "will not be retried: endpoint is required") {
+		t.Fatalf("runPluginWithRetry() error = %v, expected not retried error", err)
+	}
+}
+
+func TestRunPluginWithRetry_StopsWaitingWhenCancelled(t *testing.T) {
+	agentRunner, plugin := newRetryTestRunner(t, "slow", &agentRetryConfig{MaxAttempts: 2, InitialBackoff: "1h", MaxBackoff: "1h"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- agentRunner.runPluginWithRetry(ctx, "slow", plugin, func(ctx context.Context, name string, pluginConfig *agentPlugin) error {
+			return errors.New("connection reset")
+		}, hclog.NewNullLogger())
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		agentRunner.pluginRunMu.RLock()
+		waiting := !agentRunner.pluginRuns["slow"].NextRetryAt.IsZero()
+		agentRunner.pluginRunMu.RUnlock()
+		if waiting {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the failed run to wait for a retry")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "retry cancelled") {
+			t.Fatalf("runPluginWithRetry() error = %v, expected cancelled retry", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected runPluginWithRetry to return once cancelled")
+	}
+}
+
+func expiredContext(t *testing.T) context.Context {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
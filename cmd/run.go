@@ -0,0 +1,222 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/compliance-framework/agent/runner"
+	"github.com/compliance-framework/agent/runner/proto"
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/cobra"
+)
+
+type runOptions struct {
+	plugins []string
+}
+
+func RunCmd() *cobra.Command {
+	opts := &runOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: "run selected plugins once, whatever their schedule",
+		Long: `Run executes the selected plugins from an agent config once, whatever their schedule, and
+prints a summary of the evidence each of them created. The other plugins in the config are left
+alone, so that a single plugin can be debugged without editing the config. It exits non-zero when a
+selected plugin fails.`,
+		Example: "  ccf-agent agent run --config config.yaml --plugin local-ssh",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSelectedPlugins(cmd, opts, NewAgentRunner())
+		},
+	}
+
+	cmd.Flags().CountP("verbose", "v", "Enable verbose output")
+	cmd.Flags().StringP("config", "c", "", "Location of config file")
+	cmd.Flags().String("config-dir", "", "Directory of config fragments (*.yaml) merged over the config file in lexical order")
+	cmd.MarkFlagsOneRequired("config", "config-dir")
+	cmd.Flags().StringArrayVarP(&opts.plugins, "plugin", "p", nil, "Name of a plugin to run; may be repeated")
+	cmd.MarkFlagRequired("plugin")
+	cmd.Flags().Int("max-concurrency", defaultMaxConcurrency, "Number of plugins to run at the same time")
+	cmd.Flags().Bool("dry-run", false, "Run plugins without contacting the API, capturing the evidence and templates they would send")
+	cmd.Flags().String("output", "", "Directory to write dry run captures to as JSON files, instead of stdout")
+
+	return cmd
+}
+
+// runSelectedPlugins runs the plugins selected with --plugin once, through the same path
+// the daemon uses for a scheduled run, and prints a summary of each run.
+func runSelectedPlugins(cmd *cobra.Command, opts *runOptions, agentRun *AgentRunner) error {
+	source, err := configSourceFromFlags(cmd)
+	if err != nil {
+		return err
+	}
+	if err := agentRun.setupDryRun(cmd); err != nil {
+		return err
+	}
+
+	logger := hclog.New(&hclog.LoggerOptions{
+		Name:   "agent",
+		Output: agentRun.logOutput(),
+		Level:  hclog.Debug,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if agentRun.dryRun == nil {
+		source.remote = newRemoteConfigLoader(ctx, agentRun.httpClient, logger, func() {})
+	}
+
+	config, err := loadConfig(cmd, source)
+	if err != nil {
+		return err
+	}
+
+	names, err := selectPlugins(config, opts.plugins)
+	if err != nil {
+		return err
+	}
+	cmd.SilenceUsage = true
+
+	selected := make(map[string]*agentPlugin, len(names))
+	for _, name := range names {
+		selected[name] = config.Plugins[name]
+	}
+
+	agentRun.evidenceCounts = newEvidenceCounter()
+	agentRun.UpdateConfig(config)
+	agentRun.allowPluginClientTracking()
+	defer agentRun.closePluginClients()
+
+	if err := agentRun.openOutbox(); err != nil {
+		return err
+	}
+	agentRun.resolveProtocolsOf(ctx, selected)
+
+	runPlugin := agentRun.pluginRunner()
+	runErr := runPluginsConcurrently(ctx, names, config.maxConcurrency(), func(ctx context.Context, name string) error {
+		return agentRun.runPluginWithRetry(ctx, name, selected[name], runPlugin, logger.With("plugin", name))
+	})
+
+	// A dry run writing its captures to stdout moves everything else to stderr.
+	out := cmd.OutOrStdout()
+	if agentRun.logWriter != nil {
+		out = agentRun.logWriter
+	}
+	if err := writeRunSummary(out, names, agentRun.pluginRunSnapshot(), agentRun.evidenceCounts); err != nil {
+		return err
+	}
+	return runErr
+}
+
+// selectPlugins returns the names of the selected plugins in order, without duplicates,
+// or an error naming the selected plugins that are not configured.
+func selectPlugins(config *agentConfig, selected []string) ([]string, error) {
+	seen := map[string]bool{}
+	var names, unknown []string
+	for _, name := range selected {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		if _, ok := config.Plugins[name]; !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(unknown) > 0 {
+		configured := make([]string, 0, len(config.Plugins))
+		for name := range config.Plugins {
+			configured = append(configured, name)
+		}
+		sort.Strings(configured)
+		return nil, fmt.Errorf("plugins not found in config: %s; configured plugins are: %s", strings.Join(unknown, ", "), strings.Join(configured, ", "))
+	}
+	return names, nil
+}
+
+// writeRunSummary writes a table of the result of each plugin run, and the evidence it
+// created by status.
+func writeRunSummary(out io.Writer, names []string, snapshot pluginRunSnapshot, counts *evidenceCounter) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PLUGIN\tRESULT\tEVIDENCE\tSATISFIED\tNOT-SATISFIED\tERROR")
+	for _, name := range names {
+		result, errorMessage := string(pluginRunStatusPassing), "-"
+		if message, failed := snapshot.Errors[name]; failed {
+			result, errorMessage = string(pluginRunStatusFailed), strings.Join(strings.Fields(message), " ")
+		}
+
+		byStatus := counts.byStatus(name)
+		total := 0
+		for _, count := range byStatus {
+			total += count
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%s\n", name, result, total,
+			byStatus[runner.EvidenceStatusStateFromEnum(proto.EvidenceStatusState_EVIDENCE_STATUS_STATE_SATISFIED)],
+			byStatus[runner.EvidenceStatusStateFromEnum(proto.EvidenceStatusState_EVIDENCE_STATUS_STATE_NOT_SATISFIED)],
+			errorMessage)
+	}
+	return w.Flush()
+}
+
+// evidenceCounter counts the evidence each plugin created, by status.
+type evidenceCounter struct {
+	mu     sync.Mutex
+	counts map[string]map[string]int
+}
+
+func newEvidenceCounter() *evidenceCounter {
+	return &evidenceCounter{counts: map[string]map[string]int{}}
+}
+
+func (c *evidenceCounter) add(pluginName string, evidence []*proto.Evidence) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.counts[pluginName] == nil {
+		c.counts[pluginName] = map[string]int{}
+	}
+	for _, evid := range evidence {
+		c.counts[pluginName][runner.EvidenceStatusStateFromEnum(evid.GetStatus().GetState())]++
+	}
+}
+
+func (c *evidenceCounter) byStatus(pluginName string) map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counts := map[string]int{}
+	for status, count := range c.counts[pluginName] {
+		counts[status] = count
+	}
+	return counts
+}
+
+// wrap returns helper, counting the evidence pluginName creates through it.
+func (c *evidenceCounter) wrap(pluginName string, helper runner.ApiHelper) runner.ApiHelper {
+	return &countingApiHelper{ApiHelper: helper, counter: c, pluginName: pluginName}
+}
+
+type countingApiHelper struct {
+	runner.ApiHelper
+	counter    *evidenceCounter
+	pluginName string
+}
+
+func (h *countingApiHelper) CreateEvidence(ctx context.Context, evidence []*proto.Evidence) error {
+	err := h.ApiHelper.CreateEvidence(ctx, evidence)
+	if err == nil {
+		h.counter.add(h.pluginName, evidence)
+	}
+	return err
+}
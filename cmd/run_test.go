@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/compliance-framework/agent/runner/proto"
+	"github.com/google/uuid"
+	"github.com/hashicorp/go-hclog"
+)
+
+func newRunTestCommand(t *testing.T, args ...string) (*bytes.Buffer, func(opts *runOptions, agentRun *AgentRunner) error) {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, file, `
+api:
+  url: http://localhost:8080
+plugins:
+  healthy:
+    source: ./healthy
+  broken:
+    source: ./broken
+  untouched:
+    source: ./untouched
+`)
+
+	cmd := RunCmd()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	if err := cmd.ParseFlags(append([]string{"--config", file, "--dry-run", "--output", t.TempDir()}, args...)); err != nil {
+		t.Fatalf("ParseFlags() error = %v", err)
+	}
+	return &out, func(opts *runOptions, agentRun *AgentRunner) error {
+		return runSelectedPlugins(cmd, opts, agentRun)
+	}
+}
+
+func TestRunSelectedPlugins_RunsOnlySelectedPluginsAndSummarisesEvidence(t *testing.T) {
+	out, run := newRunTestCommand(t)
+
+	var mu sync.Mutex
+	var ran []string
+	agentRun := NewAgentRunner()
+	agentRun.runPluginFunc = func(ctx context.Context, name string, pluginConfig *agentPlugin) error {
+		mu.Lock()
+		ran = append(ran, name)
+		mu.Unlock()
+
+		if name == "broken" {
+			return errors.New("plugin exited\nunexpectedly")
+		}
+		helper := agentRun.newPluginApiHelper(hclog.NewNullLogger(), nil, nil, name)
+		return helper.CreateEvidence(ctx, []*proto.Evidence{
+			{UUID: uuid.NewString(), Title: "a", Status: &proto.EvidenceStatus{State: proto.EvidenceStatusState_EVIDENCE_STATUS_STATE_SATISFIED}},
+			{UUID: uuid.NewString(), Title: "b", Status: &proto.EvidenceStatus{State: proto.EvidenceStatusState_EVIDENCE_STATUS_STATE_SATISFIED}},
+			{UUID: uuid.NewString(), Title: "c", Status: &proto.EvidenceStatus{State: proto.EvidenceStatusState_EVIDENCE_STATUS_STATE_NOT_SATISFIED}},
+		})
+	}
+
+	err := run(&runOptions{plugins: []string{"healthy", "broken", "healthy"}}, agentRun)
+	var runsErr *pluginRunsError
+	if !errors.As(err, &runsErr) || len(runsErr.names) != 1 || runsErr.names[0] != "broken" {
+		t.Fatalf("run error = %v, expected only the broken plugin to fail", err)
+	}
+	if len(ran) != 2 {
+		t.Fatalf("expected each selected plugin to run once, ran %v", ran)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected a header and one row per selected plugin, got:\n%s", out.String())
+	}
+	if fields := strings.Fields(lines[0]); strings.Join(fields, " ") != "PLUGIN RESULT EVIDENCE SATISFIED NOT-SATISFIED ERROR" {
+		t.Fatalf("unexpected header %q", lines[0])
+	}
+	if fields := strings.Fields(lines[1]); strings.Join(fields, " ") != "broken failed 0 0 0 plugin exited unexpectedly" {
+		t.Fatalf("unexpected broken row %q", lines[1])
+	}
+	if fields := strings.Fields(lines[2]); strings.Join(fields, " ") != "healthy passing 3 2 1 -" {
+		t.Fatalf("unexpected healthy row %q", lines[2])
+	}
+}
+
+func TestRunSelectedPlugins_RejectsUnknownPlugins(t *testing.T) {
+	_, run := newRunTestCommand(t)
+
+	agentRun := NewAgentRunner()
+	agentRun.runPluginFunc = func(ctx context.Context, name string, pluginConfig *agentPlugin) error {
+		t.Fatalf("expected no plugin to run, ran %s", name)
+		return nil
+	}
+
+	err := run(&runOptions{plugins: []string{"healthy", "missing"}}, agentRun)
+	if err == nil || !strings.Contains(err.Error(), "plugins not found in config: missing") || !strings.Contains(err.Error(), "broken, healthy, untouched") {
+		t.Fatalf("run error = %v, expected the unknown plugin and the configured plugins to be named", err)
+	}
+}
+
+func TestRunSelectedPlugins_RetriesFailedRuns(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, file, `
+api:
+  url: http://localhost:8080
+plugins:
+  flaky:
+    source: ./flaky
+    retry:
+      max_attempts: 2
+      initial_backoff: 1ms
+`)
+	cmd := RunCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	if err := cmd.ParseFlags([]string{"--config", file, "--dry-run", "--output", t.TempDir()}); err != nil {
+		t.Fatalf("ParseFlags() error = %v", err)
+	}
+
+	attempts := 0
+	agentRun := NewAgentRunner()
+	agentRun.runPluginFunc = func(ctx context.Context, name string, pluginConfig *agentPlugin) error {
+		attempts++
+		if attempts == 1 {
+			return errors.New("connection reset")
+		}
+		return nil
+	}
+
+	if err := runSelectedPlugins(cmd, &runOptions{plugins: []string{"flaky"}}, agentRun); err != nil {
+		t.Fatalf("runSelectedPlugins() error = %v, expected the retry to succeed", err)
+	}
+	if attempts != 2 {
+		t.Fatalf("expected 2 attempts, got %d", attempts)
+	}
+}
@@ -0,0 +1,264 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/compliance-framework/agent/internal"
+	"github.com/spf13/cobra"
+)
+
+const (
+	jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+	// schemaFormatDuration marks strings parsed with time.ParseDuration, such as 30s or 1h30m.
+	// It is not the ISO 8601 duration format of the JSON Schema spec.
+	schemaFormatDuration = "go-duration"
+	// schemaFormatCron marks 5 field cron expressions, or descriptors such as @hourly.
+	schemaFormatCron = "cron"
+
+	durationPattern = `^\s*[-+]?(0|([0-9]*(\.[0-9]*)?(ns|us|µs|μs|ms|s|m|h))+)\s*$`
+)
+
+// jsonSchema is the subset of JSON Schema used to describe the agent config file.
+type jsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	Enum                 []any                  `json:"enum,omitempty"`
+	Default              any                    `json:"default,omitempty"`
+	Examples             []any                  `json:"examples,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	AdditionalProperties any                    `json:"additionalProperties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+}
+
+// schemaField documents a config field that its Go type alone does not describe.
+type schemaField struct {
+	description string
+	format      string
+	enum        []any
+	defaultTo   any
+	examples    []any
+	minimum     *float64
+	maximum     *float64
+	required    bool
+}
+
+func schemaBound(value float64) *float64 {
+	return &value
+}
+
+var pullPolicyEnum = []any{string(internal.PullIfNotPresent), string(internal.PullAlways), string(internal.PullOnDigestChange)}
+
+// configSchemaFields documents the fields of each config type, keyed by their config key.
+var configSchemaFields = map[reflect.Type]map[string]schemaField{
+	reflect.TypeFor[agentConfig](): {
+		"daemon":            {description: "Run as a long running daemon, running each plugin on its schedule."},
+		"verbosity":         {description: "Log verbosity: 0 shows errors, warnings and info, 1 adds debug and 2 adds trace logs.", minimum: schemaBound(0), maximum: schemaBound(2)},
+		"api":               {description: "The Compliance Framework API that evidence is sent to.", required: true},
+		"plugins":           {description: "Plugins to run, keyed by a name of your choice."},
+		"agent_evidence":    {description: "Evidence the agent reports about its own runs."},
+		"outbox":            {description: "Local storage for evidence that could not be sent to the API, replayed once it is reachable."},
+		"status_api":        {description: "Local HTTP API reporting the state of each plugin in daemon mode."},
+		"metrics":           {description: "Prometheus metrics endpoint."},
+		"remote_config":     {description: "Experimental. Fetch plugins and other settings from the API, merged over the local config."},
+		"input_recording":   {description: "Store the input plugins evaluate their policies with, to replay new policy versions against it with policy replay."},
+		"policy_evaluation": {description: "Limit and instrument the evaluation of each policy package by plugins."},
+		"max_concurrency": {
+			description: "How many plugins a one-shot run executes at the same time.",
+			defaultTo:   defaultMaxConcurrency,
+			minimum:     schemaBound(0),
+		},
+	},
+	reflect.TypeFor[apiConfig](): {
+		"url":  {description: "Base URL of the Compliance Framework API.", format: "uri", required: true},
+		"auth": {description: "OAuth client credentials. Both fields are required when either is set."},
+	},
+	reflect.TypeFor[apiAuthConfig](): {
+		"client_id":     {description: "OAuth client ID, a UUID. Can also be set with CCF_API_AUTH_CLIENT_ID."},
+		"client_secret": {description: "OAuth client secret. Can also be set with CCF_API_AUTH_CLIENT_SECRET."},
+	},
+	reflect.TypeFor[agentPlugin](): {
+		"protocol_version": {
+			description: "Plugin protocol version. When not set, it is read from the plugin's OCI annotations, falling back to 1.",
+			enum:        []any{DefaultProtocolVersion, RunnerV2ProtocolVersion},
+		},
+		"schedule": {
+			description: "Cron expression for when the plugin runs in daemon mode, in the format minute hour day month day_of_week.",
+			format:      schemaFormatCron,
+			defaultTo:   "* * * * *",
+			examples:    []any{"*/5 * * * *", "@hourly"},
+		},
+		"source":             {description: "Local path or OCI reference of the plugin.", required: true, examples: []any{"ghcr.io/compliance-framework/plugin-local-ssh:v1.0.0"}},
+		"policies":           {description: "Local paths or OCI references of the policy bundles the plugin evaluates."},
+		"config":             {description: "Plugin specific configuration, passed to the plugin as strings. Values can refer to secrets with ${file:path}, ${env:NAME} or ${exec:command}."},
+		"labels":             {description: "Labels added to the evidence the plugin reports."},
+		"policy_data":        {description: "Data made available to the plugin's policies."},
+		"policy_behavior":    {description: "Labels of plugin behavior, keyed by a substring of the policies they apply to."},
+		"verify":             {description: "Require the plugin's OCI artifact to carry a valid cosign signature."},
+		"policy_verify":      {description: "Require each policy bundle's OCI artifact to carry a valid cosign signature."},
+		"pull_policy":        {description: "When a tagged OCI plugin is downloaded again.", enum: pullPolicyEnum, defaultTo: string(internal.PullIfNotPresent)},
+		"policy_pull_policy": {description: "When tagged OCI policy bundles are downloaded again.", enum: pullPolicyEnum, defaultTo: string(internal.PullIfNotPresent)},
+		"timeout":            {description: "Maximum duration of a plugin run, after which the plugin is stopped.", format: schemaFormatDuration},
+		"retry":              {description: "Retry failed plugin runs with exponential backoff."},
+		"persistent":         {description: "Keep the plugin process running between daemon runs, only evaluating on later runs."},
+	},
+	reflect.TypeFor[agentVerifyConfig](): {
+		"key":             {description: "Public key file, or inline PEM key, the signature must verify against."},
+		"identity":        {description: "Certificate identity of a keyless signature."},
+		"identity_regexp": {description: "Regular expression the certificate identity of a keyless signature must match."},
+		"issuer":          {description: "OIDC issuer of a keyless signature."},
+		"issuer_regexp":   {description: "Regular expression the OIDC issuer of a keyless signature must match."},
+		"ignore_tlog":     {description: "Do not require the signature to be recorded in the Rekor transparency log."},
+	},
+	reflect.TypeFor[agentRetryConfig](): {
+		"max_attempts":    {description: "Total attempts per scheduled run, including the first.", defaultTo: 1, minimum: schemaBound(1)},
+		"initial_backoff": {description: "Delay before the first retry, doubled for each later retry.", format: schemaFormatDuration, defaultTo: defaultRetryInitialBackoff.String()},
+		"max_backoff":     {description: "Longest delay between retries.", format: schemaFormatDuration, defaultTo: defaultRetryMaxBackoff.String()},
+		"jitter":          {description: "Fraction by which each delay is randomly spread.", defaultTo: defaultRetryJitter, minimum: schemaBound(0), maximum: schemaBound(1)},
+	},
+	reflect.TypeFor[agentEvidenceConfig](): {
+		"enabled":                {description: "Send evidence about the agent's own runs."},
+		"emit_on_run_completion": {description: "Send agent evidence when a run completes, as well as on the interval."},
+		"interval":               {description: "How often agent evidence is sent in daemon mode.", format: schemaFormatDuration},
+	},
+	reflect.TypeFor[agentOutboxConfig](): {
+		"enabled":         {description: "Store evidence that could not be sent, and replay it later."},
+		"directory":       {description: "Directory the outbox is stored in."},
+		"max_bytes":       {description: "Maximum size of the outbox in bytes.", defaultTo: defaultOutboxMaxBytes, minimum: schemaBound(0)},
+		"replay_interval": {description: "How often stored evidence is replayed.", format: schemaFormatDuration, defaultTo: defaultOutboxReplayInterval.String()},
+	},
+	reflect.TypeFor[agentRemoteConfig](): {
+		"enabled":       {description: "Fetch the config the API assigns to this agent. Requires api.auth."},
+		"path":          {description: "API path the remote config is fetched from.", defaultTo: defaultRemoteConfigPath},
+		"poll_interval": {description: "How often the API is asked for changes to the remote config.", format: schemaFormatDuration, defaultTo: defaultRemoteConfigPollInterval.String()},
+		"wait":          {description: "How long the API may hold a request until the remote config changes. 0 disables long polling.", format: schemaFormatDuration},
+		"cache_file":    {description: "File the last remote config is cached in, used when the API cannot be reached.", defaultTo: AgentRemoteConfigCache},
+	},
+	reflect.TypeFor[agentInputRecordingConfig](): {
+		"enabled":             {description: "Record each policy input of plugins that support it."},
+		"directory":           {description: "Directory the inputs are stored in, with a subdirectory per plugin.", defaultTo: AgentPolicyInputDir},
+		"max_runs_per_plugin": {description: "Number of runs whose inputs are kept per plugin, the oldest being removed first.", defaultTo: defaultInputRecordingMaxRunsPerPlugin, minimum: schemaBound(0)},
+	},
+	reflect.TypeFor[agentPolicyEvaluationConfig](): {
+		"timeout":    {description: "How long each policy package may take to evaluate before it is reported as an error. Unset or 0 means no timeout.", format: schemaFormatDuration},
+		"instrument": {description: "Log OPA metrics for each policy package evaluation."},
+		"profile":    {description: "Log the expressions each policy package spends the most time evaluating."},
+	},
+	reflect.TypeFor[agentStatusAPIConfig](): {
+		"listen": {description: "Address the status API listens on, such as 127.0.0.1:8081."},
+	},
+	reflect.TypeFor[agentMetricsConfig](): {
+		"listen": {description: "Address the metrics endpoint listens on, such as 127.0.0.1:9090."},
+		"path":   {description: "HTTP path of the metrics endpoint.", defaultTo: defaultMetricsPath},
+	},
+}
+
+func ConfigCmd() *cobra.Command {
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "inspect the agent config file format",
+	}
+	configCmd.AddCommand(&cobra.Command{
+		Use:   "schema",
+		Short: "print the JSON Schema of the agent config file",
+		Long: `Schema prints a JSON Schema describing the agent config file, for editors and YAML language
+servers to validate and autocomplete config files with.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			encoded, err := json.MarshalIndent(agentConfigSchema(), "", "  ")
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintln(cmd.OutOrStdout(), string(encoded))
+			return err
+		},
+	})
+
+	return configCmd
+}
+
+// agentConfigSchema returns the JSON Schema of the agent config file, generated from
+// agentConfig and the types it is made of.
+func agentConfigSchema() *jsonSchema {
+	schema := schemaForType(reflect.TypeFor[agentConfig]())
+	schema.Schema = jsonSchemaDraft
+	schema.Title = "Compliance Framework agent configuration"
+	return schema
+}
+
+func schemaForType(t reflect.Type) *jsonSchema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return schemaForStruct(t)
+	case reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: schemaForType(t.Elem())}
+	case reflect.Slice, reflect.Array:
+		return &jsonSchema{Type: "array", Items: schemaForType(t.Elem())}
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &jsonSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number"}
+	default:
+		// Any value, such as policy_data entries.
+		return &jsonSchema{}
+	}
+}
+
+func schemaForStruct(t reflect.Type) *jsonSchema {
+	schema := &jsonSchema{
+		Type:                 "object",
+		Properties:           map[string]*jsonSchema{},
+		AdditionalProperties: false,
+	}
+	fields := configSchemaFields[t]
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key, _, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+		if !field.IsExported() || key == "" || key == "-" {
+			continue
+		}
+
+		property := schemaForType(field.Type)
+		doc := fields[key]
+		property.Description = doc.description
+		property.Enum = doc.enum
+		property.Default = doc.defaultTo
+		property.Examples = doc.examples
+		property.Minimum = doc.minimum
+		property.Maximum = doc.maximum
+		if doc.format != "" {
+			property.Format = doc.format
+		}
+		if doc.format == schemaFormatDuration {
+			property.Pattern = durationPattern
+		}
+		if doc.required {
+			schema.Required = append(schema.Required, key)
+		}
+		schema.Properties[key] = property
+	}
+
+	sort.Strings(schema.Required)
+	return schema
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestAgentConfigSchema_DescribesEveryField(t *testing.T) {
+	var walk func(path string, schema *jsonSchema)
+	walk = func(path string, schema *jsonSchema) {
+		for key, property := range schema.Properties {
+			if property.Description == "" {
+				t.Errorf("expected %s%s to have a description", path, key)
+			}
+			walk(path+key+".", property)
+		}
+		if property, ok := schema.AdditionalProperties.(*jsonSchema); ok {
+			walk(path+"*.", property)
+		}
+		if schema.Items != nil {
+			walk(path+"[].", schema.Items)
+		}
+	}
+
+	walk("", agentConfigSchema())
+}
+
+func TestAgentConfigSchema_Plugin(t *testing.T) {
+	plugins := agentConfigSchema().Properties["plugins"]
+	if plugins.Type != "object" {
+		t.Fatalf("expected plugins to be an object, got %q", plugins.Type)
+	}
+	plugin, ok := plugins.AdditionalProperties.(*jsonSchema)
+	if !ok {
+		t.Fatal("expected plugins to be keyed by plugin name")
+	}
+
+	if !reflect.DeepEqual(plugin.Required, []string{"source"}) {
+		t.Fatalf("expected source to be required, got %v", plugin.Required)
+	}
+	if got := plugin.Properties["protocol_version"].Enum; !reflect.DeepEqual(got, []any{DefaultProtocolVersion, RunnerV2ProtocolVersion}) {
+		t.Fatalf("expected protocol_version to be an enum of supported versions, got %v", got)
+	}
+	if got := plugin.Properties["schedule"].Format; got != schemaFormatCron {
+		t.Fatalf("expected schedule to have the cron format, got %q", got)
+	}
+	if got := plugin.Properties["policies"]; got.Type != "array" || got.Items.Type != "string" {
+		t.Fatalf("expected policies to be a list of strings, got %+v", got)
+	}
+	if _, ok := plugin.Properties["protocolSet"]; ok {
+		t.Fatal("expected unexported fields to be left out")
+	}
+
+	retry := plugin.Properties["retry"]
+	if retry.AdditionalProperties != false {
+		t.Fatal("expected unknown retry fields to be rejected")
+	}
+	if got := retry.Properties["initial_backoff"]; got.Format != schemaFormatDuration || got.Default != "10s" {
+		t.Fatalf("expected initial_backoff to be a duration defaulting to 10s, got %+v", got)
+	}
+}
+
+func TestAgentConfigSchema_DurationPattern(t *testing.T) {
+	pattern := regexp.MustCompile(durationPattern)
+	for _, valid := range []string{"30s", "1h30m", "1.5h", "0", "250ms"} {
+		if !pattern.MatchString(valid) {
+			t.Errorf("expected %q to match the duration pattern", valid)
+		}
+	}
+	for _, invalid := range []string{"", "30", "soon", "PT30S"} {
+		if pattern.MatchString(invalid) {
+			t.Errorf("expected %q not to match the duration pattern", invalid)
+		}
+	}
+}
+
+func TestConfigSchemaCmd(t *testing.T) {
+	cmd := ConfigCmd()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"schema"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("config schema error = %v", err)
+	}
+
+	var schema map[string]any
+	if err := json.Unmarshal(out.Bytes(), &schema); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if schema["$schema"] != jsonSchemaDraft {
+		t.Fatalf("expected the schema to declare its draft, got %v", schema["$schema"])
+	}
+}
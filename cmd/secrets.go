@@ -0,0 +1,228 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// secretCommandTimeout bounds how long an exec secret provider may run.
+const secretCommandTimeout = 30 * time.Second
+
+// secretReferencePattern matches references to secrets in plugin config values, such as
+// ${file:/run/secrets/password}, ${env:DB_PASSWORD} or ${exec:vault-read db/password}.
+var secretReferencePattern = regexp.MustCompile(`\$\{(file|env|exec):([^}]*)\}`)
+
+// hasSecretReference reports whether a plugin config value refers to a secret.
+func hasSecretReference(value string) bool {
+	return secretReferencePattern.MatchString(value)
+}
+
+// resolveSecretReference returns the secret a reference points at.
+//   - file: the contents of the file, without a trailing newline.
+//   - env: the value of the environment variable, which must be set.
+//   - exec: the standard output of the command, without a trailing newline. The command
+//     is split on whitespace, run without a shell, and stopped after secretCommandTimeout.
+func resolveSecretReference(ctx context.Context, provider string, target string) (string, error) {
+	target = strings.TrimSpace(target)
+	if target == "" {
+		return "", fmt.Errorf("%s secret reference is empty", provider)
+	}
+
+	switch provider {
+	case "file":
+		content, err := os.ReadFile(target)
+		if err != nil {
+			return "", fmt.Errorf("reading secret file: %w", err)
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	case "env":
+		value, ok := os.LookupEnv(target)
+		if !ok {
+			return "", fmt.Errorf("secret environment variable %s is not set", target)
+		}
+		return value, nil
+	case "exec":
+		ctx, cancel := context.WithTimeout(ctx, secretCommandTimeout)
+		defer cancel()
+
+		args := strings.Fields(target)
+		var stdout bytes.Buffer
+		command := exec.CommandContext(ctx, args[0], args[1:]...)
+		command.Stdout = &stdout
+		// The command's output is the secret, and its errors may contain it, so
+		// neither is included in the error.
+		if err := command.Run(); err != nil {
+			return "", fmt.Errorf("running secret command %s: %w", args[0], err)
+		}
+		return strings.TrimRight(stdout.String(), "\r\n"), nil
+	default:
+		return "", fmt.Errorf("unsupported secret provider %q", provider)
+	}
+}
+
+// resolvePluginConfig returns a plugin's config with secret references replaced by the
+// secrets they point at, which are then masked in the agent's logs. Values without a
+// reference are returned as they are.
+func (ar *AgentRunner) resolvePluginConfig(ctx context.Context, config agentPluginConfig) (agentPluginConfig, error) {
+	if config == nil {
+		return nil, nil
+	}
+
+	resolved := make(agentPluginConfig, len(config))
+	for key, value := range config {
+		if !hasSecretReference(value) {
+			resolved[key] = value
+			continue
+		}
+
+		var resolveErr error
+		resolved[key] = secretReferencePattern.ReplaceAllStringFunc(value, func(reference string) string {
+			match := secretReferencePattern.FindStringSubmatch(reference)
+			secret, err := resolveSecretReference(ctx, match[1], match[2])
+			if err != nil {
+				resolveErr = err
+				return ""
+			}
+			ar.secrets.add(secret)
+			return secret
+		})
+		if resolveErr != nil {
+			return nil, fmt.Errorf("resolving secret for config %s: %w", key, resolveErr)
+		}
+	}
+
+	return resolved, nil
+}
+
+// withResolvedConfig returns a copy of plugin whose config has its secret references
+// resolved. The plugin itself keeps the references, so that they, and not the secrets,
+// are what the agent's configuration hash is made of.
+func (ar *AgentRunner) withResolvedConfig(ctx context.Context, plugin *agentPlugin) (*agentPlugin, error) {
+	config, err := ar.resolvePluginConfig(ctx, plugin.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := *plugin
+	resolved.Config = config
+	return &resolved, nil
+}
+
+// logOutput is where the agent and its plugins write their logs, with any resolved
+// secrets masked.
+func (ar *AgentRunner) logOutput() io.Writer {
+	out := ar.logWriter
+	if out == nil {
+		out = os.Stdout
+	}
+	if ar.secrets == nil {
+		return out
+	}
+	return ar.secrets.writer(out)
+}
+
+// minRedactedSecretLength is the length below which secrets are not masked. Masking
+// every occurrence of a very short value would mangle the logs without hiding much.
+const minRedactedSecretLength = 4
+
+// secretRedactor masks known secret values in text written to the agent's logs.
+type secretRedactor struct {
+	mu       sync.RWMutex
+	secrets  map[string]struct{}
+	replacer *strings.Replacer
+}
+
+func newSecretRedactor() *secretRedactor {
+	return &secretRedactor{secrets: map[string]struct{}{}}
+}
+
+func (r *secretRedactor) add(secret string) {
+	if r == nil || len(secret) < minRedactedSecretLength {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.secrets[secret]; ok {
+		return
+	}
+	r.secrets[secret] = struct{}{}
+
+	// Each secret is also masked in the escaped forms hclog and JSON logs write values
+	// with quotes, backslashes or control characters in. Longer forms are replaced
+	// first, so that a secret containing another is masked whole.
+	forms := map[string]struct{}{}
+	for known := range r.secrets {
+		for _, form := range secretForms(known) {
+			forms[form] = struct{}{}
+		}
+	}
+	secrets := make([]string, 0, len(forms))
+	for form := range forms {
+		secrets = append(secrets, form)
+	}
+	sort.Slice(secrets, func(i, j int) bool {
+		if len(secrets[i]) != len(secrets[j]) {
+			return len(secrets[i]) > len(secrets[j])
+		}
+		return secrets[i] < secrets[j]
+	})
+	pairs := make([]string, 0, 2*len(secrets))
+	for _, known := range secrets {
+		pairs = append(pairs, known, maskedConfigValue)
+	}
+	r.replacer = strings.NewReplacer(pairs...)
+}
+
+// secretForms are the ways a secret can appear in a log line: as it is, quoted with
+// strconv.Quote, as hclog does for values that need it, and escaped as a JSON string.
+func secretForms(secret string) []string {
+	forms := []string{secret}
+	if quoted := strconv.Quote(secret); quoted[1:len(quoted)-1] != secret {
+		forms = append(forms, quoted[1:len(quoted)-1])
+	}
+	if encoded, err := json.Marshal(secret); err == nil && string(encoded[1:len(encoded)-1]) != secret {
+		forms = append(forms, string(encoded[1:len(encoded)-1]))
+	}
+	return forms
+}
+
+func (r *secretRedactor) redact(text string) string {
+	r.mu.RLock()
+	replacer := r.replacer
+	r.mu.RUnlock()
+
+	if replacer == nil {
+		return text
+	}
+	return replacer.Replace(text)
+}
+
+// writer returns out with known secrets masked in everything written to it. hclog writes
+// each log line in a single call, so a secret is never split across writes.
+func (r *secretRedactor) writer(out io.Writer) io.Writer {
+	return &redactingWriter{out: out, redactor: r}
+}
+
+type redactingWriter struct {
+	out      io.Writer
+	redactor *secretRedactor
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.out, w.redactor.redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestResolvePluginConfig(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(secretFile, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+	t.Setenv("CCF_TEST_SECRET", "from-env")
+
+	agentRunner := NewAgentRunner()
+	resolved, err := agentRunner.resolvePluginConfig(context.Background(), agentPluginConfig{
+		"plain":    "value",
+		"file":     "${file:" + secretFile + "}",
+		"env":      "${env:CCF_TEST_SECRET}",
+		"exec":     "${exec:echo from-exec}",
+		"embedded": "postgres://agent:${env:CCF_TEST_SECRET}@db:5432",
+		"unknown":  "${vault:secret/db}",
+	})
+	if err != nil {
+		t.Fatalf("resolvePluginConfig() error = %v", err)
+	}
+
+	expected := agentPluginConfig{
+		"plain":    "value",
+		"file":     "from-file",
+		"env":      "from-env",
+		"exec":     "from-exec",
+		"embedded": "postgres://agent:from-env@db:5432",
+		"unknown":  "${vault:secret/db}",
+	}
+	for key, want := range expected {
+		if resolved[key] != want {
+			t.Errorf("resolved[%q] = %q, expected %q", key, resolved[key], want)
+		}
+	}
+}
+
+func TestResolvePluginConfig_MissingSecret(t *testing.T) {
+	agentRunner := NewAgentRunner()
+
+	for _, reference := range []string{
+		"${env:CCF_TEST_SECRET_THAT_IS_NOT_SET}",
+		"${file:" + filepath.Join(t.TempDir(), "missing") + "}",
+		"${exec:false}",
+		"${env:}",
+	} {
+		_, err := agentRunner.resolvePluginConfig(context.Background(), agentPluginConfig{"password": reference})
+		if err == nil || !strings.Contains(err.Error(), "config password") {
+			t.Errorf("resolvePluginConfig(%q) error = %v, expected an error naming the config key", reference, err)
+		}
+	}
+}
+
+func TestWithResolvedConfig_KeepsReferencesInConfigHash(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(secretFile, []byte("first"), 0o600); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+	plugin := &agentPlugin{Source: "ghcr.io/plugin:v1", Config: agentPluginConfig{"password": "${file:" + secretFile + "}"}}
+	config := &agentConfig{Plugins: map[string]*agentPlugin{"db": plugin}}
+	agentRunner := NewAgentRunner()
+
+	first, err := agentRunner.withResolvedConfig(context.Background(), plugin)
+	if err != nil {
+		t.Fatalf("withResolvedConfig() error = %v", err)
+	}
+	hash := agentConfigurationHash(config)
+
+	if err := os.WriteFile(secretFile, []byte("rotated"), 0o600); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+	rotated, err := agentRunner.withResolvedConfig(context.Background(), plugin)
+	if err != nil {
+		t.Fatalf("withResolvedConfig() error = %v", err)
+	}
+
+	if plugin.Config["password"] != "${file:"+secretFile+"}" {
+		t.Fatal("expected the configured plugin to keep its secret reference")
+	}
+	if got := agentConfigurationHash(config); got != hash {
+		t.Fatal("expected rotating a secret not to change the agent configuration hash")
+	}
+	if pluginConfigurationHash(first) == pluginConfigurationHash(rotated) {
+		t.Fatal("expected rotating a secret to change the resolved plugin configuration")
+	}
+}
+
+func TestSecretRedactor_MasksResolvedSecretsInLogs(t *testing.T) {
+	t.Setenv("CCF_TEST_SECRET", "hunter2")
+	t.Setenv("CCF_TEST_LONGER_SECRET", "hunter2-and-more")
+
+	agentRunner := NewAgentRunner()
+	if _, err := agentRunner.resolvePluginConfig(context.Background(), agentPluginConfig{
+		"password": "${env:CCF_TEST_SECRET}",
+		"token":    "${env:CCF_TEST_LONGER_SECRET}",
+	}); err != nil {
+		t.Fatalf("resolvePluginConfig() error = %v", err)
+	}
+
+	var out bytes.Buffer
+	logger := hclog.New(&hclog.LoggerOptions{Output: agentRunner.secrets.writer(&out)})
+	logger.Info("connecting", "password", "hunter2", "token", "hunter2-and-more")
+
+	if strings.Contains(out.String(), "hunter2") {
+		t.Fatalf("expected secrets to be masked, got %q", out.String())
+	}
+	if !strings.Contains(out.String(), "password="+maskedConfigValue+" token="+maskedConfigValue) {
+		t.Fatalf("expected each secret to be replaced by the mask, got %q", out.String())
+	}
+}
+
+func TestSecretRedactor_MasksEscapedSecrets(t *testing.T) {
+	redactor := newSecretRedactor()
+	for _, secret := range []string{`pa"ss word`, `C:\secrets\key`, "tab\tseparated"} {
+		redactor.add(secret)
+	}
+
+	var out bytes.Buffer
+	logger := hclog.New(&hclog.LoggerOptions{Output: redactor.writer(&out)})
+	logger.Info("connecting", "password", `pa"ss word`, "path", `C:\secrets\key`, "text", "tab\tseparated")
+	jsonLogger := hclog.New(&hclog.LoggerOptions{Output: redactor.writer(&out), JSONFormat: true})
+	jsonLogger.Info("connecting", "password", `pa"ss word`, "path", `C:\secrets\key`, "text", "tab\tseparated")
+
+	for _, leaked := range []string{"ss word", "secrets", "separated"} {
+		if strings.Contains(out.String(), leaked) {
+			t.Fatalf("expected escaped secrets to be masked, found %q in %q", leaked, out.String())
+		}
+	}
+}
+
+func TestSecretRedactor_SkipsShortSecrets(t *testing.T) {
+	redactor := newSecretRedactor()
+	redactor.add("on")
+
+	if got := redactor.redact("connection on port 22"); got != "connection on port 22" {
+		t.Fatalf("expected a short secret not to be masked, got %q", got)
+	}
+}
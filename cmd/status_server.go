@@ -0,0 +1,515 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/compliance-framework/agent/internal"
+	"github.com/hashicorp/go-hclog"
+)
+
+const statusAPIUnixPrefix = "unix:"
+const statusAPIShutdownTimeout = 5 * time.Second
+const maskedConfigValue = "********"
+
+// statusAPIRequestHeader must be set on requests that change the agent's state. Browsers
+// only send custom headers cross-origin after a CORS preflight, which the status API
+// never allows, so a web page cannot make these requests on a user's behalf.
+const statusAPIRequestHeader = "X-Requested-By"
+
+type agentStatusAPIConfig struct {
+	Listen string `mapstructure:"listen,omitempty"`
+}
+
+func (ac *agentConfig) statusAPIListen() string {
+	if ac == nil || ac.StatusAPI == nil {
+		return ""
+	}
+
+	return strings.TrimSpace(ac.StatusAPI.Listen)
+}
+
+func (ac *agentConfig) validateStatusAPI() error {
+	listen := ac.statusAPIListen()
+	if listen == "" {
+		return nil
+	}
+
+	if strings.HasPrefix(listen, statusAPIUnixPrefix) {
+		if strings.TrimSpace(strings.TrimPrefix(listen, statusAPIUnixPrefix)) == "" {
+			return fmt.Errorf("status_api.listen unix socket path must not be empty")
+		}
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return fmt.Errorf("status_api.listen must be host:port or unix:<path>: %w", err)
+	}
+
+	if host == "localhost" {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("status_api.listen must use a loopback address or a unix socket, got %q", listen)
+	}
+
+	return nil
+}
+
+type statusPolicyView struct {
+	Source   string `json:"source"`
+	Location string `json:"location,omitempty"`
+	Digest   string `json:"digest,omitempty"`
+}
+
+type statusPluginView struct {
+	Name            string             `json:"name"`
+	Status          pluginRunStatus    `json:"status"`
+	LastStartedAt   *time.Time         `json:"last_started_at,omitempty"`
+	LastFinishedAt  *time.Time         `json:"last_finished_at,omitempty"`
+	LastError       string             `json:"last_error,omitempty"`
+	Schedule        string             `json:"schedule"`
+	NextRunAt       *time.Time         `json:"next_run_at,omitempty"`
+	ProtocolVersion int32              `json:"protocol_version"`
+	Source          string             `json:"source"`
+	SourceLocation  string             `json:"source_location,omitempty"`
+	SourceDigest    string             `json:"source_digest,omitempty"`
+	Attempt         int                `json:"attempt,omitempty"`
+	MaxAttempts     int                `json:"max_attempts,omitempty"`
+	NextRetryAt     *time.Time         `json:"next_retry_at,omitempty"`
+	Policies        []statusPolicyView `json:"policies"`
+}
+
+type statusSummaryView struct {
+	Passing []string `json:"passing"`
+	Failed  []string `json:"failed"`
+	Pending []string `json:"pending"`
+}
+
+type statusView struct {
+	Daemon  bool               `json:"daemon"`
+	Summary statusSummaryView  `json:"summary"`
+	Plugins []statusPluginView `json:"plugins"`
+}
+
+type statusErrorView struct {
+	Error string `json:"error"`
+}
+
+// statusServer is a local HTTP server exposing the state of a running daemon. It is only
+// ever bound to a loopback address or a unix socket.
+type statusServer struct {
+	server   *http.Server
+	listener net.Listener
+	logger   hclog.Logger
+}
+
+func (ar *AgentRunner) startStatusServer(listen string) (*statusServer, error) {
+	logger := ar.getLogger().Named("status-api")
+
+	var (
+		listener net.Listener
+		err      error
+	)
+	if strings.HasPrefix(listen, statusAPIUnixPrefix) {
+		socketPath := strings.TrimSpace(strings.TrimPrefix(listen, statusAPIUnixPrefix))
+		if removeErr := os.Remove(socketPath); removeErr != nil && !os.IsNotExist(removeErr) {
+			return nil, removeErr
+		}
+		listener, err = net.Listen("unix", socketPath)
+		if err == nil {
+			err = os.Chmod(socketPath, 0o600)
+		}
+	} else {
+		listener, err = net.Listen("tcp", listen)
+	}
+	if err != nil {
+		if listener != nil {
+			listener.Close()
+		}
+		return nil, fmt.Errorf("start status api on %q: %w", listen, err)
+	}
+
+	s := &statusServer{
+		server: &http.Server{
+			Handler:           ar.statusHandler(),
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+		listener: listener,
+		logger:   logger,
+	}
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Status API stopped unexpectedly", "error", err)
+		}
+	}()
+	logger.Info("Status API listening", "address", listener.Addr().String())
+	return s, nil
+}
+
+func (s *statusServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *statusServer) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), statusAPIShutdownTimeout)
+	defer cancel()
+
+	if err := s.server.Shutdown(ctx); err != nil {
+		s.logger.Warn("Failed to shut down status API cleanly", "error", err)
+	}
+}
+
+func (ar *AgentRunner) statusHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/status", ar.handleStatus)
+	mux.HandleFunc("GET /v1/plugins/{name}", ar.handlePluginStatus)
+	mux.HandleFunc("POST /v1/plugins/{name}/run", requireStatusAPIRequestHeader(ar.handleRunPlugin))
+	mux.HandleFunc("GET /v1/config", ar.handleConfig)
+	mux.HandleFunc("POST /v1/reload", requireStatusAPIRequestHeader(ar.handleReload))
+	mux.Handle("GET /metrics", internal.MetricsHandler())
+	return mux
+}
+
+// requireStatusAPIRequestHeader rejects requests that a web page could have made: those
+// without statusAPIRequestHeader, and those a browser sent, which carry an Origin.
+func requireStatusAPIRequestHeader(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if strings.TrimSpace(r.Header.Get(statusAPIRequestHeader)) == "" {
+			writeStatusJSON(w, http.StatusForbidden, statusErrorView{Error: fmt.Sprintf("the %s header is required", statusAPIRequestHeader)})
+			return
+		}
+		if r.Header.Get("Origin") != "" {
+			writeStatusJSON(w, http.StatusForbidden, statusErrorView{Error: "requests from browsers are not allowed"})
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (ar *AgentRunner) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeStatusJSON(w, http.StatusOK, ar.buildStatusView())
+}
+
+func (ar *AgentRunner) handlePluginStatus(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	for _, plugin := range ar.buildStatusView().Plugins {
+		if plugin.Name == name {
+			writeStatusJSON(w, http.StatusOK, plugin)
+			return
+		}
+	}
+
+	writeStatusJSON(w, http.StatusNotFound, statusErrorView{Error: fmt.Sprintf("plugin %q is not configured", name)})
+}
+
+func (ar *AgentRunner) handleRunPlugin(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := ar.triggerPluginRun(name); err != nil {
+		status := http.StatusServiceUnavailable
+		switch {
+		case errors.Is(err, errPluginNotConfigured):
+			status = http.StatusNotFound
+		case errors.Is(err, errPluginAlreadyRunning):
+			status = http.StatusConflict
+		}
+		writeStatusJSON(w, status, statusErrorView{Error: err.Error()})
+		return
+	}
+
+	writeStatusJSON(w, http.StatusAccepted, map[string]string{"plugin": name, "status": "triggered"})
+}
+
+func (ar *AgentRunner) handleConfig(w http.ResponseWriter, r *http.Request) {
+	writeStatusJSON(w, http.StatusOK, maskedAgentConfig(ar.getConfig()))
+}
+
+func (ar *AgentRunner) handleReload(w http.ResponseWriter, r *http.Request) {
+	ar.cronMu.RLock()
+	reload := ar.reloadFunc
+	ar.cronMu.RUnlock()
+
+	if reload == nil {
+		writeStatusJSON(w, http.StatusServiceUnavailable, statusErrorView{Error: "config reload is not available"})
+		return
+	}
+
+	ar.getLogger().Info("Config reload requested through status API")
+	reload()
+	writeStatusJSON(w, http.StatusAccepted, map[string]string{"status": "reloading"})
+}
+
+func writeStatusJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(payload)
+}
+
+func (ar *AgentRunner) setReloadFunc(reload func()) {
+	ar.cronMu.Lock()
+	defer ar.cronMu.Unlock()
+
+	ar.reloadFunc = reload
+}
+
+var errPluginNotConfigured = errors.New("plugin is not configured")
+var errPluginAlreadyRunning = errors.New("plugin is already running")
+
+// triggerPluginRun runs a scheduled plugin immediately, outside of its cron schedule. The
+// run goes through the same job as the schedule, so it is skipped rather than duplicated
+// if the plugin is already running.
+func (ar *AgentRunner) triggerPluginRun(name string) error {
+	ar.cronMu.RLock()
+	job, ok := ar.pluginJobs[name]
+	scheduled := ar.pluginJobs != nil
+	ar.cronMu.RUnlock()
+
+	if !ok {
+		if config := ar.getConfig(); config != nil {
+			if _, configured := config.Plugins[name]; configured && !scheduled {
+				return fmt.Errorf("plugin %q cannot be triggered because the daemon is not running", name)
+			}
+		}
+		return fmt.Errorf("%w: %s", errPluginNotConfigured, name)
+	}
+
+	if ar.pluginRunRecord(name).Status == pluginRunStatusRunning {
+		return fmt.Errorf("%w: %s", errPluginAlreadyRunning, name)
+	}
+
+	ar.getLogger().Info("Plugin run triggered through status API", "plugin", name)
+	go job.Run()
+	return nil
+}
+
+func (ar *AgentRunner) clearPluginCron() {
+	ar.cronMu.Lock()
+	defer ar.cronMu.Unlock()
+
+	ar.pluginCron = nil
+	ar.pluginCronEntries = nil
+	ar.pluginJobs = nil
+}
+
+func (ar *AgentRunner) pluginRunRecord(name string) pluginRunRecord {
+	ar.pluginRunMu.RLock()
+	defer ar.pluginRunMu.RUnlock()
+
+	return ar.pluginRuns[name]
+}
+
+func (ar *AgentRunner) pluginRunRecords() map[string]pluginRunRecord {
+	ar.pluginRunMu.RLock()
+	defer ar.pluginRunMu.RUnlock()
+
+	records := make(map[string]pluginRunRecord, len(ar.pluginRuns))
+	for name, record := range ar.pluginRuns {
+		records[name] = record
+	}
+	return records
+}
+
+func (ar *AgentRunner) nextPluginRuns() map[string]time.Time {
+	ar.cronMu.RLock()
+	defer ar.cronMu.RUnlock()
+
+	next := map[string]time.Time{}
+	if ar.pluginCron == nil {
+		return next
+	}
+
+	for name, entryID := range ar.pluginCronEntries {
+		entry := ar.pluginCron.Entry(entryID)
+		if entry.Valid() && !entry.Next.IsZero() {
+			next[name] = entry.Next.UTC()
+		}
+	}
+	return next
+}
+
+func (ar *AgentRunner) buildStatusView() statusView {
+	config := ar.getConfig()
+	snapshot := ar.pluginRunSnapshot()
+	records := ar.pluginRunRecords()
+	nextRuns := ar.nextPluginRuns()
+
+	view := statusView{
+		Summary: statusSummaryView{
+			Passing: nonNilStrings(snapshot.Passing),
+			Failed:  nonNilStrings(snapshot.Failed),
+			Pending: nonNilStrings(snapshot.Pending),
+		},
+		Plugins: []statusPluginView{},
+	}
+	if config == nil {
+		return view
+	}
+	view.Daemon = config.Daemon
+
+	pluginNames := make([]string, 0, len(config.Plugins))
+	for name := range config.Plugins {
+		pluginNames = append(pluginNames, name)
+	}
+	sort.Strings(pluginNames)
+
+	for _, name := range pluginNames {
+		pluginConfig := config.Plugins[name]
+		record := records[name]
+		plugin := statusPluginView{
+			Name:      name,
+			Status:    record.Status,
+			LastError: record.Error,
+			Schedule:  "* * * * *",
+			Policies:  []statusPolicyView{},
+		}
+		if plugin.Status == "" {
+			plugin.Status = pluginRunStatusPending
+		}
+		if !record.StartedAt.IsZero() {
+			startedAt := record.StartedAt
+			plugin.LastStartedAt = &startedAt
+		}
+		if !record.FinishedAt.IsZero() {
+			finishedAt := record.FinishedAt
+			plugin.LastFinishedAt = &finishedAt
+		}
+		if next, ok := nextRuns[name]; ok {
+			plugin.NextRunAt = &next
+		}
+		plugin.Attempt = record.Attempt
+		plugin.MaxAttempts = record.MaxAttempts
+		if !record.NextRetryAt.IsZero() {
+			nextRetryAt := record.NextRetryAt
+			plugin.NextRetryAt = &nextRetryAt
+		}
+		if pluginConfig != nil {
+			if pluginConfig.Schedule != nil {
+				plugin.Schedule = *pluginConfig.Schedule
+			}
+			plugin.ProtocolVersion = pluginConfig.ProtocolVersion
+			plugin.Source = pluginConfig.Source
+			plugin.SourceLocation = ar.pluginLocation(pluginConfig.Source)
+			plugin.SourceDigest = record.SourceDigest
+			for _, policy := range pluginConfig.Policies {
+				plugin.Policies = append(plugin.Policies, statusPolicyView{
+					Source:   string(policy),
+					Location: ar.policyLocation(string(policy)),
+					Digest:   record.PolicyDigests[string(policy)],
+				})
+			}
+		}
+		view.Plugins = append(view.Plugins, plugin)
+	}
+
+	return view
+}
+
+func nonNilStrings(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+// maskedAgentConfig renders the effective configuration, with every field under its
+// mapstructure key, and credentials and sensitive values replaced, so it is safe to
+// expose over the status API.
+func maskedAgentConfig(config *agentConfig) map[string]interface{} {
+	if config == nil {
+		return map[string]interface{}{}
+	}
+
+	masked, _ := maskedConfigField(reflect.ValueOf(*config), false).(map[string]interface{})
+	return masked
+}
+
+// maskedConfigField converts a configuration value to plain maps and slices keyed by the
+// mapstructure tags of its fields. Values under a key that looks sensitive, at any depth,
+// are masked, whether the key is a config field, a plugin config key or a policy_data key.
+// Credentials in URLs, such as database DSNs, are masked under any key.
+func maskedConfigField(value reflect.Value, sensitive bool) interface{} {
+	switch value.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if value.IsNil() {
+			return nil
+		}
+		return maskedConfigField(value.Elem(), sensitive)
+	case reflect.Struct:
+		fields := map[string]interface{}{}
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			key, _, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+			if !field.IsExported() || key == "" || key == "-" {
+				continue
+			}
+			fields[key] = maskedConfigField(value.Field(i), sensitive || isSensitiveConfigKey(key))
+		}
+		return fields
+	case reflect.Map:
+		if value.IsNil() {
+			return nil
+		}
+		entries := map[string]interface{}{}
+		iter := value.MapRange()
+		for iter.Next() {
+			key := fmt.Sprint(iter.Key().Interface())
+			entries[key] = maskedConfigField(iter.Value(), sensitive || isSensitiveConfigKey(key))
+		}
+		return entries
+	case reflect.Slice, reflect.Array:
+		if value.Kind() == reflect.Slice && value.IsNil() {
+			return nil
+		}
+		items := make([]interface{}, 0, value.Len())
+		for i := 0; i < value.Len(); i++ {
+			items = append(items, maskedConfigField(value.Index(i), sensitive))
+		}
+		return items
+	default:
+		if sensitive && !value.IsZero() {
+			return maskedConfigValue
+		}
+		if value.Kind() == reflect.String {
+			return maskedURLCredentials(value.String())
+		}
+		return value.Interface()
+	}
+}
+
+// maskedURLCredentials masks the userinfo of a URL, such as the user and password of
+// postgres://user:password@db:5432/app. Other values are returned as they are.
+func maskedURLCredentials(value string) string {
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.User == nil || parsed.Host == "" {
+		return value
+	}
+	parsed.User = nil
+	return strings.Replace(parsed.String(), "//", "//"+maskedConfigValue+"@", 1)
+}
+
+var sensitiveConfigKeyParts = []string{"password", "passwd", "secret", "token", "credential", "private_key", "privatekey", "api_key", "apikey", "auth"}
+
+func isSensitiveConfigKey(key string) bool {
+	normalized := strings.ToLower(strings.ReplaceAll(key, "-", "_"))
+	for _, part := range sensitiveConfigKeyParts {
+		if strings.Contains(normalized, part) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/compliance-framework/agent/runner"
+	"github.com/compliance-framework/agent/runner/proto"
+	"github.com/hashicorp/go-hclog"
+)
+
+// errPluginTimeout is wrapped by the error of a plugin run that exceeded its timeout.
+var errPluginTimeout = errors.New("plugin run timed out")
+
+// runTimeout returns how long a single run of the plugin may take. Zero means the run is
+// not bounded.
+func (p *agentPlugin) runTimeout() (time.Duration, error) {
+	raw := strings.TrimSpace(p.Timeout)
+	if raw == "" {
+		return 0, nil
+	}
+
+	timeout, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("timeout must be a Go duration such as 30s or 5m: %w", err)
+	}
+	if timeout < 0 {
+		return 0, fmt.Errorf("timeout must not be negative")
+	}
+
+	return timeout, nil
+}
+
+// pluginRunContext bounds the calls into a plugin process by timeout. When the timeout
+// passes, kill is called so that a plugin which ignores cancellation cannot keep holding
+// its schedule. The returned cancel func must be called once the run is over.
+func pluginRunContext(ctx context.Context, timeout time.Duration, kill func(), logger hclog.Logger) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	stop := context.AfterFunc(runCtx, func() {
+		if errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+			logger.Error("Plugin exceeded its timeout, killing plugin process", "timeout", timeout.String())
+			kill()
+		}
+	})
+
+	return runCtx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// pluginTimeoutError replaces the error of a call that failed because the run exceeded
+// its timeout with one that says so, as the underlying error is usually an opaque
+// gRPC deadline or transport error.
+func pluginTimeoutError(ctx context.Context, name string, timeout time.Duration, err error) error {
+	if err == nil || timeout <= 0 || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+
+	return fmt.Errorf("%w: plugin %s did not complete within %s and was killed: %v", errPluginTimeout, name, timeout, err)
+}
+
+func runnerConfigure(ctx context.Context, runnerInstance runner.RunnerV2, request *proto.ConfigureRequest) (*proto.ConfigureResponse, error) {
+	if contextRunner, ok := runnerInstance.(runner.ContextRunner); ok {
+		return contextRunner.ConfigureWithContext(ctx, request)
+	}
+
+	return runnerInstance.Configure(request)
+}
+
+func runnerInit(ctx context.Context, runnerInstance runner.RunnerV2, request *proto.InitRequest, a runner.ApiHelper) (*proto.InitResponse, error) {
+	if contextRunner, ok := runnerInstance.(runner.ContextRunner); ok {
+		return contextRunner.InitWithContext(ctx, request, a)
+	}
+
+	return runnerInstance.Init(request, a)
+}
+
+func runnerEval(ctx context.Context, runnerInstance runner.RunnerV2, request *proto.EvalRequest, a runner.ApiHelper) (*proto.EvalResponse, error) {
+	if contextRunner, ok := runnerInstance.(runner.ContextRunner); ok {
+		return contextRunner.EvalWithContext(ctx, request, a)
+	}
+
+	return runnerInstance.Eval(request, a)
+}
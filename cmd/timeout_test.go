@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/compliance-framework/agent/runner"
+	"github.com/compliance-framework/agent/runner/proto"
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// hangingContextRunner behaves like a plugin whose Eval never returns, so that only the
+// context passed by the agent can end the call.
+type hangingContextRunner struct {
+	initTestRunner
+}
+
+func (r *hangingContextRunner) ConfigureWithContext(ctx context.Context, request *proto.ConfigureRequest) (*proto.ConfigureResponse, error) {
+	return r.Configure(request)
+}
+
+func (r *hangingContextRunner) InitWithContext(ctx context.Context, request *proto.InitRequest, a runner.ApiHelper) (*proto.InitResponse, error) {
+	return r.Init(request, a)
+}
+
+func (r *hangingContextRunner) EvalWithContext(ctx context.Context, request *proto.EvalRequest, a runner.ApiHelper) (*proto.EvalResponse, error) {
+	<-ctx.Done()
+	return nil, status.FromContextError(ctx.Err()).Err()
+}
+
+func TestMergeConfig_ValidatesPluginTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		plugin   string
+		expected string
+		timeout  time.Duration
+	}{
+		{name: "valid", plugin: "    timeout: 90s\n", timeout: 90 * time.Second},
+		{name: "disabled", plugin: "    timeout: 0s\n"},
+		{name: "not a duration", plugin: "    timeout: soon\n", expected: "plugin slow has invalid timeout"},
+		{name: "negative", plugin: "    timeout: -1m\n", expected: "plugin slow has invalid timeout"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := viper.New()
+			v.SetConfigType("yaml")
+			err := v.ReadConfig(bytes.NewBufferString("api:\n  url: http://localhost:8080\n\nplugins:\n  slow:\n    source: ghcr.io/some-plugin:latest\n" + tt.plugin))
+			if err != nil {
+				t.Fatalf("Error reading config: %v", err)
+			}
+
+			config, err := mergeConfig(AgentCmd(), v)
+			if err != nil {
+				t.Fatalf("Error merging config: %v", err)
+			}
+
+			err = config.validate()
+			if tt.expected == "" {
+				if err != nil {
+					t.Fatalf("Expected config to be valid, got %v", err)
+				}
+				if got := config.Plugins["slow"].timeout; got != tt.timeout {
+					t.Fatalf("Expected the parsed timeout %s, got %s", tt.timeout, got)
+				}
+				return
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), tt.expected) {
+				t.Fatalf("Expected error starting with %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestPluginRunContext_KillsHungPluginOnTimeout(t *testing.T) {
+	var killed atomic.Int32
+	timeout := 50 * time.Millisecond
+
+	runCtx, cancel := pluginRunContext(context.Background(), timeout, func() { killed.Add(1) }, hclog.NewNullLogger())
+	defer cancel()
+
+	_, err := runnerEval(runCtx, &hangingContextRunner{}, &proto.EvalRequest{}, nil)
+	err = pluginTimeoutError(runCtx, "hung", timeout, err)
+	if !errors.Is(err, errPluginTimeout) {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "plugin hung did not complete within 50ms") {
+		t.Fatalf("expected error to name the plugin and timeout, got %q", err.Error())
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for killed.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if killed.Load() != 1 {
+		t.Fatalf("expected plugin process to be killed once, got %d", killed.Load())
+	}
+}
+
+func TestPluginRunContext_DoesNotKillPluginThatFinishes(t *testing.T) {
+	var killed atomic.Int32
+
+	runCtx, cancel := pluginRunContext(context.Background(), 20*time.Millisecond, func() { killed.Add(1) }, hclog.NewNullLogger())
+	cancel()
+
+	time.Sleep(50 * time.Millisecond)
+	if killed.Load() != 0 {
+		t.Fatal("expected plugin process not to be killed after the run finished")
+	}
+	if err := pluginTimeoutError(runCtx, "fast", 20*time.Millisecond, errors.New("eval failed")); errors.Is(err, errPluginTimeout) {
+		t.Fatalf("expected a cancelled run not to be reported as timed out, got %v", err)
+	}
+}
+
+func TestPluginRunContext_WithoutTimeout(t *testing.T) {
+	ctx := context.Background()
+
+	runCtx, cancel := pluginRunContext(ctx, 0, func() { t.Fatal("unexpected kill") }, hclog.NewNullLogger())
+	defer cancel()
+
+	if runCtx != ctx {
+		t.Fatal("expected the run context to be unchanged without a timeout")
+	}
+	if _, ok := runCtx.Deadline(); ok {
+		t.Fatal("expected no deadline without a timeout")
+	}
+}
+
+func TestRunnerCalls_FallBackForRunnersWithoutContext(t *testing.T) {
+	r := &initTestRunner{configureErr: status.Error(codes.Internal, "boom")}
+
+	_, err := runnerConfigure(context.Background(), r, &proto.ConfigureRequest{})
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("expected configure error from runner, got %v", err)
+	}
+	if _, err := runnerInit(context.Background(), r, &proto.InitRequest{}, nil); err != nil {
+		t.Fatalf("runnerInit() error = %v", err)
+	}
+	if r.configureCalls != 1 || r.initCalls != 1 {
+		t.Fatalf("expected one configure and init call, got %d and %d", r.configureCalls, r.initCalls)
+	}
+}
@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/compliance-framework/agent/internal"
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/cobra"
+)
+
+// pluginScheduleParser parses plugin schedules, which have no seconds field.
+var pluginScheduleParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+const (
+	validateOutputText = "text"
+	validateOutputJSON = "json"
+)
+
+type validateOptions struct {
+	output string
+}
+
+// validationReport is the result of linting a config file, as printed by `agent validate`.
+type validationReport struct {
+	Config   string   `json:"config"`
+	Valid    bool     `json:"valid"`
+	Problems []string `json:"problems"`
+}
+
+func ValidateCmd() *cobra.Command {
+	opts := &validateOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "check an agent config file without running any plugins",
+		Long: `Validate loads an agent config file the same way the agent does, and reports every problem
+found in it. Plugin schedules, sources, policies and policy_behavior keys are also checked, without
+downloading or running anything. It exits non-zero when the config has problems.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runValidate(cmd, opts)
+		},
+	}
+
+	cmd.Flags().StringP("config", "c", "", "Location of config file")
+	cmd.Flags().String("config-dir", "", "Directory of config fragments (*.yaml) merged over the config file in lexical order")
+	cmd.MarkFlagsOneRequired("config", "config-dir")
+	cmd.Flags().StringVarP(&opts.output, "output", "o", validateOutputText, "Output format: text or json")
+
+	return cmd
+}
+
+func runValidate(cmd *cobra.Command, opts *validateOptions) error {
+	if opts.output != validateOutputText && opts.output != validateOutputJSON {
+		return fmt.Errorf("unsupported output format %q; supported values are %s and %s", opts.output, validateOutputText, validateOutputJSON)
+	}
+	cmd.SilenceUsage = true
+
+	source, err := configSourceFromFlags(cmd)
+	if err != nil {
+		return err
+	}
+
+	report := validationReport{Config: source.String(), Problems: []string{}}
+	for _, err := range validateConfigSource(cmd, source) {
+		report.Problems = append(report.Problems, err.Error())
+	}
+	report.Valid = len(report.Problems) == 0
+
+	if err := writeValidationReport(cmd, opts.output, report); err != nil {
+		return err
+	}
+	if !report.Valid {
+		return fmt.Errorf("config %s has %d problem(s)", report.Config, len(report.Problems))
+	}
+	return nil
+}
+
+// validateConfigSource loads the config through the same path as the agent, and returns
+// every problem found in it.
+func validateConfigSource(cmd *cobra.Command, source agentConfigSource) []error {
+	v, err := source.read()
+	if err != nil {
+		return []error{err}
+	}
+
+	config, err := mergeConfig(cmd, v)
+	if err != nil {
+		return []error{err}
+	}
+
+	return append(config.validationErrors(), config.lintErrors()...)
+}
+
+// lintErrors returns problems that would only surface once plugins are scheduled or
+// downloaded: invalid schedules, sources and policies that are neither local paths nor
+// OCI references, and policy_behavior keys that match none of the plugin's policies.
+func (ac *agentConfig) lintErrors() []error {
+	names := make([]string, 0, len(ac.Plugins))
+	for name := range ac.Plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		pluginConfig := ac.Plugins[name]
+		if pluginConfig == nil {
+			continue
+		}
+
+		if pluginConfig.Schedule != nil {
+			if _, err := pluginScheduleParser.Parse(*pluginConfig.Schedule); err != nil {
+				errs = append(errs, fmt.Errorf("plugin %s has invalid schedule %q: %w", name, *pluginConfig.Schedule, err))
+			}
+		}
+
+		if strings.TrimSpace(pluginConfig.Source) == "" {
+			errs = append(errs, fmt.Errorf("plugin %s has no source", name))
+		} else if !artifactResolvable(pluginConfig.Source) {
+			errs = append(errs, fmt.Errorf("plugin %s source %q is neither an existing local path nor a valid OCI reference", name, pluginConfig.Source))
+		}
+
+		for _, policy := range pluginConfig.Policies {
+			if !artifactResolvable(string(policy)) {
+				errs = append(errs, fmt.Errorf("plugin %s policy %q is neither an existing local path nor a valid OCI reference", name, policy))
+			}
+		}
+
+		keys := make([]string, 0, len(pluginConfig.PolicyBehavior))
+		for key := range pluginConfig.PolicyBehavior {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if !policyBehaviorKeyMatches(key, pluginConfig.Policies) {
+				errs = append(errs, fmt.Errorf("plugin %s has policy_behavior key %q that matches none of its policies", name, key))
+			}
+		}
+	}
+
+	return errs
+}
+
+// artifactResolvable reports whether a plugin or policy source can be found locally, or
+// is a reference the OCI downloader accepts.
+func artifactResolvable(source string) bool {
+	if internal.IsOCI(source) {
+		return true
+	}
+	_, err := os.Stat(source)
+	return err == nil
+}
+
+// policyBehaviorKeyMatches reports whether a policy_behavior key is a substring of any
+// of the policies, which is how plugins select the policies a behavior applies to.
+func policyBehaviorKeyMatches(key string, policies []agentPolicy) bool {
+	if key == "" {
+		return false
+	}
+	for _, policy := range policies {
+		if strings.Contains(string(policy), key) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeValidationReport(cmd *cobra.Command, output string, report validationReport) error {
+	out := cmd.OutOrStdout()
+	if output == validateOutputJSON {
+		encoded, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, string(encoded))
+		return err
+	}
+
+	if report.Valid {
+		_, err := fmt.Fprintf(out, "%s: config is valid\n", report.Config)
+		return err
+	}
+	if _, err := fmt.Fprintf(out, "%s: %d problem(s) found\n", report.Config, len(report.Problems)); err != nil {
+		return err
+	}
+	for _, problem := range report.Problems {
+		if _, err := fmt.Fprintf(out, "  - %s\n", problem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
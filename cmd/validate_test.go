@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runValidateCmd(t *testing.T, config string, args ...string) (string, error) {
+	t.Helper()
+
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, []byte(config), 0o600); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+
+	cmd := ValidateCmd()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs(append([]string{"--config", configPath}, args...))
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func TestValidateCmd_ValidConfig(t *testing.T) {
+	source := writeTestPluginSource(t)
+	policyDir := t.TempDir()
+
+	out, err := runValidateCmd(t, `
+api:
+  url: http://localhost:8080
+plugins:
+  local:
+    source: `+source+`
+    schedule: "@hourly"
+    policies:
+      - `+policyDir+`
+      - ghcr.io/compliance-framework/plugin-local-ssh-policies:v1.0.0
+    policy_behavior:
+      ssh-policies:
+        - remote
+`)
+	if err != nil {
+		t.Fatalf("validate error = %v, output:\n%s", err, out)
+	}
+	if !strings.Contains(out, "config is valid") {
+		t.Fatalf("expected the config to be reported valid, got %q", out)
+	}
+}
+
+func TestValidateCmd_ReportsEveryProblem(t *testing.T) {
+	out, err := runValidateCmd(t, `
+api:
+  url: http://localhost:8080
+max_concurrency: -1
+plugins:
+  broken:
+    protocol_version: 3
+    schedule: "every minute"
+    source: ./does-not-exist
+    policies:
+      - ghcr.io/compliance-framework/policies
+    timeout: soon
+    policy_behavior:
+      unmatched:
+        - remote
+`, "--output", "json")
+	if err == nil {
+		t.Fatal("validate error = nil, expected the invalid config to fail")
+	}
+
+	var report validationReport
+	if err := json.Unmarshal([]byte(out), &report); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v, output:\n%s", err, out)
+	}
+	if report.Valid {
+		t.Fatal("expected the report to be invalid")
+	}
+
+	expected := []string{
+		"max_concurrency",
+		"plugin broken has invalid timeout",
+		"plugin broken has unsupported protocol_version=3",
+		`plugin broken has invalid schedule "every minute"`,
+		`plugin broken source "./does-not-exist" is neither`,
+		`plugin broken policy "ghcr.io/compliance-framework/policies" is neither`,
+		`plugin broken has policy_behavior key "unmatched"`,
+	}
+	if len(report.Problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %d: %v", len(expected), len(report.Problems), report.Problems)
+	}
+	for i, want := range expected {
+		if !strings.Contains(report.Problems[i], want) {
+			t.Fatalf("problem %d = %q, expected it to contain %q", i, report.Problems[i], want)
+		}
+	}
+}
+
+func TestValidateCmd_ReportsUnreadableConfig(t *testing.T) {
+	out, err := runValidateCmd(t, "plugins: [", "--output", "text")
+	if err == nil {
+		t.Fatal("validate error = nil, expected the unparseable config to fail")
+	}
+	if !strings.Contains(out, "1 problem(s) found") {
+		t.Fatalf("expected the parse error to be reported, got %q", out)
+	}
+}
+
+func TestValidateCmd_RejectsUnknownOutput(t *testing.T) {
+	if _, err := runValidateCmd(t, "api:\n  url: http://localhost:8080\n", "--output", "xml"); err == nil {
+		t.Fatal("validate error = nil, expected unsupported output format to fail")
+	}
+}
+
+func TestAgentConfigValidate_JoinsEveryProblem(t *testing.T) {
+	config := &agentConfig{
+		ApiConfig:      &apiConfig{Url: "http://localhost:8080"},
+		MaxConcurrency: -1,
+		Plugins: map[string]*agentPlugin{
+			"a": {Timeout: "soon"},
+			"b": {Timeout: "later"},
+		},
+	}
+
+	errs := config.validationErrors()
+	if len(errs) != 3 {
+		t.Fatalf("expected 3 problems, got %d: %v", len(errs), errs)
+	}
+	if !strings.HasPrefix(errs[1].Error(), "plugin a ") || !strings.HasPrefix(errs[2].Error(), "plugin b ") {
+		t.Fatalf("expected plugin problems in name order, got %v", errs)
+	}
+	if err := config.validate(); err == nil || !strings.Contains(err.Error(), "plugin b has invalid timeout") {
+		t.Fatalf("validate() error = %v, expected every problem to be reported", err)
+	}
+}
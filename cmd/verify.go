@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/compliance-framework/agent/internal"
+)
+
+// agentVerifyConfig configures cosign signature verification of an OCI plugin or policy
+// bundle. Either key is set, or identity and issuer (or their regexp forms) are set for
+// keyless signatures.
+type agentVerifyConfig struct {
+	Key            string `mapstructure:"key,omitempty"`
+	Identity       string `mapstructure:"identity,omitempty"`
+	IdentityRegexp string `mapstructure:"identity_regexp,omitempty"`
+	Issuer         string `mapstructure:"issuer,omitempty"`
+	IssuerRegexp   string `mapstructure:"issuer_regexp,omitempty"`
+	IgnoreTlog     bool   `mapstructure:"ignore_tlog,omitempty"`
+}
+
+func (vc *agentVerifyConfig) options() *internal.VerifyOptions {
+	if vc == nil {
+		return nil
+	}
+
+	return &internal.VerifyOptions{
+		Key:            strings.TrimSpace(vc.Key),
+		Identity:       strings.TrimSpace(vc.Identity),
+		IdentityRegexp: strings.TrimSpace(vc.IdentityRegexp),
+		Issuer:         strings.TrimSpace(vc.Issuer),
+		IssuerRegexp:   strings.TrimSpace(vc.IssuerRegexp),
+		IgnoreTlog:     vc.IgnoreTlog,
+	}
+}
+
+func (vc *agentVerifyConfig) validate() error {
+	return vc.options().Validate()
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestMergeConfig_ParsesVerifyConfiguration(t *testing.T) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	err := v.ReadConfig(bytes.NewBufferString(`api:
+  url: http://localhost:8080
+plugins:
+  signed:
+    source: ghcr.io/some-plugin:v1
+    policies:
+      - ghcr.io/some-policies:v1
+    verify:
+      key: /etc/ccf/cosign.pub
+      ignore_tlog: true
+    policy_verify:
+      identity_regexp: ^https://github.com/compliance-framework/
+      issuer: https://token.actions.githubusercontent.com
+`))
+	if err != nil {
+		t.Fatalf("Error reading config: %v", err)
+	}
+
+	config, err := mergeConfig(AgentCmd(), v)
+	if err != nil {
+		t.Fatalf("Error merging config: %v", err)
+	}
+	if err := config.validate(); err != nil {
+		t.Fatalf("Expected config to be valid, got %v", err)
+	}
+
+	plugin := config.Plugins["signed"]
+	verify := plugin.Verify.options()
+	if verify == nil || verify.Key != "/etc/ccf/cosign.pub" || !verify.IgnoreTlog {
+		t.Fatalf("Unexpected plugin verify options %+v", verify)
+	}
+	policyVerify := plugin.PolicyVerify.options()
+	if policyVerify == nil || policyVerify.IdentityRegexp != "^https://github.com/compliance-framework/" || policyVerify.Issuer != "https://token.actions.githubusercontent.com" {
+		t.Fatalf("Unexpected policy verify options %+v", policyVerify)
+	}
+}
+
+func TestMergeConfig_RejectsIncompleteVerifyConfiguration(t *testing.T) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	err := v.ReadConfig(bytes.NewBufferString("api:\n  url: http://localhost:8080\n\nplugins:\n  signed:\n    source: ghcr.io/some-plugin:v1\n    verify:\n      identity: release@example.com\n"))
+	if err != nil {
+		t.Fatalf("Error reading config: %v", err)
+	}
+
+	config, err := mergeConfig(AgentCmd(), v)
+	if err != nil {
+		t.Fatalf("Error merging config: %v", err)
+	}
+
+	err = config.validate()
+	if err == nil {
+		t.Fatalf("Expected config validation to fail for keyless verification without an issuer")
+	}
+	if !strings.HasPrefix(err.Error(), "plugin signed has invalid verify configuration") {
+		t.Fatalf("Unexpected error %q", err.Error())
+	}
+}
@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "ccf_agent"
+
+const (
+	MetricOutcomeSuccess = "success"
+	MetricOutcomeFailure = "failure"
+	MetricOutcomeQueued  = "queued"
+)
+
+const (
+	DownloadResultDownloaded = "downloaded"
+	DownloadResultCacheHit   = "cache_hit"
+	DownloadResultLocal      = "local"
+	DownloadResultError      = "error"
+)
+
+// MetricsRegistry holds every metric exposed by the agent. A dedicated registry is used
+// instead of the global default so that only agent and process metrics are exported.
+var MetricsRegistry = prometheus.NewRegistry()
+
+var (
+	PluginRunsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "plugin_runs_total",
+		Help:      "Plugin runs completed, by plugin and outcome.",
+	}, []string{"plugin", "outcome"})
+
+	PluginRunDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "plugin_run_duration_seconds",
+		Help:      "Duration of plugin runs, by plugin and outcome.",
+		Buckets:   []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800},
+	}, []string{"plugin", "outcome"})
+
+	PluginRunning = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "plugin_running",
+		Help:      "Whether a plugin is currently running.",
+	}, []string{"plugin"})
+
+	EvidenceCreatedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "evidence_created_total",
+		Help:      "Evidence items submitted by plugins, by plugin and outcome.",
+	}, []string{"plugin", "outcome"})
+
+	HeartbeatsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "heartbeats_total",
+		Help:      "Heartbeats sent to the API, by outcome.",
+	}, []string{"outcome"})
+
+	DownloadsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "downloads_total",
+		Help:      "Plugin and policy artifact resolutions, by artifact kind and result.",
+	}, []string{"kind", "result"})
+
+	DownloadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "download_duration_seconds",
+		Help:      "Time taken to resolve plugin and policy artifacts, by artifact kind and result.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 4, 8),
+	}, []string{"kind", "result"})
+)
+
+func init() {
+	MetricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		PluginRunsTotal,
+		PluginRunDuration,
+		PluginRunning,
+		EvidenceCreatedTotal,
+		HeartbeatsTotal,
+		DownloadsTotal,
+		DownloadDuration,
+	)
+}
+
+// MetricsHandler serves the agent metrics in the Prometheus exposition format.
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(MetricsRegistry, promhttp.HandlerOpts{})
+}
+
+func MetricOutcome(err error) string {
+	if err != nil {
+		return MetricOutcomeFailure
+	}
+	return MetricOutcomeSuccess
+}
+
+func observeDownload(kind string, result string, started time.Time) {
+	DownloadsTotal.WithLabelValues(kind, result).Inc()
+	DownloadDuration.WithLabelValues(kind, result).Observe(time.Since(started).Seconds())
+}
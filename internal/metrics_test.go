@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDownloadRecordsMetrics(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "plugin")
+	if err := os.WriteFile(source, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	local := testutil.ToFloat64(DownloadsTotal.WithLabelValues("plugin", DownloadResultLocal))
+	failed := testutil.ToFloat64(DownloadsTotal.WithLabelValues("plugin", DownloadResultError))
+
+	if _, err := Download(context.Background(), source, dir, "plugin", hclog.NewNullLogger()); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if _, err := Download(context.Background(), filepath.Join(dir, "missing"), dir, "plugin", hclog.NewNullLogger()); err == nil {
+		t.Fatal("expected Download() of a missing source to fail")
+	}
+
+	if got := testutil.ToFloat64(DownloadsTotal.WithLabelValues("plugin", DownloadResultLocal)) - local; got != 1 {
+		t.Fatalf("expected 1 local download to be recorded, got %v", got)
+	}
+	if got := testutil.ToFloat64(DownloadsTotal.WithLabelValues("plugin", DownloadResultError)) - failed; got != 1 {
+		t.Fatalf("expected 1 failed download to be recorded, got %v", got)
+	}
+}
+
+func TestMetricsHandlerServesAgentMetrics(t *testing.T) {
+	HeartbeatsTotal.WithLabelValues(MetricOutcomeSuccess).Inc()
+
+	recorder := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", recorder.Code)
+	}
+	body := recorder.Body.String()
+	for _, name := range []string{"ccf_agent_heartbeats_total", "go_goroutines"} {
+		if !strings.Contains(body, name) {
+			t.Fatalf("expected metrics output to contain %q", name)
+		}
+	}
+}
@@ -1,23 +1,30 @@
 package internal
 
 import (
+	"archive/tar"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/compliance-framework/gooci/pkg/oci"
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/hashicorp/go-hclog"
 )
 
 func IsOCI(source string) bool {
-	// Check whether this can be parsed as an OCI tag, which is what our downloader supports.
-	_, err := name.NewTag(source, name.StrictValidation)
+	// Check whether this can be parsed as an OCI tag or digest reference, which is what our
+	// downloader supports. Strict validation rejects references without an explicit tag.
+	_, err := name.ParseReference(source, name.StrictValidation)
 	return err == nil
 }
 
@@ -107,26 +114,94 @@ func shouldSkipOCIDownload(outDir string, localPath string, binaryPath string) (
 	return true, nil
 }
 
+// PullPolicy controls when a tagged OCI artifact that has already been extracted is
+// downloaded again.
+type PullPolicy string
+
+const (
+	// PullIfNotPresent only downloads a tag that has not been extracted yet.
+	PullIfNotPresent PullPolicy = "if-not-present"
+	// PullAlways downloads and extracts the tag on every use.
+	PullAlways PullPolicy = "always"
+	// PullOnDigestChange downloads the tag again whenever the digest it points at differs
+	// from the digest that was last extracted.
+	PullOnDigestChange PullPolicy = "on-digest-change"
+)
+
+func (p PullPolicy) Validate() error {
+	switch p {
+	case "", PullIfNotPresent, PullAlways, PullOnDigestChange:
+		return nil
+	default:
+		return fmt.Errorf("unsupported pull policy %q; supported values are %q, %q and %q", string(p), PullIfNotPresent, PullAlways, PullOnDigestChange)
+	}
+}
+
+// DownloadOptions controls how an artifact is resolved by DownloadArtifact.
+type DownloadOptions struct {
+	// Verify, when set, requires the cosign signature of the OCI artifact to be verified
+	// before anything is extracted. The artifact is then fetched by the verified digest,
+	// and local sources, which cannot be verified, are refused.
+	Verify *VerifyOptions
+	// PullPolicy applies to tag references. Digest references never change, so they are
+	// only downloaded once. Defaults to PullIfNotPresent.
+	PullPolicy PullPolicy
+}
+
+// Artifact is a downloaded plugin or policy bundle.
+type Artifact struct {
+	// Path is the local path to the plugin executable or policy directory.
+	Path string
+	// Digest is the OCI manifest digest the artifact was extracted from. It is empty for
+	// local sources, and for tags extracted before digests were recorded.
+	Digest string
+}
+
+// digestFile records, inside a tag's extraction directory, the digest that was extracted.
+const digestFile = ".digest"
+
 func Download(ctx context.Context, source string, outputDir string, binaryPath string, logger hclog.Logger, option ...remote.Option) (string, error) {
+	artifact, err := DownloadArtifact(ctx, source, outputDir, binaryPath, DownloadOptions{}, logger, option...)
+	return artifact.Path, err
+}
+
+func DownloadArtifact(ctx context.Context, source string, outputDir string, binaryPath string, opts DownloadOptions, logger hclog.Logger, option ...remote.Option) (Artifact, error) {
+	started := time.Now()
+	artifact, result, err := download(ctx, source, outputDir, binaryPath, opts, logger, option...)
+	if err != nil {
+		result = DownloadResultError
+	}
+	observeDownload(binaryPath, result, started)
+	return artifact, err
+}
+
+func download(ctx context.Context, source string, outputDir string, binaryPath string, opts DownloadOptions, logger hclog.Logger, option ...remote.Option) (Artifact, string, error) {
 	// Add a task to indicate we've downloaded the items
 	logger.Trace("Checking for source", "source", source)
 
+	if err := opts.PullPolicy.Validate(); err != nil {
+		return Artifact{}, "", err
+	}
+
 	// First we check if the source is a path that exists on the fs, if so we just use that.
 	sourceInfo, err := os.Stat(source)
 
 	if err == nil {
+		if opts.Verify != nil {
+			return Artifact{}, "", fmt.Errorf("signature verification is configured, but %q is a local path and cannot be verified", source)
+		}
 		if sourceInfo.IsDir() {
 			localPath := filepath.Join(source, binaryPath)
 			useNestedPath, err := shouldSkipOCIDownload(source, localPath, binaryPath)
 			if err != nil {
-				return "", err
+				return Artifact{}, "", err
 			}
 			if useNestedPath {
 				logger.Debug("Found source locally, using extracted artifact path", "Path", localPath)
-				return localPath, nil
+				return Artifact{Path: localPath}, DownloadResultLocal, nil
 			}
 			if binaryPath == "plugin" {
-				return "", fmt.Errorf("expected plugin executable at %q", localPath)
+				return Artifact{}, "", fmt.Errorf("expected plugin executable at %q", localPath)
 			}
 		}
 
@@ -134,48 +209,225 @@ func Download(ctx context.Context, source string, outputDir string, binaryPath s
 		logger.Debug("Found source locally, using local path", "Path", source)
 
 		// The file exists locally, so we use the local path.
-		return source, nil
+		return Artifact{Path: source}, DownloadResultLocal, nil
 	}
 
 	// The error we've received is something other than not exists.
 	// Exit early with the error
 	if !os.IsNotExist(err) {
-		return "", err
+		return Artifact{}, "", err
 	}
 
 	if IsOCI(source) {
 		logger.Debug("Source looks like an OCI endpoint, attempting to download", "Source", source)
-		tag, err := name.NewTag(source)
+		ref, err := name.ParseReference(source)
 		if err != nil {
-			return "", err
+			return Artifact{}, "", err
 		}
 
-		outDir := filepath.Join(outputDir, tag.RepositoryStr(), tag.Identifier())
-		localPath := filepath.Join(outDir, binaryPath)
+		remoteOpts := append([]remote.Option{
+			remote.WithContext(ctx),
+			remote.WithAuthFromKeychain(oci.ECRKeychain()),
+		}, option...)
 
-		skipDownload, err := shouldSkipOCIDownload(outDir, localPath, binaryPath)
-		if err != nil {
-			return "", err
+		if opts.Verify != nil {
+			digest, err := VerifySignature(ctx, ref, opts.Verify, remoteOpts...)
+			if err != nil {
+				return Artifact{}, "", err
+			}
+			logger.Debug("Verified OCI artifact signature", "Source", source, "Digest", digest.DigestStr())
+			return downloadDigest(digest, outputDir, binaryPath, logger, remoteOpts...)
 		}
-		if skipDownload {
-			logger.Debug("OCI extraction path already exists, skipping download", "Source", source, "Path", outDir)
-			return localPath, nil
+
+		if digest, ok := ref.(name.Digest); ok {
+			return downloadDigest(digest, outputDir, binaryPath, logger, remoteOpts...)
 		}
 
-		downloaderImpl, err := oci.NewDownloader(
-			tag,
-			outDir,
-		)
-		if err != nil {
-			return "", err
+		return downloadTag(ref.(name.Tag), outputDir, binaryPath, opts.PullPolicy, logger, remoteOpts...)
+	}
+
+	return Artifact{}, "", errors.New("downloadable item source cannot be found locally and does not look like OCI")
+}
+
+// ociCacheDir returns the directory an OCI artifact is extracted to. Tags are cached under
+// <repository>/<tag>. Digests are cached under <repository>/@<algorithm>/<hex>, which can
+// never collide with a tag since tags cannot start with "@".
+func ociCacheDir(outputDir string, ref name.Reference) string {
+	if digest, ok := ref.(name.Digest); ok {
+		algorithm, hex, found := strings.Cut(digest.DigestStr(), ":")
+		if found {
+			return filepath.Join(outputDir, ref.Context().RepositoryStr(), "@"+algorithm, hex)
+		}
+	}
+
+	return filepath.Join(outputDir, ref.Context().RepositoryStr(), ref.Identifier())
+}
+
+// downloadTag extracts the artifact a tag points at, following the pull policy when the
+// tag has been extracted before. The digest that was extracted is recorded next to it.
+func downloadTag(tag name.Tag, outputDir string, binaryPath string, pullPolicy PullPolicy, logger hclog.Logger, option ...remote.Option) (Artifact, string, error) {
+	outDir := ociCacheDir(outputDir, tag)
+	localPath := filepath.Join(outDir, binaryPath)
+
+	extracted, err := shouldSkipOCIDownload(outDir, localPath, binaryPath)
+	if err != nil {
+		return Artifact{}, "", err
+	}
+	storedDigest := ""
+	if content, err := os.ReadFile(filepath.Join(outDir, digestFile)); err == nil {
+		storedDigest = strings.TrimSpace(string(content))
+	}
+
+	if extracted && (pullPolicy == "" || pullPolicy == PullIfNotPresent) {
+		logger.Debug("OCI extraction path already exists, skipping download", "Source", tag.String(), "Path", outDir)
+		return Artifact{Path: localPath, Digest: storedDigest}, DownloadResultCacheHit, nil
+	}
+
+	desc, err := remote.Head(tag, option...)
+	if err != nil {
+		return Artifact{}, "", fmt.Errorf("resolve digest of %s: %w", tag, err)
+	}
+	digest := tag.Context().Digest(desc.Digest.String())
+
+	if extracted && pullPolicy == PullOnDigestChange && storedDigest == digest.DigestStr() {
+		logger.Debug("OCI tag digest unchanged, skipping download", "Source", tag.String(), "Digest", storedDigest, "Path", outDir)
+		return Artifact{Path: localPath, Digest: storedDigest}, DownloadResultCacheHit, nil
+	}
+
+	if extracted {
+		logger.Info("Refreshing OCI artifact", "Source", tag.String(), "pull_policy", string(pullPolicy), "previous_digest", storedDigest, "digest", digest.DigestStr())
+	}
+	if err := extractDigest(digest, outDir, option...); err != nil {
+		return Artifact{}, "", err
+	}
+
+	return Artifact{Path: localPath, Digest: digest.DigestStr()}, DownloadResultDownloaded, nil
+}
+
+// downloadDigest fetches and extracts the artifact at an immutable digest. Since the
+// content of a digest never changes, an existing extraction is always reused.
+func downloadDigest(digest name.Digest, outputDir string, binaryPath string, logger hclog.Logger, option ...remote.Option) (Artifact, string, error) {
+	outDir := ociCacheDir(outputDir, digest)
+	localPath := filepath.Join(outDir, binaryPath)
+	artifact := Artifact{Path: localPath, Digest: digest.DigestStr()}
+
+	skipDownload, err := shouldSkipOCIDownload(outDir, localPath, binaryPath)
+	if err != nil {
+		return Artifact{}, "", err
+	}
+	if skipDownload {
+		logger.Debug("OCI extraction path already exists, skipping download", "Source", digest.String(), "Path", outDir)
+		return artifact, DownloadResultCacheHit, nil
+	}
+
+	if err := extractDigest(digest, outDir, option...); err != nil {
+		return Artifact{}, "", err
+	}
+
+	return artifact, DownloadResultDownloaded, nil
+}
+
+// extractDigest fetches the artifact at digest and extracts it to outDir, recording the
+// digest alongside it. It extracts next to outDir and swaps the result into place, so
+// readers never observe a partial extraction, and an interrupted download never leaves
+// one behind that would later count as cached.
+func extractDigest(digest name.Digest, outDir string, option ...remote.Option) error {
+	img, err := remote.Image(digest, option...)
+	if err != nil {
+		return err
+	}
+
+	parent := filepath.Dir(outDir)
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return err
+	}
+	tmpDir, err := os.MkdirTemp(parent, filepath.Base(outDir)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := extractImage(img, tmpDir); err != nil {
+		return fmt.Errorf("extract %s: %w", digest, err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, digestFile), []byte(digest.DigestStr()), 0o644); err != nil {
+		return err
+	}
+
+	// Move any previous extraction aside rather than deleting it first, so the artifact is
+	// only missing for the instant between the two renames.
+	oldDir := ""
+	if _, err := os.Stat(outDir); err == nil {
+		oldDir = tmpDir + ".old"
+		if err := os.Rename(outDir, oldDir); err != nil {
+			return err
+		}
+		defer os.RemoveAll(oldDir)
+	}
+	if err := os.Rename(tmpDir, outDir); err != nil {
+		if oldDir != "" {
+			os.Rename(oldDir, outDir)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// maxExtractedImageBytes bounds the total size of the files extracted from an image, so
+// that a malicious or broken artifact cannot fill the disk.
+var maxExtractedImageBytes int64 = 1 << 30
+
+// extractImage writes the flattened filesystem of img into dir. Only directories and
+// regular files are extracted; entries of any other type, entries that would land
+// outside of dir, and images larger than maxExtractedImageBytes are rejected.
+func extractImage(img v1.Image, dir string) error {
+	reader := mutate.Extract(img)
+	defer reader.Close()
+
+	remaining := maxExtractedImageBytes
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
 		}
-		err = downloaderImpl.Download(option...)
 		if err != nil {
-			return "", err
+			return err
 		}
 
-		return localPath, nil
-	}
+		if !filepath.IsLocal(header.Name) {
+			return fmt.Errorf("refusing to extract %q outside of the destination", header.Name)
+		}
+		target := filepath.Join(dir, header.Name)
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if header.Size > remaining {
+				return fmt.Errorf("refusing to extract %q: image exceeds %d bytes", header.Name, maxExtractedImageBytes)
+			}
+			remaining -= header.Size
 
-	return "", errors.New("downloadable item source cannot be found locally and does not look like OCI")
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode).Perm())
+			if err != nil {
+				return err
+			}
+			if _, err := io.CopyN(f, tr, header.Size); err != nil {
+				f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("refusing to extract %q: unsupported entry type %q", header.Name, header.Typeflag)
+		}
+	}
 }
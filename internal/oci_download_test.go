@@ -4,10 +4,13 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func TestDownload_FallsBackToExistingLocalDirectoryWhenNestedArtifactMissing(t *testing.T) {
@@ -186,3 +189,114 @@ func TestShouldSkipOCIDownload_ReturnsErrorWhenPoliciesPathIsFile(t *testing.T)
 		t.Fatal("shouldSkipOCIDownload() = true, expected false")
 	}
 }
+
+func TestDownload_DownloadsDigestReferenceIntoDigestCache(t *testing.T) {
+	tag := pushTestPlugin(t, "plugins/pinned")
+	desc, err := remote.Head(tag)
+	if err != nil {
+		t.Fatalf("remote.Head() error = %v, expected nil", err)
+	}
+	digest := tag.Context().Digest(desc.Digest.String())
+	outputDir := t.TempDir()
+
+	got, err := Download(context.Background(), digest.String(), outputDir, "plugin", hclog.NewNullLogger())
+	if err != nil {
+		t.Fatalf("Download() error = %v, expected nil", err)
+	}
+
+	expected := filepath.Join(outputDir, digest.RepositoryStr(), "@sha256", desc.Digest.Hex, "plugin")
+	if got != expected {
+		t.Fatalf("Download() = %q, expected %q", got, expected)
+	}
+	if _, err := os.Stat(got); err != nil {
+		t.Fatalf("expected plugin to be extracted, stat error = %v", err)
+	}
+
+	cached, err := Download(context.Background(), digest.String(), outputDir, "plugin", hclog.NewNullLogger())
+	if err != nil || cached != expected {
+		t.Fatalf("Download() from cache = %q, %v, expected %q", cached, err, expected)
+	}
+}
+
+func TestOCICacheDir(t *testing.T) {
+	tag, err := name.NewTag("ghcr.io/compliance-framework/plugin:v1")
+	if err != nil {
+		t.Fatalf("name.NewTag() error = %v, expected nil", err)
+	}
+	digest, err := name.NewDigest("ghcr.io/compliance-framework/plugin:v1@sha256:88252198a40099248f5cc3272bc879fade8b7001a2bcb36d7b43aa8f54328714")
+	if err != nil {
+		t.Fatalf("name.NewDigest() error = %v, expected nil", err)
+	}
+
+	if got, expected := ociCacheDir("out", tag), filepath.Join("out", "compliance-framework/plugin", "v1"); got != expected {
+		t.Fatalf("ociCacheDir(tag) = %q, expected %q", got, expected)
+	}
+	if got, expected := ociCacheDir("out", digest), filepath.Join("out", "compliance-framework/plugin", "@sha256", "88252198a40099248f5cc3272bc879fade8b7001a2bcb36d7b43aa8f54328714"); got != expected {
+		t.Fatalf("ociCacheDir(digest) = %q, expected %q", got, expected)
+	}
+}
+
+func TestDownloadArtifact_PullPolicies(t *testing.T) {
+	tag := pushTestPlugin(t, "plugins/mutable")
+	outputDir := t.TempDir()
+	logger := hclog.NewNullLogger()
+
+	download := func(policy PullPolicy) (Artifact, string) {
+		t.Helper()
+
+		artifact, err := DownloadArtifact(context.Background(), tag.String(), outputDir, "plugin", DownloadOptions{PullPolicy: policy}, logger)
+		if err != nil {
+			t.Fatalf("DownloadArtifact(%s) error = %v, expected nil", policy, err)
+		}
+		content, err := os.ReadFile(artifact.Path)
+		if err != nil {
+			t.Fatalf("os.ReadFile() error = %v", err)
+		}
+		return artifact, string(content)
+	}
+
+	first, content := download(PullOnDigestChange)
+	if first.Digest == "" || !strings.Contains(content, "echo verified") {
+		t.Fatalf("unexpected first download %+v with content %q", first, content)
+	}
+
+	pushTestPluginContent(t, tag, "#!/bin/sh\necho updated\n")
+
+	cached, content := download(PullIfNotPresent)
+	if cached.Digest != first.Digest || !strings.Contains(content, "echo verified") {
+		t.Fatalf("expected if-not-present to keep the extracted digest %s, got %+v with content %q", first.Digest, cached, content)
+	}
+
+	refreshed, content := download(PullOnDigestChange)
+	if refreshed.Digest == first.Digest || !strings.Contains(content, "echo updated") {
+		t.Fatalf("expected on-digest-change to extract the new digest, got %+v with content %q", refreshed, content)
+	}
+	if refreshed.Path != first.Path {
+		t.Fatalf("expected the tag to be re-extracted in place at %q, got %q", first.Path, refreshed.Path)
+	}
+
+	downloaded := testutil.ToFloat64(DownloadsTotal.WithLabelValues("plugin", DownloadResultDownloaded))
+	download(PullOnDigestChange)
+	if got := testutil.ToFloat64(DownloadsTotal.WithLabelValues("plugin", DownloadResultDownloaded)); got != downloaded {
+		t.Fatal("expected on-digest-change not to download an unchanged digest")
+	}
+	download(PullAlways)
+	if got := testutil.ToFloat64(DownloadsTotal.WithLabelValues("plugin", DownloadResultDownloaded)); got != downloaded+1 {
+		t.Fatal("expected always to download an unchanged digest")
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(filepath.Dir(first.Path)))
+	if err != nil {
+		t.Fatalf("os.ReadDir() error = %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected temporary extractions to be cleaned up, got %d entries", len(entries))
+	}
+}
+
+func TestDownloadArtifact_RejectsUnsupportedPullPolicy(t *testing.T) {
+	_, err := DownloadArtifact(context.Background(), "ghcr.io/compliance-framework/plugin:v1", t.TempDir(), "plugin", DownloadOptions{PullPolicy: "sometimes"}, hclog.NewNullLogger())
+	if err == nil {
+		t.Fatal("DownloadArtifact() error = nil, expected unsupported pull policy to be rejected")
+	}
+}
@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// testImage returns an image with a single layer holding headers, each regular file
+// filled with its size in bytes.
+func testImage(t *testing.T, headers ...*tar.Header) v1.Image {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, header := range headers {
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatalf("WriteHeader() error = %v", err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, err := tw.Write(bytes.Repeat([]byte("x"), int(header.Size))); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	if err != nil {
+		t.Fatalf("LayerFromOpener() error = %v", err)
+	}
+	img, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		t.Fatalf("AppendLayers() error = %v", err)
+	}
+	return img
+}
+
+func TestExtractImage_ExtractsDirectoriesAndFiles(t *testing.T) {
+	dir := t.TempDir()
+	img := testImage(t,
+		&tar.Header{Name: "policies/", Typeflag: tar.TypeDir, Mode: 0o755},
+		&tar.Header{Name: "policies/buckets.rego", Typeflag: tar.TypeReg, Mode: 0o644, Size: 5},
+	)
+
+	if err := extractImage(img, dir); err != nil {
+		t.Fatalf("extractImage() error = %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "policies", "buckets.rego"))
+	if err != nil || string(content) != "xxxxx" {
+		t.Fatalf("expected the file to be extracted, got %q, %v", content, err)
+	}
+}
+
+func TestExtractImage_RejectsUnsupportedEntries(t *testing.T) {
+	tests := []*tar.Header{
+		{Name: "plugin", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+		{Name: "plugin", Typeflag: tar.TypeLink, Linkname: "other"},
+		{Name: "fifo", Typeflag: tar.TypeFifo},
+	}
+	for _, header := range tests {
+		err := extractImage(testImage(t, header), t.TempDir())
+		if err == nil || !strings.Contains(err.Error(), "unsupported entry type") {
+			t.Fatalf("extractImage() error = %v, expected type %q to be rejected", err, header.Typeflag)
+		}
+	}
+}
+
+func TestExtractImage_RejectsOversizedImages(t *testing.T) {
+	limit := maxExtractedImageBytes
+	maxExtractedImageBytes = 8
+	t.Cleanup(func() { maxExtractedImageBytes = limit })
+
+	img := testImage(t,
+		&tar.Header{Name: "first", Typeflag: tar.TypeReg, Mode: 0o644, Size: 5},
+		&tar.Header{Name: "second", Typeflag: tar.TypeReg, Mode: 0o644, Size: 5},
+	)
+	err := extractImage(img, t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "exceeds 8 bytes") {
+		t.Fatalf("extractImage() error = %v, expected the image to be too large", err)
+	}
+}
@@ -87,8 +87,18 @@ func TestIsOci(t *testing.T) {
 			expected: true,
 		},
 		{
-			name:     "Digest OCI reference is not treated as supported OCI tag",
+			name:     "Digest OCI reference",
 			source:   "ghcr.io/example/plugin@sha256:88252198a40099248f5cc3272bc879fade8b7001a2bcb36d7b43aa8f54328714",
+			expected: true,
+		},
+		{
+			name:     "Tag and digest OCI reference",
+			source:   "ghcr.io/example/plugin:v1@sha256:88252198a40099248f5cc3272bc879fade8b7001a2bcb36d7b43aa8f54328714",
+			expected: true,
+		},
+		{
+			name:     "OCI reference without tag or digest",
+			source:   "ghcr.io/example/plugin",
 			expected: false,
 		},
 		{
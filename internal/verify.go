@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/sigstore/cosign/v2/pkg/cosign"
+	ociremote "github.com/sigstore/cosign/v2/pkg/oci/remote"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/sigstore/sigstore/pkg/signature"
+)
+
+// VerifyOptions describes how the cosign signature of an OCI artifact is verified before
+// it is extracted. Either Key is set, to verify against a cosign public key, or an
+// identity and issuer are set, to verify a keyless signature issued by Fulcio.
+type VerifyOptions struct {
+	// Key is a path to a PEM encoded public key, or the PEM encoded key itself.
+	Key            string
+	Identity       string
+	IdentityRegexp string
+	Issuer         string
+	IssuerRegexp   string
+	// IgnoreTlog skips checking that the signature was recorded in the Rekor transparency
+	// log, for signatures created with --tlog-upload=false.
+	IgnoreTlog bool
+}
+
+func (v *VerifyOptions) Validate() error {
+	if v == nil {
+		return nil
+	}
+
+	keyless := v.Identity != "" || v.IdentityRegexp != "" || v.Issuer != "" || v.IssuerRegexp != ""
+	if v.Key != "" {
+		if keyless {
+			return errors.New("key cannot be combined with identity or issuer")
+		}
+		return nil
+	}
+
+	if v.Identity == "" && v.IdentityRegexp == "" {
+		return errors.New("either key, or identity or identity_regexp with issuer or issuer_regexp, must be set")
+	}
+	if v.Issuer == "" && v.IssuerRegexp == "" {
+		return errors.New("issuer or issuer_regexp must be set for keyless verification")
+	}
+
+	return nil
+}
+
+// String returns a stable description of the options, used to tell apart downloads of the
+// same source with different verification requirements.
+func (v *VerifyOptions) String() string {
+	if v == nil {
+		return ""
+	}
+
+	return strings.Join([]string{v.Key, v.Identity, v.IdentityRegexp, v.Issuer, v.IssuerRegexp, fmt.Sprint(v.IgnoreTlog)}, "\x00")
+}
+
+// VerifySignature checks that the artifact ref currently resolves to carries a cosign
+// signature satisfying opts, and returns the digest that was verified. Callers must
+// fetch the artifact by this digest, so that what runs is exactly what was verified.
+func VerifySignature(ctx context.Context, ref name.Reference, opts *VerifyOptions, option ...remote.Option) (name.Digest, error) {
+	if err := opts.Validate(); err != nil {
+		return name.Digest{}, err
+	}
+
+	desc, err := remote.Head(ref, option...)
+	if err != nil {
+		return name.Digest{}, fmt.Errorf("resolve digest of %s: %w", ref, err)
+	}
+	digest := ref.Context().Digest(desc.Digest.String())
+
+	checkOpts := &cosign.CheckOpts{
+		RegistryClientOpts: []ociremote.Option{ociremote.WithRemoteOptions(option...)},
+		ClaimVerifier:      cosign.SimpleClaimVerifier,
+		IgnoreTlog:         opts.IgnoreTlog,
+	}
+
+	if opts.Key != "" {
+		verifier, err := loadPublicKeyVerifier(opts.Key)
+		if err != nil {
+			return name.Digest{}, err
+		}
+		checkOpts.SigVerifier = verifier
+	} else {
+		checkOpts.Identities = []cosign.Identity{{
+			Subject:       opts.Identity,
+			SubjectRegExp: opts.IdentityRegexp,
+			Issuer:        opts.Issuer,
+			IssuerRegExp:  opts.IssuerRegexp,
+		}}
+	}
+
+	// The Sigstore trusted root supplies the Fulcio roots for keyless signatures and the
+	// Rekor keys for transparency log checks. Key based signatures without a tlog entry
+	// need neither, and can be verified fully offline.
+	if opts.Key == "" || !opts.IgnoreTlog {
+		trustedRoot, err := cosign.TrustedRoot()
+		if err != nil {
+			return name.Digest{}, fmt.Errorf("load sigstore trusted root: %w", err)
+		}
+		checkOpts.TrustedMaterial = trustedRoot
+	}
+
+	if _, _, err := cosign.VerifyImageSignatures(ctx, digest, checkOpts); err != nil {
+		return name.Digest{}, fmt.Errorf("signature verification failed for %s: %w", ref, err)
+	}
+
+	return digest, nil
+}
+
+func loadPublicKeyVerifier(key string) (signature.Verifier, error) {
+	pemBytes := []byte(key)
+	if !strings.HasPrefix(strings.TrimSpace(key), "-----BEGIN") {
+		content, err := os.ReadFile(key)
+		if err != nil {
+			return nil, fmt.Errorf("read verification key: %w", err)
+		}
+		pemBytes = content
+	}
+
+	publicKey, err := cryptoutils.UnmarshalPEMToPublicKey(pemBytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse verification key: %w", err)
+	}
+
+	return signature.LoadDefaultVerifier(publicKey)
+}
@@ -0,0 +1,221 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/hashicorp/go-hclog"
+	"github.com/sigstore/cosign/v2/pkg/oci/mutate"
+	ociremote "github.com/sigstore/cosign/v2/pkg/oci/remote"
+	"github.com/sigstore/cosign/v2/pkg/oci/static"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/sigstore/sigstore/pkg/signature/payload"
+)
+
+// pushTestPlugin pushes an image containing a plugin executable to an in-process registry
+// and returns its tag.
+func pushTestPlugin(t *testing.T, repository string) name.Tag {
+	t.Helper()
+
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(server.Close)
+
+	tag, err := name.NewTag(strings.TrimPrefix(server.URL, "http://") + "/" + repository + ":v1")
+	if err != nil {
+		t.Fatalf("name.NewTag() error = %v", err)
+	}
+	pushTestPluginContent(t, tag, "#!/bin/sh\necho verified\n")
+	return tag
+}
+
+// pushTestPluginContent points tag at a new image whose plugin executable has content.
+func pushTestPluginContent(t *testing.T, tag name.Tag, content string) {
+	t.Helper()
+
+	img, err := crane.Image(map[string][]byte{"plugin": []byte(content)})
+	if err != nil {
+		t.Fatalf("crane.Image() error = %v", err)
+	}
+	if err := remote.Write(tag, img); err != nil {
+		t.Fatalf("remote.Write() error = %v", err)
+	}
+}
+
+// signTestArtifact signs the artifact tag points at with a new key, the way
+// `cosign sign --key --tlog-upload=false` does, and returns the PEM encoded public key.
+func signTestArtifact(t *testing.T, tag name.Tag) string {
+	t.Helper()
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() error = %v", err)
+	}
+	signer, err := signature.LoadECDSASignerVerifier(privateKey, crypto.SHA256)
+	if err != nil {
+		t.Fatalf("LoadECDSASignerVerifier() error = %v", err)
+	}
+
+	desc, err := remote.Head(tag)
+	if err != nil {
+		t.Fatalf("remote.Head() error = %v", err)
+	}
+	digest := tag.Context().Digest(desc.Digest.String())
+
+	signedPayload, err := (&payload.Cosign{Image: digest}).MarshalJSON()
+	if err != nil {
+		t.Fatalf("MarshalJSON() error = %v", err)
+	}
+	rawSignature, err := signer.SignMessage(bytes.NewReader(signedPayload))
+	if err != nil {
+		t.Fatalf("SignMessage() error = %v", err)
+	}
+	sig, err := static.NewSignature(signedPayload, base64.StdEncoding.EncodeToString(rawSignature))
+	if err != nil {
+		t.Fatalf("static.NewSignature() error = %v", err)
+	}
+
+	entity, err := ociremote.SignedEntity(digest)
+	if err != nil {
+		t.Fatalf("ociremote.SignedEntity() error = %v", err)
+	}
+	signed, err := mutate.AttachSignatureToEntity(entity, sig)
+	if err != nil {
+		t.Fatalf("AttachSignatureToEntity() error = %v", err)
+	}
+	if err := ociremote.WriteSignatures(digest.Repository, signed); err != nil {
+		t.Fatalf("WriteSignatures() error = %v", err)
+	}
+
+	publicKey, err := cryptoutils.MarshalPublicKeyToPEM(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("MarshalPublicKeyToPEM() error = %v", err)
+	}
+	return string(publicKey)
+}
+
+func writeTestKey(t *testing.T, key string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "cosign.pub")
+	if err := os.WriteFile(path, []byte(key), 0o644); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+	return path
+}
+
+func TestDownloadArtifact_VerifyExtractsArtifactSignedWithKey(t *testing.T) {
+	tag := pushTestPlugin(t, "plugins/signed")
+	keyPath := writeTestKey(t, signTestArtifact(t, tag))
+	outputDir := t.TempDir()
+	verify := &VerifyOptions{Key: keyPath, IgnoreTlog: true}
+
+	got, err := DownloadArtifact(context.Background(), tag.String(), outputDir, "plugin", DownloadOptions{Verify: verify}, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatalf("DownloadArtifact() error = %v, expected nil", err)
+	}
+	if got.Digest == "" {
+		t.Fatal("expected the verified digest to be reported")
+	}
+
+	content, err := os.ReadFile(got.Path)
+	if err != nil {
+		t.Fatalf("os.ReadFile() error = %v", err)
+	}
+	if !strings.Contains(string(content), "echo verified") {
+		t.Fatalf("unexpected plugin content %q", content)
+	}
+
+	again, err := DownloadArtifact(context.Background(), tag.String(), outputDir, "plugin", DownloadOptions{Verify: verify}, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatalf("DownloadArtifact() from cache error = %v, expected nil", err)
+	}
+	if again != got {
+		t.Fatalf("DownloadArtifact() from cache = %+v, expected %+v", again, got)
+	}
+}
+
+func TestDownloadArtifact_VerifyRejectsWrongKey(t *testing.T) {
+	tag := pushTestPlugin(t, "plugins/wrong-key")
+	signTestArtifact(t, tag)
+	otherTag := pushTestPlugin(t, "plugins/other")
+	wrongKeyPath := writeTestKey(t, signTestArtifact(t, otherTag))
+	outputDir := t.TempDir()
+
+	_, err := DownloadArtifact(context.Background(), tag.String(), outputDir, "plugin", DownloadOptions{Verify: &VerifyOptions{Key: wrongKeyPath, IgnoreTlog: true}}, hclog.NewNullLogger())
+	if err == nil {
+		t.Fatal("DownloadArtifact() error = nil, expected signature verification to fail")
+	}
+
+	entries, err := os.ReadDir(outputDir)
+	if err != nil {
+		t.Fatalf("os.ReadDir() error = %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected nothing to be extracted for a wrongly signed artifact, got %d entries", len(entries))
+	}
+}
+
+func TestDownloadArtifact_VerifyRejectsUnsignedArtifact(t *testing.T) {
+	tag := pushTestPlugin(t, "plugins/unsigned")
+	otherTag := pushTestPlugin(t, "plugins/other")
+	keyPath := writeTestKey(t, signTestArtifact(t, otherTag))
+
+	_, err := DownloadArtifact(context.Background(), tag.String(), t.TempDir(), "plugin", DownloadOptions{Verify: &VerifyOptions{Key: keyPath, IgnoreTlog: true}}, hclog.NewNullLogger())
+	if err == nil {
+		t.Fatal("DownloadArtifact() error = nil, expected unsigned artifact to be rejected")
+	}
+}
+
+func TestDownloadArtifact_VerifyRejectsLocalSource(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "plugin")
+	if err := os.WriteFile(source, []byte{}, 0o755); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+
+	_, err := DownloadArtifact(context.Background(), source, t.TempDir(), "plugin", DownloadOptions{Verify: &VerifyOptions{Key: "cosign.pub"}}, hclog.NewNullLogger())
+	if err == nil {
+		t.Fatal("DownloadArtifact() error = nil, expected local source to be rejected")
+	}
+}
+
+func TestVerifyOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    *VerifyOptions
+		wantErr bool
+	}{
+		{name: "nil", opts: nil},
+		{name: "key", opts: &VerifyOptions{Key: "cosign.pub"}},
+		{name: "keyless", opts: &VerifyOptions{Identity: "release@example.com", Issuer: "https://accounts.google.com"}},
+		{name: "keyless regexp", opts: &VerifyOptions{IdentityRegexp: "^https://github.com/example/", IssuerRegexp: "github"}},
+		{name: "empty", opts: &VerifyOptions{}, wantErr: true},
+		{name: "identity without issuer", opts: &VerifyOptions{Identity: "release@example.com"}, wantErr: true},
+		{name: "key with identity", opts: &VerifyOptions{Key: "cosign.pub", Identity: "release@example.com"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -19,6 +19,7 @@ func main() {
 	rootCmd.AddCommand(cmd.AgentCmd())
 	rootCmd.AddCommand(cmd.DownloadPluginCmd())
 	rootCmd.AddCommand(cmd.SubmitEvidenceCmd())
+	rootCmd.AddCommand(cmd.PolicyCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
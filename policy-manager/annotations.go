@@ -1,4 +1,4 @@
-package internal
+package policy_manager
 
 import (
 	"fmt"
@@ -45,7 +45,8 @@ func ExtractAnnotations(comments []*ast.Comment) map[string]interface{} {
 				break
 			}
 
-			metadataLines = append(metadataLines, text)
+			// Keep the indentation of the line, as it is part of the yaml document.
+			metadataLines = append(metadataLines, strings.TrimRight(string(comment.Text), " \t"))
 			previousLine = comment.Location.Row
 		}
 	}
@@ -55,7 +56,17 @@ func ExtractAnnotations(comments []*ast.Comment) map[string]interface{} {
 		return map[string]interface{}{}
 	}
 
-	// Join metadata lines and parse them as YAML
+	// Remove the indentation every line shares, usually the space after the #, and parse
+	// the lines as YAML
+	indent := -1
+	for _, line := range metadataLines {
+		if width := len(line) - len(strings.TrimLeft(line, " \t")); indent == -1 || width < indent {
+			indent = width
+		}
+	}
+	for i, line := range metadataLines {
+		metadataLines[i] = line[indent:]
+	}
 	metadataYAML := strings.Join(metadataLines, "\n")
 	metadata := make(map[string]interface{})
 	if err := yaml.Unmarshal([]byte(metadataYAML), &metadata); err != nil {
@@ -1,4 +1,4 @@
-package internal
+package policy_manager
 
 import (
 	"github.com/open-policy-agent/opa/ast"
@@ -37,6 +37,22 @@ func TestExtractAnnotations(t *testing.T) {
 				"description": "A test policy",
 			},
 		},
+		{
+			name: "Nested Metadata",
+			comments: []*ast.Comment{
+				{Text: []byte(" METADATA"), Location: &ast.Location{Row: 1}},
+				{Text: []byte(" title: test-policy"), Location: &ast.Location{Row: 2}},
+				{Text: []byte(" controls:"), Location: &ast.Location{Row: 3}},
+				{Text: []byte("   - class: NIST_800-53"), Location: &ast.Location{Row: 4}},
+				{Text: []byte("     control-id: ac-2"), Location: &ast.Location{Row: 5}},
+			},
+			expected: map[string]interface{}{
+				"title": "test-policy",
+				"controls": []interface{}{
+					map[interface{}]interface{}{"class": "NIST_800-53", "control-id": "ac-2"},
+				},
+			},
+		},
 		{
 			name: "Comments Before Metadata",
 			comments: []*ast.Comment{
@@ -0,0 +1,319 @@
+package policy_manager
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/bundle"
+	"github.com/open-policy-agent/opa/v1/loader"
+	"github.com/open-policy-agent/opa/v1/rego"
+	"github.com/open-policy-agent/opa/v1/storage"
+	"github.com/open-policy-agent/opa/v1/storage/inmem"
+)
+
+// compiledBundle is a policy bundle compiled once, and shared by every evaluation of it
+// until its content changes.
+type compiledBundle struct {
+	digest   string
+	compiler *ast.Compiler
+	modules  map[string]*ast.Module
+	data     map[string]interface{}
+
+	// fingerprint identifies the files the bundle was loaded from by their size and
+	// modification time, so that an unchanged bundle is not read again.
+	fingerprint string
+
+	mu       sync.Mutex
+	prepared map[string]*preparedBundle
+}
+
+// maxPreparedPolicyData bounds how many sets of policy data a compiled bundle keeps
+// prepared queries for.
+const maxPreparedPolicyData = 8
+
+// preparedBundle is a compiled bundle with a set of policy data written to a store, and
+// the queries prepared against them so far. The store is only read from, so it is
+// shared by every evaluation of the bundle with the same policy data.
+type preparedBundle struct {
+	compiler *ast.Compiler
+	store    storage.Store
+
+	mu      sync.Mutex
+	queries map[string]rego.PreparedEvalQuery
+}
+
+// compiledBundleCache keeps the latest compiled content of each bundle path, so that
+// the packages of a bundle, its risk templates and each GenerateResults call do not
+// load and compile the bundle again.
+type compiledBundleCache struct {
+	mu      sync.Mutex
+	bundles map[string]*compiledBundle
+	// digests are the digests of bundles that were not compiled by this process, such
+	// as those the agent records policy inputs for.
+	digests map[string]bundleDigestEntry
+}
+
+type bundleDigestEntry struct {
+	fingerprint string
+	digest      string
+}
+
+var compiledBundles = &compiledBundleCache{
+	bundles: map[string]*compiledBundle{},
+	digests: map[string]bundleDigestEntry{},
+}
+
+// get returns the compiled bundle at path, compiling it when its files have changed
+// since it was last compiled. A bundle whose files changed is loaded again, but only
+// compiled again when the digest of its modules and data changed too, rather than its
+// manifest revision, so a bundle changed in place is compiled again.
+func (c *compiledBundleCache) get(ctx context.Context, logger hclog.Logger, path string) (*compiledBundle, error) {
+	fingerprint, err := bundleFingerprint(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached := c.bundles[path]
+	if cached != nil && cached.fingerprint == fingerprint {
+		logger.Trace("Using compiled policy bundle", "policy_path", path, "digest", cached.digest)
+		return cached, nil
+	}
+
+	b, err := loadBundle(path)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := bundleDigest(b)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil && cached.digest == digest {
+		cached.fingerprint = fingerprint
+		logger.Trace("Using compiled policy bundle", "policy_path", path, "digest", digest)
+		return cached, nil
+	}
+
+	compiled, err := compileBundle(ctx, path, b)
+	if err != nil {
+		return nil, err
+	}
+	compiled.digest = digest
+	compiled.fingerprint = fingerprint
+	c.bundles[path] = compiled
+	delete(c.digests, path)
+	logger.Debug("Compiled policy bundle", "policy_path", path, "digest", digest)
+	return compiled, nil
+}
+
+// BundleDigest is the digest of the modules and data of the bundle at path, which tells
+// whether the bundle has changed since it was last evaluated. The bundle is only read
+// when its files have changed since its digest was last taken.
+func BundleDigest(path string) (string, error) {
+	return compiledBundles.digest(path)
+}
+
+func (c *compiledBundleCache) digest(path string) (string, error) {
+	fingerprint, err := bundleFingerprint(path)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if compiled := c.bundles[path]; compiled != nil && compiled.fingerprint == fingerprint {
+		return compiled.digest, nil
+	}
+	if entry, ok := c.digests[path]; ok && entry.fingerprint == fingerprint {
+		return entry.digest, nil
+	}
+
+	b, err := loadBundle(path)
+	if err != nil {
+		return "", err
+	}
+	digest, err := bundleDigest(b)
+	if err != nil {
+		return "", err
+	}
+	c.digests[path] = bundleDigestEntry{fingerprint: fingerprint, digest: digest}
+	return digest, nil
+}
+
+// bundleFingerprint identifies the files of the bundle at path, a directory or a bundle
+// archive, by their names, sizes and modification times, without reading them.
+func bundleFingerprint(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	fingerprint := sha256.New()
+	if !info.IsDir() {
+		fmt.Fprintf(fingerprint, "%d\x00%d", info.Size(), info.ModTime().UnixNano())
+		return hex.EncodeToString(fingerprint.Sum(nil)), nil
+	}
+
+	err = filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(fingerprint, "%s\x00%d\x00%d\x00", file, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(fingerprint.Sum(nil)), nil
+}
+
+// withPolicyData returns the bundle prepared with policyData, writing the bundle data
+// and policy data to a new store the first time.
+func (c *compiledBundle) withPolicyData(ctx context.Context, policyData map[string]interface{}) (*preparedBundle, error) {
+	encoded, err := json.Marshal(policyData)
+	if err != nil {
+		return nil, err
+	}
+	key := string(encoded)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if prepared, ok := c.prepared[key]; ok {
+		return prepared, nil
+	}
+
+	store := inmem.New()
+	txn, err := store.NewTransaction(ctx, storage.TransactionParams{Write: true})
+	if err != nil {
+		return nil, err
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			store.Abort(ctx, txn)
+		}
+	}()
+
+	if err := writePolicyData(ctx, store, txn, c.data); err != nil {
+		return nil, err
+	}
+	if err := writePolicyData(ctx, store, txn, policyData); err != nil {
+		return nil, err
+	}
+
+	if err := store.Commit(ctx, txn); err != nil {
+		return nil, err
+	}
+	committed = true
+
+	if c.prepared == nil || len(c.prepared) >= maxPreparedPolicyData {
+		c.prepared = map[string]*preparedBundle{}
+	}
+	prepared := &preparedBundle{
+		compiler: c.compiler,
+		store:    store,
+		queries:  map[string]rego.PreparedEvalQuery{},
+	}
+	c.prepared[key] = prepared
+	return prepared, nil
+}
+
+// query returns query prepared against the bundle, preparing it the first time.
+// PreparedEvalQuery.Eval opens a fresh read transaction on the store for each
+// evaluation, so a prepared query can be evaluated concurrently.
+func (p *preparedBundle) query(ctx context.Context, query string) (rego.PreparedEvalQuery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if prepared, ok := p.queries[query]; ok {
+		return prepared, nil
+	}
+
+	prepared, err := rego.New(
+		rego.Compiler(p.compiler),
+		rego.Store(p.store),
+		rego.Query(query),
+	).PrepareForEval(ctx)
+	if err != nil {
+		return rego.PreparedEvalQuery{}, err
+	}
+	p.queries[query] = prepared
+	return prepared, nil
+}
+
+func loadBundle(path string) (*bundle.Bundle, error) {
+	return loader.NewFileLoader().
+		WithProcessAnnotation(true).
+		WithBundleLazyLoadingMode(bundle.HasExtension()).
+		AsBundle(path)
+}
+
+// bundleDigest is a sha256 digest of the modules and data of a bundle.
+func bundleDigest(b *bundle.Bundle) (string, error) {
+	modules := append([]bundle.ModuleFile{}, b.Modules...)
+	sort.Slice(modules, func(i, j int) bool { return modules[i].Path < modules[j].Path })
+	digest := sha256.New()
+	for _, module := range modules {
+		digest.Write([]byte(module.Path))
+		digest.Write([]byte{0})
+		digest.Write(module.Raw)
+		digest.Write([]byte{0})
+	}
+	data, err := json.Marshal(b.Data)
+	if err != nil {
+		return "", err
+	}
+	digest.Write(data)
+	return "sha256:" + hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// compileBundle compiles the modules of a bundle the same way rego does when it loads
+// the bundle itself, into a compiler that queries can then be prepared against.
+func compileBundle(ctx context.Context, name string, b *bundle.Bundle) (*compiledBundle, error) {
+	compiler := ast.NewCompiler().WithUseTypeCheckAnnotations(true)
+	store := inmem.New()
+	txn, err := store.NewTransaction(ctx, storage.TransactionParams{Write: true})
+	if err != nil {
+		return nil, err
+	}
+	// The store only holds the bundle while it is compiled; each PolicyManager writes
+	// the bundle data to its own store along with its policy data.
+	defer store.Abort(ctx, txn)
+
+	r := rego.New(
+		rego.Compiler(compiler),
+		rego.Store(store),
+		rego.Transaction(txn),
+		rego.ParsedBundle(name, b),
+		rego.Query("data"),
+	)
+	query, err := r.PrepareForEval(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &compiledBundle{
+		compiler: compiler,
+		modules:  query.Modules(),
+		data:     b.Data,
+	}, nil
+}
@@ -0,0 +1,150 @@
+package policy_manager
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+const bundleCacheTestPolicy = `package compliance_framework.buckets
+
+title := "Buckets are private"
+
+risk_templates := [{"name": "public-bucket", "title": "Public bucket"}]
+`
+
+func cachedBundles(path string) int {
+	compiledBundles.mu.Lock()
+	defer compiledBundles.mu.Unlock()
+
+	if compiledBundles.bundles[path] == nil {
+		return 0
+	}
+	return 1
+}
+
+func TestPolicyManagerReusesCompiledBundles(t *testing.T) {
+	ctx := context.Background()
+	policyDir := writeTestPolicy(t, bundleCacheTestPolicy)
+
+	first := New(ctx, hclog.NewNullLogger(), policyDir, nil)
+	results, err := first.Execute(ctx, map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+
+	templates, err := first.GetRiskTemplates(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, templates["compliance_framework.buckets"], 1)
+
+	// Another evaluation of the same bundle reuses its compilation, with its own policy data.
+	second := New(ctx, hclog.NewNullLogger(), policyDir, map[string]interface{}{"owner": "storage"})
+	_, err = second.Execute(ctx, map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Same(t, first.compiled, second.compiled)
+	assert.NotSame(t, first.prepared, second.prepared)
+
+	// Changing the bundle compiles it again, and drops the previous compilation.
+	err = os.WriteFile(filepath.Join(policyDir, "buckets.rego"), []byte(`package compliance_framework.buckets
+
+title := "Buckets are encrypted"
+`), 0o644)
+	assert.NoError(t, err)
+	third := New(ctx, hclog.NewNullLogger(), policyDir, nil)
+	results, err = third.Execute(ctx, map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.NotSame(t, first.compiled, third.compiled)
+	assert.Equal(t, "Buckets are encrypted", *results[0].Title)
+	assert.Equal(t, 1, cachedBundles(policyDir))
+}
+
+func TestPolicyManagerIgnoresManifestRevisionWhenCaching(t *testing.T) {
+	ctx := context.Background()
+	policyDir := writeTestPolicy(t, bundleCacheTestPolicy)
+	err := os.WriteFile(filepath.Join(policyDir, ".manifest"), []byte(`{"revision": "v1"}`), 0o644)
+	assert.NoError(t, err)
+
+	first := New(ctx, hclog.NewNullLogger(), policyDir, nil)
+	_, err = first.Execute(ctx, map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Contains(t, first.compiled.digest, "sha256:")
+
+	// A bundle changed in place without bumping its revision is compiled again.
+	err = os.WriteFile(filepath.Join(policyDir, "buckets.rego"), []byte(`package compliance_framework.buckets
+
+title := "Buckets are encrypted"
+`), 0o644)
+	assert.NoError(t, err)
+	second := New(ctx, hclog.NewNullLogger(), policyDir, nil)
+	results, err := second.Execute(ctx, map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.NotSame(t, first.compiled, second.compiled)
+	assert.Equal(t, "Buckets are encrypted", *results[0].Title)
+}
+
+func TestPolicyManagerReportsBundleCompileErrors(t *testing.T) {
+	ctx := context.Background()
+	policyDir := writeTestPolicy(t, `package compliance_framework.buckets
+
+title := undefined_function(1)
+`)
+
+	_, err := New(ctx, hclog.NewNullLogger(), policyDir, nil).Execute(ctx, map[string]interface{}{})
+	assert.Error(t, err)
+	assert.Equal(t, 0, cachedBundles(policyDir))
+}
+
+func TestPolicyManagerDoesNotReadUnchangedBundles(t *testing.T) {
+	ctx := context.Background()
+	policyDir := writeTestPolicy(t, bundleCacheTestPolicy)
+	policyFile := filepath.Join(policyDir, "buckets.rego")
+
+	first := New(ctx, hclog.NewNullLogger(), policyDir, map[string]interface{}{"owner": "storage"})
+	results, err := first.Execute(ctx, map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, "Buckets are private", *results[0].Title)
+	digest, err := BundleDigest(policyDir)
+	assert.NoError(t, err)
+	assert.Equal(t, first.compiled.digest, digest)
+
+	// Replace the policy with one of the same size and modification time. Since the
+	// bundle's files look unchanged, it is not read again.
+	info, err := os.Stat(policyFile)
+	assert.NoError(t, err)
+	content := []byte(strings.Replace(bundleCacheTestPolicy, "private", "PRIVATE", 1))
+	assert.NoError(t, os.WriteFile(policyFile, content, 0o644))
+	assert.NoError(t, os.Chtimes(policyFile, info.ModTime(), info.ModTime()))
+
+	second := New(ctx, hclog.NewNullLogger(), policyDir, map[string]interface{}{"owner": "storage"})
+	results, err = second.Execute(ctx, map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, "Buckets are private", *results[0].Title)
+	assert.Same(t, first.compiled, second.compiled)
+	// The same policy data shares the store and prepared queries.
+	assert.Same(t, first.prepared, second.prepared)
+	assert.Len(t, second.prepared.queries, 1)
+	digest, err = BundleDigest(policyDir)
+	assert.NoError(t, err)
+	assert.Equal(t, first.compiled.digest, digest)
+}
+
+func TestBundleDigestOfUncompiledBundle(t *testing.T) {
+	policyDir := writeTestPolicy(t, bundleCacheTestPolicy)
+
+	digest, err := BundleDigest(policyDir)
+	assert.NoError(t, err)
+	assert.Contains(t, digest, "sha256:")
+
+	err = os.WriteFile(filepath.Join(policyDir, "buckets.rego"), []byte(`package compliance_framework.buckets
+
+title := "Buckets are encrypted"
+`), 0o644)
+	assert.NoError(t, err)
+	changed, err := BundleDigest(policyDir)
+	assert.NoError(t, err)
+	assert.NotEqual(t, digest, changed)
+}
@@ -0,0 +1,184 @@
+package policy_manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/open-policy-agent/opa/v1/metrics"
+	"github.com/open-policy-agent/opa/v1/profiler"
+	"github.com/open-policy-agent/opa/v1/rego"
+)
+
+const (
+	// EvalProfileTopN is how many of the slowest expressions a package profile keeps.
+	EvalProfileTopN = 10
+
+	// The agent passes its policy_evaluation configuration to plugin processes through
+	// these environment variables.
+	EnvEvalTimeout    = "CCF_POLICY_EVALUATION_TIMEOUT"
+	EnvEvalInstrument = "CCF_POLICY_EVALUATION_INSTRUMENT"
+	EnvEvalProfile    = "CCF_POLICY_EVALUATION_PROFILE"
+
+	// EvalErrorPropName names the prop holding why a policy package could not be evaluated.
+	EvalErrorPropName = "ccf:evaluation-error"
+)
+
+// ErrEvalTimeout is wrapped by the error of a policy package that did not finish
+// evaluating within the timeout.
+var ErrEvalTimeout = errors.New("policy evaluation timed out")
+
+// EvalOptions limit and instrument the evaluation of each policy package.
+type EvalOptions struct {
+	// Timeout bounds the evaluation of each package. Zero disables it.
+	Timeout time.Duration
+	// Instrument collects OPA metrics, such as the time spent compiling and evaluating
+	// the package.
+	Instrument bool
+	// Profile collects the expressions the package spends the most time evaluating.
+	Profile bool
+}
+
+// EvalStats are the metrics and profile of a package evaluation, when they are enabled.
+type EvalStats struct {
+	Metrics map[string]interface{} `json:"metrics,omitempty"`
+	Profile []profiler.ExprStats   `json:"profile,omitempty"`
+}
+
+// EvalOptionsFromEnv reads the evaluation options the agent passes to plugin processes.
+// Packages have no timeout unless one is set.
+func EvalOptionsFromEnv() (EvalOptions, error) {
+	opts := EvalOptions{}
+	var errs []error
+
+	if raw := strings.TrimSpace(os.Getenv(EnvEvalTimeout)); raw != "" {
+		timeout, err := time.ParseDuration(raw)
+		if err == nil && timeout < 0 {
+			err = fmt.Errorf("must not be negative")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", EnvEvalTimeout, err))
+		} else {
+			opts.Timeout = timeout
+		}
+	}
+
+	for _, flag := range []struct {
+		name  string
+		value *bool
+	}{{EnvEvalInstrument, &opts.Instrument}, {EnvEvalProfile, &opts.Profile}} {
+		raw := strings.TrimSpace(os.Getenv(flag.name))
+		if raw == "" {
+			continue
+		}
+		enabled, err := strconv.ParseBool(raw)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", flag.name, err))
+			continue
+		}
+		*flag.value = enabled
+	}
+
+	return opts, errors.Join(errs...)
+}
+
+func defaultEvalOptions(logger hclog.Logger) EvalOptions {
+	opts, err := EvalOptionsFromEnv()
+	if err != nil {
+		logger.Warn("Ignoring invalid policy evaluation options", "error", err)
+	}
+	return opts
+}
+
+// SetEvalOptions replaces the evaluation options, which otherwise come from the
+// environment.
+func (pm *PolicyManager) SetEvalOptions(opts EvalOptions) {
+	pm.evalOptions = opts
+}
+
+// SetEvalOptions makes GenerateResults evaluate policies with opts, instead of the
+// options from the environment.
+func (p *PolicyProcessor) SetEvalOptions(opts EvalOptions) {
+	p.evalOptions = &opts
+}
+
+// evaluate prepares and evaluates a query with input, when set, within the evaluation
+// timeout, collecting metrics and a profile when they are enabled. A query that does not
+// finish in time fails with ErrEvalTimeout.
+func (pm *PolicyManager) evaluate(ctx context.Context, policy Policy, query string, input interface{}) (rego.ResultSet, *EvalStats, error) {
+	evalCtx := ctx
+	if pm.evalOptions.Timeout > 0 {
+		var cancel context.CancelFunc
+		evalCtx, cancel = context.WithTimeout(ctx, pm.evalOptions.Timeout)
+		defer cancel()
+	}
+
+	prepared, err := pm.prepareForEval(evalCtx, query)
+	if err != nil {
+		return nil, nil, pm.evalError(ctx, evalCtx, policy, err)
+	}
+
+	var evalArgs []rego.EvalOption
+	if input != nil {
+		evalArgs = append(evalArgs, rego.EvalInput(input))
+	}
+	var m metrics.Metrics
+	if pm.evalOptions.Instrument {
+		m = metrics.New()
+		evalArgs = append(evalArgs, rego.EvalMetrics(m), rego.EvalInstrument(true))
+	}
+	var prof *profiler.Profiler
+	if pm.evalOptions.Profile {
+		prof = profiler.New()
+		evalArgs = append(evalArgs, rego.EvalQueryTracer(prof))
+	}
+
+	evaluation, err := prepared.Eval(evalCtx, evalArgs...)
+	if err != nil {
+		return nil, nil, pm.evalError(ctx, evalCtx, policy, err)
+	}
+
+	if m == nil && prof == nil {
+		return evaluation, nil, nil
+	}
+	stats := &EvalStats{}
+	if m != nil {
+		stats.Metrics = m.All()
+	}
+	if prof != nil {
+		stats.Profile = prof.ReportTopNResults(EvalProfileTopN, []string{"total_time_ns", "num_eval", "num_redo", "file", "line"})
+	}
+	return evaluation, stats, nil
+}
+
+// evalError tells a query stopped by the evaluation timeout apart from other errors,
+// including the caller cancelling ctx.
+func (pm *PolicyManager) evalError(ctx context.Context, evalCtx context.Context, policy Policy, err error) error {
+	if ctx.Err() == nil && errors.Is(evalCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("policy package %q did not finish within %s: %w", policy.Package.PurePackage(), pm.evalOptions.Timeout, ErrEvalTimeout)
+	}
+	return err
+}
+
+func (pm *PolicyManager) logEvalStats(policy Policy, stats *EvalStats) {
+	if stats == nil {
+		return
+	}
+	if stats.Metrics != nil {
+		pm.logger.Info("Evaluated policy package", "policy_package", policy.Package.PurePackage(), "metrics", stats.Metrics)
+	}
+	for _, expr := range stats.Profile {
+		pm.logger.Info("Profiled policy expression",
+			"policy_package", policy.Package.PurePackage(),
+			"location", expr.Location.String(),
+			"total_time", time.Duration(expr.ExprTimeNs),
+			"num_eval", expr.NumEval,
+			"num_redo", expr.NumRedo,
+		)
+	}
+}
@@ -0,0 +1,131 @@
+package policy_manager
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/compliance-framework/agent/runner/proto"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+// runawayPolicy takes far longer than any test timeout to find that it has no violations.
+const runawayPolicy = `# METADATA
+# title: Buckets are tagged
+# custom:
+#   controls: [cm-8]
+package compliance_framework.runaway
+
+import future.keywords.in
+
+violation[{"id": "impossible"}] if {
+	some a in numbers.range(1, 100000)
+	some b in numbers.range(1, 100000)
+	a + b == -1
+}
+`
+
+func TestEvalOptionsFromEnv(t *testing.T) {
+	t.Setenv(EnvEvalTimeout, "")
+	t.Setenv(EnvEvalInstrument, "")
+	t.Setenv(EnvEvalProfile, "")
+	opts, err := EvalOptionsFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, EvalOptions{}, opts)
+
+	t.Setenv(EnvEvalTimeout, "2m")
+	t.Setenv(EnvEvalInstrument, "true")
+	t.Setenv(EnvEvalProfile, "1")
+	opts, err = EvalOptionsFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, EvalOptions{Timeout: 2 * time.Minute, Instrument: true, Profile: true}, opts)
+
+	t.Setenv(EnvEvalTimeout, "0")
+	opts, err = EvalOptionsFromEnv()
+	assert.NoError(t, err)
+	assert.Zero(t, opts.Timeout)
+
+	t.Setenv(EnvEvalTimeout, "-1s")
+	t.Setenv(EnvEvalProfile, "sometimes")
+	opts, err = EvalOptionsFromEnv()
+	assert.ErrorContains(t, err, EnvEvalTimeout)
+	assert.ErrorContains(t, err, EnvEvalProfile)
+	assert.Zero(t, opts.Timeout)
+}
+
+func TestPolicyProcessorReportsTimedOutPackagesAsErrorEvidence(t *testing.T) {
+	policyDir := writeTestPolicy(t, `package compliance_framework.buckets
+
+title := "Buckets are private"
+`)
+	err := os.WriteFile(filepath.Join(policyDir, "runaway.rego"), []byte(runawayPolicy), 0o644)
+	assert.NoError(t, err)
+
+	processor := NewPolicyProcessor(hclog.NewNullLogger(), map[string]string{"_plugin": "test-plugin"}, nil, nil, nil, nil, nil, nil)
+	processor.SetEvalOptions(EvalOptions{Timeout: 200 * time.Millisecond})
+	started := time.Now()
+	evidences, err := processor.GenerateResults(context.Background(), policyDir, map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(started), 10*time.Second)
+
+	byPolicy := map[string]*proto.Evidence{}
+	for _, evidence := range evidences {
+		byPolicy[evidence.GetLabels()["_policy"]] = evidence
+	}
+	assert.Len(t, byPolicy, 2)
+	assert.Equal(t, proto.EvidenceStatusState_EVIDENCE_STATUS_STATE_SATISFIED, byPolicy["compliance_framework.buckets"].GetStatus().GetState())
+
+	runaway := byPolicy["compliance_framework.runaway"]
+	assert.Equal(t, "Buckets are tagged", runaway.GetTitle())
+	assert.Equal(t, proto.EvidenceStatusState_EVIDENCE_STATUS_STATE_NOT_SATISFIED, runaway.GetStatus().GetState())
+	assert.Equal(t, "error", runaway.GetStatus().GetReason())
+	assert.Contains(t, runaway.GetStatus().GetRemarks(), "did not finish within 200ms")
+	errorProps := propsNamed(runaway.GetProps(), EvalErrorPropName)
+	assert.Len(t, errorProps, 1)
+	assert.Contains(t, errorProps[0].GetValue(), ErrEvalTimeout.Error())
+	assert.Len(t, propsNamed(runaway.GetProps(), ControlPropName), 1)
+}
+
+func TestPolicyManagerCancelledEvaluationIsNotATimeout(t *testing.T) {
+	policyDir := writeTestPolicy(t, runawayPolicy)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	manager := New(ctx, hclog.NewNullLogger(), policyDir, nil)
+	manager.SetEvalOptions(EvalOptions{Timeout: time.Minute})
+	_, err := manager.Execute(ctx, map[string]interface{}{})
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrEvalTimeout)
+}
+
+func TestPolicyManagerInstrumentsAndProfilesEvaluation(t *testing.T) {
+	ctx := context.Background()
+	policyDir := writeTestPolicy(t, `package compliance_framework.buckets
+
+import future.keywords.in
+
+title := "Buckets are private"
+
+violation[{"id": "public-bucket"}] if {
+	some bucket in input.buckets
+	bucket.public
+}
+`)
+
+	manager := New(ctx, hclog.NewNullLogger(), policyDir, nil)
+	manager.SetEvalOptions(EvalOptions{Instrument: true, Profile: true})
+	results, err := manager.Execute(ctx, testBuckets)
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.NotNil(t, results[0].Stats)
+	assert.Contains(t, results[0].Stats.Metrics, "timer_rego_query_eval_ns")
+	assert.NotEmpty(t, results[0].Stats.Profile)
+
+	manager.SetEvalOptions(EvalOptions{})
+	results, err = manager.Execute(ctx, testBuckets)
+	assert.NoError(t, err)
+	assert.Nil(t, results[0].Stats)
+}
@@ -0,0 +1,260 @@
+package policy_manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/compliance-framework/agent/runner/proto"
+	"github.com/open-policy-agent/opa/ast"
+)
+
+const (
+	// ControlPropName names the props of the controls a policy verifies, which hold the
+	// control ID and have the catalog class as their class.
+	ControlPropName = "ccf:control"
+	// ControlStatementPropName names the props of the control statements a policy
+	// verifies, grouped by the control ID.
+	ControlStatementPropName = "ccf:control-statement"
+	// AnnotationPropPrefix prefixes the props of the custom annotations of a policy.
+	AnnotationPropPrefix = "ccf:annotation-"
+	// OutputPropPrefix prefixes the props of the other outputs of a policy.
+	OutputPropPrefix = "ccf:output-"
+)
+
+// outputsWithoutProps are the outputs that are already part of evidence, or control how
+// it is generated.
+var outputsWithoutProps = []string{"title", "description", "remarks", "skip_reason", "evidence_mode", "controls"}
+
+// customAnnotationsWithoutProps are the custom annotations with their own meaning.
+var customAnnotationsWithoutProps = []string{"evidence_mode", "controls", "labels"}
+
+// Control is a control a policy verifies, written as the control ID, such as ac-2, or as
+// an object with the control-id, the class of the catalog it belongs to and the IDs of
+// the statements verified.
+type Control struct {
+	Class        string   `json:"class,omitempty"`
+	ID           string   `json:"control-id"`
+	StatementIDs []string `json:"statement-ids,omitempty"`
+}
+
+func parseControls(value interface{}) ([]Control, error) {
+	entries, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("controls must be a list, got %T", value)
+	}
+
+	controls := make([]Control, 0, len(entries))
+	for _, entry := range entries {
+		if id, ok := entry.(string); ok {
+			controls = append(controls, Control{ID: id})
+			continue
+		}
+
+		encoded, err := json.Marshal(stringKeys(entry))
+		if err != nil {
+			return nil, err
+		}
+		var control Control
+		if err := json.Unmarshal(encoded, &control); err != nil {
+			return nil, fmt.Errorf("decode control %s: %w", encoded, err)
+		}
+		if control.ID == "" {
+			return nil, fmt.Errorf("control %s has no control-id", encoded)
+		}
+		controls = append(controls, control)
+	}
+	return controls, nil
+}
+
+// packageAnnotations are the METADATA annotations that apply to the policy's package.
+func (res Result) packageAnnotations() []*ast.Annotations {
+	annotations := make([]*ast.Annotations, 0, len(res.Policy.Annotations))
+	for _, annotation := range res.Policy.Annotations {
+		if annotation != nil && (annotation.Scope == "package" || annotation.Scope == "subpackages") {
+			annotations = append(annotations, annotation)
+		}
+	}
+	return annotations
+}
+
+// withAnnotationDefaults uses the title and description annotations of the package when
+// the policy does not output them.
+func (res Result) withAnnotationDefaults() Result {
+	if res.EvalOutput == nil {
+		return res
+	}
+
+	output := *res.EvalOutput
+	for _, annotation := range res.packageAnnotations() {
+		if output.Title == nil && annotation.Title != "" {
+			output.Title = Pointer(annotation.Title)
+		}
+		if output.Description == nil && annotation.Description != "" {
+			output.Description = Pointer(annotation.Description)
+		}
+	}
+	res.EvalOutput = &output
+	return res
+}
+
+// annotationLabels are the labels of the labels custom annotation of the package.
+func (res Result) annotationLabels() map[string]string {
+	labels := map[string]string{}
+	for _, annotation := range res.packageAnnotations() {
+		values, ok := annotation.Custom["labels"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for key, value := range values {
+			labels[key] = fmt.Sprint(value)
+		}
+	}
+	return labels
+}
+
+// metadataLinks link evidence to the related resources annotated on the package.
+func (res Result) metadataLinks() []*proto.Link {
+	links := make([]*proto.Link, 0)
+	for _, annotation := range res.packageAnnotations() {
+		for _, resource := range annotation.RelatedResources {
+			if resource == nil {
+				continue
+			}
+			link := &proto.Link{Href: resource.Ref.String(), Rel: Pointer("related")}
+			if resource.Description != "" {
+				link.Text = Pointer(resource.Description)
+			}
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// metadataProps describe the controls the policy verifies, from its controls output,
+// controls custom annotation and the controls of its METADATA block, its other custom annotations, and its other outputs that
+// are strings, numbers or booleans. Outputs starting with an underscore are left out.
+func (res Result) metadataProps() ([]*proto.Property, error) {
+	props := make([]*proto.Property, 0)
+
+	var controls []Control
+	if value, ok := res.Policy.Metadata["controls"]; ok {
+		metadata, err := parseControls(value)
+		if err != nil {
+			return nil, fmt.Errorf("controls metadata of policy package %q: %w", res.Policy.Package.PurePackage(), err)
+		}
+		controls = append(controls, metadata...)
+	}
+	for _, annotation := range res.packageAnnotations() {
+		if value, ok := annotation.Custom["controls"]; ok {
+			annotated, err := parseControls(value)
+			if err != nil {
+				return nil, fmt.Errorf("controls annotation of policy package %q: %w", res.Policy.Package.PurePackage(), err)
+			}
+			controls = append(controls, annotated...)
+		}
+	}
+	if res.EvalOutput != nil {
+		if value, ok := res.AdditionalVariables["controls"]; ok {
+			output, err := parseControls(value)
+			if err != nil {
+				return nil, fmt.Errorf("controls of policy package %q: %w", res.Policy.Package.PurePackage(), err)
+			}
+			controls = append(controls, output...)
+		}
+	}
+	seen := map[string]bool{}
+	for _, control := range controls {
+		key := control.Class + "\x00" + control.ID
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		prop := &proto.Property{Name: ControlPropName, Ns: Pointer(PropNamespace), Value: control.ID}
+		if control.Class != "" {
+			prop.Class = Pointer(control.Class)
+		}
+		props = append(props, prop)
+		for _, statementID := range control.StatementIDs {
+			statement := &proto.Property{Name: ControlStatementPropName, Ns: Pointer(PropNamespace), Value: statementID, Group: Pointer(control.ID)}
+			statement.Class = prop.Class
+			props = append(props, statement)
+		}
+	}
+
+	for _, annotation := range res.packageAnnotations() {
+		for _, key := range sortedKeys(annotation.Custom) {
+			if containsString(customAnnotationsWithoutProps, key) {
+				continue
+			}
+			prop, err := valueProp(AnnotationPropPrefix+key, annotation.Custom[key])
+			if err != nil {
+				return nil, fmt.Errorf("encode annotation %q: %w", key, err)
+			}
+			props = append(props, prop)
+		}
+	}
+
+	if res.EvalOutput != nil {
+		for _, key := range sortedKeys(res.AdditionalVariables) {
+			value := res.AdditionalVariables[key]
+			if strings.HasPrefix(key, "_") || containsString(outputsWithoutProps, key) || !isScalar(value) {
+				continue
+			}
+			prop, err := valueProp(OutputPropPrefix+key, value)
+			if err != nil {
+				return nil, fmt.Errorf("encode output %q: %w", key, err)
+			}
+			props = append(props, prop)
+		}
+	}
+	return props, nil
+}
+
+// stringKeys converts the maps YAML decodes METADATA into, which may have keys of any
+// type, into maps that can be JSON encoded.
+func stringKeys(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for key, entry := range value {
+			converted[fmt.Sprint(key)] = stringKeys(entry)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(value))
+		for i, entry := range value {
+			converted[i] = stringKeys(entry)
+		}
+		return converted
+	}
+	return value
+}
+
+func isScalar(value interface{}) bool {
+	switch value.(type) {
+	case string, bool, json.Number, float64, int, int64:
+		return true
+	}
+	return false
+}
+
+func sortedKeys(values map[string]interface{}) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
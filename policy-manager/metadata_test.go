@@ -0,0 +1,170 @@
+package policy_manager
+
+import (
+	"testing"
+
+	"github.com/compliance-framework/agent/runner/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func propsNamed(props []*proto.Property, name string) []*proto.Property {
+	named := make([]*proto.Property, 0)
+	for _, prop := range props {
+		if prop.GetName() == name {
+			named = append(named, prop)
+		}
+	}
+	return named
+}
+
+func TestPolicyProcessorEvidenceFromAnnotations(t *testing.T) {
+	evidences, err := generateTestEvidence(t, `# METADATA
+# title: Buckets are private
+# description: Public buckets expose their objects to anyone.
+# related_resources:
+#   - ref: https://docs.example.com/buckets
+#     description: Bucket guidance
+# custom:
+#   severity: high
+#   frameworks: [cis, soc2]
+#   labels:
+#     team: storage
+#   controls:
+#     - ac-3
+#     - class: SAMA_CSF_1.0
+#       control-id: "3.3.5"
+#       statement-ids: ["2", "4"]
+package compliance_framework.buckets
+
+import future.keywords.in
+
+violation[{"id": "public-bucket"}] if {
+	some bucket in input.buckets
+	bucket.public
+}
+`, testBuckets)
+
+	assert.NoError(t, err)
+	assert.Len(t, evidences, 1)
+	evidence := evidences[0]
+	assert.Equal(t, "Buckets are private", evidence.GetTitle())
+	assert.Equal(t, "Public buckets expose their objects to anyone.", evidence.GetDescription())
+	assert.Equal(t, "storage", evidence.GetLabels()["team"])
+	assert.Equal(t, "test-plugin", evidence.GetLabels()["_plugin"])
+
+	assert.Len(t, evidence.GetLinks(), 1)
+	assert.Equal(t, "https://docs.example.com/buckets", evidence.GetLinks()[0].GetHref())
+	assert.Equal(t, "Bucket guidance", evidence.GetLinks()[0].GetText())
+
+	controls := propsNamed(evidence.GetProps(), ControlPropName)
+	assert.Len(t, controls, 2)
+	assert.Equal(t, "ac-3", controls[0].GetValue())
+	assert.Nil(t, controls[0].Class)
+	assert.Equal(t, "3.3.5", controls[1].GetValue())
+	assert.Equal(t, "SAMA_CSF_1.0", controls[1].GetClass())
+	assert.Equal(t, PropNamespace, controls[1].GetNs())
+
+	statements := propsNamed(evidence.GetProps(), ControlStatementPropName)
+	assert.Len(t, statements, 2)
+	assert.Equal(t, "2", statements[0].GetValue())
+	assert.Equal(t, "3.3.5", statements[0].GetGroup())
+	assert.Equal(t, "SAMA_CSF_1.0", statements[1].GetClass())
+
+	severity := propsNamed(evidence.GetProps(), AnnotationPropPrefix+"severity")
+	assert.Len(t, severity, 1)
+	assert.Equal(t, "high", severity[0].GetValue())
+	frameworks := propsNamed(evidence.GetProps(), AnnotationPropPrefix+"frameworks")
+	assert.Len(t, frameworks, 1)
+	assert.Equal(t, `["cis","soc2"]`, frameworks[0].GetValue())
+	assert.Equal(t, "json", frameworks[0].GetClass())
+	assert.Empty(t, propsNamed(evidence.GetProps(), AnnotationPropPrefix+"labels"))
+	assert.Empty(t, propsNamed(evidence.GetProps(), AnnotationPropPrefix+"controls"))
+
+	// Violation IDs are still reported for the API to match risks on.
+	assert.Len(t, propsNamed(evidence.GetProps(), "_violation_id"), 1)
+}
+
+func TestPolicyProcessorEvidenceFromOutputs(t *testing.T) {
+	evidences, err := generateTestEvidence(t, `package compliance_framework.buckets
+
+title := "Buckets are private"
+controls := ["ac-3", {"class": "NIST_800-53", "control-id": "ac-6"}]
+bucket_count := count(input.buckets)
+provider := "s3"
+encrypted := true
+exceptions := ["backups"]
+_internal := "hidden"
+`, testBuckets)
+
+	assert.NoError(t, err)
+	assert.Len(t, evidences, 1)
+	evidence := evidences[0]
+	assert.Equal(t, proto.EvidenceStatusState_EVIDENCE_STATUS_STATE_SATISFIED, evidence.GetStatus().GetState())
+
+	controls := propsNamed(evidence.GetProps(), ControlPropName)
+	assert.Len(t, controls, 2)
+	assert.Equal(t, "ac-6", controls[1].GetValue())
+	assert.Equal(t, "NIST_800-53", controls[1].GetClass())
+
+	names := make([]string, 0)
+	values := map[string]string{}
+	for _, prop := range evidence.GetProps() {
+		if prop.GetName() != ControlPropName {
+			names = append(names, prop.GetName())
+			values[prop.GetName()] = prop.GetValue()
+		}
+	}
+	// Outputs are reported in name order, leaving out lists, objects and hidden outputs.
+	assert.Equal(t, []string{OutputPropPrefix + "bucket_count", OutputPropPrefix + "encrypted", OutputPropPrefix + "provider"}, names)
+	assert.Equal(t, "3", values[OutputPropPrefix+"bucket_count"])
+	assert.Equal(t, "true", values[OutputPropPrefix+"encrypted"])
+	assert.Equal(t, "s3", values[OutputPropPrefix+"provider"])
+}
+
+func TestPolicyProcessorOutputsOverrideAnnotations(t *testing.T) {
+	evidences, err := generateTestEvidence(t, `# METADATA
+# title: Annotated title
+# description: Annotated description
+package compliance_framework.buckets
+
+title := "Output title"
+labels := {"team": "platform"}
+`, testBuckets)
+
+	assert.NoError(t, err)
+	assert.Len(t, evidences, 1)
+	assert.Equal(t, "Output title", evidences[0].GetTitle())
+	assert.Equal(t, "Annotated description", evidences[0].GetDescription())
+	assert.Equal(t, "platform", evidences[0].GetLabels()["team"])
+}
+
+func TestPolicyProcessorRejectsMalformedControls(t *testing.T) {
+	_, err := generateTestEvidence(t, `package compliance_framework.buckets
+
+title := "Buckets are private"
+controls := [{"class": "NIST_800-53"}]
+`, testBuckets)
+
+	assert.ErrorContains(t, err, "has no control-id")
+}
+
+func TestPolicyProcessorEvidenceFromMetadataControls(t *testing.T) {
+	evidences, err := generateTestEvidence(t, `# METADATA
+# title: Buckets are private
+# controls:
+#   - class: NIST_800-53
+#     control-id: ac-2
+#     statement-ids: [a]
+package compliance_framework.buckets
+`, testBuckets)
+
+	assert.NoError(t, err)
+	assert.Len(t, evidences, 1)
+	controls := propsNamed(evidences[0].GetProps(), ControlPropName)
+	assert.Len(t, controls, 1)
+	assert.Equal(t, "ac-2", controls[0].GetValue())
+	assert.Equal(t, "NIST_800-53", controls[0].GetClass())
+	statements := propsNamed(evidences[0].GetProps(), ControlStatementPropName)
+	assert.Len(t, statements, 1)
+	assert.Equal(t, "ac-2", statements[0].GetGroup())
+}
@@ -13,76 +13,84 @@ import (
 	"github.com/compliance-framework/api/sdk"
 	"github.com/go-viper/mapstructure/v2"
 	"github.com/hashicorp/go-hclog"
+	"github.com/open-policy-agent/opa/v1/bundle"
 	"github.com/open-policy-agent/opa/v1/rego"
 	"github.com/open-policy-agent/opa/v1/storage"
-	"github.com/open-policy-agent/opa/v1/storage/inmem"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type EvalOutput struct {
-	Title               *string            `mapstructure:"title,omitempty"`
-	Description         *string            `mapstructure:"description,omitempty"`
-	Remarks             *string            `mapstructure:"remarks,omitempty"`
-	SkipReason          *string            `mapstructure:"skip_reason,omitempty"`
-	Labels              *map[string]string `mapstructure:"labels,omitempty"`
-	Violations          []Violation
+	Title        *string            `mapstructure:"title,omitempty"`
+	Description  *string            `mapstructure:"description,omitempty"`
+	Remarks      *string            `mapstructure:"remarks,omitempty"`
+	SkipReason   *string            `mapstructure:"skip_reason,omitempty"`
+	Labels       *map[string]string `mapstructure:"labels,omitempty"`
+	EvidenceMode *string            `mapstructure:"evidence_mode,omitempty"`
+	Violations   []Violation
+	// Results, when the policy reports a results set, are the resources it checked, each
+	// of which becomes its own evidence.
+	Results             []PolicyResult `mapstructure:"-"`
 	AdditionalVariables map[string]interface{}
 }
 
 type PolicyManager struct {
-	logger        hclog.Logger
-	loaderOptions []func(r *rego.Rego)
-	policyData    map[string]interface{}
+	logger     hclog.Logger
+	policyPath string
+	// bundle, when set, is evaluated instead of loading the bundle at policyPath, and is
+	// compiled for this PolicyManager only.
+	bundle      *bundle.Bundle
+	policyData  map[string]interface{}
+	evalOptions EvalOptions
+
+	compiled *compiledBundle
+	prepared *preparedBundle
 }
 
 func New(ctx context.Context, logger hclog.Logger, policyPath string, policyData map[string]interface{}) *PolicyManager {
 	return &PolicyManager{
-		logger:        logger,
-		policyData:    policyData,
-		loaderOptions: []func(r *rego.Rego){rego.LoadBundle(policyPath)},
+		logger:      logger,
+		policyData:  policyData,
+		policyPath:  policyPath,
+		evalOptions: defaultEvalOptions(logger),
 	}
 }
 
-func (pm *PolicyManager) prepareForEval(ctx context.Context, regoArgs ...func(r *rego.Rego)) (rego.PreparedEvalQuery, error) {
-	store := inmem.New()
-	txn, err := store.NewTransaction(ctx, storage.TransactionParams{Write: true})
-	if err != nil {
-		return rego.PreparedEvalQuery{}, err
+// prepare takes the compiled bundle from the cache, compiling it when needed, along with
+// the store of the bundle data and policy data that queries are evaluated against.
+// Both are kept for the life of the PolicyManager.
+func (pm *PolicyManager) prepare(ctx context.Context) (*compiledBundle, error) {
+	if pm.compiled != nil {
+		return pm.compiled, nil
 	}
 
-	committed := false
-	defer func() {
-		if !committed {
-			store.Abort(ctx, txn)
-		}
-	}()
-
-	args := make([]func(r *rego.Rego), 0, len(regoArgs)+len(pm.loaderOptions)+2)
-	args = append(args,
-		rego.Store(store),
-		rego.Transaction(txn),
-	)
-	args = append(args, regoArgs...)
-	args = append(args, pm.loaderOptions...)
-
-	query, err := rego.New(args...).PrepareForEval(ctx)
+	var compiled *compiledBundle
+	var err error
+	if pm.bundle != nil {
+		compiled, err = compileBundle(ctx, pm.policyPath, pm.bundle)
+	} else {
+		compiled, err = compiledBundles.get(ctx, pm.logger, pm.policyPath)
+	}
 	if err != nil {
-		return rego.PreparedEvalQuery{}, err
+		return nil, err
 	}
 
-	if err := writePolicyData(ctx, store, txn, pm.policyData); err != nil {
-		return rego.PreparedEvalQuery{}, err
+	prepared, err := compiled.withPolicyData(ctx, pm.policyData)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := store.Commit(ctx, txn); err != nil {
+	pm.compiled = compiled
+	pm.prepared = prepared
+	return compiled, nil
+}
+
+// prepareForEval returns query prepared against the bundle and policy data, which is
+// shared with every other evaluation of the bundle with the same policy data.
+func (pm *PolicyManager) prepareForEval(ctx context.Context, query string) (rego.PreparedEvalQuery, error) {
+	if _, err := pm.prepare(ctx); err != nil {
 		return rego.PreparedEvalQuery{}, err
 	}
-	committed = true
-
-	// PreparedEvalQuery.Eval opens a fresh read transaction unless an
-	// EvalTransaction is provided, so committing this write transaction makes the
-	// loaded bundle and injected policy data visible to later evaluations.
-	return query, nil
+	return pm.prepared.query(ctx, query)
 }
 
 func writePolicyData(ctx context.Context, store storage.Store, txn storage.Transaction, data map[string]interface{}) error {
@@ -162,15 +170,12 @@ func (pm *PolicyManager) Execute(ctx context.Context, input interface{}) ([]Resu
 	var output []Result
 
 	pm.logger.Trace("Executing policy", "input", input)
-	query, err := pm.prepareForEval(ctx,
-		rego.Query("data.compliance_framework"),
-		rego.Package("compliance_framework"),
-	)
+	compiled, err := pm.prepare(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, module := range query.Modules() {
+	for _, module := range compiled.modules {
 		// Exclude any test files for this compilation
 		if strings.HasSuffix(module.Package.Location.File, "_test.rego") {
 			continue
@@ -190,22 +195,24 @@ func (pm *PolicyManager) Execute(ctx context.Context, input interface{}) ([]Resu
 				File:        module.Package.Location.File,
 				Package:     Package(module.Package.Path.String()),
 				Annotations: module.Annotations,
+				Metadata:    ExtractAnnotations(module.Comments),
 			},
 		}
 
-		subQuery, err := pm.prepareForEval(ctx,
-			rego.Query(module.Package.Path.String()),
-			rego.Package(module.Package.Path.String()),
-			rego.Input(input),
-		)
-		if err != nil {
-			return nil, err
+		evaluation, stats, err := pm.evaluate(ctx, result.Policy, module.Package.Path.String(), input)
+		if errors.Is(err, ErrEvalTimeout) {
+			// A runaway package is reported on its own, so the rest of the bundle is
+			// still evaluated.
+			pm.logger.Error("Policy package timed out", "policy_package", result.Policy.Package.PurePackage(), "timeout", pm.evalOptions.Timeout)
+			result.EvalError = err
+			output = append(output, result)
+			continue
 		}
-
-		evaluation, err := subQuery.Eval(ctx)
 		if err != nil {
 			return nil, err
 		}
+		pm.logEvalStats(result.Policy, stats)
+		result.Stats = stats
 
 		for _, eval := range evaluation {
 			for _, expression := range eval.Expressions {
@@ -238,9 +245,32 @@ func (pm *PolicyManager) Execute(ctx context.Context, input interface{}) ([]Resu
 					}
 				}
 
+				var policyResults []PolicyResult
+				if val, ok := moduleOutputs["results"]; ok {
+					rawEntries, err := normalizeViolationEntries(val)
+					if err != nil {
+						return nil, fmt.Errorf(
+							"%w (policy package %q, file %q)",
+							err, result.Policy.Package, result.Policy.File,
+						)
+					}
+					policyResults = make([]PolicyResult, 0, len(rawEntries))
+					for _, raw := range rawEntries {
+						policyResult := PolicyResult{}
+						if err := json.Unmarshal(raw, &policyResult); err != nil {
+							return nil, fmt.Errorf(
+								"decode results entry (policy package %q, file %q): %w",
+								result.Policy.Package, result.Policy.File, err,
+							)
+						}
+						policyResults = append(policyResults, policyResult)
+					}
+				}
+
 				evalOutput := &EvalOutput{
 					AdditionalVariables: map[string]interface{}{},
 					Violations:          violations,
+					Results:             policyResults,
 				}
 
 				if err := mapstructure.Decode(moduleOutputs, evalOutput); err != nil {
@@ -252,7 +282,7 @@ func (pm *PolicyManager) Execute(ctx context.Context, input interface{}) ([]Resu
 
 				// TODO here we could run evalOutput.Validate()
 				for key, value := range moduleOutputs {
-					if !slices.Contains([]string{"violation", "labels"}, key) {
+					if !slices.Contains([]string{"violation", "results", "labels"}, key) {
 						evalOutput.AdditionalVariables[key] = value
 					}
 				}
@@ -267,6 +297,12 @@ func (pm *PolicyManager) Execute(ctx context.Context, input interface{}) ([]Resu
 	return output, nil
 }
 
+// InputRecorder records the input a policy bundle is evaluated with, so that new versions
+// of the bundle can be replayed against it later.
+type InputRecorder interface {
+	RecordPolicyInput(ctx context.Context, policyPath string, labels map[string]string, input interface{}) error
+}
+
 type PolicyProcessor struct {
 	logger         hclog.Logger
 	labels         map[string]string
@@ -276,6 +312,8 @@ type PolicyProcessor struct {
 	actors         []*proto.OriginActor
 	activities     []*proto.Activity
 	policyData     map[string]interface{}
+	recorder       InputRecorder
+	evalOptions    *EvalOptions
 }
 
 func NewPolicyProcessor(
@@ -300,6 +338,12 @@ func NewPolicyProcessor(
 	}
 }
 
+// SetInputRecorder makes GenerateResults record each input before evaluating it. Plugins
+// pass the API helper they are given, when it is an InputRecorder.
+func (p *PolicyProcessor) SetInputRecorder(recorder InputRecorder) {
+	p.recorder = recorder
+}
+
 func (p *PolicyProcessor) GenerateResults(ctx context.Context, policyPath string, data interface{}) ([]*proto.Evidence, error) {
 	var resultErr error
 	activities := p.activities
@@ -320,7 +364,17 @@ func (p *PolicyProcessor) GenerateResults(ctx context.Context, policyPath string
 			},
 		},
 	})
-	results, err := New(ctx, p.logger, policyPath, p.policyData).Execute(ctx, data)
+	if p.recorder != nil {
+		// Recording is a debugging aid, so it never fails the evaluation.
+		if err := p.recorder.RecordPolicyInput(ctx, policyPath, p.labels, data); err != nil {
+			p.logger.Warn("Failed to record policy input", "policy_path", policyPath, "error", err)
+		}
+	}
+	manager := New(ctx, p.logger, policyPath, p.policyData)
+	if p.evalOptions != nil {
+		manager.SetEvalOptions(*p.evalOptions)
+	}
+	results, err := manager.Execute(ctx, data)
 	if err != nil {
 		p.logger.Error("Failed to evaluate against policy bundle", "error", err)
 		resultErr = errors.Join(resultErr, err)
@@ -338,59 +392,138 @@ func (p *PolicyProcessor) GenerateResults(ctx context.Context, policyPath string
 		},
 	})
 	for _, result := range results {
+		if result.EvalError != nil {
+			evidence, err := p.errorEvidence(result, activities)
+			if err != nil {
+				resultErr = errors.Join(resultErr, err)
+				continue
+			}
+			evidences = append(evidences, evidence)
+			continue
+		}
+
 		// If skip_reason is set and non-empty, skip evidence production entirely
 		if result.SkipReason != nil && *result.SkipReason != "" {
 			p.logger.Debug("Skipping evidence for policy", "policy_file", result.Policy.File, "policy_package", result.Policy.Package.PurePackage(), "skip_reason", *result.SkipReason)
 			continue
 		}
 
-		// Observation UUID should differ for each individual subject, but remain consistent when validating the same policy for the same subject.
-		// This acts as an identifier to show the history of an observation.
-		evidence, err := p.newEvidence(result, activities)
+		evidenceResults, err := result.withAnnotationDefaults().evidenceResults()
 		if err != nil {
 			resultErr = errors.Join(resultErr, err)
 			continue
 		}
-
-		if len(result.Violations) == 0 {
-			evidence.Title = *result.Title
-			evidence.Description = result.Description
-			evidence.Remarks = result.Remarks
-			evidence.Status = &proto.EvidenceStatus{
-				Reason:  "pass",
-				Remarks: *FirstOf(result.Remarks, Pointer("")),
-				State:   proto.EvidenceStatusState_EVIDENCE_STATUS_STATE_SATISFIED,
+		seen := map[string]bool{}
+		for _, evidenceResult := range evidenceResults {
+			evidence, err := p.resultEvidence(evidenceResult.Result, evidenceResult.identity, activities)
+			if err != nil {
+				resultErr = errors.Join(resultErr, err)
+				continue
 			}
-
+			if seen[evidence.UUID] {
+				resultErr = errors.Join(resultErr, fmt.Errorf(
+					"policy package %q reports more than one evidence with the labels %v; give each violation or result distinct labels",
+					result.Policy.Package.PurePackage(), evidenceResult.identity,
+				))
+				continue
+			}
+			seen[evidence.UUID] = true
 			evidences = append(evidences, evidence)
 		}
+	}
 
-		if len(result.Violations) > 0 {
-			evidence.Title = *result.Title
-			evidence.Description = result.Description
-			evidence.Remarks = result.Remarks
-			evidence.Status = &proto.EvidenceStatus{
-				Reason:  "fail",
-				Remarks: *FirstOf(result.Remarks, Pointer("")),
-				State:   proto.EvidenceStatusState_EVIDENCE_STATUS_STATE_NOT_SATISFIED,
-			}
+	return evidences, resultErr
+}
 
-			props := make([]*proto.Property, 0, len(result.Violations))
-			for _, value := range result.Violations {
-				if value.ID != nil {
-					props = append(props, &proto.Property{
-						Name:  "_violation_id",
-						Value: *value.ID,
-					})
-				}
-			}
-			evidence.Props = props
+// resultEvidence builds the evidence of a policy result, satisfied when it has no
+// violations.
+func (p *PolicyProcessor) resultEvidence(result Result, identity map[string]string, activities []*proto.Activity) (*proto.Evidence, error) {
+	// Observation UUID should differ for each individual subject, but remain consistent when validating the same policy for the same subject.
+	// This acts as an identifier to show the history of an observation.
+	evidence, err := p.newEvidence(result, identity, activities)
+	if err != nil {
+		return nil, err
+	}
 
-			evidences = append(evidences, evidence)
+	evidence.Title = *result.Title
+	evidence.Description = result.Description
+	evidence.Remarks = result.Remarks
+
+	// Controls, annotations and outputs describe the policy, so every evidence has them.
+	metadataProps, err := result.metadataProps()
+	if err != nil {
+		return nil, err
+	}
+	evidence.Links = result.metadataLinks()
+
+	if len(result.Violations) == 0 {
+		evidence.Status = &proto.EvidenceStatus{
+			Reason:  "pass",
+			Remarks: *FirstOf(result.Remarks, Pointer("")),
+			State:   proto.EvidenceStatusState_EVIDENCE_STATUS_STATE_SATISFIED,
 		}
+		evidence.Props = metadataProps
+		return evidence, nil
 	}
 
-	return evidences, resultErr
+	evidence.Status = &proto.EvidenceStatus{
+		Reason:  "fail",
+		Remarks: *FirstOf(result.Remarks, Pointer("")),
+		State:   proto.EvidenceStatusState_EVIDENCE_STATUS_STATE_NOT_SATISFIED,
+	}
+
+	props := make([]*proto.Property, 0, len(result.Violations))
+	for _, value := range result.Violations {
+		if value.ID != nil {
+			props = append(props, &proto.Property{
+				Name:  "_violation_id",
+				Value: *value.ID,
+			})
+		}
+	}
+	// The details of each violation are kept alongside the IDs the API matches risks on.
+	violationProps, err := ViolationProps(result.Violations)
+	if err != nil {
+		return nil, err
+	}
+	evidence.Props = append(append(props, violationProps...), metadataProps...)
+	return evidence, nil
+}
+
+// errorEvidence reports a policy package that could not be evaluated. It has the UUID the
+// package's evidence has when it reports one, so the failure shows in its history.
+func (p *PolicyProcessor) errorEvidence(result Result, activities []*proto.Activity) (*proto.Evidence, error) {
+	message := result.EvalError.Error()
+	result.EvalOutput = &EvalOutput{Remarks: Pointer(message)}
+	result = result.withAnnotationDefaults()
+	if result.Title == nil {
+		result.Title = Pointer(result.Policy.Package.PurePackage())
+	}
+
+	evidence, err := p.newEvidence(result, nil, activities)
+	if err != nil {
+		return nil, err
+	}
+	metadataProps, err := result.metadataProps()
+	if err != nil {
+		return nil, err
+	}
+
+	evidence.Title = *result.Title
+	evidence.Description = result.Description
+	evidence.Remarks = result.Remarks
+	evidence.Links = result.metadataLinks()
+	evidence.Status = &proto.EvidenceStatus{
+		Reason:  "error",
+		Remarks: message,
+		State:   proto.EvidenceStatusState_EVIDENCE_STATUS_STATE_NOT_SATISFIED,
+	}
+	evidence.Props = append([]*proto.Property{{
+		Name:  EvalErrorPropName,
+		Ns:    Pointer(PropNamespace),
+		Value: message,
+	}}, metadataProps...)
+	return evidence, nil
 }
 
 func validateNewEvidence(result Result) error {
@@ -401,7 +534,10 @@ func validateNewEvidence(result Result) error {
 	return nil
 }
 
-func (p *PolicyProcessor) newEvidence(result Result, activities []*proto.Activity) (*proto.Evidence, error) {
+// newEvidence starts the evidence of a policy result. Identity labels tell apart the
+// evidence of each resource when a policy reports several, and are added to the labels
+// the evidence UUID is seeded from.
+func (p *PolicyProcessor) newEvidence(result Result, identity map[string]string, activities []*proto.Activity) (*proto.Evidence, error) {
 	if err := validateNewEvidence(result); err != nil {
 		return nil, err
 	}
@@ -410,7 +546,7 @@ func (p *PolicyProcessor) newEvidence(result Result, activities []*proto.Activit
 		"type":        "evidence",
 		"policy":      result.Policy.Package.PurePackage(),
 		"policy_file": result.Policy.File,
-	}, p.labels))
+	}, p.labels, identity))
 	if err != nil {
 		return nil, err
 	}
@@ -426,7 +562,9 @@ func (p *PolicyProcessor) newEvidence(result Result, activities []*proto.Activit
 				"_policy": result.Policy.Package.PurePackage(),
 			},
 			p.labels,
+			result.annotationLabels(),
 			resultLabels,
+			identity,
 		),
 		Start:          timestamppb.New(time.Now()),
 		End:            timestamppb.New(time.Now()),
@@ -441,17 +579,14 @@ func (p *PolicyProcessor) newEvidence(result Result, activities []*proto.Activit
 }
 
 func (pm *PolicyManager) GetRiskTemplates(ctx context.Context) (map[string][]*proto.RiskTemplate, error) {
-	query, err := pm.prepareForEval(ctx,
-		rego.Query("data.compliance_framework"),
-		rego.Package("compliance_framework"),
-	)
+	compiled, err := pm.prepare(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	allTemplates := map[string][]*proto.RiskTemplate{}
 
-	for _, module := range query.Modules() {
+	for _, module := range compiled.modules {
 		// Exclude any test files for this compilation
 		if strings.HasSuffix(module.Package.Location.File, "_test.rego") {
 			continue
@@ -499,14 +634,7 @@ func (pm *PolicyManager) GetRiskTemplates(ctx context.Context) (map[string][]*pr
 }
 
 func (pm *PolicyManager) evaluateRiskTemplates(ctx context.Context, policy Policy) ([]interface{}, error) {
-	query, err := pm.prepareForEval(ctx,
-		rego.Query(fmt.Sprintf("%s.risk_templates", policy.Package)),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("prepare %q in %s: %w", "risk_templates", policy.File, err)
-	}
-
-	evaluation, err := query.Eval(ctx)
+	evaluation, _, err := pm.evaluate(ctx, policy, fmt.Sprintf("%s.risk_templates", policy.Package), nil)
 	if err != nil {
 		return nil, fmt.Errorf("evaluate %q in %s: %w", "risk_templates", policy.File, err)
 	}
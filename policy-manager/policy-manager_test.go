@@ -9,7 +9,6 @@ import (
 	"github.com/hashicorp/go-hclog"
 	"github.com/open-policy-agent/opa/v1/ast"
 	"github.com/open-policy-agent/opa/v1/bundle"
-	"github.com/open-policy-agent/opa/v1/rego"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -34,11 +33,10 @@ func buildPolicyManagerWithModules(modules map[string][]byte) *PolicyManager {
 			Level:      hclog.Debug,
 			JSONFormat: true,
 		}),
-		loaderOptions: []func(r *rego.Rego){
-			rego.ParsedBundle("test", &bundle.Bundle{
-				Modules:  bundleModules,
-				Manifest: bundle.Manifest{Revision: "test", Roots: &[]string{"/"}},
-			}),
+		policyPath: "test",
+		bundle: &bundle.Bundle{
+			Modules:  bundleModules,
+			Manifest: bundle.Manifest{Revision: "test", Roots: &[]string{"/"}},
 		},
 	}
 }
@@ -359,7 +357,7 @@ func TestPolicyProcessorNewEvidenceRejectsMissingTitle(t *testing.T) {
 			Package: Package("data.compliance_framework.missing_title"),
 		},
 		EvalOutput: &EvalOutput{},
-	}, nil)
+	}, nil, nil)
 
 	assert.Nil(t, evidence)
 	assert.EqualError(t, err, "evidence title is required")
@@ -549,3 +547,39 @@ skip_reason := "Invalid payload - missing required field"
 	assert.NoError(t, err)
 	assert.Empty(t, evidences, "No evidence should be produced when skip_reason is set, even without title")
 }
+
+type testInputRecorder struct {
+	policyPath string
+	labels     map[string]string
+	input      interface{}
+}
+
+func (r *testInputRecorder) RecordPolicyInput(ctx context.Context, policyPath string, labels map[string]string, input interface{}) error {
+	r.policyPath, r.labels, r.input = policyPath, labels, input
+	return nil
+}
+
+func TestPolicyProcessorRecordsInput(t *testing.T) {
+	ctx := context.Background()
+	policyDir := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(policyDir, "record.rego"), []byte(`package compliance_framework.record
+
+title := "Recorded"
+`), 0o644)
+	assert.NoError(t, err)
+
+	labels := map[string]string{"_plugin": "test-plugin"}
+	processor := NewPolicyProcessor(hclog.NewNullLogger(), labels, nil, nil, nil, nil, nil, nil)
+	recorder := &testInputRecorder{}
+	processor.SetInputRecorder(recorder)
+
+	input := map[string]interface{}{"enabled": true}
+	evidences, err := processor.GenerateResults(ctx, policyDir, input)
+
+	assert.NoError(t, err)
+	assert.Len(t, evidences, 1)
+	assert.Equal(t, policyDir, recorder.policyPath)
+	assert.Equal(t, labels, recorder.labels)
+	assert.Equal(t, input, recorder.input)
+}
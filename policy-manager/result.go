@@ -12,8 +12,38 @@ type Violation struct {
 	Title       *string `json:"title,omitempty" mapstructure:"title"`
 	Description *string `json:"description,omitempty" mapstructure:"description"`
 	Remarks     *string `json:"remarks,omitempty" mapstructure:"remarks"`
+	// Labels identify the resource that failed, when each violation is reported as its own
+	// evidence.
+	Labels map[string]string `json:"labels,omitempty" mapstructure:"labels"`
+	// Fields are any other fields the policy reported for the violation, such as the
+	// resource that failed, or the expected and actual values.
+	Fields map[string]interface{} `json:"-" mapstructure:",remain"`
 }
 
+// PolicyResult is an entry of a policy's results set, reporting a resource the policy
+// checked. Each entry becomes its own evidence, identified by its labels, which is
+// satisfied when the entry has no violations.
+type PolicyResult struct {
+	Title       *string           `json:"title,omitempty"`
+	Description *string           `json:"description,omitempty"`
+	Remarks     *string           `json:"remarks,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Violations  []Violation       `json:"violations,omitempty"`
+}
+
+// EvidenceMode is how the outputs of a policy package are turned into evidence, set with
+// the evidence_mode output or custom METADATA annotation.
+type EvidenceMode string
+
+const (
+	// EvidenceModePerPolicy reports one evidence for the package, failing when it has any
+	// violation. It is the default.
+	EvidenceModePerPolicy EvidenceMode = "per_policy"
+	// EvidenceModePerViolation reports each violation as its own evidence, identified by
+	// the violation's labels.
+	EvidenceModePerViolation EvidenceMode = "per_violation"
+)
+
 type Package string
 
 func (p Package) PurePackage() string {
@@ -24,6 +54,9 @@ type Policy struct {
 	File        string
 	Package     Package
 	Annotations []*ast.Annotations
+	// Metadata is the METADATA block of the policy file as read by
+	// ExtractAnnotations, which keeps keys OPA annotations drop, such as controls.
+	Metadata map[string]interface{}
 }
 
 type Step struct {
@@ -91,9 +124,23 @@ type RiskTemplate struct {
 type Result struct {
 	Policy Policy
 	*EvalOutput
+	// EvalError is set, instead of EvalOutput, when the package could not be evaluated,
+	// such as when it did not finish within the evaluation timeout.
+	EvalError error
+	// Stats are the metrics and profile of the evaluation, when they are enabled.
+	Stats *EvalStats
 }
 
 func (res Result) String() string {
+	if res.EvalOutput == nil {
+		return fmt.Sprintf(`
+Policy:
+	file: %s
+	package: %s
+	annotations: %s
+EvalError: %v
+`, res.Policy.File, res.Policy.Package.PurePackage(), res.Policy.Annotations, res.EvalError)
+	}
 	return fmt.Sprintf(`
 Policy:
 	file: %s
@@ -104,3 +151,73 @@ Labels: %v
 Violations: %v
 `, res.Policy.File, res.Policy.Package.PurePackage(), res.Policy.Annotations, res.AdditionalVariables, res.Labels, res.Violations)
 }
+
+// evidenceResult is a policy result to report as one evidence, along with the labels
+// that identify it among the other evidence of the policy.
+type evidenceResult struct {
+	Result
+	identity map[string]string
+}
+
+// evidenceResults splits a policy result into the evidence it is reported as: one per
+// entry of its results set when it has one, one per violation in the per_violation
+// evidence mode, and otherwise only the one. A policy without violations in the
+// per_violation mode is reported as one satisfied evidence.
+func (res Result) evidenceResults() ([]evidenceResult, error) {
+	if res.Results != nil {
+		evidenceResults := make([]evidenceResult, 0, len(res.Results))
+		for _, entry := range res.Results {
+			output := *res.EvalOutput
+			output.Title = FirstOf(entry.Title, res.Title)
+			output.Description = FirstOf(entry.Description, res.Description)
+			output.Remarks = FirstOf(entry.Remarks, res.Remarks)
+			output.Violations = entry.Violations
+			output.Results = nil
+			evidenceResults = append(evidenceResults, evidenceResult{
+				Result:   Result{Policy: res.Policy, EvalOutput: &output},
+				identity: entry.Labels,
+			})
+		}
+		return evidenceResults, nil
+	}
+
+	mode, err := res.evidenceMode()
+	if err != nil {
+		return nil, err
+	}
+	if mode != EvidenceModePerViolation || len(res.Violations) == 0 {
+		return []evidenceResult{{Result: res}}, nil
+	}
+
+	evidenceResults := make([]evidenceResult, 0, len(res.Violations))
+	for _, violation := range res.Violations {
+		output := *res.EvalOutput
+		output.Violations = []Violation{violation}
+		evidenceResults = append(evidenceResults, evidenceResult{
+			Result:   Result{Policy: res.Policy, EvalOutput: &output},
+			identity: violation.Labels,
+		})
+	}
+	return evidenceResults, nil
+}
+
+// evidenceMode reads the evidence mode from the evidence_mode output, or else from the
+// evidence_mode custom annotation of the package.
+func (res Result) evidenceMode() (EvidenceMode, error) {
+	mode := EvidenceModePerPolicy
+	for _, annotation := range res.packageAnnotations() {
+		if value, ok := annotation.Custom["evidence_mode"].(string); ok {
+			mode = EvidenceMode(value)
+		}
+	}
+	if res.EvalOutput != nil && res.EvidenceMode != nil {
+		mode = EvidenceMode(*res.EvidenceMode)
+	}
+
+	switch mode {
+	case EvidenceModePerPolicy, EvidenceModePerViolation:
+		return mode, nil
+	}
+	return "", fmt.Errorf("policy package %q has an unknown evidence_mode %q; supported modes are %s and %s",
+		res.Policy.Package.PurePackage(), mode, EvidenceModePerPolicy, EvidenceModePerViolation)
+}
@@ -1 +1,161 @@
 package policy_manager
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/compliance-framework/agent/runner/proto"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestPolicy(t *testing.T, regoContents string) string {
+	t.Helper()
+
+	policyDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(policyDir, "buckets.rego"), []byte(regoContents), 0o644)
+	assert.NoError(t, err)
+	return policyDir
+}
+
+func generateTestEvidence(t *testing.T, regoContents string, input interface{}) ([]*proto.Evidence, error) {
+	t.Helper()
+
+	processor := NewPolicyProcessor(hclog.NewNullLogger(), map[string]string{"_plugin": "test-plugin"}, nil, nil, nil, nil, nil, nil)
+	return processor.GenerateResults(context.Background(), writeTestPolicy(t, regoContents), input)
+}
+
+var testBuckets = map[string]interface{}{
+	"buckets": []map[string]interface{}{
+		{"name": "logs", "public": true},
+		{"name": "assets", "public": true},
+		{"name": "backups", "public": false},
+	},
+}
+
+func TestPolicyProcessorPerViolationEvidence(t *testing.T) {
+	policyDir := writeTestPolicy(t, `package compliance_framework.buckets
+
+import future.keywords.in
+
+title := "Buckets are private"
+evidence_mode := "per_violation"
+
+violation[{"id": "public-bucket", "title": "Bucket is public", "labels": {"bucket": bucket.name}}] if {
+	some bucket in input.buckets
+	bucket.public
+}
+`)
+	processor := NewPolicyProcessor(hclog.NewNullLogger(), map[string]string{"_plugin": "test-plugin"}, nil, nil, nil, nil, nil, nil)
+	evidences, err := processor.GenerateResults(context.Background(), policyDir, testBuckets)
+
+	assert.NoError(t, err)
+	assert.Len(t, evidences, 2)
+	buckets := map[string]string{}
+	for _, evidence := range evidences {
+		assert.Equal(t, "Buckets are private", evidence.GetTitle())
+		assert.Equal(t, proto.EvidenceStatusState_EVIDENCE_STATUS_STATE_NOT_SATISFIED, evidence.GetStatus().GetState())
+		assert.Equal(t, "public-bucket", evidence.GetProps()[0].GetValue())
+		buckets[evidence.GetLabels()["bucket"]] = evidence.GetUUID()
+	}
+	assert.Contains(t, buckets, "logs")
+	assert.Contains(t, buckets, "assets")
+	assert.NotEqual(t, buckets["logs"], buckets["assets"], "each bucket should have its own evidence UUID")
+
+	// The UUID of a bucket's evidence does not depend on the other buckets.
+	again, err := processor.GenerateResults(context.Background(), policyDir, map[string]interface{}{
+		"buckets": []map[string]interface{}{{"name": "logs", "public": true}},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, again, 1)
+	assert.Equal(t, buckets["logs"], again[0].GetUUID())
+}
+
+func TestPolicyProcessorPerViolationEvidenceFromAnnotation(t *testing.T) {
+	policy := `# METADATA
+# custom:
+#   evidence_mode: per_violation
+package compliance_framework.buckets
+
+import future.keywords.in
+
+title := "Buckets are private"
+
+violation[{"id": "public-bucket", "labels": {"bucket": bucket.name}}] if {
+	some bucket in input.buckets
+	bucket.public
+}
+`
+	evidences, err := generateTestEvidence(t, policy, testBuckets)
+	assert.NoError(t, err)
+	assert.Len(t, evidences, 2)
+
+	// Without violations, the policy is reported as one satisfied evidence.
+	evidences, err = generateTestEvidence(t, policy, map[string]interface{}{"buckets": []interface{}{}})
+	assert.NoError(t, err)
+	assert.Len(t, evidences, 1)
+	assert.Equal(t, proto.EvidenceStatusState_EVIDENCE_STATUS_STATE_SATISFIED, evidences[0].GetStatus().GetState())
+}
+
+func TestPolicyProcessorResultsSetEvidence(t *testing.T) {
+	evidences, err := generateTestEvidence(t, `package compliance_framework.buckets
+
+import future.keywords.in
+
+title := "Buckets are private"
+
+results contains {
+	"title": sprintf("Bucket %s is private", [bucket.name]),
+	"labels": {"bucket": bucket.name},
+	"violations": [v | bucket.public; v := {"id": "public-bucket", "bucket": bucket.name}],
+} if {
+	some bucket in input.buckets
+}
+`, testBuckets)
+
+	assert.NoError(t, err)
+	assert.Len(t, evidences, 3)
+	states := map[string]proto.EvidenceStatusState{}
+	for _, evidence := range evidences {
+		bucket := evidence.GetLabels()["bucket"]
+		states[bucket] = evidence.GetStatus().GetState()
+		assert.Equal(t, "Bucket "+bucket+" is private", evidence.GetTitle())
+	}
+	assert.Equal(t, map[string]proto.EvidenceStatusState{
+		"logs":    proto.EvidenceStatusState_EVIDENCE_STATUS_STATE_NOT_SATISFIED,
+		"assets":  proto.EvidenceStatusState_EVIDENCE_STATUS_STATE_NOT_SATISFIED,
+		"backups": proto.EvidenceStatusState_EVIDENCE_STATUS_STATE_SATISFIED,
+	}, states)
+}
+
+func TestPolicyProcessorRejectsAmbiguousEvidence(t *testing.T) {
+	// Violations without labels cannot be told apart.
+	evidences, err := generateTestEvidence(t, `package compliance_framework.buckets
+
+import future.keywords.in
+
+title := "Buckets are private"
+evidence_mode := "per_violation"
+
+violation[{"id": "public-bucket", "bucket": bucket.name}] if {
+	some bucket in input.buckets
+	bucket.public
+}
+`, testBuckets)
+	assert.Len(t, evidences, 1)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "more than one evidence with the labels")
+	}
+
+	evidences, err = generateTestEvidence(t, `package compliance_framework.buckets
+
+title := "Buckets are private"
+evidence_mode := "per_bucket"
+`, testBuckets)
+	assert.Empty(t, evidences)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `unknown evidence_mode "per_bucket"`)
+	}
+}
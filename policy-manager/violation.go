@@ -0,0 +1,179 @@
+package policy_manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/compliance-framework/agent/runner/proto"
+)
+
+const (
+	// PropNamespace is the namespace of the props the agent adds to evidence from policy
+	// outputs and annotations, the same namespace the API uses for its own props.
+	PropNamespace = "https://compliance-framework.io"
+	// ViolationPropNamespace is the namespace of the props that describe the violations of
+	// evidence.
+	ViolationPropNamespace = PropNamespace
+	// ViolationPropPrefix prefixes the name of each violation field, such as
+	// ccf:violation-title.
+	ViolationPropPrefix = "ccf:violation-"
+	// propClassJSON marks a prop whose value is JSON encoded.
+	propClassJSON = "json"
+)
+
+var violationKnownFields = []string{"id", "title", "description", "remarks"}
+
+// UnmarshalJSON decodes a violation, keeping any fields besides the known ones in Fields,
+// so that policies can report details such as the resource or the expected and actual
+// values.
+func (v *Violation) UnmarshalJSON(data []byte) error {
+	type violation Violation
+	var known violation
+	if err := json.Unmarshal(data, &known); err != nil {
+		return err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for _, key := range violationKnownFields {
+		delete(fields, key)
+	}
+	delete(fields, "labels")
+
+	*v = Violation(known)
+	v.Fields = nil
+	if len(fields) > 0 {
+		v.Fields = fields
+	}
+	return nil
+}
+
+// MarshalJSON encodes a violation with its Fields alongside the known fields, the same
+// way policies report them.
+func (v Violation) MarshalJSON() ([]byte, error) {
+	out := map[string]interface{}{}
+	for key, value := range v.Fields {
+		out[key] = value
+	}
+	for key, value := range map[string]*string{"id": v.ID, "title": v.Title, "description": v.Description, "remarks": v.Remarks} {
+		if value != nil {
+			out[key] = *value
+		}
+	}
+	if len(v.Labels) > 0 {
+		out["labels"] = v.Labels
+	}
+	return json.Marshal(out)
+}
+
+// ViolationProps describes violations as evidence props, grouping the fields of each
+// violation as violation-1, violation-2 and so on. Values that are not strings are JSON
+// encoded, and marked with the json class.
+func ViolationProps(violations []Violation) ([]*proto.Property, error) {
+	props := make([]*proto.Property, 0)
+	for i, violation := range violations {
+		group := fmt.Sprintf("violation-%d", i+1)
+		for _, field := range violation.fields() {
+			prop, err := valueProp(ViolationPropPrefix+field.name, field.value)
+			if err != nil {
+				return nil, fmt.Errorf("encode violation field %q: %w", field.name, err)
+			}
+			prop.Group = Pointer(group)
+			props = append(props, prop)
+		}
+	}
+	return props, nil
+}
+
+// ViolationsFromProps reads back the violations described by ViolationProps, in order.
+// Other props are ignored.
+func ViolationsFromProps(props []*proto.Property) ([]Violation, error) {
+	var groups []string
+	fields := map[string]map[string]interface{}{}
+	for _, prop := range props {
+		if prop.GetNs() != ViolationPropNamespace || !strings.HasPrefix(prop.GetName(), ViolationPropPrefix) {
+			continue
+		}
+
+		group := prop.GetGroup()
+		if fields[group] == nil {
+			groups = append(groups, group)
+			fields[group] = map[string]interface{}{}
+		}
+		var value interface{} = prop.GetValue()
+		if prop.GetClass() == propClassJSON {
+			if err := json.Unmarshal([]byte(prop.GetValue()), &value); err != nil {
+				return nil, fmt.Errorf("decode violation prop %q: %w", prop.GetName(), err)
+			}
+		}
+		fields[group][strings.TrimPrefix(prop.GetName(), ViolationPropPrefix)] = value
+	}
+
+	violations := make([]Violation, 0, len(groups))
+	for _, group := range groups {
+		encoded, err := json.Marshal(fields[group])
+		if err != nil {
+			return nil, err
+		}
+		var violation Violation
+		if err := json.Unmarshal(encoded, &violation); err != nil {
+			return nil, fmt.Errorf("decode violation %s: %w", group, err)
+		}
+		violations = append(violations, violation)
+	}
+	return violations, nil
+}
+
+// valueProp is a prop in the PropNamespace holding a policy value, which is JSON
+// encoded and marked with the json class when it is not a string.
+func valueProp(name string, value interface{}) (*proto.Property, error) {
+	prop := &proto.Property{
+		Name: name,
+		Ns:   Pointer(PropNamespace),
+	}
+	if text, ok := value.(string); ok {
+		prop.Value = text
+		return prop, nil
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	prop.Value = string(encoded)
+	prop.Class = Pointer(propClassJSON)
+	return prop, nil
+}
+
+type violationField struct {
+	name  string
+	value interface{}
+}
+
+// fields lists the fields of a violation, the known fields and labels first and then the
+// others by name.
+func (v Violation) fields() []violationField {
+	var fields []violationField
+	for i, value := range []*string{v.ID, v.Title, v.Description, v.Remarks} {
+		if value != nil {
+			fields = append(fields, violationField{name: violationKnownFields[i], value: *value})
+		}
+	}
+
+	if len(v.Labels) > 0 {
+		fields = append(fields, violationField{name: "labels", value: v.Labels})
+	}
+
+	names := make([]string, 0, len(v.Fields))
+	for name := range v.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fields = append(fields, violationField{name: name, value: v.Fields[name]})
+	}
+	return fields
+}
@@ -0,0 +1,86 @@
+package policy_manager
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/compliance-framework/agent/runner/proto"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestViolationKeepsPolicyFields(t *testing.T) {
+	var violation Violation
+	err := json.Unmarshal([]byte(`{"id": "open-port", "title": "Port open", "resource": "sg-123", "expected": [22], "actual": [22, 23]}`), &violation)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "open-port", *violation.ID)
+	assert.Equal(t, "Port open", *violation.Title)
+	assert.Equal(t, map[string]interface{}{
+		"resource": "sg-123",
+		"expected": []interface{}{float64(22)},
+		"actual":   []interface{}{float64(22), float64(23)},
+	}, violation.Fields)
+
+	encoded, err := json.Marshal(violation)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"id": "open-port", "title": "Port open", "resource": "sg-123", "expected": [22], "actual": [22, 23]}`, string(encoded))
+}
+
+func TestViolationPropsRoundTrip(t *testing.T) {
+	violations := []Violation{
+		{ID: Pointer("open-port"), Title: Pointer("Port open"), Fields: map[string]interface{}{"resource": "sg-123", "port": float64(23)}},
+		{ID: Pointer("open-port"), Description: Pointer("Telnet is enabled")},
+	}
+
+	props, err := ViolationProps(violations)
+	assert.NoError(t, err)
+	assert.Len(t, props, 6)
+	assert.Equal(t, "ccf:violation-id", props[0].GetName())
+	assert.Equal(t, ViolationPropNamespace, props[0].GetNs())
+	assert.Equal(t, "violation-1", props[0].GetGroup())
+	assert.Equal(t, "ccf:violation-port", props[2].GetName())
+	assert.Equal(t, "23", props[2].GetValue())
+	assert.Equal(t, "json", props[2].GetClass())
+	assert.Equal(t, "violation-2", props[5].GetGroup())
+
+	// Props of other namespaces, such as the violation IDs risks are matched on, are ignored.
+	props = append([]*proto.Property{{Name: "_violation_id", Value: "open-port"}}, props...)
+	decoded, err := ViolationsFromProps(props)
+	assert.NoError(t, err)
+	assert.Equal(t, violations, decoded)
+}
+
+func TestPolicyProcessorKeepsViolationDetails(t *testing.T) {
+	policyDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(policyDir, "ports.rego"), []byte(`package compliance_framework.ports
+
+import future.keywords.in
+
+title := "Only SSH is open"
+
+violation[{"id": "open-port", "title": "Port open", "port": port}] if {
+	some port in input.ports
+	port != 22
+}
+`), 0o644)
+	assert.NoError(t, err)
+
+	processor := NewPolicyProcessor(hclog.NewNullLogger(), map[string]string{"_plugin": "test-plugin"}, nil, nil, nil, nil, nil, nil)
+	evidences, err := processor.GenerateResults(context.Background(), policyDir, map[string]interface{}{"ports": []int{22, 23}})
+	assert.NoError(t, err)
+	assert.Len(t, evidences, 1)
+
+	props := evidences[0].GetProps()
+	assert.Equal(t, "_violation_id", props[0].GetName())
+	assert.Equal(t, "open-port", props[0].GetValue())
+
+	violations, err := ViolationsFromProps(props)
+	assert.NoError(t, err)
+	assert.Len(t, violations, 1)
+	assert.Equal(t, "Port open", *violations[0].Title)
+	assert.Equal(t, map[string]interface{}{"port": float64(23)}, violations[0].Fields)
+}
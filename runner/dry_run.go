@@ -0,0 +1,130 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/compliance-framework/agent/runner/proto"
+	"github.com/hashicorp/go-hclog"
+)
+
+const (
+	DryRunKindEvidence         = "evidence"
+	DryRunKindRiskTemplates    = "risk-templates"
+	DryRunKindSubjectTemplates = "subject-templates"
+	DryRunKindAgentEvidence    = "agent-evidence"
+)
+
+var dryRunFilenameUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// DryRunRecord is a request the agent would have made to the API during a dry run.
+// Payload is the body that would have been sent.
+type DryRunRecord struct {
+	Plugin  string      `json:"plugin,omitempty"`
+	Kind    string      `json:"kind"`
+	Package string      `json:"package,omitempty"`
+	Payload interface{} `json:"payload"`
+}
+
+// DryRunOutput captures the requests a dry run would have made to the API. Each record
+// is written to its own JSON file in a directory, named so that the files of a run sort
+// in the order they were written, or to a stream when there is no directory.
+type DryRunOutput struct {
+	mu      sync.Mutex
+	dir     string
+	out     io.Writer
+	started string
+	seq     int
+}
+
+func NewDryRunOutput(dir string, out io.Writer) (*DryRunOutput, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("create dry run output directory %q: %w", dir, err)
+		}
+	}
+
+	return &DryRunOutput{
+		dir:     dir,
+		out:     out,
+		started: time.Now().UTC().Format("20060102T150405Z"),
+	}, nil
+}
+
+// Write writes a record, and returns the file it was written to, if any.
+func (o *DryRunOutput) Write(record DryRunRecord) (string, error) {
+	encoded, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.dir == "" {
+		_, err := fmt.Fprintln(o.out, string(encoded))
+		return "", err
+	}
+
+	o.seq++
+	parts := []string{o.started, fmt.Sprintf("%06d", o.seq)}
+	if record.Plugin != "" {
+		parts = append(parts, dryRunFilenameUnsafe.ReplaceAllString(record.Plugin, "_"))
+	}
+	parts = append(parts, record.Kind)
+	path := filepath.Join(o.dir, strings.Join(parts, "-")+".json")
+
+	return path, os.WriteFile(path, append(encoded, '\n'), 0o600)
+}
+
+// dryRunApiHelper is an ApiHelper that writes what it would have sent to the API to a
+// DryRunOutput instead. Payloads are prepared exactly as apiHelper prepares them.
+type dryRunApiHelper struct {
+	logger      hclog.Logger
+	output      *DryRunOutput
+	agentLabels map[string]string
+	pluginName  string
+}
+
+func NewDryRunApiHelper(logger hclog.Logger, output *DryRunOutput, agentLabels map[string]string, pluginName string) *dryRunApiHelper {
+	return &dryRunApiHelper{
+		logger:      logger.Named("dry-run"),
+		output:      output,
+		agentLabels: agentLabels,
+		pluginName:  pluginName,
+	}
+}
+
+func (h *dryRunApiHelper) CreateEvidence(ctx context.Context, evidence []*proto.Evidence) error {
+	labelled := labelEvidence(evidence, h.agentLabels)
+	return h.write(DryRunRecord{Kind: DryRunKindEvidence, Payload: labelled}, len(labelled))
+}
+
+func (h *dryRunApiHelper) UpsertRiskTemplates(ctx context.Context, packageName string, riskTemplates []*proto.RiskTemplate) error {
+	templates := riskTemplatesForUpsert(riskTemplates)
+	return h.write(DryRunRecord{Kind: DryRunKindRiskTemplates, Package: packageName, Payload: templates}, len(templates))
+}
+
+func (h *dryRunApiHelper) UpsertSubjectTemplates(ctx context.Context, subjectTemplates []*proto.SubjectTemplate) error {
+	templates := subjectTemplatesForUpsert(subjectTemplates, h.pluginName)
+	return h.write(DryRunRecord{Kind: DryRunKindSubjectTemplates, Payload: templates}, len(templates))
+}
+
+func (h *dryRunApiHelper) write(record DryRunRecord, count int) error {
+	record.Plugin = h.pluginName
+	path, err := h.output.Write(record)
+	if err != nil {
+		h.logger.Error("Error writing dry run output", "kind", record.Kind, "error", err)
+		return err
+	}
+	h.logger.Debug("Captured dry run request instead of sending it", "kind", record.Kind, "count", count, "path", path)
+	return nil
+}
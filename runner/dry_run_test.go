@@ -0,0 +1,103 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/compliance-framework/agent/runner/proto"
+	"github.com/compliance-framework/api/sdk/types"
+	"github.com/google/uuid"
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestDryRunApiHelper_WritesPayloadsToFiles(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "captures")
+	output, err := NewDryRunOutput(dir, nil)
+	if err != nil {
+		t.Fatalf("NewDryRunOutput() error = %v", err)
+	}
+	helper := NewDryRunApiHelper(hclog.NewNullLogger(), output, map[string]string{"_agent": "agent-1", "env": "agent"}, "ssh/plugin")
+
+	ctx := context.Background()
+	if err := helper.UpsertSubjectTemplates(ctx, []*proto.SubjectTemplate{{Name: "host", Type: proto.SubjectType_SUBJECT_TYPE_COMPONENT}}); err != nil {
+		t.Fatalf("UpsertSubjectTemplates() error = %v", err)
+	}
+	if err := helper.UpsertRiskTemplates(ctx, "compliance_framework.ssh", []*proto.RiskTemplate{{Title: "Weak ciphers"}}); err != nil {
+		t.Fatalf("UpsertRiskTemplates() error = %v", err)
+	}
+	if err := helper.CreateEvidence(ctx, []*proto.Evidence{{UUID: uuid.NewString(), Title: "Evidence", Labels: map[string]string{"env": "evidence"}}}); err != nil {
+		t.Fatalf("CreateEvidence() error = %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("os.ReadDir() error = %v", err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	if len(names) != 3 {
+		t.Fatalf("expected one file per request, got %v", names)
+	}
+	for i, suffix := range []string{"-000001-ssh_plugin-subject-templates.json", "-000002-ssh_plugin-risk-templates.json", "-000003-ssh_plugin-evidence.json"} {
+		if !strings.HasSuffix(names[i], suffix) {
+			t.Fatalf("expected file %d to end with %q, got %v", i, suffix, names)
+		}
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, names[2]))
+	if err != nil {
+		t.Fatalf("os.ReadFile() error = %v", err)
+	}
+	var record struct {
+		Plugin  string           `json:"plugin"`
+		Kind    string           `json:"kind"`
+		Payload []types.Evidence `json:"payload"`
+	}
+	if err := json.Unmarshal(content, &record); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if record.Plugin != "ssh/plugin" || record.Kind != DryRunKindEvidence || len(record.Payload) != 1 {
+		t.Fatalf("unexpected evidence record %+v", record)
+	}
+	labels := record.Payload[0].Labels
+	if labels["_agent"] != "agent-1" || labels["env"] != "evidence" {
+		t.Fatalf("expected agent labels merged under the evidence labels, got %v", labels)
+	}
+}
+
+func TestDryRunOutput_WritesToStreamWithoutDirectory(t *testing.T) {
+	var out bytes.Buffer
+	output, err := NewDryRunOutput("", &out)
+	if err != nil {
+		t.Fatalf("NewDryRunOutput() error = %v", err)
+	}
+	helper := NewDryRunApiHelper(hclog.NewNullLogger(), output, nil, "plugin")
+
+	if err := helper.UpsertRiskTemplates(context.Background(), "package", []*proto.RiskTemplate{{Title: "Risk"}}); err != nil {
+		t.Fatalf("UpsertRiskTemplates() error = %v", err)
+	}
+
+	var record struct {
+		Kind    string               `json:"kind"`
+		Package string               `json:"package"`
+		Payload []types.RiskTemplate `json:"payload"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v, output %q", err, out.String())
+	}
+	if record.Kind != DryRunKindRiskTemplates || record.Package != "package" || len(record.Payload) != 1 {
+		t.Fatalf("unexpected risk template record %+v", record)
+	}
+	if record.Payload[0].IsActive == nil || !*record.Payload[0].IsActive {
+		t.Fatal("expected risk templates to be prepared as they would be for the API")
+	}
+}
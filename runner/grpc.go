@@ -2,6 +2,7 @@ package runner
 
 import (
 	"context"
+	"encoding/json"
 	"sync"
 
 	"github.com/compliance-framework/agent/runner/proto"
@@ -18,7 +19,13 @@ type ApiHelper interface {
 	UpsertSubjectTemplates(context.Context, []*proto.SubjectTemplate) error
 }
 
-type GRPCApiHelperClient struct{ client proto.ApiHelperClient }
+type GRPCApiHelperClient struct {
+	client proto.ApiHelperClient
+
+	mu sync.Mutex
+	// recording is whether the agent records policy inputs, once it has been asked.
+	recording *bool
+}
 
 func (m *GRPCApiHelperClient) CreateEvidence(ctx context.Context, evidence []*proto.Evidence) error {
 	_, err := m.client.CreateEvidence(ctx, &proto.CreateEvidenceRequest{
@@ -51,6 +58,45 @@ func (m *GRPCApiHelperClient) UpsertSubjectTemplates(ctx context.Context, subjec
 	return err
 }
 
+// RecordPolicyInput sends the input a policy bundle is evaluated with to the agent, when
+// the agent records inputs. It makes GRPCApiHelperClient an InputRecorder.
+func (m *GRPCApiHelperClient) RecordPolicyInput(ctx context.Context, policyPath string, labels map[string]string, input interface{}) error {
+	recording, err := m.recordsPolicyInputs(ctx)
+	if err != nil || !recording {
+		return err
+	}
+
+	encoded, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	_, err = m.client.RecordPolicyInput(ctx, &proto.RecordPolicyInputRequest{
+		PolicyPath: policyPath,
+		Labels:     labels,
+		Input:      encoded,
+	})
+	return err
+}
+
+// recordsPolicyInputs asks the agent whether it records policy inputs the first time an
+// input is recorded, so that inputs are not encoded and sent to an agent that drops them.
+// Agents without input recording enabled, or that predate it, answer Unimplemented.
+func (m *GRPCApiHelperClient) recordsPolicyInputs(ctx context.Context) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.recording != nil {
+		return *m.recording, nil
+	}
+
+	_, err := m.client.RecordPolicyInput(ctx, &proto.RecordPolicyInputRequest{})
+	if err != nil && status.Code(err) != codes.Unimplemented {
+		return false, err
+	}
+	recording := err == nil
+	m.recording = &recording
+	return recording, nil
+}
+
 type GRPCApiHelperServer struct {
 	mu sync.RWMutex
 
@@ -109,42 +155,107 @@ func (m *GRPCApiHelperServer) UpsertSubjectTemplates(ctx context.Context, req *p
 	return &proto.UpsertSubjectTemplatesResponse{}, nil
 }
 
+// RecordPolicyInput passes the input on to the implementation as JSON, when it records
+// inputs, and answers Unimplemented otherwise, so that the plugin stops sending them. A
+// request without an input only asks whether inputs are recorded.
+func (m *GRPCApiHelperServer) RecordPolicyInput(ctx context.Context, req *proto.RecordPolicyInputRequest) (resp *proto.RecordPolicyInputResponse, err error) {
+	m.mu.RLock()
+	impl := m.Impl
+	m.mu.RUnlock()
+	if impl == nil {
+		return nil, status.Error(codes.FailedPrecondition, "API helper server is not configured")
+	}
+
+	recorder, ok := impl.(InputRecorder)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "policy input recording is not enabled")
+	}
+	if len(req.GetInput()) == 0 {
+		return &proto.RecordPolicyInputResponse{}, nil
+	}
+	err = recorder.RecordPolicyInput(ctx, req.GetPolicyPath(), req.GetLabels(), json.RawMessage(req.GetInput()))
+	if err != nil {
+		return nil, err
+	}
+	return &proto.RecordPolicyInputResponse{}, nil
+}
+
 // GRPCClient implements Runner over go-plugin gRPC.
 type GRPCClient struct {
 	client proto.RunnerClient
 	broker *plugin.GRPCBroker
 }
 
-func (m *GRPCClient) startAPIServer(a ApiHelper) uint32 {
+// startAPIServer serves a for the plugin over the broker, for the length of one call.
+// The returned stop function shuts the server down; it must be called once the call
+// returns, so that long-lived plugins do not accumulate a server per run.
+func (m *GRPCClient) startAPIServer(a ApiHelper) (uint32, func()) {
 	apiHelperServer := &GRPCApiHelperServer{}
 	apiHelperServer.SetImpl(a)
 
+	var (
+		mu      sync.Mutex
+		server  *grpc.Server
+		stopped bool
+	)
 	serverFunc := func(opts []grpc.ServerOption) *grpc.Server {
 		s := grpc.NewServer(opts...)
 		proto.RegisterApiHelperServer(s, apiHelperServer)
+
+		mu.Lock()
+		defer mu.Unlock()
+		server = s
+		if stopped {
+			// The call returned before the broker got here; Serve returns at once.
+			s.Stop()
+		}
 		return s
 	}
 
 	apiServerID := m.broker.NextId()
 	go m.broker.AcceptAndServe(apiServerID, serverFunc)
 
-	return apiServerID
+	stop := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		stopped = true
+		if server != nil {
+			server.Stop()
+		}
+	}
+	return apiServerID, stop
 }
 
 func (m *GRPCClient) Configure(request *proto.ConfigureRequest) (*proto.ConfigureResponse, error) {
-	return m.client.Configure(context.Background(), request)
+	return m.ConfigureWithContext(context.Background(), request)
 }
 
 func (m *GRPCClient) Init(request *proto.InitRequest, a ApiHelper) (*proto.InitResponse, error) {
-	request.ApiServer = m.startAPIServer(a)
-	resp, err := m.client.Init(context.Background(), request)
-	return resp, err
+	return m.InitWithContext(context.Background(), request, a)
 }
 
 func (m *GRPCClient) Eval(request *proto.EvalRequest, a ApiHelper) (*proto.EvalResponse, error) {
-	request.ApiServer = m.startAPIServer(a)
-	resp, err := m.client.Eval(context.Background(), request)
-	return resp, err
+	return m.EvalWithContext(context.Background(), request, a)
+}
+
+func (m *GRPCClient) ConfigureWithContext(ctx context.Context, request *proto.ConfigureRequest) (*proto.ConfigureResponse, error) {
+	return m.client.Configure(ctx, request)
+}
+
+func (m *GRPCClient) InitWithContext(ctx context.Context, request *proto.InitRequest, a ApiHelper) (*proto.InitResponse, error) {
+	apiServerID, stop := m.startAPIServer(a)
+	defer stop()
+
+	request.ApiServer = apiServerID
+	return m.client.Init(ctx, request)
+}
+
+func (m *GRPCClient) EvalWithContext(ctx context.Context, request *proto.EvalRequest, a ApiHelper) (*proto.EvalResponse, error) {
+	apiServerID, stop := m.startAPIServer(a)
+	defer stop()
+
+	request.ApiServer = apiServerID
+	return m.client.Eval(ctx, request)
 }
 
 type GRPCServer struct {
@@ -168,7 +279,7 @@ func (m *GRPCServer) Init(ctx context.Context, req *proto.InitRequest) (*proto.I
 	}
 	defer conn.Close()
 
-	a := &GRPCApiHelperClient{proto.NewApiHelperClient(conn)}
+	a := &GRPCApiHelperClient{client: proto.NewApiHelperClient(conn)}
 	return runnerV2.Init(req, a)
 }
 
@@ -179,7 +290,7 @@ func (m *GRPCServer) Eval(ctx context.Context, req *proto.EvalRequest) (*proto.E
 	}
 	defer conn.Close()
 
-	a := &GRPCApiHelperClient{proto.NewApiHelperClient(conn)}
+	a := &GRPCApiHelperClient{client: proto.NewApiHelperClient(conn)}
 
 	return m.Impl.Eval(req, a)
 }
@@ -2,9 +2,12 @@ package runner
 
 import (
 	"context"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/compliance-framework/agent/runner/proto"
+	"github.com/hashicorp/go-plugin"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -41,4 +44,72 @@ func TestCanonicalRunnerClientSupportsInitLifecycle(t *testing.T) {
 	if _, ok := client.(RunnerV2); !ok {
 		t.Fatal("expected canonical runner client to implement RunnerV2")
 	}
+
+	if _, ok := client.(ContextRunner); !ok {
+		t.Fatal("expected canonical runner client to implement ContextRunner")
+	}
+}
+
+type evidenceRunner struct{}
+
+func (r *evidenceRunner) Configure(request *proto.ConfigureRequest) (*proto.ConfigureResponse, error) {
+	return &proto.ConfigureResponse{}, nil
+}
+
+func (r *evidenceRunner) Eval(request *proto.EvalRequest, a ApiHelper) (*proto.EvalResponse, error) {
+	if err := a.CreateEvidence(context.Background(), []*proto.Evidence{{}}); err != nil {
+		return nil, err
+	}
+	return &proto.EvalResponse{}, nil
+}
+
+type countingApiHelper struct {
+	nopApiHelper
+	evidence int
+}
+
+func (h *countingApiHelper) CreateEvidence(ctx context.Context, evidence []*proto.Evidence) error {
+	h.evidence += len(evidence)
+	return nil
+}
+
+func TestGRPCClientEvalStopsAPIServer(t *testing.T) {
+	client, _ := plugin.TestPluginGRPCConn(t, false, map[string]plugin.Plugin{
+		"runner": &RunnerGRPCPlugin{Impl: &evidenceRunner{}},
+	})
+	defer client.Close()
+
+	raw, err := client.Dispense("runner")
+	if err != nil {
+		t.Fatalf("Dispense() error = %v", err)
+	}
+	contextRunner := raw.(ContextRunner)
+	helper := &countingApiHelper{}
+
+	eval := func() {
+		if _, err := contextRunner.EvalWithContext(context.Background(), &proto.EvalRequest{}, helper); err != nil {
+			t.Fatalf("EvalWithContext() error = %v", err)
+		}
+	}
+
+	// The first run starts the goroutines that live as long as the connection.
+	eval()
+	baseline := runtime.NumGoroutine()
+
+	const runs = 50
+	for i := 0; i < runs; i++ {
+		eval()
+	}
+	if helper.evidence != runs+1 {
+		t.Fatalf("expected %d evidence through the API helper, got %d", runs+1, helper.evidence)
+	}
+
+	// Servers wind down asynchronously once each call has returned.
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > baseline+5 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected goroutines to return to about %d after %d runs, got %d", baseline, runs, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
 }
@@ -0,0 +1,256 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+const policyInputSnapshotTimeFormat = "20060102T150405.000000000Z"
+
+// PolicyInputSnapshot is the input a plugin evaluated a policy bundle with, along with
+// what else the evaluation depends on, so that the bundle can be evaluated again later.
+type PolicyInputSnapshot struct {
+	Plugin     string `json:"plugin"`
+	PolicyPath string `json:"policy_path"`
+	// PolicyDigest is the digest of the bundle at PolicyPath when the input was recorded,
+	// as the bundle at that path may since have been replaced by a newer version.
+	PolicyDigest string                 `json:"policy_digest,omitempty"`
+	RecordedAt   time.Time              `json:"recorded_at"`
+	Labels       map[string]string      `json:"labels,omitempty"`
+	PolicyData   map[string]interface{} `json:"policy_data,omitempty"`
+	Input        json.RawMessage        `json:"input"`
+}
+
+// PolicyInputStore records the policy input snapshots of a plugin run, one JSON file per
+// snapshot, in a directory per run within a directory per plugin. A plugin that evaluates
+// an input per resource records several snapshots each run, so the store keeps the
+// snapshots of the latest runs of each plugin. Run directories are named by the time the
+// store was created, so the oldest runs are pruned first.
+type PolicyInputStore struct {
+	mu               sync.Mutex
+	dir              string
+	maxRunsPerPlugin int
+	startedAt        time.Time
+	runDirs          map[string]string
+	seq              int
+}
+
+// NewPolicyInputStore creates a store for the snapshots of one run, keeping the snapshots
+// of the latest maxRunsPerPlugin runs of each plugin, or of every run when it is 0.
+func NewPolicyInputStore(dir string, maxRunsPerPlugin int) (*PolicyInputStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create policy input directory %q: %w", dir, err)
+	}
+
+	return &PolicyInputStore{
+		dir:              dir,
+		maxRunsPerPlugin: maxRunsPerPlugin,
+		startedAt:        time.Now().UTC(),
+		runDirs:          map[string]string{},
+	}, nil
+}
+
+// Record writes a snapshot and returns the file it was written to.
+func (s *PolicyInputStore) Record(snapshot PolicyInputSnapshot) (string, error) {
+	if snapshot.RecordedAt.IsZero() {
+		snapshot.RecordedAt = time.Now()
+	}
+	snapshot.RecordedAt = snapshot.RecordedAt.UTC()
+	encoded, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir, err := s.runDir(snapshot.Plugin)
+	if err != nil {
+		return "", err
+	}
+
+	s.seq++
+	name := fmt.Sprintf("%s-%06d.json", snapshot.RecordedAt.Format(policyInputSnapshotTimeFormat), s.seq)
+	tmp, err := os.CreateTemp(dir, name+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(append(encoded, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	path := filepath.Join(dir, name)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return path, nil
+}
+
+// runDir returns the directory of this run's snapshots of a plugin, creating it on the
+// first snapshot, which is also when older runs of the plugin are pruned.
+func (s *PolicyInputStore) runDir(plugin string) (string, error) {
+	if dir, ok := s.runDirs[plugin]; ok {
+		return dir, nil
+	}
+
+	pluginDir := filepath.Join(s.dir, dryRunFilenameUnsafe.ReplaceAllString(plugin, "_"))
+	if err := os.MkdirAll(pluginDir, 0o700); err != nil {
+		return "", err
+	}
+	dir, err := os.MkdirTemp(pluginDir, s.startedAt.Format(policyInputSnapshotTimeFormat)+"-")
+	if err != nil {
+		return "", err
+	}
+	s.runDirs[plugin] = dir
+	return dir, s.prune(pluginDir)
+}
+
+func (s *PolicyInputStore) prune(pluginDir string) error {
+	if s.maxRunsPerPlugin <= 0 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(pluginDir)
+	if err != nil {
+		return err
+	}
+	runs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			runs = append(runs, filepath.Join(pluginDir, entry.Name()))
+		}
+	}
+	sort.Strings(runs)
+	for len(runs) > s.maxRunsPerPlugin {
+		if err := os.RemoveAll(runs[0]); err != nil {
+			return err
+		}
+		runs = runs[1:]
+	}
+	return nil
+}
+
+// ReadPolicyInputSnapshots reads the snapshots under a store directory, or a single
+// snapshot file, oldest first within each plugin and run. It returns the file of each snapshot
+// alongside it.
+func ReadPolicyInputSnapshots(path string) ([]string, []PolicyInputSnapshot, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		files = nil
+		err := filepath.WalkDir(path, func(file string, entry os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() && strings.HasSuffix(file, ".json") {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		sort.Strings(files)
+	}
+
+	snapshots := make([]PolicyInputSnapshot, 0, len(files))
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		var snapshot PolicyInputSnapshot
+		if err := json.Unmarshal(content, &snapshot); err != nil {
+			return nil, nil, fmt.Errorf("parse policy input snapshot %s: %w", file, err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return files, snapshots, nil
+}
+
+// InputRecorder records the input a policy bundle is evaluated with. The API helper a
+// plugin is given implements it when the agent records policy inputs; it matches
+// policy_manager.InputRecorder, so plugins can pass the helper to SetInputRecorder.
+type InputRecorder interface {
+	RecordPolicyInput(ctx context.Context, policyPath string, labels map[string]string, input interface{}) error
+}
+
+// BundleDigestFunc returns the digest of the policy bundle at policyPath, which is
+// recorded with each input so that replays can tell whether the bundle changed.
+type BundleDigestFunc func(policyPath string) (string, error)
+
+// recordingApiHelper is an ApiHelper that also records the policy inputs a plugin sends
+// to a PolicyInputStore.
+type recordingApiHelper struct {
+	ApiHelper
+	logger     hclog.Logger
+	store      *PolicyInputStore
+	pluginName string
+	policyData map[string]interface{}
+	digest     BundleDigestFunc
+}
+
+// NewRecordingApiHelper wraps helper so that the policy inputs pluginName sends are
+// recorded with the policy data it was configured with. digest, when set, records the
+// digest of each policy bundle alongside its inputs.
+func NewRecordingApiHelper(logger hclog.Logger, helper ApiHelper, store *PolicyInputStore, pluginName string, policyData map[string]interface{}, digest BundleDigestFunc) ApiHelper {
+	return &recordingApiHelper{
+		ApiHelper:  helper,
+		logger:     logger.Named("input-recording"),
+		store:      store,
+		pluginName: pluginName,
+		policyData: policyData,
+		digest:     digest,
+	}
+}
+
+func (h *recordingApiHelper) RecordPolicyInput(ctx context.Context, policyPath string, labels map[string]string, input interface{}) error {
+	encoded, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+
+	var digest string
+	if h.digest != nil {
+		digest, err = h.digest(policyPath)
+		if err != nil {
+			// The input is still worth keeping, to replay against an explicit baseline.
+			h.logger.Warn("Error computing the policy bundle digest", "policy_path", policyPath, "error", err)
+		}
+	}
+
+	path, err := h.store.Record(PolicyInputSnapshot{
+		Plugin:       h.pluginName,
+		PolicyPath:   policyPath,
+		PolicyDigest: digest,
+		Labels:       labels,
+		PolicyData:   h.policyData,
+		Input:        encoded,
+	})
+	if err != nil {
+		h.logger.Error("Error recording policy input", "policy_path", policyPath, "error", err)
+		return err
+	}
+	h.logger.Debug("Recorded policy input", "policy_path", policyPath, "path", path)
+	return nil
+}
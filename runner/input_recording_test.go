@@ -0,0 +1,161 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/compliance-framework/agent/runner/proto"
+	"github.com/hashicorp/go-hclog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type nopApiHelper struct{}
+
+func (nopApiHelper) CreateEvidence(context.Context, []*proto.Evidence) error { return nil }
+func (nopApiHelper) UpsertRiskTemplates(context.Context, string, []*proto.RiskTemplate) error {
+	return nil
+}
+func (nopApiHelper) UpsertSubjectTemplates(context.Context, []*proto.SubjectTemplate) error {
+	return nil
+}
+
+func compactJSON(t *testing.T, raw json.RawMessage) string {
+	t.Helper()
+
+	var out bytes.Buffer
+	if err := json.Compact(&out, raw); err != nil {
+		t.Fatalf("json.Compact() error = %v", err)
+	}
+	return out.String()
+}
+
+func TestPolicyInputStore_KeepsTheLatestRunsOfEachPlugin(t *testing.T) {
+	dir := t.TempDir()
+	recordedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for run := 1; run <= 3; run++ {
+		store, err := NewPolicyInputStore(dir, 2)
+		if err != nil {
+			t.Fatalf("NewPolicyInputStore() error = %v", err)
+		}
+		// Each run records an input per host, which are all kept.
+		for _, host := range []string{"web-1", "web-2", "web-3"} {
+			if _, err := store.Record(PolicyInputSnapshot{
+				Plugin:     "local/ssh",
+				PolicyPath: "/policies/ssh",
+				RecordedAt: recordedAt.Add(time.Duration(run) * time.Minute),
+				Labels:     map[string]string{"host": host},
+				Input:      []byte(fmt.Sprintf(`{"run":%d}`, run)),
+			}); err != nil {
+				t.Fatalf("Record() error = %v", err)
+			}
+		}
+		if run == 1 {
+			if _, err := store.Record(PolicyInputSnapshot{Plugin: "other", Input: []byte(`{}`)}); err != nil {
+				t.Fatalf("Record() error = %v", err)
+			}
+		}
+	}
+
+	files, snapshots, err := ReadPolicyInputSnapshots(filepath.Join(dir, "local_ssh"))
+	if err != nil {
+		t.Fatalf("ReadPolicyInputSnapshots() error = %v", err)
+	}
+	if len(snapshots) != 6 || compactJSON(t, snapshots[0].Input) != `{"run":2}` || compactJSON(t, snapshots[5].Input) != `{"run":3}` {
+		t.Fatalf("expected the snapshots of the two latest runs, oldest first, got %v", files)
+	}
+	if snapshots[0].Plugin != "local/ssh" || snapshots[0].PolicyPath != "/policies/ssh" || !snapshots[0].RecordedAt.Equal(recordedAt.Add(2*time.Minute)) {
+		t.Fatalf("unexpected snapshot %+v", snapshots[0])
+	}
+
+	if _, snapshots, err := ReadPolicyInputSnapshots(dir); err != nil || len(snapshots) != 7 {
+		t.Fatalf("ReadPolicyInputSnapshots() = %d snapshots, %v, expected the snapshots of every plugin", len(snapshots), err)
+	}
+}
+
+func TestGRPCApiHelperServer_RecordPolicyInput(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewPolicyInputStore(dir, 0)
+	if err != nil {
+		t.Fatalf("NewPolicyInputStore() error = %v", err)
+	}
+	policyData := map[string]interface{}{"weak_ciphers": []interface{}{"3des-cbc"}}
+	server := &GRPCApiHelperServer{}
+	server.SetImpl(NewRecordingApiHelper(hclog.NewNullLogger(), nopApiHelper{}, store, "ssh", policyData, nil))
+
+	_, err = server.RecordPolicyInput(context.Background(), &proto.RecordPolicyInputRequest{
+		PolicyPath: "/policies/ssh",
+		Labels:     map[string]string{"host": "web-1"},
+		Input:      []byte(`{"ciphers":["3des-cbc"]}`),
+	})
+	if err != nil {
+		t.Fatalf("RecordPolicyInput() error = %v", err)
+	}
+
+	_, snapshots, err := ReadPolicyInputSnapshots(dir)
+	if err != nil || len(snapshots) != 1 {
+		t.Fatalf("ReadPolicyInputSnapshots() = %v, %v, expected one snapshot", snapshots, err)
+	}
+	snapshot := snapshots[0]
+	if snapshot.Plugin != "ssh" || snapshot.Labels["host"] != "web-1" || compactJSON(t, snapshot.Input) != `{"ciphers":["3des-cbc"]}` || snapshot.PolicyData["weak_ciphers"] == nil {
+		t.Fatalf("unexpected snapshot %+v", snapshot)
+	}
+
+	// A request without an input is not recorded.
+	if _, err := server.RecordPolicyInput(context.Background(), &proto.RecordPolicyInputRequest{}); err != nil {
+		t.Fatalf("RecordPolicyInput() error = %v, expected the agent to record inputs", err)
+	}
+	if _, snapshots, _ := ReadPolicyInputSnapshots(dir); len(snapshots) != 1 {
+		t.Fatalf("expected only the input to be recorded, got %d snapshots", len(snapshots))
+	}
+
+	// Helpers that do not record inputs tell the plugin to stop sending them.
+	server.SetImpl(nopApiHelper{})
+	if _, err := server.RecordPolicyInput(context.Background(), &proto.RecordPolicyInputRequest{Input: []byte(`{}`)}); status.Code(err) != codes.Unimplemented {
+		t.Fatalf("RecordPolicyInput() error = %v, expected Unimplemented", err)
+	}
+}
+
+type recordingApiHelperClient struct {
+	proto.ApiHelperClient
+	recording bool
+	requests  []*proto.RecordPolicyInputRequest
+}
+
+func (c *recordingApiHelperClient) RecordPolicyInput(ctx context.Context, in *proto.RecordPolicyInputRequest, opts ...grpc.CallOption) (*proto.RecordPolicyInputResponse, error) {
+	c.requests = append(c.requests, in)
+	if !c.recording {
+		return nil, status.Error(codes.Unimplemented, "unknown method RecordPolicyInput")
+	}
+	return &proto.RecordPolicyInputResponse{}, nil
+}
+
+func TestGRPCApiHelperClient_RecordPolicyInputOnlySendsInputsAgentsRecord(t *testing.T) {
+	agent := &recordingApiHelperClient{}
+	client := &GRPCApiHelperClient{client: agent}
+	for i := 0; i < 2; i++ {
+		if err := client.RecordPolicyInput(context.Background(), "/policies/ssh", nil, map[string]interface{}{}); err != nil {
+			t.Fatalf("RecordPolicyInput() error = %v, expected agents without recording to be ignored", err)
+		}
+	}
+	if len(agent.requests) != 1 || agent.requests[0].GetInput() != nil {
+		t.Fatalf("expected only one request asking whether inputs are recorded, got %v", agent.requests)
+	}
+
+	agent = &recordingApiHelperClient{recording: true}
+	client = &GRPCApiHelperClient{client: agent}
+	for i := 0; i < 2; i++ {
+		if err := client.RecordPolicyInput(context.Background(), "/policies/ssh", nil, map[string]interface{}{"run": i}); err != nil {
+			t.Fatalf("RecordPolicyInput() error = %v", err)
+		}
+	}
+	if len(agent.requests) != 3 || compactJSON(t, agent.requests[2].GetInput()) != `{"run":1}` {
+		t.Fatalf("expected a request asking whether inputs are recorded, then each input, got %v", agent.requests)
+	}
+}
@@ -0,0 +1,389 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/compliance-framework/api/sdk"
+	"github.com/compliance-framework/api/sdk/types"
+	"github.com/hashicorp/go-hclog"
+)
+
+const outboxEntrySuffix = ".json"
+
+var apiStatusCodePattern = regexp.MustCompile(`status code: (\d{3})`)
+
+// outboxEntry is a single queued evidence batch as persisted on disk. Evidence is stored
+// after agent labels have been merged, so replaying an entry posts exactly the payload
+// that would have been sent when the batch was produced.
+type outboxEntry struct {
+	Plugin    string           `json:"plugin"`
+	CreatedAt time.Time        `json:"created_at"`
+	Evidence  []types.Evidence `json:"evidence"`
+}
+
+// OutboxStats describes the evidence currently waiting in an EvidenceOutbox.
+type OutboxStats struct {
+	Batches  int
+	Evidence int
+	Bytes    int64
+	Dropped  int
+}
+
+// EvidenceOutbox is a durable, size-bounded queue of evidence batches that could not be
+// delivered to the API. Batches are stored one per file and replayed in the order they
+// were queued. When the queue grows beyond its size limit the oldest batches are dropped.
+//
+// Each file is named after a sequence number that carries on from the files already
+// queued, so that files sort in the order they were queued whatever the clock does, and
+// the number of evidence items in the batch, so that the queue is measured without
+// reading it.
+type EvidenceOutbox struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+	logger   hclog.Logger
+	seq      uint64
+	dropped  int
+}
+
+func NewEvidenceOutbox(logger hclog.Logger, dir string, maxBytes int64) (*EvidenceOutbox, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create evidence outbox directory %q: %w", dir, err)
+	}
+
+	outbox := &EvidenceOutbox{
+		dir:      dir,
+		maxBytes: maxBytes,
+		logger:   logger.Named("outbox"),
+	}
+	files, err := outbox.files()
+	if err != nil {
+		return nil, fmt.Errorf("read evidence outbox directory %q: %w", dir, err)
+	}
+	for _, file := range files {
+		outbox.seq = max(outbox.seq, file.seq)
+	}
+	return outbox, nil
+}
+
+// Deliver posts evidence to the API, queueing whatever could not be delivered because the
+// API is unreachable, and returns the number of evidence items queued. Queued batches are
+// replayed first so that evidence reaches the API in the order it was produced. Evidence
+// rejected by the API is returned as an error.
+func (o *EvidenceOutbox) Deliver(ctx context.Context, client *sdk.Client, pluginName string, evidence []types.Evidence) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if _, err := o.replayLocked(ctx, client); err != nil {
+		o.logger.Warn("Evidence outbox could not be drained, queueing new evidence behind it", "plugin", pluginName, "error", err)
+		return len(evidence), o.enqueueLocked(pluginName, evidence)
+	}
+
+	for i, evid := range evidence {
+		err := client.Evidence.Create(ctx, evid)
+		if err == nil {
+			continue
+		}
+		if !IsRetryableAPIError(err) {
+			return 0, err
+		}
+
+		o.logger.Warn("API unavailable, queueing evidence in outbox", "plugin", pluginName, "queued", len(evidence)-i, "error", err)
+		return len(evidence) - i, o.enqueueLocked(pluginName, evidence[i:])
+	}
+
+	return 0, nil
+}
+
+// Enqueue persists an evidence batch to the outbox without attempting delivery.
+func (o *EvidenceOutbox) Enqueue(pluginName string, evidence []types.Evidence) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.enqueueLocked(pluginName, evidence)
+}
+
+// Replay posts queued batches to the API in order, stopping at the first batch that
+// cannot be delivered. It returns the number of evidence items delivered.
+func (o *EvidenceOutbox) Replay(ctx context.Context, client *sdk.Client) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.replayLocked(ctx, client)
+}
+
+// Stats measures the queue from the names and sizes of its files, without reading them.
+func (o *EvidenceOutbox) Stats() (OutboxStats, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	stats := OutboxStats{Dropped: o.dropped}
+	files, err := o.files()
+	if err != nil {
+		return stats, err
+	}
+
+	for _, file := range files {
+		stats.Batches++
+		stats.Evidence += file.evidence
+		stats.Bytes += file.size
+	}
+
+	return stats, nil
+}
+
+func (o *EvidenceOutbox) enqueueLocked(pluginName string, evidence []types.Evidence) error {
+	if len(evidence) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(outboxEntry{
+		Plugin:    pluginName,
+		CreatedAt: time.Now().UTC(),
+		Evidence:  evidence,
+	})
+	if err != nil {
+		return err
+	}
+
+	if o.maxBytes > 0 && int64(len(payload)) > o.maxBytes {
+		o.dropped += len(evidence)
+		return fmt.Errorf("evidence batch of %d bytes exceeds outbox limit of %d bytes", len(payload), o.maxBytes)
+	}
+
+	o.seq++
+	if err := o.writeEntry(outboxEntryName(o.seq, len(evidence)), payload); err != nil {
+		return err
+	}
+
+	return o.enforceLimitLocked()
+}
+
+func (o *EvidenceOutbox) replayLocked(ctx context.Context, client *sdk.Client) (int, error) {
+	files, err := o.files()
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, file := range files {
+		path := filepath.Join(o.dir, file.name)
+		entry, err := o.readEntry(path)
+		if err != nil {
+			o.logger.Error("Discarding unreadable outbox entry", "path", path, "error", err)
+			if removeErr := os.Remove(path); removeErr != nil && !os.IsNotExist(removeErr) {
+				return delivered, removeErr
+			}
+			continue
+		}
+
+		for i := 0; i < len(entry.Evidence); i++ {
+			err := client.Evidence.Create(ctx, entry.Evidence[i])
+			if err == nil {
+				delivered++
+				continue
+			}
+
+			if IsRetryableAPIError(err) {
+				entry.Evidence = entry.Evidence[i:]
+				if rewriteErr := o.rewriteEntry(file, entry); rewriteErr != nil {
+					return delivered, errors.Join(err, rewriteErr)
+				}
+				return delivered, err
+			}
+
+			o.logger.Error("API rejected queued evidence, dropping it from the outbox", "plugin", entry.Plugin, "uuid", entry.Evidence[i].UUID.String(), "error", err)
+			o.dropped++
+		}
+
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return delivered, err
+		}
+	}
+
+	if delivered > 0 {
+		o.logger.Info("Replayed queued evidence from outbox", "delivered", delivered)
+	}
+	return delivered, nil
+}
+
+func (o *EvidenceOutbox) enforceLimitLocked() error {
+	if o.maxBytes <= 0 {
+		return nil
+	}
+
+	files, err := o.files()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, file := range files {
+		total += file.size
+	}
+
+	for i := 0; total > o.maxBytes && i < len(files); i++ {
+		path := filepath.Join(o.dir, files[i].name)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		o.dropped += files[i].evidence
+		total -= files[i].size
+		o.logger.Warn("Evidence outbox is full, dropped oldest queued batch", "path", path, "max_bytes", o.maxBytes)
+	}
+
+	return nil
+}
+
+// outboxFile is a queued batch as listed in the outbox directory.
+type outboxFile struct {
+	name     string
+	seq      uint64
+	evidence int
+	size     int64
+}
+
+// outboxEntryName names the file of the seq'th batch queued, holding evidence items.
+func outboxEntryName(seq uint64, evidence int) string {
+	return fmt.Sprintf("%020d-%d%s", seq, evidence, outboxEntrySuffix)
+}
+
+// files lists the queued batches in the order they were queued. Files that are not named
+// like queued batches are left alone.
+func (o *EvidenceOutbox) files() ([]outboxFile, error) {
+	entries, err := os.ReadDir(o.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	files := make([]outboxFile, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		seqPart, evidencePart, ok := strings.Cut(strings.TrimSuffix(entry.Name(), outboxEntrySuffix), "-")
+		if !ok || !strings.HasSuffix(entry.Name(), outboxEntrySuffix) {
+			continue
+		}
+		seq, seqErr := strconv.ParseUint(seqPart, 10, 64)
+		evidence, evidenceErr := strconv.Atoi(evidencePart)
+		if seqErr != nil || evidenceErr != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		files = append(files, outboxFile{name: entry.Name(), seq: seq, evidence: evidence, size: info.Size()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].seq < files[j].seq })
+	return files, nil
+}
+
+func (o *EvidenceOutbox) readEntry(path string) (*outboxEntry, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &outboxEntry{}
+	if err := json.Unmarshal(content, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// rewriteEntry replaces a partly delivered batch with what is left of it, under the same
+// sequence number so that it keeps its place in the queue.
+func (o *EvidenceOutbox) rewriteEntry(file outboxFile, entry *outboxEntry) error {
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	name := outboxEntryName(file.seq, len(entry.Evidence))
+	if err := o.writeEntry(name, payload); err != nil {
+		return err
+	}
+	if name == file.name {
+		return nil
+	}
+	if err := os.Remove(filepath.Join(o.dir, file.name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// writeEntry writes through a temporary file and renames it into place so that a crash
+// never leaves a partially written batch behind.
+func (o *EvidenceOutbox) writeEntry(name string, payload []byte) error {
+	if err := os.MkdirAll(o.dir, 0o700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(o.dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := tmp.Write(payload); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, filepath.Join(o.dir, name)); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// IsRetryableAPIError reports whether an API error is likely transient, such as a network
+// failure, a timeout or a server-side error. Authentication failures are retryable too, as
+// they are fixed by correcting or rotating the agent's credentials rather than the
+// evidence. Other requests the API rejected are not retryable, since sending them again
+// would fail the same way. The SDK only reports the status code in its error messages.
+func IsRetryableAPIError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	match := apiStatusCodePattern.FindStringSubmatch(err.Error())
+	if match == nil {
+		return true
+	}
+
+	code, convErr := strconv.Atoi(match[1])
+	if convErr != nil {
+		return true
+	}
+
+	return code >= 500 || code == 401 || code == 403 || code == 408 || code == 429
+}
@@ -0,0 +1,273 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/compliance-framework/api/sdk"
+	"github.com/compliance-framework/api/sdk/types"
+	"github.com/google/uuid"
+	"github.com/hashicorp/go-hclog"
+)
+
+type outboxTestAPI struct {
+	mu       sync.Mutex
+	status   int
+	received []string
+}
+
+func (a *outboxTestAPI) setStatus(status int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.status = status
+}
+
+func (a *outboxTestAPI) titles() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string(nil), a.received...)
+}
+
+func (a *outboxTestAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.status != http.StatusCreated {
+		w.WriteHeader(a.status)
+		return
+	}
+
+	evidence := types.Evidence{}
+	if err := json.NewDecoder(r.Body).Decode(&evidence); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	a.received = append(a.received, evidence.Title)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func newOutboxTestClient(t *testing.T, api *outboxTestAPI) *sdk.Client {
+	t.Helper()
+
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	return sdk.NewClient(server.Client(), &sdk.Config{BaseURL: server.URL})
+}
+
+func outboxTestEvidence(titles ...string) []types.Evidence {
+	evidence := make([]types.Evidence, 0, len(titles))
+	for _, title := range titles {
+		evidence = append(evidence, types.Evidence{
+			UUID:  uuid.New(),
+			Title: title,
+			Start: time.Now().UTC(),
+			End:   time.Now().UTC(),
+		})
+	}
+	return evidence
+}
+
+func TestEvidenceOutboxQueuesWhileAPIUnavailableAndReplaysInOrder(t *testing.T) {
+	api := &outboxTestAPI{status: http.StatusServiceUnavailable}
+	client := newOutboxTestClient(t, api)
+	outbox, err := NewEvidenceOutbox(hclog.NewNullLogger(), t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("NewEvidenceOutbox() error = %v", err)
+	}
+
+	ctx := context.Background()
+	if _, err := outbox.Deliver(ctx, client, "plugin-a", outboxTestEvidence("first", "second")); err != nil {
+		t.Fatalf("Deliver() error = %v, expected evidence to be queued", err)
+	}
+	if _, err := outbox.Deliver(ctx, client, "plugin-b", outboxTestEvidence("third")); err != nil {
+		t.Fatalf("Deliver() error = %v, expected evidence to be queued", err)
+	}
+
+	stats, err := outbox.Stats()
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	if stats.Batches != 2 || stats.Evidence != 3 {
+		t.Fatalf("expected 2 batches with 3 evidence queued, got %+v", stats)
+	}
+
+	api.setStatus(http.StatusCreated)
+	if _, err := outbox.Deliver(ctx, client, "plugin-a", outboxTestEvidence("fourth")); err != nil {
+		t.Fatalf("Deliver() error = %v", err)
+	}
+
+	expected := []string{"first", "second", "third", "fourth"}
+	got := api.titles()
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Fatalf("expected evidence delivered in order %v, got %v", expected, got)
+	}
+
+	stats, err = outbox.Stats()
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	if stats.Batches != 0 || stats.Evidence != 0 {
+		t.Fatalf("expected outbox to be drained, got %+v", stats)
+	}
+}
+
+func TestEvidenceOutboxReturnsRejectedEvidenceErrors(t *testing.T) {
+	api := &outboxTestAPI{status: http.StatusBadRequest}
+	client := newOutboxTestClient(t, api)
+	outbox, err := NewEvidenceOutbox(hclog.NewNullLogger(), t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("NewEvidenceOutbox() error = %v", err)
+	}
+
+	if _, err := outbox.Deliver(context.Background(), client, "plugin-a", outboxTestEvidence("bad")); err == nil {
+		t.Fatal("expected rejected evidence to return an error")
+	}
+
+	stats, err := outbox.Stats()
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	if stats.Batches != 0 {
+		t.Fatalf("expected rejected evidence not to be queued, got %+v", stats)
+	}
+}
+
+func TestEvidenceOutboxSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	outbox, err := NewEvidenceOutbox(hclog.NewNullLogger(), dir, 0)
+	if err != nil {
+		t.Fatalf("NewEvidenceOutbox() error = %v", err)
+	}
+	if err := outbox.Enqueue("plugin-a", outboxTestEvidence("persisted")); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	reopened, err := NewEvidenceOutbox(hclog.NewNullLogger(), dir, 0)
+	if err != nil {
+		t.Fatalf("NewEvidenceOutbox() error = %v", err)
+	}
+	// Batches queued after the restart are replayed after those queued before it.
+	if err := reopened.Enqueue("plugin-a", outboxTestEvidence("after-restart")); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	api := &outboxTestAPI{status: http.StatusCreated}
+	delivered, err := reopened.Replay(context.Background(), newOutboxTestClient(t, api))
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if delivered != 2 {
+		t.Fatalf("expected 2 replayed evidence, got %d", delivered)
+	}
+	if got := api.titles(); len(got) != 2 || got[0] != "persisted" || got[1] != "after-restart" {
+		t.Fatalf("expected persisted evidence to be replayed first, got %v", got)
+	}
+}
+
+func TestEvidenceOutboxStatsDoNotReadBatches(t *testing.T) {
+	dir := t.TempDir()
+	outbox, err := NewEvidenceOutbox(hclog.NewNullLogger(), dir, 0)
+	if err != nil {
+		t.Fatalf("NewEvidenceOutbox() error = %v", err)
+	}
+	if err := outbox.Enqueue("plugin-a", outboxTestEvidence("first", "second")); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	// Stats come from the file's name and size, so a batch that cannot be read is still
+	// counted.
+	name := outboxEntryName(1, 2)
+	if err := os.WriteFile(filepath.Join(dir, name), []byte("not json"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	stats, err := outbox.Stats()
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	if stats.Batches != 1 || stats.Evidence != 2 || stats.Bytes != int64(len("not json")) {
+		t.Fatalf("expected stats from the queued file, got %+v", stats)
+	}
+}
+
+func TestEvidenceOutboxDropsOldestBatchesWhenFull(t *testing.T) {
+	dir := t.TempDir()
+	probe, err := NewEvidenceOutbox(hclog.NewNullLogger(), t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("NewEvidenceOutbox() error = %v", err)
+	}
+	if err := probe.Enqueue("plugin-a", outboxTestEvidence("probe")); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	probeStats, err := probe.Stats()
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+
+	outbox, err := NewEvidenceOutbox(hclog.NewNullLogger(), dir, probeStats.Bytes*2+probeStats.Bytes/2)
+	if err != nil {
+		t.Fatalf("NewEvidenceOutbox() error = %v", err)
+	}
+	for _, title := range []string{"old", "mid", "new"} {
+		if err := outbox.Enqueue("plugin-a", outboxTestEvidence(title)); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+
+	stats, err := outbox.Stats()
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	if stats.Batches != 2 || stats.Dropped != 1 {
+		t.Fatalf("expected oldest batch to be dropped, got %+v", stats)
+	}
+
+	api := &outboxTestAPI{status: http.StatusCreated}
+	if _, err := outbox.Replay(context.Background(), newOutboxTestClient(t, api)); err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if got := api.titles(); fmt.Sprint(got) != fmt.Sprint([]string{"mid", "new"}) {
+		t.Fatalf("expected newest batches to remain, got %v", got)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected outbox directory to be empty after replay, got %d entries", len(entries))
+	}
+}
+
+func TestIsRetryableAPIError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "nil", err: nil, expected: false},
+		{name: "network error", err: errors.New("dial tcp: connection refused"), expected: true},
+		{name: "server error", err: errors.New("unexpected api response status code: 503"), expected: true},
+		{name: "rate limited", err: errors.New("unexpected api response status code: 429"), expected: true},
+		{name: "unauthorized", err: errors.New("unexpected api response status code: 401"), expected: true},
+		{name: "forbidden", err: errors.New("unexpected api response status code: 403"), expected: true},
+		{name: "auth failed", err: errors.New("agent auth failed with status code: 401"), expected: true},
+		{name: "bad request", err: errors.New("unexpected api response status code: 400"), expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryableAPIError(tt.err); got != tt.expected {
+				t.Fatalf("IsRetryableAPIError(%v) = %t, expected %t", tt.err, got, tt.expected)
+			}
+		})
+	}
+}
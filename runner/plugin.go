@@ -18,6 +18,15 @@ type RunnerV2 interface {
 	Init(request *proto.InitRequest, a ApiHelper) (*proto.InitResponse, error)
 }
 
+// ContextRunner is implemented by runner clients that accept a context on each call, so
+// that the agent can bound or cancel calls into a plugin process. Plugins themselves keep
+// implementing Runner or RunnerV2.
+type ContextRunner interface {
+	ConfigureWithContext(ctx context.Context, request *proto.ConfigureRequest) (*proto.ConfigureResponse, error)
+	InitWithContext(ctx context.Context, request *proto.InitRequest, a ApiHelper) (*proto.InitResponse, error)
+	EvalWithContext(ctx context.Context, request *proto.EvalRequest, a ApiHelper) (*proto.EvalResponse, error)
+}
+
 type RunnerGRPCPlugin struct {
 	plugin.Plugin
 
@@ -161,6 +161,66 @@ func (x *UpsertSubjectTemplatesRequest) GetSubjectTemplates() []*SubjectTemplate
 	return nil
 }
 
+type RecordPolicyInputRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	PolicyPath    string                 `protobuf:"bytes,1,opt,name=PolicyPath,proto3" json:"PolicyPath,omitempty"`
+	Labels        map[string]string      `protobuf:"bytes,2,rep,name=Labels,proto3" json:"Labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Input         []byte                 `protobuf:"bytes,3,opt,name=Input,proto3" json:"Input,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RecordPolicyInputRequest) Reset() {
+	*x = RecordPolicyInputRequest{}
+	mi := &file_runner_proto_results_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RecordPolicyInputRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RecordPolicyInputRequest) ProtoMessage() {}
+
+func (x *RecordPolicyInputRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_runner_proto_results_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RecordPolicyInputRequest.ProtoReflect.Descriptor instead.
+func (*RecordPolicyInputRequest) Descriptor() ([]byte, []int) {
+	return file_runner_proto_results_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *RecordPolicyInputRequest) GetPolicyPath() string {
+	if x != nil {
+		return x.PolicyPath
+	}
+	return ""
+}
+
+func (x *RecordPolicyInputRequest) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *RecordPolicyInputRequest) GetInput() []byte {
+	if x != nil {
+		return x.Input
+	}
+	return nil
+}
+
 type CreateEvidenceResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	unknownFields protoimpl.UnknownFields
@@ -169,7 +229,7 @@ type CreateEvidenceResponse struct {
 
 func (x *CreateEvidenceResponse) Reset() {
 	*x = CreateEvidenceResponse{}
-	mi := &file_runner_proto_results_proto_msgTypes[3]
+	mi := &file_runner_proto_results_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -181,7 +241,7 @@ func (x *CreateEvidenceResponse) String() string {
 func (*CreateEvidenceResponse) ProtoMessage() {}
 
 func (x *CreateEvidenceResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_runner_proto_results_proto_msgTypes[3]
+	mi := &file_runner_proto_results_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -194,7 +254,7 @@ func (x *CreateEvidenceResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use CreateEvidenceResponse.ProtoReflect.Descriptor instead.
 func (*CreateEvidenceResponse) Descriptor() ([]byte, []int) {
-	return file_runner_proto_results_proto_rawDescGZIP(), []int{3}
+	return file_runner_proto_results_proto_rawDescGZIP(), []int{4}
 }
 
 type UpsertRiskTemplatesResponse struct {
@@ -205,7 +265,7 @@ type UpsertRiskTemplatesResponse struct {
 
 func (x *UpsertRiskTemplatesResponse) Reset() {
 	*x = UpsertRiskTemplatesResponse{}
-	mi := &file_runner_proto_results_proto_msgTypes[4]
+	mi := &file_runner_proto_results_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -217,7 +277,7 @@ func (x *UpsertRiskTemplatesResponse) String() string {
 func (*UpsertRiskTemplatesResponse) ProtoMessage() {}
 
 func (x *UpsertRiskTemplatesResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_runner_proto_results_proto_msgTypes[4]
+	mi := &file_runner_proto_results_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -230,7 +290,7 @@ func (x *UpsertRiskTemplatesResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use UpsertRiskTemplatesResponse.ProtoReflect.Descriptor instead.
 func (*UpsertRiskTemplatesResponse) Descriptor() ([]byte, []int) {
-	return file_runner_proto_results_proto_rawDescGZIP(), []int{4}
+	return file_runner_proto_results_proto_rawDescGZIP(), []int{5}
 }
 
 type UpsertSubjectTemplatesResponse struct {
@@ -241,7 +301,7 @@ type UpsertSubjectTemplatesResponse struct {
 
 func (x *UpsertSubjectTemplatesResponse) Reset() {
 	*x = UpsertSubjectTemplatesResponse{}
-	mi := &file_runner_proto_results_proto_msgTypes[5]
+	mi := &file_runner_proto_results_proto_msgTypes[6]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -253,7 +313,7 @@ func (x *UpsertSubjectTemplatesResponse) String() string {
 func (*UpsertSubjectTemplatesResponse) ProtoMessage() {}
 
 func (x *UpsertSubjectTemplatesResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_runner_proto_results_proto_msgTypes[5]
+	mi := &file_runner_proto_results_proto_msgTypes[6]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -266,7 +326,43 @@ func (x *UpsertSubjectTemplatesResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use UpsertSubjectTemplatesResponse.ProtoReflect.Descriptor instead.
 func (*UpsertSubjectTemplatesResponse) Descriptor() ([]byte, []int) {
-	return file_runner_proto_results_proto_rawDescGZIP(), []int{5}
+	return file_runner_proto_results_proto_rawDescGZIP(), []int{6}
+}
+
+type RecordPolicyInputResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RecordPolicyInputResponse) Reset() {
+	*x = RecordPolicyInputResponse{}
+	mi := &file_runner_proto_results_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RecordPolicyInputResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RecordPolicyInputResponse) ProtoMessage() {}
+
+func (x *RecordPolicyInputResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_runner_proto_results_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RecordPolicyInputResponse.ProtoReflect.Descriptor instead.
+func (*RecordPolicyInputResponse) Descriptor() ([]byte, []int) {
+	return file_runner_proto_results_proto_rawDescGZIP(), []int{7}
 }
 
 var File_runner_proto_results_proto protoreflect.FileDescriptor
@@ -280,14 +376,25 @@ const file_runner_proto_results_proto_rawDesc = "" +
 	"\vPackageName\x18\x01 \x01(\tR\vPackageName\x129\n" +
 	"\rRiskTemplates\x18\x02 \x03(\v2\x13.proto.RiskTemplateR\rRiskTemplates\"c\n" +
 	"\x1dUpsertSubjectTemplatesRequest\x12B\n" +
-	"\x10SubjectTemplates\x18\x01 \x03(\v2\x16.proto.SubjectTemplateR\x10SubjectTemplates\"\x18\n" +
+	"\x10SubjectTemplates\x18\x01 \x03(\v2\x16.proto.SubjectTemplateR\x10SubjectTemplates\"\xd0\x01\n" +
+	"\x18RecordPolicyInputRequest\x12\x1e\n" +
+	"\n" +
+	"PolicyPath\x18\x01 \x01(\tR\n" +
+	"PolicyPath\x12C\n" +
+	"\x06Labels\x18\x02 \x03(\v2+.proto.RecordPolicyInputRequest.LabelsEntryR\x06Labels\x12\x14\n" +
+	"\x05Input\x18\x03 \x01(\fR\x05Input\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\x18\n" +
 	"\x16CreateEvidenceResponse\"\x1d\n" +
 	"\x1bUpsertRiskTemplatesResponse\" \n" +
-	"\x1eUpsertSubjectTemplatesResponse2\x9f\x02\n" +
+	"\x1eUpsertSubjectTemplatesResponse\"\x1b\n" +
+	"\x19RecordPolicyInputResponse2\xf7\x02\n" +
 	"\tApiHelper\x12M\n" +
 	"\x0eCreateEvidence\x12\x1c.proto.CreateEvidenceRequest\x1a\x1d.proto.CreateEvidenceResponse\x12\\\n" +
 	"\x13UpsertRiskTemplates\x12!.proto.UpsertRiskTemplatesRequest\x1a\".proto.UpsertRiskTemplatesResponse\x12e\n" +
-	"\x16UpsertSubjectTemplates\x12$.proto.UpsertSubjectTemplatesRequest\x1a%.proto.UpsertSubjectTemplatesResponseB\tZ\a./protob\x06proto3"
+	"\x16UpsertSubjectTemplates\x12$.proto.UpsertSubjectTemplatesRequest\x1a%.proto.UpsertSubjectTemplatesResponse\x12V\n" +
+	"\x11RecordPolicyInput\x12\x1f.proto.RecordPolicyInputRequest\x1a .proto.RecordPolicyInputResponseB\tZ\a./protob\x06proto3"
 
 var (
 	file_runner_proto_results_proto_rawDescOnce sync.Once
@@ -301,33 +408,39 @@ func file_runner_proto_results_proto_rawDescGZIP() []byte {
 	return file_runner_proto_results_proto_rawDescData
 }
 
-var file_runner_proto_results_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_runner_proto_results_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
 var file_runner_proto_results_proto_goTypes = []any{
 	(*CreateEvidenceRequest)(nil),          // 0: proto.CreateEvidenceRequest
 	(*UpsertRiskTemplatesRequest)(nil),     // 1: proto.UpsertRiskTemplatesRequest
 	(*UpsertSubjectTemplatesRequest)(nil),  // 2: proto.UpsertSubjectTemplatesRequest
-	(*CreateEvidenceResponse)(nil),         // 3: proto.CreateEvidenceResponse
-	(*UpsertRiskTemplatesResponse)(nil),    // 4: proto.UpsertRiskTemplatesResponse
-	(*UpsertSubjectTemplatesResponse)(nil), // 5: proto.UpsertSubjectTemplatesResponse
-	(*Evidence)(nil),                       // 6: proto.Evidence
-	(*RiskTemplate)(nil),                   // 7: proto.RiskTemplate
-	(*SubjectTemplate)(nil),                // 8: proto.SubjectTemplate
+	(*RecordPolicyInputRequest)(nil),       // 3: proto.RecordPolicyInputRequest
+	(*CreateEvidenceResponse)(nil),         // 4: proto.CreateEvidenceResponse
+	(*UpsertRiskTemplatesResponse)(nil),    // 5: proto.UpsertRiskTemplatesResponse
+	(*UpsertSubjectTemplatesResponse)(nil), // 6: proto.UpsertSubjectTemplatesResponse
+	(*RecordPolicyInputResponse)(nil),      // 7: proto.RecordPolicyInputResponse
+	nil,                                    // 8: proto.RecordPolicyInputRequest.LabelsEntry
+	(*Evidence)(nil),                       // 9: proto.Evidence
+	(*RiskTemplate)(nil),                   // 10: proto.RiskTemplate
+	(*SubjectTemplate)(nil),                // 11: proto.SubjectTemplate
 }
 var file_runner_proto_results_proto_depIdxs = []int32{
-	6, // 0: proto.CreateEvidenceRequest.Evidence:type_name -> proto.Evidence
-	7, // 1: proto.UpsertRiskTemplatesRequest.RiskTemplates:type_name -> proto.RiskTemplate
-	8, // 2: proto.UpsertSubjectTemplatesRequest.SubjectTemplates:type_name -> proto.SubjectTemplate
-	0, // 3: proto.ApiHelper.CreateEvidence:input_type -> proto.CreateEvidenceRequest
-	1, // 4: proto.ApiHelper.UpsertRiskTemplates:input_type -> proto.UpsertRiskTemplatesRequest
-	2, // 5: proto.ApiHelper.UpsertSubjectTemplates:input_type -> proto.UpsertSubjectTemplatesRequest
-	3, // 6: proto.ApiHelper.CreateEvidence:output_type -> proto.CreateEvidenceResponse
-	4, // 7: proto.ApiHelper.UpsertRiskTemplates:output_type -> proto.UpsertRiskTemplatesResponse
-	5, // 8: proto.ApiHelper.UpsertSubjectTemplates:output_type -> proto.UpsertSubjectTemplatesResponse
-	6, // [6:9] is the sub-list for method output_type
-	3, // [3:6] is the sub-list for method input_type
-	3, // [3:3] is the sub-list for extension type_name
-	3, // [3:3] is the sub-list for extension extendee
-	0, // [0:3] is the sub-list for field type_name
+	9,  // 0: proto.CreateEvidenceRequest.Evidence:type_name -> proto.Evidence
+	10, // 1: proto.UpsertRiskTemplatesRequest.RiskTemplates:type_name -> proto.RiskTemplate
+	11, // 2: proto.UpsertSubjectTemplatesRequest.SubjectTemplates:type_name -> proto.SubjectTemplate
+	8,  // 3: proto.RecordPolicyInputRequest.Labels:type_name -> proto.RecordPolicyInputRequest.LabelsEntry
+	0,  // 4: proto.ApiHelper.CreateEvidence:input_type -> proto.CreateEvidenceRequest
+	1,  // 5: proto.ApiHelper.UpsertRiskTemplates:input_type -> proto.UpsertRiskTemplatesRequest
+	2,  // 6: proto.ApiHelper.UpsertSubjectTemplates:input_type -> proto.UpsertSubjectTemplatesRequest
+	3,  // 7: proto.ApiHelper.RecordPolicyInput:input_type -> proto.RecordPolicyInputRequest
+	4,  // 8: proto.ApiHelper.CreateEvidence:output_type -> proto.CreateEvidenceResponse
+	5,  // 9: proto.ApiHelper.UpsertRiskTemplates:output_type -> proto.UpsertRiskTemplatesResponse
+	6,  // 10: proto.ApiHelper.UpsertSubjectTemplates:output_type -> proto.UpsertSubjectTemplatesResponse
+	7,  // 11: proto.ApiHelper.RecordPolicyInput:output_type -> proto.RecordPolicyInputResponse
+	8,  // [8:12] is the sub-list for method output_type
+	4,  // [4:8] is the sub-list for method input_type
+	4,  // [4:4] is the sub-list for extension type_name
+	4,  // [4:4] is the sub-list for extension extendee
+	0,  // [0:4] is the sub-list for field type_name
 }
 
 func init() { file_runner_proto_results_proto_init() }
@@ -342,7 +455,7 @@ func file_runner_proto_results_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_runner_proto_results_proto_rawDesc), len(file_runner_proto_results_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   6,
+			NumMessages:   9,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -22,6 +22,7 @@ const (
 	ApiHelper_CreateEvidence_FullMethodName         = "/proto.ApiHelper/CreateEvidence"
 	ApiHelper_UpsertRiskTemplates_FullMethodName    = "/proto.ApiHelper/UpsertRiskTemplates"
 	ApiHelper_UpsertSubjectTemplates_FullMethodName = "/proto.ApiHelper/UpsertSubjectTemplates"
+	ApiHelper_RecordPolicyInput_FullMethodName      = "/proto.ApiHelper/RecordPolicyInput"
 )
 
 // ApiHelperClient is the client API for ApiHelper service.
@@ -31,6 +32,7 @@ type ApiHelperClient interface {
 	CreateEvidence(ctx context.Context, in *CreateEvidenceRequest, opts ...grpc.CallOption) (*CreateEvidenceResponse, error)
 	UpsertRiskTemplates(ctx context.Context, in *UpsertRiskTemplatesRequest, opts ...grpc.CallOption) (*UpsertRiskTemplatesResponse, error)
 	UpsertSubjectTemplates(ctx context.Context, in *UpsertSubjectTemplatesRequest, opts ...grpc.CallOption) (*UpsertSubjectTemplatesResponse, error)
+	RecordPolicyInput(ctx context.Context, in *RecordPolicyInputRequest, opts ...grpc.CallOption) (*RecordPolicyInputResponse, error)
 }
 
 type apiHelperClient struct {
@@ -68,6 +70,15 @@ func (c *apiHelperClient) UpsertSubjectTemplates(ctx context.Context, in *Upsert
 	return out, nil
 }
 
+func (c *apiHelperClient) RecordPolicyInput(ctx context.Context, in *RecordPolicyInputRequest, opts ...grpc.CallOption) (*RecordPolicyInputResponse, error) {
+	out := new(RecordPolicyInputResponse)
+	err := c.cc.Invoke(ctx, ApiHelper_RecordPolicyInput_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ApiHelperServer is the server API for ApiHelper service.
 // All implementations should embed UnimplementedApiHelperServer
 // for forward compatibility
@@ -75,6 +86,7 @@ type ApiHelperServer interface {
 	CreateEvidence(context.Context, *CreateEvidenceRequest) (*CreateEvidenceResponse, error)
 	UpsertRiskTemplates(context.Context, *UpsertRiskTemplatesRequest) (*UpsertRiskTemplatesResponse, error)
 	UpsertSubjectTemplates(context.Context, *UpsertSubjectTemplatesRequest) (*UpsertSubjectTemplatesResponse, error)
+	RecordPolicyInput(context.Context, *RecordPolicyInputRequest) (*RecordPolicyInputResponse, error)
 }
 
 // UnimplementedApiHelperServer should be embedded to have forward compatible implementations.
@@ -90,6 +102,9 @@ func (UnimplementedApiHelperServer) UpsertRiskTemplates(context.Context, *Upsert
 func (UnimplementedApiHelperServer) UpsertSubjectTemplates(context.Context, *UpsertSubjectTemplatesRequest) (*UpsertSubjectTemplatesResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method UpsertSubjectTemplates not implemented")
 }
+func (UnimplementedApiHelperServer) RecordPolicyInput(context.Context, *RecordPolicyInputRequest) (*RecordPolicyInputResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RecordPolicyInput not implemented")
+}
 
 // UnsafeApiHelperServer may be embedded to opt out of forward compatibility for this service.
 // Use of this interface is not recommended, as added methods to ApiHelperServer will
@@ -156,6 +171,24 @@ func _ApiHelper_UpsertSubjectTemplates_Handler(srv interface{}, ctx context.Cont
 	return interceptor(ctx, in, info, handler)
 }
 
+func _ApiHelper_RecordPolicyInput_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RecordPolicyInputRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiHelperServer).RecordPolicyInput(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ApiHelper_RecordPolicyInput_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiHelperServer).RecordPolicyInput(ctx, req.(*RecordPolicyInputRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // ApiHelper_ServiceDesc is the grpc.ServiceDesc for ApiHelper service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -175,6 +208,10 @@ var ApiHelper_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "UpsertSubjectTemplates",
 			Handler:    _ApiHelper_UpsertSubjectTemplates_Handler,
 		},
+		{
+			MethodName: "RecordPolicyInput",
+			Handler:    _ApiHelper_RecordPolicyInput_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "runner/proto/results.proto",
@@ -14,8 +14,14 @@ type apiHelper struct {
 	client      *sdk.Client
 	agentLabels map[string]string
 	pluginName  string
+	outbox      *EvidenceOutbox
+	observer    EvidenceObserver
 }
 
+// EvidenceObserver is told how each batch of evidence sent by a plugin was handled:
+// how much was delivered to the API, queued in the outbox, or lost to an error.
+type EvidenceObserver func(delivered, queued, failed int)
+
 func NewApiHelper(logger hclog.Logger, client *sdk.Client, agentLabels map[string]string, pluginName string) *apiHelper {
 	logger = logger.Named("api-helper")
 	return &apiHelper{
@@ -26,14 +32,64 @@ func NewApiHelper(logger hclog.Logger, client *sdk.Client, agentLabels map[strin
 	}
 }
 
+// SetOutbox routes evidence through a durable outbox, so that evidence produced while the
+// API is unreachable is queued on disk and replayed later instead of being lost.
+func (h *apiHelper) SetOutbox(outbox *EvidenceOutbox) {
+	h.outbox = outbox
+}
+
+// SetEvidenceObserver registers a callback that is told the outcome of every
+// CreateEvidence call, so the agent can keep evidence metrics.
+func (h *apiHelper) SetEvidenceObserver(observer EvidenceObserver) {
+	h.observer = observer
+}
+
+func (h *apiHelper) observe(delivered, queued, failed int) {
+	if h.observer != nil {
+		h.observer(delivered, queued, failed)
+	}
+}
+
 func (h *apiHelper) CreateEvidence(ctx context.Context, evidence []*proto.Evidence) error {
+	labelled := labelEvidence(evidence, h.agentLabels)
+
+	if h.outbox != nil {
+		queued, err := h.outbox.Deliver(ctx, h.client, h.pluginName, labelled)
+		if err != nil {
+			h.observe(0, 0, len(labelled))
+			return err
+		}
+		h.observe(len(labelled)-queued, queued, 0)
+		return nil
+	}
+
+	err := h.client.Evidence.Create(ctx, labelled...)
+	if err != nil {
+		h.observe(0, 0, len(labelled))
+		return err
+	}
+	h.observe(len(labelled), 0, 0)
+	return nil
+}
+
+func (h *apiHelper) UpsertRiskTemplates(ctx context.Context, packageName string, riskTemplates []*proto.RiskTemplate) error {
+	return h.client.RiskTemplate.Upsert(ctx, h.pluginName, packageName, riskTemplatesForUpsert(riskTemplates)...)
+}
+
+func (h *apiHelper) UpsertSubjectTemplates(ctx context.Context, subjectTemplates []*proto.SubjectTemplate) error {
+	return h.client.SubjectTemplate.Upsert(ctx, h.pluginName, subjectTemplatesForUpsert(subjectTemplates, h.pluginName)...)
+}
+
+// labelEvidence converts evidence to the API's types, with the agent's labels merged
+// into each evidence's own labels, which take precedence.
+func labelEvidence(evidence []*proto.Evidence, agentLabels map[string]string) []types.Evidence {
 	evidences := ProtoToSdk(evidence, EvidenceProtoToSdk)
 
 	// Merge agent, config and finding labels all together.
 	labelled := make([]types.Evidence, 0)
 	for _, evid := range *evidences {
 		labels := make(map[string]string)
-		for k, v := range h.agentLabels {
+		for k, v := range agentLabels {
 			labels[k] = v
 		}
 		for k, v := range evid.Labels {
@@ -43,11 +99,10 @@ func (h *apiHelper) CreateEvidence(ctx context.Context, evidence []*proto.Eviden
 
 		labelled = append(labelled, *evid)
 	}
-
-	return h.client.Evidence.Create(ctx, labelled...)
+	return labelled
 }
 
-func (h *apiHelper) UpsertRiskTemplates(ctx context.Context, packageName string, riskTemplates []*proto.RiskTemplate) error {
+func riskTemplatesForUpsert(riskTemplates []*proto.RiskTemplate) []types.RiskTemplate {
 	templates := ProtoToSdk(riskTemplates, RiskTemplateProtoToSdk)
 
 	enriched := make([]types.RiskTemplate, 0)
@@ -59,11 +114,10 @@ func (h *apiHelper) UpsertRiskTemplates(ctx context.Context, packageName string,
 
 		enriched = append(enriched, *temp)
 	}
-
-	return h.client.RiskTemplate.Upsert(ctx, h.pluginName, packageName, enriched...)
+	return enriched
 }
 
-func (h *apiHelper) UpsertSubjectTemplates(ctx context.Context, subjectTemplates []*proto.SubjectTemplate) error {
+func subjectTemplatesForUpsert(subjectTemplates []*proto.SubjectTemplate, pluginName string) []types.SubjectTemplate {
 	templates := ProtoToSdk(subjectTemplates, SubjectTemplateProtoToSdk)
 
 	enriched := make([]types.SubjectTemplate, 0)
@@ -76,14 +130,14 @@ func (h *apiHelper) UpsertSubjectTemplates(ctx context.Context, subjectTemplates
 			"type":         "subject_template",
 			"subject_type": temp.Type,
 			"name":         temp.Name,
-			"plugin_id":    h.pluginName,
+			"plugin_id":    pluginName,
 		}).String()
 		temp.SourceMode = "runtime-derived"
-		temp.SelectorLabels = withPluginSelectorLabel(temp.SelectorLabels, h.pluginName)
+		temp.SelectorLabels = withPluginSelectorLabel(temp.SelectorLabels, pluginName)
 
 		enriched = append(enriched, *temp)
 	}
-	return h.client.SubjectTemplate.Upsert(ctx, h.pluginName, enriched...)
+	return enriched
 }
 
 func prepareRiskTemplateForUpsert(temp *types.RiskTemplate) *types.RiskTemplate {